@@ -11,6 +11,11 @@ import (
 	"github.com/qioalice/ekago/v3/ekalog"
 )
 
+//noinspection GoSnakeCaseUsage
+const (
+	_ISO8601 = "2006-01-02T15:04:05.000-0700"
+)
+
 // NewJsonEncoder creates a Datadog encoder that is based on ekalog.CI_JSONEncoder.
 // It has almost the same rules but with the following changes:
 //
@@ -36,12 +41,12 @@ import (
 //
 //        "[<stack_index>]: <message>"
 //
+// Consider to use NewEncoder() instead, that uses Datadog's reserved attributes.
 func NewJsonEncoder() *ekalog.CI_JSONEncoder {
 	return new(ekalog.CI_JSONEncoder).
 		SetOneDepthLevel(true).
 		SetNameForField(ekalog.CI_JSON_ENCODER_FIELD_TIME, "timestamp_real").
 		SetTimeFormatter(func(t time.Time) string {
-			const ISO8601 = "2006-01-02T15:04:05.000-0700"
-			return t.Format(ISO8601)
+			return t.Format(_ISO8601)
 		})
 }
@@ -0,0 +1,248 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_datadog
+
+import (
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+	"github.com/qioalice/ekago/v3/ekasys"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_DatadogEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as JSON object using Datadog's reserved and standard attributes
+	// ( https://docs.datadoghq.com/logs/log_configuration/attributes_naming_convention/ ),
+	// so Datadog recognizes them w/o any pipeline, and Error Tracking works.
+	//
+	// 1. "timestamp" is ISO8601 with milliseconds, "message" is log entry's
+	//    message or error's one, if it's empty,
+	//    "logger.name" is "ekalog".
+	//
+	// 2. "status" is a syslog severity level name, that Datadog understands:
+	//    "emergency", "alert", "critical", "error", "warning", "notice",
+	//    "info", "debug".
+	//
+	// 3. "service", "hostname", "ddsource" are set using SetService(),
+	//    SetHostname() (os.Hostname() by default), SetSource() ("go" by default).
+	//    "ddtags" are "env:<env>", "version:<version>" (SetEnv(), SetVersion())
+	//    and static tags (SetTags()). Empty ones are omitted.
+	//
+	// 4. If there is an attached ekaerr.Error, "error.kind" is its class name,
+	//    "error.message" is all error's messages (from the last to the first,
+	//    separated by ": ", or log entry's message if there are none),
+	//    "error.id" is its ID and "error.stack" is its stacktrace
+	//    in the Go's debug.Stack() format.
+	//    If there's no error, but log entry has a stacktrace,
+	//    it's "logger.stack" in the same format.
+	//
+	// 5. All log's fields, and all attached error's fields
+	//    are encoded as JSON key-value pairs at the root
+	//    (like NewJsonEncoder() does).
+	//
+	// Encoded log entry is a JSON object w/o trailing '\n', because
+	// CI_WriterHttp's UseProviderDataDog() sends a pack as a JSON array.
+	CI_DatadogEncoder struct {
+
+		ekaenc.NopEncoder
+
+		service  string
+		hostname string
+		source   string
+		env      string
+		version  string
+		tags     []string
+
+		// Pre-built "ddtags" value. Rebuilt by the setters of tags.
+		ddtags string
+	}
+)
+
+// NewEncoder creates a new CI_DatadogEncoder.
+// Use its setters to specify service, env, etc.
+// "ddtags" value is pre-built by them, so they are not thread-safe and
+// must be called before the encoder is registered with ekalog.CommonIntegrator.
+func NewEncoder() *CI_DatadogEncoder {
+
+	hostname, _ := os.Hostname()
+
+	return &CI_DatadogEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		hostname:   hostname,
+		source:     "go",
+	}
+}
+
+// SetService sets "service" reserved attribute.
+func (de *CI_DatadogEncoder) SetService(service string) *CI_DatadogEncoder {
+	if de != nil {
+		de.service = service
+	}
+	return de
+}
+
+// SetHostname sets "hostname" reserved attribute.
+func (de *CI_DatadogEncoder) SetHostname(hostname string) *CI_DatadogEncoder {
+	if de != nil {
+		de.hostname = hostname
+	}
+	return de
+}
+
+// SetSource sets "ddsource" reserved attribute.
+func (de *CI_DatadogEncoder) SetSource(source string) *CI_DatadogEncoder {
+	if de != nil {
+		de.source = source
+	}
+	return de
+}
+
+// SetEnv sets "env:<env>" tag.
+func (de *CI_DatadogEncoder) SetEnv(env string) *CI_DatadogEncoder {
+	if de != nil {
+		de.env = env
+		de.build()
+	}
+	return de
+}
+
+// SetVersion sets "version:<version>" tag.
+func (de *CI_DatadogEncoder) SetVersion(version string) *CI_DatadogEncoder {
+	if de != nil {
+		de.version = version
+		de.build()
+	}
+	return de
+}
+
+// SetTags sets static tags (like "team:backend", "region:eu"),
+// that are added to "ddtags" of each log entry. Empty tags are ignored.
+func (de *CI_DatadogEncoder) SetTags(tags ...string) *CI_DatadogEncoder {
+	if de != nil {
+		de.tags = de.tags[:0]
+		for _, tag := range tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				de.tags = append(de.tags, tag)
+			}
+		}
+		de.build()
+	}
+	return de
+}
+
+// EncodeEntry encodes passed ekalog.Entry as JSON object
+// with Datadog's reserved attributes.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (de *CI_DatadogEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("timestamp")
+	s.WriteString(e.Time.Format(_ISO8601))
+	s.WriteMore()
+
+	s.WriteObjectField("status")
+	s.WriteString(e.Level.ToLower())
+	s.WriteMore()
+
+	s.WriteObjectField("message")
+	s.WriteString(ekaenc.EntryMessage(logLetter, errLetter))
+
+	ekaenc.WriteStringIfNotEmpty(s, "service", de.service)
+	ekaenc.WriteStringIfNotEmpty(s, "hostname", de.hostname)
+	ekaenc.WriteStringIfNotEmpty(s, "ddsource", de.source)
+	ekaenc.WriteStringIfNotEmpty(s, "ddtags", de.ddtags)
+
+	stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter)
+
+	s.WriteMore()
+	s.WriteObjectField("logger")
+	s.WriteObjectStart()
+	s.WriteObjectField("name")
+	s.WriteString("ekalog")
+	if errLetter == nil && len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("stack")
+		s.WriteString(ekaenc.FormatGoStack(stackTrace))
+	}
+	s.WriteObjectEnd()
+
+	if errLetter != nil {
+		s.WriteMore()
+		de.encodeError(s, logLetter, errLetter, stackTrace)
+	}
+
+	unnamedFieldIdx := int16(0)
+	for i := range logLetter.Fields {
+		logLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+	}
+	if errLetter != nil {
+		for i := range errLetter.Fields {
+			errLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+		}
+	}
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// build prepares "ddtags" value.
+func (de *CI_DatadogEncoder) build() {
+
+	tags := make([]string, 0, len(de.tags)+2)
+	if de.env != "" {
+		tags = append(tags, "env:"+de.env)
+	}
+	if de.version != "" {
+		tags = append(tags, "version:"+de.version)
+	}
+
+	de.ddtags = strings.Join(append(tags, de.tags...), ",")
+}
+
+// encodeError writes "error" standard attribute to 's'.
+func (de *CI_DatadogEncoder) encodeError(
+
+	s *jsoniter.Stream,
+	logLetter, errLetter *ekaenc.Letter,
+	stackTrace ekasys.StackTrace,
+) {
+	s.WriteObjectField("error")
+	s.WriteObjectStart()
+
+	s.WriteObjectField("kind")
+	s.WriteString(errLetter.ErrorClassName())
+	s.WriteMore()
+
+	s.WriteObjectField("message")
+	s.WriteString(ekaenc.ErrorMessage(logLetter, errLetter))
+
+	ekaenc.WriteStringIfNotEmpty(s, "id", errLetter.ErrorID())
+
+	if len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("stack")
+		s.WriteString(ekaenc.FormatGoStack(stackTrace))
+	}
+
+	s.WriteObjectEnd()
+}
@@ -0,0 +1,95 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_datadog
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+func TestCI_DatadogEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled").Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_DatadogEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"reserved attributes",
+			NewEncoder().SetService("api").SetHostname("test-host").
+				SetEnv("prod").SetVersion("v1").SetTags(" team:backend ", ""),
+			func() *ekaerr.Error {
+				ekalog.Notice("user logged in", "user_id", 42, "admin", true)
+				return nil
+			},
+			`{"timestamp":"2021-06-01T12:30:45.123+0000","status":"notice","message":"user logged in",` +
+				`"service":"api","hostname":"test-host","ddsource":"go","ddtags":"env:prod,version:v1,team:backend",` +
+				`"logger":{"name":"ekalog"},"user_id":42,"admin":true}`,
+		},
+		{
+			"error's message",
+			NewEncoder().SetHostname("").SetSource(""),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					AddMessage("failed to authorize").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"timestamp":"2021-06-01T12:30:45.123+0000","status":"error","message":"failed to authorize",` +
+				`"logger":{"name":"ekalog"},` +
+				`"error":{"kind":"NotFound","message":"the user is not found","id":"<error_id>"},` +
+				`"method":"GET","path":"/users/42"}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder().SetHostname("").SetSource(""),
+			func() *ekaerr.Error {
+				ekalog.Warne("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"timestamp":"2021-06-01T12:30:45.123+0000","status":"warning","message":"request failed",` +
+				`"logger":{"name":"ekalog"},` +
+				`"error":{"kind":"Interrupted","message":"the request is cancelled","id":"<error_id>",` +
+				`"stack":"goroutine 1 [running]:\n` +
+				`github.com/qioalice/ekago_ext/v3/ekalog/encoders/datadog.TestCI_DatadogEncoder_EncodeEntry(...)\n` +
+				`\t<dir>/encoder_datadog_reserved_test.go:<line>"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected log entry:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
@@ -9,8 +9,47 @@ import (
 	"github.com/qioalice/ekago/v3/ekalog"
 
 	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/datadog"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/gcp"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/gelf"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/loki"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/otlp"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/rollbar"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/sentry"
+	"github.com/qioalice/ekago_ext/v3/ekalog/encoders/splunk"
 )
 
 func NewDatadogJsonEncoder() *ekalog.CI_JSONEncoder {
 	return ekalog_encoder_datadog.NewJsonEncoder()
 }
+
+func NewDatadogEncoder() *ekalog_encoder_datadog.CI_DatadogEncoder {
+	return ekalog_encoder_datadog.NewEncoder()
+}
+
+func NewGELFEncoder() *ekalog_encoder_gelf.CI_GELFEncoder {
+	return ekalog_encoder_gelf.NewEncoder()
+}
+
+func NewSentryEncoder() *ekalog_encoder_sentry.CI_SentryEncoder {
+	return ekalog_encoder_sentry.NewEncoder()
+}
+
+func NewRollbarEncoder() *ekalog_encoder_rollbar.CI_RollbarEncoder {
+	return ekalog_encoder_rollbar.NewEncoder()
+}
+
+func NewLokiEncoder() *ekalog_encoder_loki.CI_LokiEncoder {
+	return ekalog_encoder_loki.NewEncoder()
+}
+
+func NewSplunkHECEncoder() *ekalog_encoder_splunk.CI_SplunkHECEncoder {
+	return ekalog_encoder_splunk.NewEncoder()
+}
+
+func NewOTLPEncoder() *ekalog_encoder_otlp.CI_OTLPEncoder {
+	return ekalog_encoder_otlp.NewEncoder()
+}
+
+func NewGCPEncoder() *ekalog_encoder_gcp.CI_GCPEncoder {
+	return ekalog_encoder_gcp.NewEncoder()
+}
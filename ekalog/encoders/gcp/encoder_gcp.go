@@ -0,0 +1,279 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_gcp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_GCPEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as Google Cloud Logging's structured log entry
+	// ( https://cloud.google.com/logging/docs/structured-logging ),
+	// that is recognized when it's written to stdout on GKE, Cloud Run, etc.
+	// Use CI_WriterHttp's UseProviderGoogleCloudLogging() to send it using API.
+	//
+	// 1. "severity" is ekalog's level ("DEBUG", "INFO", "NOTICE", "WARNING",
+	//    "ERROR", "CRITICAL", "ALERT", "EMERGENCY"), "message" is log entry's
+	//    message, "time" is RFC3339 with nanoseconds.
+	//
+	// 2. "logging.googleapis.com/sourceLocation" is the first stack frame
+	//    of log entry's (or attached error's) stacktrace, if it's presented.
+	//
+	// 3. "logging.googleapis.com/trace" and "logging.googleapis.com/spanId"
+	//    are taken from the log's or error's fields with keys set
+	//    by SetTraceFields() ("trace_id", "span_id" by default).
+	//    The trace is "projects/<project ID>/traces/<trace ID>"
+	//    if project ID is set by SetProjectID().
+	//
+	// 4. "logging.googleapis.com/labels" are static labels (SetLabels())
+	//    and the log's or error's fields, which keys are set by SetFieldLabels().
+	//
+	// 5. "httpRequest" is an object of log's or error's fields,
+	//    which keys are prefixed by "httpRequest." (the prefix is removed),
+	//    like "httpRequest.requestMethod", "httpRequest.status", "httpRequest.latency".
+	//    See https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest .
+	//
+	// 6. All the rest log's fields and all attached error's fields
+	//    are encoded as JSON key-value pairs at the root.
+	//    If there is an attached ekaerr.Error, "error_id", "error_class" are
+	//    its ID and class name, and "stack_trace" is its message and stacktrace
+	//    in the Go's debug.Stack() format, so Error Reporting recognizes it.
+	//
+	// Encoded log entry has no trailing '\n'. Add it using the writer,
+	// if the entries are written to stdout for the logging agent.
+	CI_GCPEncoder struct {
+
+		ekaenc.NopEncoder
+
+		projectID    string
+		traceIDKey   string
+		spanIDKey    string
+		staticLabels []string
+		fieldLabels  []string
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_HTTP_REQUEST_PREFIX = "httpRequest."
+)
+
+// NewEncoder creates a new CI_GCPEncoder, that takes trace and span IDs
+// from "trace_id" and "span_id" fields. Configure it using setters
+// before it's registered with ekalog.CommonIntegrator.
+func NewEncoder() *CI_GCPEncoder {
+	return &CI_GCPEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		traceIDKey: "trace_id",
+		spanIDKey:  "span_id",
+	}
+}
+
+// SetProjectID sets Google Cloud project ID, that is used to build
+// the full trace name.
+func (ge *CI_GCPEncoder) SetProjectID(projectID string) *CI_GCPEncoder {
+	if ge != nil {
+		ge.projectID = projectID
+	}
+	return ge
+}
+
+// SetTraceFields sets the keys of the fields, "logging.googleapis.com/trace"
+// and "logging.googleapis.com/spanId" are taken from.
+// Empty key means the attribute is never encoded.
+func (ge *CI_GCPEncoder) SetTraceFields(traceIDKey, spanIDKey string) *CI_GCPEncoder {
+	if ge != nil {
+		ge.traceIDKey, ge.spanIDKey = traceIDKey, spanIDKey
+	}
+	return ge
+}
+
+// SetLabels sets "logging.googleapis.com/labels", that are the same for all
+// log entries, as name, value, name, value, ... An odd name is ignored.
+func (ge *CI_GCPEncoder) SetLabels(nameValue ...string) *CI_GCPEncoder {
+	if ge != nil {
+		ge.staticLabels = append(ge.staticLabels[:0], nameValue[:len(nameValue)&^1]...)
+	}
+	return ge
+}
+
+// SetFieldLabels sets the keys of the fields, that are moved
+// from the root to "logging.googleapis.com/labels".
+func (ge *CI_GCPEncoder) SetFieldLabels(keys ...string) *CI_GCPEncoder {
+	if ge != nil {
+		ge.fieldLabels = append(ge.fieldLabels[:0], keys...)
+	}
+	return ge
+}
+
+// EncodeEntry encodes passed ekalog.Entry as Google Cloud Logging's
+// structured log entry.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (ge *CI_GCPEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+	stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter)
+	message := ekaenc.EntryMessage(logLetter, errLetter)
+
+	var (
+		fields      []*ekaenc.Field
+		labels      []*ekaenc.Field
+		httpRequest []*ekaenc.Field
+		traceID     string
+		spanID      string
+	)
+
+	for _, l := range []*ekaenc.Letter{logLetter, errLetter} {
+		if l == nil {
+			continue
+		}
+		for i := range l.Fields {
+			f := &l.Fields[i]
+			switch {
+			case f.IsHidden():
+			case f.Key == ge.traceIDKey && ge.traceIDKey != "" && traceID == "":
+				traceID = f.String()
+			case f.Key == ge.spanIDKey && ge.spanIDKey != "" && spanID == "":
+				spanID = f.String()
+			case strings.HasPrefix(f.Key, _HTTP_REQUEST_PREFIX):
+				httpRequest = append(httpRequest, f)
+			case ge.isFieldLabel(f.Key):
+				labels = append(labels, f)
+			default:
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("severity")
+	s.WriteString(e.Level.ToUpper())
+	s.WriteMore()
+
+	s.WriteObjectField("message")
+	s.WriteString(message)
+	s.WriteMore()
+
+	s.WriteObjectField("time")
+	s.WriteString(e.Time.UTC().Format(time.RFC3339Nano))
+
+	if len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("logging.googleapis.com/sourceLocation")
+		s.WriteObjectStart()
+		s.WriteObjectField("file")
+		s.WriteString(stackTrace[0].File)
+		s.WriteMore()
+		s.WriteObjectField("line")
+		s.WriteString(strconv.Itoa(stackTrace[0].Line))
+		s.WriteMore()
+		s.WriteObjectField("function")
+		s.WriteString(stackTrace[0].Function)
+		s.WriteObjectEnd()
+	}
+
+	if traceID != "" {
+		if ge.projectID != "" {
+			traceID = "projects/" + ge.projectID + "/traces/" + traceID
+		}
+		s.WriteMore()
+		s.WriteObjectField("logging.googleapis.com/trace")
+		s.WriteString(traceID)
+	}
+
+	if spanID != "" {
+		s.WriteMore()
+		s.WriteObjectField("logging.googleapis.com/spanId")
+		s.WriteString(spanID)
+	}
+
+	if len(ge.staticLabels) > 0 || len(labels) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("logging.googleapis.com/labels")
+		s.WriteObjectStart()
+		for i := 0; i < len(ge.staticLabels); i += 2 {
+			if i > 0 {
+				s.WriteMore()
+			}
+			s.WriteObjectField(ge.staticLabels[i])
+			s.WriteString(ge.staticLabels[i+1])
+		}
+		for i, f := range labels {
+			if i > 0 || len(ge.staticLabels) > 0 {
+				s.WriteMore()
+			}
+			s.WriteObjectField(f.Key)
+			s.WriteString(f.String())
+		}
+		s.WriteObjectEnd()
+	}
+
+	if len(httpRequest) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("httpRequest")
+		s.WriteObjectStart()
+		for i, f := range httpRequest {
+			if i > 0 {
+				s.WriteMore()
+			}
+			s.WriteObjectField(strings.TrimPrefix(f.Key, _HTTP_REQUEST_PREFIX))
+			f.WriteJSON(s)
+		}
+		s.WriteObjectEnd()
+	}
+
+	unnamedFieldIdx := int16(0)
+	for _, f := range fields {
+		s.WriteMore()
+		s.WriteObjectField(f.KeyOrUnnamed(&unnamedFieldIdx))
+		f.WriteJSON(s)
+	}
+
+	if errLetter != nil {
+		s.WriteMore()
+		s.WriteObjectField("error_id")
+		s.WriteString(errLetter.ErrorID())
+		s.WriteMore()
+		s.WriteObjectField("error_class")
+		s.WriteString(errLetter.ErrorClassName())
+
+		if len(stackTrace) > 0 {
+			s.WriteMore()
+			s.WriteObjectField("stack_trace")
+			s.WriteString(message + "\n\n" + ekaenc.FormatGoStack(stackTrace))
+		}
+	}
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// isFieldLabel reports whether the field with 'key' must be used as label.
+func (ge *CI_GCPEncoder) isFieldLabel(key string) bool {
+	for _, fieldLabel := range ge.fieldLabels {
+		if key == fieldLabel {
+			return true
+		}
+	}
+	return false
+}
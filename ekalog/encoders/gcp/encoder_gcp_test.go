@@ -0,0 +1,102 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_gcp
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+// sourceLineRegexp matches source location's line, like ekaenctest.HideLines() does.
+var sourceLineRegexp = regexp.MustCompile(`"line":"\d+"`)
+
+func TestCI_GCPEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled").Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_GCPEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"special fields",
+			NewEncoder().SetProjectID("project").SetLabels("env", "prod", "odd").SetFieldLabels("region"),
+			func() *ekaerr.Error {
+				ekalog.Notice("request handled",
+					"trace_id", "105445aa7843bc8bf206b12000100000", "span_id", "000000000000004a",
+					"region", "eu", "httpRequest.requestMethod", "GET", "httpRequest.status", 200,
+					"user_id", 42)
+				return nil
+			},
+			`{"severity":"NOTICE","message":"request handled","time":"2021-06-01T12:30:45.123456789Z",` +
+				`"logging.googleapis.com/trace":"projects/project/traces/105445aa7843bc8bf206b12000100000",` +
+				`"logging.googleapis.com/spanId":"000000000000004a",` +
+				`"logging.googleapis.com/labels":{"env":"prod","region":"eu"},` +
+				`"httpRequest":{"requestMethod":"GET","status":200},"user_id":42}`,
+		},
+		{
+			"error's message",
+			NewEncoder().SetTraceFields("", ""),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err, "trace_id", "t", "method", "GET")
+				return err
+			},
+			`{"severity":"ERROR","message":"the user is not found","time":"2021-06-01T12:30:45.123456789Z",` +
+				`"trace_id":"t","method":"GET","path":"/users/42","error_id":"<error_id>","error_class":"NotFound"}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder(),
+			func() *ekaerr.Error {
+				ekalog.Warne("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"severity":"WARNING","message":"request failed","time":"2021-06-01T12:30:45.123456789Z",` +
+				`"logging.googleapis.com/sourceLocation":{"file":"<dir>/encoder_gcp_test.go","line":"<line>",` +
+				`"function":"github.com/qioalice/ekago_ext/v3/ekalog/encoders/gcp.TestCI_GCPEncoder_EncodeEntry"},` +
+				`"error_id":"<error_id>","error_class":"Interrupted",` +
+				`"stack_trace":"request failed\n\ngoroutine 1 [running]:\n` +
+				`github.com/qioalice/ekago_ext/v3/ekalog/encoders/gcp.TestCI_GCPEncoder_EncodeEntry(...)\n` +
+				`\t<dir>/encoder_gcp_test.go:<line>"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			encoded = sourceLineRegexp.ReplaceAllString(encoded, `"line":"<line>"`)
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected log entry:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
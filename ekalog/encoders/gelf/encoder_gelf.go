@@ -0,0 +1,247 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_gelf
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_GELFEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as GELF 1.1 JSON message (Graylog Extended Log Format):
+	// https://docs.graylog.org/en/latest/pages/gelf.html .
+	//
+	// 1. "version" is always "1.1".
+	//
+	// 2. "host" is os.Hostname() by default. You may change it (see SetHost()).
+	//
+	// 3. "short_message" is log entry's message. GELF requires it,
+	//    so it's the last error's message if log entry has none.
+	//
+	// 4. "full_message" contains short message, error's ID, class
+	//    and the stacktrace with error's messages (each line per stack frame).
+	//    It's omitted if there is no error and no stacktrace.
+	//
+	// 5. "timestamp" is UNIX timestamp with milliseconds (as fraction).
+	//
+	// 6. "level" is syslog severity level (the same as ekalog.Level).
+	//
+	// 7. All log's fields are encoded as additional fields
+	//    (their keys are prefixed by "_"). Attached error's fields
+	//    are prefixed by "_error_<stack_index>_". Error's ID and class name
+	//    are "_error_id" and "_error_class". Level's name is "_level_name".
+	//    Keys are sanitized (only letters, digits, '_', '-', '.' are allowed).
+	//
+	//    GELF allows only strings and numbers as values, so bools, durations,
+	//    maps, etc are encoded as strings.
+	//
+	// Encoded GELF message has no trailing '\n' nor '\0',
+	// because each GELF message must be sent by its own HTTP request
+	// (see ekalog_writer_http.CI_WriterHttp's UseProviderGrayLog()).
+	CI_GELFEncoder struct {
+
+		ekaenc.NopEncoder
+
+		host string
+	}
+)
+
+// NewEncoder creates a new CI_GELFEncoder.
+func NewEncoder() *CI_GELFEncoder {
+
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "localhost"
+	}
+
+	return &CI_GELFEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		host:       host,
+	}
+}
+
+// SetHost sets the "host" GELF field. Empty string is ignored.
+func (ge *CI_GELFEncoder) SetHost(host string) *CI_GELFEncoder {
+	if ge != nil && host != "" {
+		ge.host = host
+	}
+	return ge
+}
+
+// EncodeEntry encodes passed ekalog.Entry as GELF 1.1 JSON message.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (ge *CI_GELFEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+
+	shortMessage := ekaenc.EntryMessage(logLetter, errLetter)
+	if shortMessage == "" {
+		// GELF requires non-empty "short_message".
+		shortMessage = "<no message>"
+	}
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("version")
+	s.WriteString("1.1")
+	s.WriteMore()
+
+	s.WriteObjectField("host")
+	s.WriteString(ge.host)
+	s.WriteMore()
+
+	s.WriteObjectField("short_message")
+	s.WriteString(shortMessage)
+	s.WriteMore()
+
+	if fullMessage := ge.fullMessage(shortMessage, logLetter, errLetter); fullMessage != "" {
+		s.WriteObjectField("full_message")
+		s.WriteString(fullMessage)
+		s.WriteMore()
+	}
+
+	s.WriteObjectField("timestamp")
+	s.SetBuffer(strconv.AppendFloat(s.Buffer(), float64(e.Time.UnixNano()/1e6)/1e3, 'f', 3, 64))
+	s.WriteMore()
+
+	s.WriteObjectField("level")
+	s.WriteUint8(uint8(e.Level))
+	s.WriteMore()
+
+	s.WriteObjectField("_level_name")
+	s.WriteString(e.Level.String())
+
+	unnamedFieldIdx := int16(0)
+	for i := range logLetter.Fields {
+		ge.encodeField(s, "_", &logLetter.Fields[i], &unnamedFieldIdx)
+	}
+
+	if errLetter != nil {
+		if errorID := errLetter.ErrorID(); errorID != "" {
+			s.WriteMore()
+			s.WriteObjectField("_error_id")
+			s.WriteString(errorID)
+		}
+		if errorClassName := errLetter.ErrorClassName(); errorClassName != "" {
+			s.WriteMore()
+			s.WriteObjectField("_error_class")
+			s.WriteString(errorClassName)
+		}
+		for i := range errLetter.Fields {
+			prefix := "_error_" + strconv.Itoa(int(errLetter.Fields[i].StackFrameIdx)) + "_"
+			ge.encodeField(s, prefix, &errLetter.Fields[i], &unnamedFieldIdx)
+		}
+	}
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// encodeField writes 'f' as GELF additional field to 's', using 'prefix'
+// as key's prefix. Writes JSON comma before. System fields are ignored.
+func (ge *CI_GELFEncoder) encodeField(
+
+	s *jsoniter.Stream,
+	prefix string,
+	f *ekaenc.Field,
+	unnamedFieldIdx *int16,
+) {
+	if f.IsHidden() {
+		return
+	}
+
+	key := prefix + sanitizeKey(f.KeyOrUnnamed(unnamedFieldIdx))
+	if key == "_id" {
+		// "_id" is reserved by GELF.
+		key = "_id_"
+	}
+
+	s.WriteMore()
+	s.WriteObjectField(key)
+
+	if f.IsNumber() {
+		f.WriteJSON(s)
+	} else {
+		s.WriteString(f.String())
+	}
+}
+
+// fullMessage returns GELF's "full_message": short message, error's ID, class
+// and the stacktrace (each stack frame per line) with error's messages.
+// Returns an empty string if there is no error nor stacktrace.
+func (ge *CI_GELFEncoder) fullMessage(
+
+	shortMessage string,
+	logLetter, errLetter *ekaenc.Letter,
+
+) string {
+
+	stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter)
+	if errLetter == nil && len(stackTrace) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(shortMessage)
+
+	if errLetter != nil {
+		sb.WriteString("\nError: ")
+		sb.WriteString(errLetter.ErrorClassName())
+		sb.WriteString(" (")
+		sb.WriteString(errLetter.ErrorID())
+		sb.WriteString(")")
+	}
+
+	for i := range stackTrace {
+		frame := &stackTrace[i]
+		frame.DoFormat()
+
+		sb.WriteString("\n[")
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteString("]: ")
+		sb.WriteString(frame.Format)
+
+		if errLetter != nil {
+			if message := errLetter.MessageOfFrame(int16(i)); message != "" {
+				sb.WriteString("\n    ")
+				sb.WriteString(message)
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// sanitizeKey replaces all chars in 'key' that are not allowed by GELF
+// for additional field's key (allowed: letters, digits, '_', '-', '.') by '_'.
+func sanitizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return '_'
+		}
+		return r
+	}, key)
+}
@@ -0,0 +1,94 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_gelf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+func TestCI_GELFEncoder_EncodeEntry(t *testing.T) {
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled").Throw()
+
+	tests := []struct {
+		name     string
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"fields",
+			func() *ekaerr.Error {
+				ekalog.Info("user logged in",
+					"user_id", 42, "admin", true, "user name", "alice", "id", "x", "ratio", 0.5)
+				return nil
+			},
+			`{"version":"1.1","host":"test-host","short_message":"user logged in",` +
+				`"timestamp":1622550645.123,"level":6,"_level_name":"Info",` +
+				`"_user_id":42,"_admin":"true","_user_name":"alice","_id_":"x","_ratio":0.5}`,
+		},
+		{
+			"no message",
+			func() *ekaerr.Error {
+				ekalog.Debug("")
+				return nil
+			},
+			`{"version":"1.1","host":"test-host","short_message":"<no message>",` +
+				`"timestamp":1622550645.123,"level":7,"_level_name":"Debug"}`,
+		},
+		{
+			"error's message",
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"version":"1.1","host":"test-host","short_message":"the user is not found",` +
+				`"full_message":"the user is not found\nError: NotFound (<error_id>)",` +
+				`"timestamp":1622550645.123,"level":3,"_level_name":"Error","_method":"GET",` +
+				`"_error_id":"<error_id>","_error_class":"NotFound","_error_0_path":"/users/42"}`,
+		},
+		{
+			"error's stacktrace",
+			func() *ekaerr.Error {
+				ekalog.Warne("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"version":"1.1","host":"test-host","short_message":"request failed",` +
+				`"full_message":"request failed\nError: Interrupted (<error_id>)\n` +
+				`[0]: encoders.gelf.TestCI_GELFEncoder_EncodeEntry (encoder_gelf_test.go:<line>) ` +
+				`github.com/qioalice/ekago_ext/v3/ekalog\n    the request is cancelled",` +
+				`"timestamp":1622550645.123,"level":4,"_level_name":"Warning",` +
+				`"_error_id":"<error_id>","_error_class":"Interrupted"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(NewEncoder().SetHost("test-host"), func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected GELF message:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
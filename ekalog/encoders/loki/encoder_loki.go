@@ -0,0 +1,297 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_loki
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_LokiEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as a Grafana Loki's stream object
+	// ( https://grafana.com/docs/loki/latest/api/#post-lokiapiv1push ),
+	// containing the only one value:
+	//
+	//     {"stream":{<labels>},"values":[["<unix nano>","<line>"]]}
+	//
+	// Use it with CI_WriterHttp's UseProviderLoki(), that groups streams
+	// with the same labels of the whole entries pack to the one stream.
+	//
+	// 1. Labels are:
+	//    - a level (lowercased level's name) with the name set by SetLevelLabel()
+	//      ("level" by default, an empty name disables it),
+	//    - "service" if SetService() has been called,
+	//    - static labels set by SetLabels(),
+	//    - log's or attached error's fields, which keys are set by SetFieldLabels().
+	//      A value of the first found field is used.
+	//    Labels are sorted by their names. Invalid chars in names are replaced by "_".
+	//    Labels with empty values are omitted. Of labels with the same name,
+	//    the first non-empty one (in the order above) is used.
+	//
+	//    Keep in mind, Loki does not like high cardinality labels.
+	//    DO NOT USE FIELDS LIKE USER ID OR REQUEST ID AS LABELS.
+	//
+	// 2. Line is a JSON object: "level", "message", then all log's fields
+	//    and all attached error's fields at the root (including those,
+	//    that are used as labels), then "error_id", "error_class"
+	//    and "stacktrace" (an array of "<function> <file>:<line>") if presented.
+	//
+	// A stream object has no trailing '\n', because streams are JSON array
+	// items of the push request.
+	CI_LokiEncoder struct {
+
+		ekaenc.NopEncoder
+
+		levelLabel   string
+		staticLabels []label
+		fieldLabels  []string
+	}
+
+	label struct {
+		name, value string
+	}
+)
+
+// NewEncoder creates a new CI_LokiEncoder.
+// Use its setters to specify labels. They are not thread-safe,
+// so call them before the encoder is registered with ekalog.CommonIntegrator.
+func NewEncoder() *CI_LokiEncoder {
+	return &CI_LokiEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		levelLabel: "level",
+	}
+}
+
+// SetLevelLabel sets the name of label, log entry's level is stored to.
+// Empty name means there is no level label.
+func (le *CI_LokiEncoder) SetLevelLabel(name string) *CI_LokiEncoder {
+	if le != nil {
+		le.levelLabel = sanitizeLabelName(name)
+	}
+	return le
+}
+
+// SetService sets "service" label.
+func (le *CI_LokiEncoder) SetService(service string) *CI_LokiEncoder {
+	return le.SetLabels("service", service)
+}
+
+// SetLabels adds static labels, that are attached to each log entry.
+// Pass them as name, value, name, value, ... (an odd name is ignored).
+// A label with the same name is replaced.
+func (le *CI_LokiEncoder) SetLabels(nameValue ...string) *CI_LokiEncoder {
+
+	if le == nil {
+		return le
+	}
+
+	for i := 0; i+1 < len(nameValue); i += 2 {
+		name := sanitizeLabelName(nameValue[i])
+		if name == "" {
+			continue
+		}
+
+		replaced := false
+		for j := range le.staticLabels {
+			if le.staticLabels[j].name == name {
+				le.staticLabels[j].value = nameValue[i+1]
+				replaced = true
+			}
+		}
+		if !replaced {
+			le.staticLabels = append(le.staticLabels, label{name, nameValue[i+1]})
+		}
+	}
+
+	return le
+}
+
+// SetFieldLabels sets the keys of the fields, that are stream's labels
+// in addition to the static ones. Keep their cardinality low.
+func (le *CI_LokiEncoder) SetFieldLabels(keys ...string) *CI_LokiEncoder {
+	if le != nil {
+		le.fieldLabels = append(le.fieldLabels[:0], keys...)
+	}
+	return le
+}
+
+// EncodeEntry encodes passed ekalog.Entry as Grafana Loki's stream object.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (le *CI_LokiEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+
+	levelName := e.Level.ToLower()
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("stream")
+	le.encodeLabels(s, levelName, logLetter, errLetter)
+	s.WriteMore()
+
+	s.WriteObjectField("values")
+	s.WriteArrayStart()
+	s.WriteArrayStart()
+	s.WriteString(strconv.FormatInt(e.Time.UnixNano(), 10))
+	s.WriteMore()
+	s.WriteString(le.encodeLine(levelName, logLetter, errLetter))
+	s.WriteArrayEnd()
+	s.WriteArrayEnd()
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// encodeLabels writes stream's labels as JSON object to 's'.
+func (le *CI_LokiEncoder) encodeLabels(
+
+	s *jsoniter.Stream,
+	levelName string,
+	logLetter, errLetter *ekaenc.Letter,
+) {
+	labels := make([]label, 0, 1+len(le.staticLabels)+len(le.fieldLabels))
+
+	if le.levelLabel != "" {
+		labels = append(labels, label{le.levelLabel, levelName})
+	}
+
+	labels = append(labels, le.staticLabels...)
+
+	for _, key := range le.fieldLabels {
+		if value, found := findField(logLetter, key); found {
+			labels = append(labels, label{sanitizeLabelName(key), value})
+		} else if value, found = findField(errLetter, key); found {
+			labels = append(labels, label{sanitizeLabelName(key), value})
+		}
+	}
+
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	s.WriteObjectStart()
+
+	// Labels with the same name are adjacent. The first non-empty one wins.
+	written, lastName := false, ""
+	for i := range labels {
+		if labels[i].value == "" || written && labels[i].name == lastName {
+			continue
+		}
+		if written {
+			s.WriteMore()
+		}
+		s.WriteObjectField(labels[i].name)
+		s.WriteString(labels[i].value)
+		written, lastName = true, labels[i].name
+	}
+
+	s.WriteObjectEnd()
+}
+
+// encodeLine returns JSON object, that is used as a stream's line.
+func (le *CI_LokiEncoder) encodeLine(
+
+	levelName string,
+	logLetter, errLetter *ekaenc.Letter,
+
+) string {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("level")
+	s.WriteString(levelName)
+	s.WriteMore()
+
+	s.WriteObjectField("message")
+	s.WriteString(ekaenc.EntryMessage(logLetter, errLetter))
+
+	unnamedFieldIdx := int16(0)
+	for i := range logLetter.Fields {
+		logLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+	}
+
+	if errLetter != nil {
+		for i := range errLetter.Fields {
+			errLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+		}
+
+		s.WriteMore()
+		s.WriteObjectField("error_id")
+		s.WriteString(errLetter.ErrorID())
+		s.WriteMore()
+		s.WriteObjectField("error_class")
+		s.WriteString(errLetter.ErrorClassName())
+	}
+
+	if stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter); len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("stacktrace")
+		s.WriteArrayStart()
+		for i := range stackTrace {
+			if i > 0 {
+				s.WriteMore()
+			}
+			s.WriteString(stackTrace[i].Function + " " +
+				stackTrace[i].File + ":" + strconv.Itoa(stackTrace[i].Line))
+		}
+		s.WriteArrayEnd()
+	}
+
+	s.WriteObjectEnd()
+
+	return string(s.Buffer())
+}
+
+// findField returns a string value of the first not system field of 'l',
+// which key is 'key'.
+func findField(l *ekaenc.Letter, key string) (string, bool) {
+	if l != nil {
+		for i := range l.Fields {
+			if !l.Fields[i].IsSystem() && l.Fields[i].Key == key {
+				return l.Fields[i].String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// sanitizeLabelName replaces all chars of 'name', that are not allowed
+// in Loki's (Prometheus's) label names, by "_".
+func sanitizeLabelName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_':
+		default:
+			return '_'
+		}
+		return r
+	}, name)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
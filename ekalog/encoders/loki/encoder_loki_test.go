@@ -0,0 +1,92 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_loki
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+func TestCI_LokiEncoder_EncodeEntry(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		enc      *CI_LokiEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"labels",
+			NewEncoder().SetService("api").SetLabels("env", "prod", "1st", "x").SetFieldLabels("region"),
+			func() *ekaerr.Error {
+				ekalog.Info("user logged in", "region", "eu", "user_id", 42)
+				return nil
+			},
+			`{"stream":{"_1st":"x","env":"prod","level":"info","region":"eu","service":"api"},` +
+				`"values":[["1622550645123456789",` +
+				`"{\"level\":\"info\",\"message\":\"user logged in\",\"region\":\"eu\",\"user_id\":42}"]]}`,
+		},
+		{
+			"empty static label, non-empty field label",
+			NewEncoder().SetLevelLabel("").SetLabels("region", "").SetFieldLabels("region"),
+			func() *ekaerr.Error {
+				ekalog.Debug("test", "region", "eu")
+				return nil
+			},
+			`{"stream":{"region":"eu"},` +
+				`"values":[["1622550645123456789",` +
+				`"{\"level\":\"debug\",\"message\":\"test\",\"region\":\"eu\"}"]]}`,
+		},
+		{
+			"static label wins over field label",
+			NewEncoder().SetLevelLabel("").SetLabels("region", "us").SetFieldLabels("region", "zone"),
+			func() *ekaerr.Error {
+				ekalog.Debug("test", "region", "eu", "zone", "")
+				return nil
+			},
+			`{"stream":{"region":"us"},` +
+				`"values":[["1622550645123456789",` +
+				`"{\"level\":\"debug\",\"message\":\"test\",\"region\":\"eu\",\"zone\":\"\"}"]]}`,
+		},
+		{
+			"error",
+			NewEncoder().SetFieldLabels("path"),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err)
+				return err
+			},
+			`{"stream":{"level":"error","path":"/users/42"},` +
+				`"values":[["1622550645123456789",` +
+				`"{\"level\":\"error\",\"message\":\"the user is not found\",\"path\":\"/users/42\",` +
+				`\"error_id\":\"<error_id>\",\"error_class\":\"NotFound\"}"]]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected Loki's stream:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_otlp
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_OTLPEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as OpenTelemetry's LogRecord
+	// ( https://opentelemetry.io/docs/specs/otel/logs/data-model/ )
+	// in OTLP/JSON (by default) or OTLP/protobuf (see SetProtobuf()) format.
+	//
+	// Use it with CI_WriterHttp's UseProviderOTLP(), that wraps an entries pack
+	// to the ExportLogsServiceRequest. The formats MUST BE the same.
+	//
+	// 1. "timeUnixNano" is log entry's time, "observedTimeUnixNano"
+	//    is the time log entry is encoded at.
+	//
+	// 2. "severityNumber" is OpenTelemetry's severity number
+	//    (DEBUG, INFO, INFO2, WARN, ERROR, ERROR2, FATAL, FATAL2
+	//    for ekalog's levels from debug to emergency),
+	//    "severityText" is ekalog's level name.
+	//
+	// 3. "body" is log entry's message as string.
+	//
+	// 4. "attributes" are all log's fields and all attached error's fields.
+	//    Bools, integers and floats are kept typed, all the rest are strings.
+	//    If there is an attached ekaerr.Error, "exception.type" is its class name,
+	//    "exception.message" is all error's messages (from the last to the first,
+	//    separated by ": "), "exception.stacktrace" is its stacktrace
+	//    in the Go's debug.Stack() format, and "exception.id" is its ID.
+	//
+	// 5. "traceId" and "spanId" are taken from the log's or error's fields
+	//    with keys set by SetTraceFields() ("trace_id", "span_id" by default),
+	//    if they are valid hex encoded IDs (32 and 16 chars). Such fields
+	//    are not attributes then.
+	//
+	// In OTLP/protobuf format, encoded log entry is the log_records field
+	// of ScopeLogs message (with its tag and length), so the entries
+	// might be just concatenated.
+	CI_OTLPEncoder struct {
+
+		ekaenc.NopEncoder
+
+		protobuf   bool
+		traceIDKey string
+		spanIDKey  string
+	}
+
+	// attribute is an OpenTelemetry's attribute, that is encoded
+	// as KeyValue with AnyValue of one of the kinds.
+	attribute struct {
+		key  string
+		kind uint8
+		s    string
+		i    int64
+		f    float64
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_ATTR_KIND_STRING uint8 = iota
+	_ATTR_KIND_BOOL
+	_ATTR_KIND_INT
+	_ATTR_KIND_DOUBLE
+	_ATTR_KIND_EMPTY
+)
+
+// NewEncoder creates a new CI_OTLPEncoder, that uses OTLP/JSON format.
+// The format must match CI_WriterHttp's UseProviderOTLP() 'protobuf' flag,
+// so call SetProtobuf() before the encoder is registered.
+func NewEncoder() *CI_OTLPEncoder {
+	return &CI_OTLPEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		traceIDKey: "trace_id",
+		spanIDKey:  "span_id",
+	}
+}
+
+// SetProtobuf switches the encoder to OTLP/protobuf format (if 'protobuf' is true).
+func (oe *CI_OTLPEncoder) SetProtobuf(protobuf bool) *CI_OTLPEncoder {
+	if oe != nil {
+		oe.protobuf = protobuf
+	}
+	return oe
+}
+
+// SetTraceFields sets the keys of the fields, LogRecord's "traceId"
+// and "spanId" are decoded from. Empty key disables decoding.
+func (oe *CI_OTLPEncoder) SetTraceFields(traceIDKey, spanIDKey string) *CI_OTLPEncoder {
+	if oe != nil {
+		oe.traceIDKey, oe.spanIDKey = traceIDKey, spanIDKey
+	}
+	return oe
+}
+
+// EncodeEntry encodes passed ekalog.Entry as OpenTelemetry's LogRecord.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (oe *CI_OTLPEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+
+	var (
+		traceID, spanID []byte
+		attributes      = make([]attribute, 0, 16)
+		unnamedFieldIdx = int16(0)
+	)
+
+	for _, l := range []*ekaenc.Letter{logLetter, errLetter} {
+		if l == nil {
+			continue
+		}
+		for i := range l.Fields {
+			f := &l.Fields[i]
+			switch {
+			case f.IsHidden():
+			case traceID == nil && oe.traceIDKey != "" && f.Key == oe.traceIDKey &&
+				decodeID(f.String(), 16, &traceID):
+			case spanID == nil && oe.spanIDKey != "" && f.Key == oe.spanIDKey &&
+				decodeID(f.String(), 8, &spanID):
+			default:
+				attributes = append(attributes, attributeOf(f, &unnamedFieldIdx))
+			}
+		}
+	}
+
+	if errLetter != nil {
+		attributes = append(attributes,
+			attribute{key: "exception.type", s: errLetter.ErrorClassName()},
+			attribute{key: "exception.message", s: ekaenc.ErrorMessage(logLetter, errLetter)},
+			attribute{key: "exception.id", s: errLetter.ErrorID()},
+		)
+		if stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter); len(stackTrace) > 0 {
+			attributes = append(attributes,
+				attribute{key: "exception.stacktrace", s: ekaenc.FormatGoStack(stackTrace)})
+		}
+	}
+
+	var (
+		timeUnixNano         = uint64(e.Time.UnixNano())
+		observedTimeUnixNano = uint64(time.Now().UnixNano())
+		severityNumber       = severityNumber(e.Level)
+		severityText         = e.Level.String()
+		body                 = ekaenc.EntryMessage(logLetter, errLetter)
+	)
+
+	if oe.protobuf {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, timeUnixNano)
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, severityNumber)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, severityText)
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendAnyValue(nil, &attribute{s: body}))
+		for i := range attributes {
+			b = protowire.AppendTag(b, 6, protowire.BytesType)
+			b = protowire.AppendBytes(b, appendKeyValue(nil, &attributes[i]))
+		}
+		if traceID != nil {
+			b = protowire.AppendTag(b, 9, protowire.BytesType)
+			b = protowire.AppendBytes(b, traceID)
+		}
+		if spanID != nil {
+			b = protowire.AppendTag(b, 10, protowire.BytesType)
+			b = protowire.AppendBytes(b, spanID)
+		}
+		b = protowire.AppendTag(b, 11, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, observedTimeUnixNano)
+
+		// ScopeLogs.log_records
+		record := protowire.AppendTag(nil, 2, protowire.BytesType)
+		return protowire.AppendBytes(record, b)
+	}
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("timeUnixNano")
+	s.WriteString(strconv.FormatUint(timeUnixNano, 10))
+	s.WriteMore()
+
+	s.WriteObjectField("observedTimeUnixNano")
+	s.WriteString(strconv.FormatUint(observedTimeUnixNano, 10))
+	s.WriteMore()
+
+	s.WriteObjectField("severityNumber")
+	s.WriteUint64(severityNumber)
+	s.WriteMore()
+
+	s.WriteObjectField("severityText")
+	s.WriteString(severityText)
+	s.WriteMore()
+
+	s.WriteObjectField("body")
+	writeAnyValue(s, &attribute{s: body})
+	s.WriteMore()
+
+	s.WriteObjectField("attributes")
+	s.WriteArrayStart()
+	for i := range attributes {
+		if i > 0 {
+			s.WriteMore()
+		}
+		s.WriteObjectStart()
+		s.WriteObjectField("key")
+		s.WriteString(attributes[i].key)
+		s.WriteMore()
+		s.WriteObjectField("value")
+		writeAnyValue(s, &attributes[i])
+		s.WriteObjectEnd()
+	}
+	s.WriteArrayEnd()
+
+	if traceID != nil {
+		s.WriteMore()
+		s.WriteObjectField("traceId")
+		s.WriteString(hex.EncodeToString(traceID))
+	}
+
+	if spanID != nil {
+		s.WriteMore()
+		s.WriteObjectField("spanId")
+		s.WriteString(hex.EncodeToString(spanID))
+	}
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// attributeOf returns an attribute for 'f'.
+func attributeOf(f *ekaenc.Field, unnamedFieldIdx *int16) attribute {
+
+	a := attribute{key: f.KeyOrUnnamed(unnamedFieldIdx)}
+
+	switch {
+	case f.IsNil():
+		a.kind = _ATTR_KIND_EMPTY
+	case f.BaseType() == ekaenc.KIND_TYPE_BOOL:
+		a.kind, a.i = _ATTR_KIND_BOOL, f.IValue
+	case f.IsFloat():
+		a.kind, a.f = _ATTR_KIND_DOUBLE, f.Float64()
+	case f.IsNumber():
+		a.kind, a.i = _ATTR_KIND_INT, f.IValue
+	default:
+		a.kind, a.s = _ATTR_KIND_STRING, f.String()
+	}
+
+	return a
+}
+
+// writeAnyValue writes 'a's value as OTLP/JSON AnyValue to 's'.
+func writeAnyValue(s *jsoniter.Stream, a *attribute) {
+
+	s.WriteObjectStart()
+
+	switch a.kind {
+	case _ATTR_KIND_STRING:
+		s.WriteObjectField("stringValue")
+		s.WriteString(a.s)
+	case _ATTR_KIND_BOOL:
+		s.WriteObjectField("boolValue")
+		s.WriteBool(a.i != 0)
+	case _ATTR_KIND_INT:
+		s.WriteObjectField("intValue")
+		s.WriteString(strconv.FormatInt(a.i, 10))
+	case _ATTR_KIND_DOUBLE:
+		s.WriteObjectField("doubleValue")
+		s.WriteFloat64(a.f)
+	}
+
+	s.WriteObjectEnd()
+}
+
+// appendKeyValue appends 'a' as OTLP/protobuf KeyValue to 'b'.
+func appendKeyValue(b []byte, a *attribute) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, a.key)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, appendAnyValue(nil, a))
+}
+
+// appendAnyValue appends 'a's value as OTLP/protobuf AnyValue to 'b'.
+func appendAnyValue(b []byte, a *attribute) []byte {
+
+	switch a.kind {
+	case _ATTR_KIND_STRING:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, a.s)
+	case _ATTR_KIND_BOOL:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(a.i != 0))
+	case _ATTR_KIND_INT:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(a.i))
+	case _ATTR_KIND_DOUBLE:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(a.f))
+	}
+
+	return b
+}
+
+// decodeID decodes hex encoded trace or span ID 's' to 'dest',
+// if it's valid and has exactly 'n' bytes.
+func decodeID(s string, n int, dest *[]byte) bool {
+
+	if len(s) != 2*n {
+		return false
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+
+	*dest = b
+	return true
+}
+
+// severityNumber returns OpenTelemetry's severity number for ekalog.Level.
+func severityNumber(l ekalog.Level) uint64 {
+	switch l {
+	case ekalog.LEVEL_EMERGENCY:
+		return 22 // FATAL2
+	case ekalog.LEVEL_ALERT:
+		return 21 // FATAL
+	case ekalog.LEVEL_CRITICAL:
+		return 18 // ERROR2
+	case ekalog.LEVEL_ERROR:
+		return 17 // ERROR
+	case ekalog.LEVEL_WARNING:
+		return 13 // WARN
+	case ekalog.LEVEL_NOTICE:
+		return 10 // INFO2
+	case ekalog.LEVEL_INFO:
+		return 9 // INFO
+	default:
+		return 5 // DEBUG
+	}
+}
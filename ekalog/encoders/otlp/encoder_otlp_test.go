@@ -0,0 +1,169 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_otlp
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// observedTimeRegexp matches "observedTimeUnixNano", that is the time
+// log entry is encoded at, and thus can't be a part of the expected output.
+var observedTimeRegexp = regexp.MustCompile(`"observedTimeUnixNano":"\d+"`)
+
+func TestCI_OTLPEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled").Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_OTLPEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"fields",
+			NewEncoder(),
+			func() *ekaerr.Error {
+				ekalog.Info("user logged in",
+					"user_id", 42, "admin", true, "user name", "alice", "ratio", 0.5)
+				return nil
+			},
+			`{"timeUnixNano":"1622550645123456789","observedTimeUnixNano":"<now>",` +
+				`"severityNumber":9,"severityText":"Info","body":{"stringValue":"user logged in"},` +
+				`"attributes":[` +
+				`{"key":"user_id","value":{"intValue":"42"}},` +
+				`{"key":"admin","value":{"boolValue":true}},` +
+				`{"key":"user name","value":{"stringValue":"alice"}},` +
+				`{"key":"ratio","value":{"doubleValue":0.5}}]}`,
+		},
+		{
+			"trace",
+			NewEncoder().SetTraceFields("trace", "span"),
+			func() *ekaerr.Error {
+				ekalog.Debug("test",
+					"trace", "0102030405060708090a0b0c0d0e0f10", "span", "not an ID", "span", "0102030405060708")
+				return nil
+			},
+			`{"timeUnixNano":"1622550645123456789","observedTimeUnixNano":"<now>",` +
+				`"severityNumber":5,"severityText":"Debug","body":{"stringValue":"test"},` +
+				`"attributes":[{"key":"span","value":{"stringValue":"not an ID"}}],` +
+				`"traceId":"0102030405060708090a0b0c0d0e0f10","spanId":"0102030405060708"}`,
+		},
+		{
+			"error's message",
+			NewEncoder(),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"timeUnixNano":"1622550645123456789","observedTimeUnixNano":"<now>",` +
+				`"severityNumber":17,"severityText":"Error","body":{"stringValue":"the user is not found"},` +
+				`"attributes":[` +
+				`{"key":"method","value":{"stringValue":"GET"}},` +
+				`{"key":"path","value":{"stringValue":"/users/42"}},` +
+				`{"key":"exception.type","value":{"stringValue":"NotFound"}},` +
+				`{"key":"exception.message","value":{"stringValue":"the user is not found"}},` +
+				`{"key":"exception.id","value":{"stringValue":"<error_id>"}}]}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder(),
+			func() *ekaerr.Error {
+				ekalog.Warne("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"timeUnixNano":"1622550645123456789","observedTimeUnixNano":"<now>",` +
+				`"severityNumber":13,"severityText":"Warning","body":{"stringValue":"request failed"},` +
+				`"attributes":[` +
+				`{"key":"exception.type","value":{"stringValue":"Interrupted"}},` +
+				`{"key":"exception.message","value":{"stringValue":"the request is cancelled"}},` +
+				`{"key":"exception.id","value":{"stringValue":"<error_id>"}},` +
+				`{"key":"exception.stacktrace","value":{"stringValue":"goroutine 1 [running]:\n` +
+				`github.com/qioalice/ekago_ext/v3/ekalog/encoders/otlp.TestCI_OTLPEncoder_EncodeEntry(...)\n` +
+				`\t<dir>/encoder_otlp_test.go:<line>"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			encoded = observedTimeRegexp.ReplaceAllString(encoded, `"observedTimeUnixNano":"<now>"`)
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected LogRecord:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCI_OTLPEncoder_EncodeEntry_Protobuf(t *testing.T) {
+
+	encoded := ekaenctest.Encode(NewEncoder().SetProtobuf(true), func() {
+		ekalog.Warn("disk is almost full", "free", 5, "trace_id", "0102030405060708090a0b0c0d0e0f10")
+	})
+
+	var expected []byte
+	expected = protowire.AppendTag(expected, 1, protowire.Fixed64Type)
+	expected = protowire.AppendFixed64(expected, 1622550645123456789)
+	expected = protowire.AppendTag(expected, 2, protowire.VarintType)
+	expected = protowire.AppendVarint(expected, 13)
+	expected = protowire.AppendTag(expected, 3, protowire.BytesType)
+	expected = protowire.AppendString(expected, "Warning")
+	expected = protowire.AppendTag(expected, 5, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, appendAnyValue(nil, &attribute{s: "disk is almost full"}))
+	expected = protowire.AppendTag(expected, 6, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, appendKeyValue(nil, &attribute{key: "free", kind: _ATTR_KIND_INT, i: 5}))
+	expected = protowire.AppendTag(expected, 9, protowire.BytesType)
+	expected = protowire.AppendBytes(expected, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+
+	// ScopeLogs.log_records, which ends with "observed_time_unix_nano",
+	// that is the time log entry is encoded at.
+	record, n := []byte(encoded), 0
+	if num, typ, n := protowire.ConsumeTag(record); num != 2 || typ != protowire.BytesType {
+		t.Fatalf("encoded log entry is not ScopeLogs.log_records: field %d of type %d", num, typ)
+	} else {
+		record = record[n:]
+	}
+	if record, n = protowire.ConsumeBytes(record); n < 0 || n != len(encoded)-protowire.SizeTag(2) {
+		t.Fatalf("malformed ScopeLogs.log_records: %q", encoded)
+	}
+
+	observedTimeTag := protowire.AppendTag(nil, 11, protowire.Fixed64Type)
+	observedTimeIdx := len(record) - len(observedTimeTag) - 8
+
+	switch {
+	case observedTimeIdx < 0 || string(record[observedTimeIdx:][:len(observedTimeTag)]) != string(observedTimeTag):
+		t.Fatalf("LogRecord doesn't end with observed_time_unix_nano: %x", record)
+	case string(record[:observedTimeIdx]) != string(expected):
+		t.Fatalf("unexpected LogRecord:\n got: %x\nwant: %x", record[:observedTimeIdx], expected)
+	}
+}
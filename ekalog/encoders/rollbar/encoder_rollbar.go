@@ -0,0 +1,318 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_rollbar
+
+import (
+	"encoding/hex"
+	"os"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_RollbarEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as Rollbar item ( https://explorer.docs.rollbar.com/#operation/create-item ):
+	// {"data":{...}}. Access token is not encoded, it's sent as HTTP header
+	// (see ekalog_writer_http.CI_WriterHttp's UseProviderRollbar()).
+	//
+	// 1. "environment" is "production" by default (see SetEnvironment()),
+	//    "platform" is "go", "language" is "go", "uuid" is random.
+	//    "server.host" is os.Hostname() by default (see SetServerHost()).
+	//
+	// 2. "level" is one of Rollbar's 5 levels: "critical" for the levels
+	//    above Error, Notice is "info", the rest are the same.
+	//
+	// 3. If there is an attached ekaerr.Error with stacktrace,
+	//    "body" is "trace", frames of which are error's stacktrace
+	//    (in Rollbar's order: the oldest frame first), error's fields and messages
+	//    of each stack frame are frame's "locals", exception's class is
+	//    error's class name, exception's message is log entry's message
+	//    or error's one.
+	//    Error's ID is "error_id" in "custom".
+	//    "fingerprint" is error's class name and the function, error is created in.
+	//
+	// 4. Otherwise "body" is "message", that has log entry's message as "body".
+	//
+	// 5. Log's fields are "custom".
+	//
+	// Encoded item has no trailing '\n'. Rollbar accepts only one item
+	// per HTTP request.
+	CI_RollbarEncoder struct {
+
+		ekaenc.NopEncoder
+
+		environment string
+		codeVersion string
+		serverHost  string
+	}
+)
+
+// NewEncoder creates a new CI_RollbarEncoder.
+func NewEncoder() *CI_RollbarEncoder {
+
+	serverHost, _ := os.Hostname()
+
+	return &CI_RollbarEncoder{
+		NopEncoder:  ekaenc.NewNopEncoder(),
+		environment: "production",
+		serverHost:  serverHost,
+	}
+}
+
+// SetEnvironment sets Rollbar item's "environment". Empty string is ignored.
+func (re *CI_RollbarEncoder) SetEnvironment(environment string) *CI_RollbarEncoder {
+	if re != nil && environment != "" {
+		re.environment = environment
+	}
+	return re
+}
+
+// SetCodeVersion sets Rollbar item's "code_version" (like git commit).
+func (re *CI_RollbarEncoder) SetCodeVersion(codeVersion string) *CI_RollbarEncoder {
+	if re != nil {
+		re.codeVersion = codeVersion
+	}
+	return re
+}
+
+// SetServerHost sets Rollbar item's "server.host".
+func (re *CI_RollbarEncoder) SetServerHost(serverHost string) *CI_RollbarEncoder {
+	if re != nil {
+		re.serverHost = serverHost
+	}
+	return re
+}
+
+// EncodeEntry encodes passed ekalog.Entry as Rollbar item.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (re *CI_RollbarEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+	message := ekaenc.EntryMessage(logLetter, errLetter)
+
+	s.WriteObjectStart()
+	s.WriteObjectField("data")
+	s.WriteObjectStart()
+
+	s.WriteObjectField("environment")
+	s.WriteString(re.environment)
+	s.WriteMore()
+
+	s.WriteObjectField("level")
+	s.WriteString(ekaenc.LevelOf5(e.Level, "critical"))
+	s.WriteMore()
+
+	s.WriteObjectField("timestamp")
+	s.WriteInt64(e.Time.Unix())
+	s.WriteMore()
+
+	s.WriteObjectField("platform")
+	s.WriteString("go")
+	s.WriteMore()
+
+	s.WriteObjectField("language")
+	s.WriteString("go")
+	s.WriteMore()
+
+	s.WriteObjectField("uuid")
+	s.WriteString(uuid4())
+	s.WriteMore()
+
+	if re.codeVersion != "" {
+		s.WriteObjectField("code_version")
+		s.WriteString(re.codeVersion)
+		s.WriteMore()
+	}
+
+	if re.serverHost != "" {
+		s.WriteObjectField("server")
+		s.WriteObjectStart()
+		s.WriteObjectField("host")
+		s.WriteString(re.serverHost)
+		s.WriteObjectEnd()
+		s.WriteMore()
+	}
+
+	s.WriteObjectField("notifier")
+	s.WriteObjectStart()
+	s.WriteObjectField("name")
+	s.WriteString("ekago_ext.rollbar")
+	s.WriteMore()
+	s.WriteObjectField("version")
+	s.WriteString("3")
+	s.WriteObjectEnd()
+	s.WriteMore()
+
+	s.WriteObjectField("body")
+	if errLetter != nil && len(errLetter.StackTrace) > 0 {
+		re.encodeTrace(s, message, errLetter)
+
+		s.WriteMore()
+		s.WriteObjectField("fingerprint")
+		s.WriteString(errLetter.ErrorClassName() + ":" + errLetter.StackTrace[0].Function)
+
+	} else {
+		s.WriteObjectStart()
+		s.WriteObjectField("message")
+		s.WriteObjectStart()
+		s.WriteObjectField("body")
+		s.WriteString(message)
+		s.WriteObjectEnd()
+		s.WriteObjectEnd()
+	}
+
+	re.encodeCustom(s, logLetter, errLetter)
+
+	s.WriteObjectEnd()
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// encodeTrace writes Rollbar item's "trace" body to 's'.
+func (re *CI_RollbarEncoder) encodeTrace(s *jsoniter.Stream, message string, errLetter *ekaenc.Letter) {
+
+	s.WriteObjectStart()
+	s.WriteObjectField("trace")
+	s.WriteObjectStart()
+
+	s.WriteObjectField("frames")
+	s.WriteArrayStart()
+
+	for i := len(errLetter.StackTrace) - 1; i >= 0; i-- {
+		frame := &errLetter.StackTrace[i]
+		_, fn := ekaenc.SplitFunction(frame.Function)
+
+		s.WriteObjectStart()
+
+		s.WriteObjectField("filename")
+		s.WriteString(frame.File)
+		s.WriteMore()
+
+		s.WriteObjectField("lineno")
+		s.WriteInt(frame.Line)
+		s.WriteMore()
+
+		s.WriteObjectField("method")
+		s.WriteString(fn)
+
+		frameMessage := errLetter.MessageOfFrame(int16(i))
+		fields := errLetter.FieldsOfFrame(int16(i))
+
+		if frameMessage != "" || len(fields) > 0 {
+			s.WriteMore()
+			s.WriteObjectField("locals")
+			s.WriteObjectStart()
+
+			unnamedFieldIdx := int16(0)
+			for j := range fields {
+				if j > 0 {
+					s.WriteMore()
+				}
+				s.WriteObjectField(fields[j].KeyOrUnnamed(&unnamedFieldIdx))
+				fields[j].WriteJSON(s)
+			}
+
+			if frameMessage != "" {
+				if len(fields) > 0 {
+					s.WriteMore()
+				}
+				s.WriteObjectField("message")
+				s.WriteString(frameMessage)
+			}
+
+			s.WriteObjectEnd()
+		}
+
+		s.WriteObjectEnd()
+
+		if i > 0 {
+			s.WriteMore()
+		}
+	}
+
+	s.WriteArrayEnd()
+	s.WriteMore()
+
+	s.WriteObjectField("exception")
+	s.WriteObjectStart()
+	s.WriteObjectField("class")
+	s.WriteString(errLetter.ErrorClassName())
+	s.WriteMore()
+	s.WriteObjectField("message")
+	s.WriteString(message)
+	s.WriteObjectEnd()
+
+	s.WriteObjectEnd()
+	s.WriteObjectEnd()
+}
+
+// encodeCustom writes Rollbar item's "custom" section to 's' (JSON comma before)
+// using log entry's fields and error's ID. Writes nothing if there are no them.
+func (re *CI_RollbarEncoder) encodeCustom(s *jsoniter.Stream, logLetter, errLetter *ekaenc.Letter) {
+
+	var (
+		unnamedFieldIdx int16
+		wasField        bool
+	)
+
+	writeKey := func(key string) {
+		s.WriteMore()
+		if !wasField {
+			s.WriteObjectField("custom")
+			s.WriteObjectStart()
+			wasField = true
+		}
+		s.WriteObjectField(key)
+	}
+
+	if errLetter != nil {
+		if errorID := errLetter.ErrorID(); errorID != "" {
+			writeKey("error_id")
+			s.WriteString(errorID)
+		}
+		// Lightweight error has no stacktrace, so its fields are "custom".
+		if len(errLetter.StackTrace) == 0 {
+			for i := range errLetter.Fields {
+				writeKey(errLetter.Fields[i].KeyOrUnnamed(&unnamedFieldIdx))
+				errLetter.Fields[i].WriteJSON(s)
+			}
+		}
+	}
+
+	for i := range logLetter.Fields {
+		f := &logLetter.Fields[i]
+		if !f.IsHidden() {
+			writeKey(f.KeyOrUnnamed(&unnamedFieldIdx))
+			f.WriteJSON(s)
+		}
+	}
+
+	if wasField {
+		s.WriteObjectEnd()
+	}
+}
+
+// uuid4 generates a new random UUID4 in its canonical (dashed) form,
+// Rollbar requires for item's "uuid".
+func uuid4() string {
+	uuid := ekaenc.UUID4()
+	h := hex.EncodeToString(uuid[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
@@ -0,0 +1,111 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_rollbar
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+var (
+	// uuidRegexp matches item's "uuid", that is random,
+	// and thus can't be a part of the expected output.
+	uuidRegexp = regexp.MustCompile(`"uuid":"[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"`)
+
+	// linenoRegexp matches stack frame's line, like ekaenctest.HideLines() does.
+	linenoRegexp = regexp.MustCompile(`"lineno":\d+`)
+)
+
+func TestCI_RollbarEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled", "attempt", 2).Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_RollbarEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"message",
+			NewEncoder().SetServerHost("test-host").SetCodeVersion("v1"),
+			func() *ekaerr.Error {
+				ekalog.Notice("user logged in", "user_id", 42, "admin", true)
+				return nil
+			},
+			`{"data":{"environment":"production","level":"info","timestamp":1622550645,` +
+				`"platform":"go","language":"go","uuid":"<uuid>","code_version":"v1",` +
+				`"server":{"host":"test-host"},"notifier":{"name":"ekago_ext.rollbar","version":"3"},` +
+				`"body":{"message":{"body":"user logged in"}},` +
+				`"custom":{"user_id":42,"admin":true}}}`,
+		},
+		{
+			"error's message",
+			NewEncoder().SetServerHost("").SetEnvironment("staging"),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"data":{"environment":"staging","level":"error","timestamp":1622550645,` +
+				`"platform":"go","language":"go","uuid":"<uuid>",` +
+				`"notifier":{"name":"ekago_ext.rollbar","version":"3"},` +
+				`"body":{"message":{"body":"the user is not found"}},` +
+				`"custom":{"error_id":"<error_id>","path":"/users/42","method":"GET"}}}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder().SetServerHost(""),
+			func() *ekaerr.Error {
+				ekalog.Crite("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"data":{"environment":"production","level":"critical","timestamp":1622550645,` +
+				`"platform":"go","language":"go","uuid":"<uuid>",` +
+				`"notifier":{"name":"ekago_ext.rollbar","version":"3"},` +
+				`"body":{"trace":{"frames":[{"filename":"<dir>/encoder_rollbar_test.go","lineno":<line>,` +
+				`"method":"TestCI_RollbarEncoder_EncodeEntry",` +
+				`"locals":{"attempt":2,"message":"the request is cancelled"}}],` +
+				`"exception":{"class":"Interrupted","message":"request failed"}}},` +
+				`"fingerprint":"Interrupted:` +
+				`github.com/qioalice/ekago_ext/v3/ekalog/encoders/rollbar.TestCI_RollbarEncoder_EncodeEntry",` +
+				`"custom":{"error_id":"<error_id>"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			encoded = linenoRegexp.ReplaceAllString(encoded, `"lineno":<line>`)
+			encoded = uuidRegexp.ReplaceAllString(encoded, `"uuid":"<uuid>"`)
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected Rollbar item:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
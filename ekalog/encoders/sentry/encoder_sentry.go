@@ -0,0 +1,391 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_sentry
+
+import (
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+	"github.com/qioalice/ekago/v3/ekasys"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_SentryEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as Sentry event ( https://develop.sentry.dev/sdk/event-payloads/ ).
+	//
+	// 1. "event_id" is random, "platform" is "go", "logger" is "ekalog".
+	//
+	// 2. "level" is Sentry's "fatal" for Emergency, Alert, Critical
+	//    and "info" for Notice. Error, Warning, Info, Debug are kept.
+	//
+	// 3. "logentry" contains log entry's message, the last error's one
+	//    if it's empty.
+	//
+	// 4. If there is an attached ekaerr.Error, "exception" contains one value,
+	//    type of which is error's class name, value is log entry's message
+	//    and all error's messages (from the last to the first,
+	//    separated by ": "), and stacktrace frames
+	//    are error's stacktrace (in Sentry's order: the oldest frame first).
+	//    Error's fields and messages of each stack frame
+	//    are frame's "vars". Error's ID is "error_id" tag.
+	//    Otherwise, if log entry has a stacktrace, it's a current thread's one.
+	//
+	// 5. Log's fields are "tags", but only if their keys are not longer
+	//    than 32 chars and values are not longer than 200 chars (Sentry's limits).
+	//    All the rest are "extra".
+	//
+	// 6. "fingerprint" is error's class name and the function, error is created in.
+	//    It's omitted (Sentry's default grouping) if there is no error.
+	//
+	// Encoded event is just a JSON object w/o trailing '\n'. It must be sent
+	// as an item of Sentry's envelope (see ekalog_writer_http.CI_WriterHttp's
+	// UseProviderSentry()).
+	CI_SentryEncoder struct {
+
+		ekaenc.NopEncoder
+
+		serverName  string
+		environment string
+		release     string
+	}
+)
+
+// NewEncoder creates a new CI_SentryEncoder.
+// Server name is os.Hostname() by default.
+func NewEncoder() *CI_SentryEncoder {
+
+	serverName, _ := os.Hostname()
+
+	return &CI_SentryEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		serverName: serverName,
+	}
+}
+
+// SetServerName sets Sentry event's "server_name".
+func (se *CI_SentryEncoder) SetServerName(serverName string) *CI_SentryEncoder {
+	if se != nil {
+		se.serverName = serverName
+	}
+	return se
+}
+
+// SetEnvironment sets Sentry event's "environment" (like "production").
+func (se *CI_SentryEncoder) SetEnvironment(environment string) *CI_SentryEncoder {
+	if se != nil {
+		se.environment = environment
+	}
+	return se
+}
+
+// SetRelease sets Sentry event's "release" (like app's version or git commit).
+func (se *CI_SentryEncoder) SetRelease(release string) *CI_SentryEncoder {
+	if se != nil {
+		se.release = release
+	}
+	return se
+}
+
+// EncodeEntry encodes passed ekalog.Entry as Sentry event.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (se *CI_SentryEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+	stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter)
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("event_id")
+	eventID := ekaenc.UUID4()
+	s.WriteString(hex.EncodeToString(eventID[:]))
+	s.WriteMore()
+
+	s.WriteObjectField("timestamp")
+	s.SetBuffer(strconv.AppendFloat(s.Buffer(), float64(e.Time.UnixNano()/1e3)/1e6, 'f', 6, 64))
+	s.WriteMore()
+
+	s.WriteObjectField("platform")
+	s.WriteString("go")
+	s.WriteMore()
+
+	s.WriteObjectField("logger")
+	s.WriteString("ekalog")
+	s.WriteMore()
+
+	s.WriteObjectField("level")
+	s.WriteString(ekaenc.LevelOf5(e.Level, "fatal"))
+	s.WriteMore()
+
+	s.WriteObjectField("logentry")
+	s.WriteObjectStart()
+	s.WriteObjectField("formatted")
+	s.WriteString(ekaenc.EntryMessage(logLetter, errLetter))
+	s.WriteObjectEnd()
+
+	ekaenc.WriteStringIfNotEmpty(s, "server_name", se.serverName)
+	ekaenc.WriteStringIfNotEmpty(s, "environment", se.environment)
+	ekaenc.WriteStringIfNotEmpty(s, "release", se.release)
+
+	if errLetter != nil {
+		s.WriteMore()
+		se.encodeException(s, logLetter, errLetter, stackTrace)
+
+		if len(stackTrace) > 0 {
+			s.WriteMore()
+			s.WriteObjectField("fingerprint")
+			s.WriteArrayStart()
+			s.WriteString(errLetter.ErrorClassName())
+			s.WriteMore()
+			s.WriteString(stackTrace[0].Function)
+			s.WriteArrayEnd()
+		}
+
+	} else if len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("threads")
+		s.WriteObjectStart()
+		s.WriteObjectField("values")
+		s.WriteArrayStart()
+		s.WriteObjectStart()
+		s.WriteObjectField("current")
+		s.WriteTrue()
+		s.WriteMore()
+		se.encodeStackTrace(s, nil, stackTrace)
+		s.WriteObjectEnd()
+		s.WriteArrayEnd()
+		s.WriteObjectEnd()
+	}
+
+	se.encodeTagsAndExtra(s, logLetter, errLetter)
+
+	s.WriteMore()
+	s.WriteObjectField("sdk")
+	s.WriteObjectStart()
+	s.WriteObjectField("name")
+	s.WriteString("ekago_ext.sentry")
+	s.WriteMore()
+	s.WriteObjectField("version")
+	s.WriteString("3")
+	s.WriteObjectEnd()
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// encodeException writes Sentry event's "exception" section to 's'.
+func (se *CI_SentryEncoder) encodeException(
+
+	s *jsoniter.Stream,
+	logLetter, errLetter *ekaenc.Letter,
+	stackTrace ekasys.StackTrace,
+) {
+	var (
+		sb   strings.Builder
+		last = ekaenc.EntryMessage(logLetter, errLetter)
+	)
+
+	// The last error's message might be used as log entry's one,
+	// and it's empty then. Avoid duplicates anyway.
+	sb.WriteString(last)
+	for i := len(errLetter.Messages) - 1; i >= 0; i-- {
+		if body := errLetter.Messages[i].Body; body != "" && body != last {
+			if sb.Len() > 0 {
+				sb.WriteString(": ")
+			}
+			sb.WriteString(body)
+			last = body
+		}
+	}
+
+	s.WriteObjectField("exception")
+	s.WriteObjectStart()
+	s.WriteObjectField("values")
+	s.WriteArrayStart()
+	s.WriteObjectStart()
+
+	s.WriteObjectField("type")
+	s.WriteString(errLetter.ErrorClassName())
+	s.WriteMore()
+
+	s.WriteObjectField("value")
+	s.WriteString(sb.String())
+
+	if len(stackTrace) > 0 {
+		s.WriteMore()
+		se.encodeStackTrace(s, errLetter, stackTrace)
+	}
+
+	s.WriteObjectEnd()
+	s.WriteArrayEnd()
+	s.WriteObjectEnd()
+}
+
+// encodeStackTrace writes "stacktrace" object to 's' using 'stackTrace'
+// in Sentry's order (the oldest frame first). If 'errLetter' is not nil,
+// its fields and messages are frame's "vars".
+func (se *CI_SentryEncoder) encodeStackTrace(
+
+	s *jsoniter.Stream,
+	errLetter *ekaenc.Letter,
+	stackTrace ekasys.StackTrace,
+) {
+	s.WriteObjectField("stacktrace")
+	s.WriteObjectStart()
+	s.WriteObjectField("frames")
+	s.WriteArrayStart()
+
+	for i := len(stackTrace) - 1; i >= 0; i-- {
+		frame := &stackTrace[i]
+		pkg, fn := ekaenc.SplitFunction(frame.Function)
+
+		s.WriteObjectStart()
+
+		s.WriteObjectField("function")
+		s.WriteString(fn)
+		s.WriteMore()
+
+		s.WriteObjectField("module")
+		s.WriteString(pkg)
+		s.WriteMore()
+
+		s.WriteObjectField("filename")
+		s.WriteString(ekaenc.ShortFile(frame.File))
+		s.WriteMore()
+
+		s.WriteObjectField("abs_path")
+		s.WriteString(frame.File)
+		s.WriteMore()
+
+		s.WriteObjectField("lineno")
+		s.WriteInt(frame.Line)
+		s.WriteMore()
+
+		s.WriteObjectField("in_app")
+		s.WriteTrue()
+
+		if errLetter != nil {
+			message := errLetter.MessageOfFrame(int16(i))
+			fields := errLetter.FieldsOfFrame(int16(i))
+
+			if message != "" || len(fields) > 0 {
+				s.WriteMore()
+				s.WriteObjectField("vars")
+				s.WriteObjectStart()
+
+				unnamedFieldIdx := int16(0)
+				for j := range fields {
+					if j > 0 {
+						s.WriteMore()
+					}
+					s.WriteObjectField(fields[j].KeyOrUnnamed(&unnamedFieldIdx))
+					fields[j].WriteJSON(s)
+				}
+
+				if message != "" {
+					if len(fields) > 0 {
+						s.WriteMore()
+					}
+					s.WriteObjectField("message")
+					s.WriteString(message)
+				}
+
+				s.WriteObjectEnd()
+			}
+		}
+
+		s.WriteObjectEnd()
+
+		if i > 0 {
+			s.WriteMore()
+		}
+	}
+
+	s.WriteArrayEnd()
+	s.WriteObjectEnd()
+}
+
+// encodeTagsAndExtra writes Sentry event's "tags" and "extra" sections to 's'
+// (JSON comma before each) using log entry's fields and error's ID.
+func (se *CI_SentryEncoder) encodeTagsAndExtra(s *jsoniter.Stream, logLetter, errLetter *ekaenc.Letter) {
+
+	var (
+		unnamedFieldIdx int16
+		extra           []int
+		extraKeys       []string
+		wasTag          bool
+	)
+
+	s.WriteMore()
+	s.WriteObjectField("tags")
+	s.WriteObjectStart()
+
+	if errLetter != nil {
+		if errorID := errLetter.ErrorID(); errorID != "" {
+			s.WriteObjectField("error_id")
+			s.WriteString(errorID)
+			wasTag = true
+		}
+	}
+
+	for i := range logLetter.Fields {
+		f := &logLetter.Fields[i]
+		if f.IsHidden() {
+			continue
+		}
+
+		key := f.KeyOrUnnamed(&unnamedFieldIdx)
+		value := f.String()
+
+		if len(key) > 32 || len(value) > 200 {
+			extra = append(extra, i)
+			extraKeys = append(extraKeys, key)
+			continue
+		}
+
+		if wasTag {
+			s.WriteMore()
+		}
+		s.WriteObjectField(key)
+		s.WriteString(value)
+		wasTag = true
+	}
+
+	s.WriteObjectEnd()
+
+	if len(extra) == 0 {
+		return
+	}
+
+	s.WriteMore()
+	s.WriteObjectField("extra")
+	s.WriteObjectStart()
+
+	for i, idx := range extra {
+		if i > 0 {
+			s.WriteMore()
+		}
+		s.WriteObjectField(extraKeys[i])
+		logLetter.Fields[idx].WriteJSON(s)
+	}
+
+	s.WriteObjectEnd()
+}
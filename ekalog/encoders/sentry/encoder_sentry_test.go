@@ -0,0 +1,115 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_sentry
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+var (
+	// eventIDRegexp matches "event_id", that is random,
+	// and thus can't be a part of the expected output.
+	eventIDRegexp = regexp.MustCompile(`"event_id":"[0-9a-f]{32}"`)
+
+	// linenoRegexp matches stack frame's line, like ekaenctest.HideLines() does.
+	linenoRegexp = regexp.MustCompile(`"lineno":\d+`)
+)
+
+func TestCI_SentryEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled", "attempt", 2).Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_SentryEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"tags and extra",
+			NewEncoder().SetServerName("test-host").SetEnvironment("production").SetRelease("v1"),
+			func() *ekaerr.Error {
+				ekalog.Notice("user logged in",
+					"user_id", 42, "admin", true, "user_agent", strings.Repeat("x", 201))
+				return nil
+			},
+			`{"event_id":"<event_id>","timestamp":1622550645.123456,"platform":"go","logger":"ekalog",` +
+				`"level":"info","logentry":{"formatted":"user logged in"},` +
+				`"server_name":"test-host","environment":"production","release":"v1",` +
+				`"tags":{"user_id":"42","admin":"true"},` +
+				`"extra":{"user_agent":"` + strings.Repeat("x", 201) + `"},` +
+				`"sdk":{"name":"ekago_ext.sentry","version":"3"}}`,
+		},
+		{
+			"error's message",
+			NewEncoder().SetServerName(""),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					AddMessage("failed to authorize").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"event_id":"<event_id>","timestamp":1622550645.123456,"platform":"go","logger":"ekalog",` +
+				`"level":"error","logentry":{"formatted":"failed to authorize"},` +
+				`"exception":{"values":[{"type":"NotFound","value":"failed to authorize: the user is not found"}]},` +
+				`"tags":{"error_id":"<error_id>","method":"GET"},` +
+				`"sdk":{"name":"ekago_ext.sentry","version":"3"}}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder().SetServerName(""),
+			func() *ekaerr.Error {
+				ekalog.Crite("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"event_id":"<event_id>","timestamp":1622550645.123456,"platform":"go","logger":"ekalog",` +
+				`"level":"fatal","logentry":{"formatted":"request failed"},` +
+				`"exception":{"values":[{"type":"Interrupted","value":"request failed: the request is cancelled",` +
+				`"stacktrace":{"frames":[{"function":"TestCI_SentryEncoder_EncodeEntry",` +
+				`"module":"github.com/qioalice/ekago_ext/v3/ekalog/encoders/sentry",` +
+				`"filename":"encoder_sentry_test.go","abs_path":"<dir>/encoder_sentry_test.go",` +
+				`"lineno":<line>,"in_app":true,"vars":{"attempt":2,"message":"the request is cancelled"}}]}}]},` +
+				`"fingerprint":["Interrupted",` +
+				`"github.com/qioalice/ekago_ext/v3/ekalog/encoders/sentry.TestCI_SentryEncoder_EncodeEntry"],` +
+				`"tags":{"error_id":"<error_id>"},` +
+				`"sdk":{"name":"ekago_ext.sentry","version":"3"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			encoded = linenoRegexp.ReplaceAllString(encoded, `"lineno":<line>`)
+			encoded = eventIDRegexp.ReplaceAllString(encoded, `"event_id":"<event_id>"`)
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected Sentry event:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_splunk
+
+import (
+	"os"
+	"strconv"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc"
+
+	"github.com/json-iterator/go"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_SplunkHECEncoder is an ekalog.CI_Encoder, that encodes ekalog.Entry
+	// as Splunk HTTP Event Collector's event
+	// ( https://docs.splunk.com/Documentation/Splunk/latest/Data/FormateventsforHTTPEventCollector ).
+	//
+	// 1. "time" is an epoch time in seconds with milliseconds (float).
+	//
+	// 2. "host" is set by SetHost() (os.Hostname() by default),
+	//    "source", "sourcetype", "index" are set by SetSource(), SetSourceType(),
+	//    SetIndex(). Empty ones are omitted (HEC's token defaults are used then).
+	//
+	// 3. "event" is a JSON object: "level" (lowercased level's name), "message",
+	//    then all log's fields and all attached error's fields at the root,
+	//    then "error_id", "error_class" and "stacktrace"
+	//    (an array of "<function> <file>:<line>") if presented.
+	//
+	// Encoded HEC event has no trailing '\n'. CI_WriterHttp's
+	// UseProviderSplunkHEC() separates events of a pack by '\n'.
+	CI_SplunkHECEncoder struct {
+
+		ekaenc.NopEncoder
+
+		host       string
+		source     string
+		sourceType string
+		index      string
+	}
+)
+
+// NewEncoder creates a new CI_SplunkHECEncoder.
+// Use its setters to specify HEC's event metadata before the encoder
+// is registered with ekalog.CommonIntegrator.
+func NewEncoder() *CI_SplunkHECEncoder {
+
+	hostname, _ := os.Hostname()
+
+	return &CI_SplunkHECEncoder{
+		NopEncoder: ekaenc.NewNopEncoder(),
+		host:       hostname,
+		sourceType: "_json",
+	}
+}
+
+// SetHost sets "host" of HEC's event.
+func (se *CI_SplunkHECEncoder) SetHost(host string) *CI_SplunkHECEncoder {
+	if se != nil {
+		se.host = host
+	}
+	return se
+}
+
+// SetSource sets "source" of HEC's event.
+func (se *CI_SplunkHECEncoder) SetSource(source string) *CI_SplunkHECEncoder {
+	if se != nil {
+		se.source = source
+	}
+	return se
+}
+
+// SetSourceType sets "sourcetype" of HEC's event. Default: "_json".
+func (se *CI_SplunkHECEncoder) SetSourceType(sourceType string) *CI_SplunkHECEncoder {
+	if se != nil {
+		se.sourceType = sourceType
+	}
+	return se
+}
+
+// SetIndex sets "index" of HEC's event.
+// It must be allowed for HEC's token.
+func (se *CI_SplunkHECEncoder) SetIndex(index string) *CI_SplunkHECEncoder {
+	if se != nil {
+		se.index = index
+	}
+	return se
+}
+
+// EncodeEntry encodes passed ekalog.Entry as Splunk HEC's event.
+//
+// EncodeEntry is for internal purposes only and MUST NOT be called directly.
+// UB otherwise, may panic.
+func (se *CI_SplunkHECEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+
+	s := ekaenc.JsonApi.BorrowStream(nil)
+	defer ekaenc.JsonApi.ReturnStream(s)
+
+	logLetter := ekaenc.LetterOf(unsafe.Pointer(e.LogLetter))
+	errLetter := ekaenc.LetterOf(unsafe.Pointer(e.ErrLetter))
+
+	s.WriteObjectStart()
+
+	s.WriteObjectField("time")
+	s.WriteRaw(strconv.FormatFloat(float64(e.Time.UnixNano()/1e6)/1e3, 'f', 3, 64))
+
+	ekaenc.WriteStringIfNotEmpty(s, "host", se.host)
+	ekaenc.WriteStringIfNotEmpty(s, "source", se.source)
+	ekaenc.WriteStringIfNotEmpty(s, "sourcetype", se.sourceType)
+	ekaenc.WriteStringIfNotEmpty(s, "index", se.index)
+
+	s.WriteMore()
+	s.WriteObjectField("event")
+	se.encodeEvent(s, e, logLetter, errLetter)
+
+	s.WriteObjectEnd()
+
+	return ekaenc.CopyBuffer(s)
+}
+
+// encodeEvent writes "event" JSON object to 's'.
+func (se *CI_SplunkHECEncoder) encodeEvent(
+
+	s *jsoniter.Stream,
+	e *ekalog.Entry,
+	logLetter, errLetter *ekaenc.Letter,
+) {
+	s.WriteObjectStart()
+
+	s.WriteObjectField("level")
+	s.WriteString(e.Level.ToLower())
+	s.WriteMore()
+
+	s.WriteObjectField("message")
+	s.WriteString(ekaenc.EntryMessage(logLetter, errLetter))
+
+	unnamedFieldIdx := int16(0)
+	for i := range logLetter.Fields {
+		logLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+	}
+
+	if errLetter != nil {
+		for i := range errLetter.Fields {
+			errLetter.Fields[i].WriteObjectField(s, &unnamedFieldIdx)
+		}
+
+		s.WriteMore()
+		s.WriteObjectField("error_id")
+		s.WriteString(errLetter.ErrorID())
+		s.WriteMore()
+		s.WriteObjectField("error_class")
+		s.WriteString(errLetter.ErrorClassName())
+	}
+
+	if stackTrace := ekaenc.EntryStackTrace(logLetter, errLetter); len(stackTrace) > 0 {
+		s.WriteMore()
+		s.WriteObjectField("stacktrace")
+		s.WriteArrayStart()
+		for i := range stackTrace {
+			if i > 0 {
+				s.WriteMore()
+			}
+			s.WriteString(stackTrace[i].Function + " " +
+				stackTrace[i].File + ":" + strconv.Itoa(stackTrace[i].Line))
+		}
+		s.WriteArrayEnd()
+	}
+
+	s.WriteObjectEnd()
+}
@@ -0,0 +1,101 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_encoder_splunk
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+func TestCI_SplunkHECEncoder_EncodeEntry(t *testing.T) {
+
+	// Stack frames have absolute paths, the test is run in the package's directory.
+	dir, _ := os.Getwd()
+
+	// Stacktrace is generated up to the test, so it has the only one frame.
+	errWithStackTrace := ekaerr.Interrupted.New("the request is cancelled").Throw()
+
+	tests := []struct {
+		name     string
+		enc      *CI_SplunkHECEncoder
+		log      func() *ekaerr.Error
+		expected string
+	}{
+		{
+			"fields",
+			NewEncoder().SetHost("test-host"),
+			func() *ekaerr.Error {
+				ekalog.Info("user logged in", "user_id", 42, "admin", true, "ratio", 0.5)
+				return nil
+			},
+			`{"time":1622550645.123,"host":"test-host","sourcetype":"_json",` +
+				`"event":{"level":"info","message":"user logged in",` +
+				`"user_id":42,"admin":true,"ratio":0.5}}`,
+		},
+		{
+			"metadata",
+			NewEncoder().SetHost("").SetSource("api").SetSourceType("").SetIndex("main"),
+			func() *ekaerr.Error {
+				ekalog.Debug("test")
+				return nil
+			},
+			`{"time":1622550645.123,"source":"api","index":"main",` +
+				`"event":{"level":"debug","message":"test"}}`,
+		},
+		{
+			"error's message",
+			NewEncoder().SetHost("test-host"),
+			func() *ekaerr.Error {
+				err := ekaerr.NotFound.LightNew("the user is not found").
+					WithString("path", "/users/42").
+					Throw()
+				ekalog.Errore("", err, "method", "GET")
+				return err
+			},
+			`{"time":1622550645.123,"host":"test-host","sourcetype":"_json",` +
+				`"event":{"level":"error","message":"the user is not found",` +
+				`"method":"GET","path":"/users/42","error_id":"<error_id>","error_class":"NotFound"}}`,
+		},
+		{
+			"error's stacktrace",
+			NewEncoder().SetHost("test-host"),
+			func() *ekaerr.Error {
+				ekalog.Warne("request failed", errWithStackTrace)
+				return errWithStackTrace
+			},
+			`{"time":1622550645.123,"host":"test-host","sourcetype":"_json",` +
+				`"event":{"level":"warning","message":"request failed",` +
+				`"error_id":"<error_id>","error_class":"Interrupted","stacktrace":[` +
+				`"github.com/qioalice/ekago_ext/v3/ekalog/encoders/splunk.TestCI_SplunkHECEncoder_EncodeEntry ` +
+				`<dir>/encoder_splunk_test.go:<line>"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *ekaerr.Error
+			encoded := ekaenctest.Encode(tt.enc, func() {
+				err = tt.log()
+			})
+
+			encoded = ekaenctest.HideLines(encoded)
+			encoded = strings.ReplaceAll(encoded, dir, "<dir>")
+			if err != nil {
+				encoded = strings.ReplaceAll(encoded, err.ID(), "<error_id>")
+			}
+
+			if encoded != tt.expected {
+				t.Fatalf("unexpected HEC event:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
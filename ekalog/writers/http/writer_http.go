@@ -6,7 +6,6 @@
 package ekalog_writer_http
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -49,6 +48,10 @@ type (
 	//    (the stopping of app or flush time has come), sends accumulated data
 	//    to the HTTP service.
 	//
+	//    Worker buffer might be limited by bytes also (see SetPackMaxSize() method).
+	//    A single encoded log entry that is too big might be truncated or dropped
+	//    (see SetEntryMaxSize() method).
+	//
 	// 4. Control how encoded log entries will be combined before sending.
 	//    Each worker aggregates a single encoded log entries with others
 	//    to the log entries pack (contains many encoded log entries) before send it.
@@ -65,6 +68,11 @@ type (
 	//          I mean between all of them, excluding before first and after last.
 	//          (see AddBetween() method).
 	//
+	//        - Need to frame each encoded log entry individually?
+	//          (length-prefixed frames, bulk action lines, syslog octet counting,
+	//          envelope item headers, etc).
+	//          (see SetPackBuilder() method).
+	//
 	// 5. Flushing data each N time intervals.
 	//    Each worker flushes accumulated data on demand (the internal buffer is full)
 	//    or at the timeout you may set (see SetWorkerAutoFlushDelay() method).
@@ -72,14 +80,48 @@ type (
 	// 6. Slow down when service unavailable or network error.
 	//    If request sending at the some worker is failed
 	//    (it's only network or log service issue if you configured writer well),
-	//    the usage of HTTP API service will slow down, logging what happens
-	//    and why and saving (not dropping) your logs to the internal buffer.
+	//    it's retried a few times with exponential backoff
+	//    (see SetRetryPolicy() method). The request that takes too long
+	//    is failed too (see SetRequestTimeout() method).
+	//
+	//    If it's still failed, the usage of HTTP API service will slow down,
+	//    logging what happens and why and saving (not dropping) your logs
+	//    to the internal buffer. It's a circuit breaker: when too many
+	//    requests are failed, no requests are sent for a while
+	//    (see SetCircuitBreaker() method). The attempts to restore connection
+	//    (probes) are performed with exponential backoff, not at the each flush,
+	//    and even if there are no new log entries.
 	//
 	//    They will send when connection will be restored.
 	//    You may set the deferred log entries pack buffer capacity
 	//    (pack, because each worker builds a pack of log entries, remember? see p.3)
 	//    (see SetDeferredBufferCap() method).
 	//
+	//    Afraid of losing them if your app crashes while your provider is down?
+	//    Store them on the disk instead of RAM (see SetDeferredSpool() method).
+	//    They will be sent even after restart of your app.
+	//
+	//    Have more than one endpoint (like primary and secondary collectors)?
+	//    Failover to the healthy ones or balance the load between them
+	//    (see SetEndpoints() method). The endpoint, that is unavailable,
+	//    is not used for a while (see SetEndpointCooldown() method).
+	//
+	//    Which HTTP status codes mean success is configurable
+	//    (see SetAcceptedStatusCodes() method). If your provider accepts
+	//    the request, but rejects some of log entries (like bulk APIs do),
+	//    classify its responses (see SetResponseHandler() method).
+	//    Rejected log entries, that may be retried, are sent again.
+	//
+	//    Does your provider throttle requests? Limit their rate
+	//    (see SetRateLimit() method) and choose what to do when it's reached:
+	//    wait, sample or coalesce log entries (see SetThrottlePolicy() method).
+	//    Provider's rate limit headers are honored too.
+	//
+	//    If your provider says the log entries pack is too large (HTTP 413),
+	//    it's split into halves and they are sent again (and so on).
+	//    It's not considered as service issue. Only the log entry, that is
+	//    too large by itself, is dropped.
+	//
 	// 7. Graceful shutdown.
 	//    Of course, if you're familiar of ekadeath package. If you're not yet,
 	//    it's time to: https://github.com/qioalice/ekago/ekadeath .
@@ -97,6 +139,11 @@ type (
 	//    only when all accumulated logs are flushed.
 	//    You may specify only one of them.
 	//
+	//    Or do it explicitly. Flush() sends all accumulated logs and waits,
+	//    Close() does the same but disables CI_WriterHttp then.
+	//    Want to know what's going on? Stats() is for you.
+	//    Export it to Prometheus (see MetricsHandler()) or expvar (see PublishExpvar()).
+	//
 	// 8. Auto-initialization:
 	//    You do need to call methods like Start() or something like that.
 	//    You may check whether you configuration is valid calling Ping() method,
@@ -114,6 +161,12 @@ type (
 	//         - Rollbar: https://rollbar.com/       : UseProviderRollbar(),
 	//         - GrayLog: https://www.graylog.org/   : UseProviderGrayLog(),
 	//         - Sentry:  https://sentry.io/         : UseProviderSentry(),
+	//         - Loki:    https://grafana.com/oss/loki/ : UseProviderLoki(),
+	//         - Elasticsearch: https://www.elastic.co/ : UseProviderElasticsearch(),
+	//         - Splunk:  https://www.splunk.com/   : UseProviderSplunkHEC(),
+	//         - OpenTelemetry: https://opentelemetry.io/ : UseProviderOTLP(),
+	//         - AWS CloudWatch Logs: https://aws.amazon.com/cloudwatch/ : UseProviderCloudWatch(),
+	//         - Google Cloud Logging: https://cloud.google.com/logging : UseProviderGoogleCloudLogging(),
 	//         etc.
 	//
 	//     For those services, CI_WriterHttp has a methods (3rd column)
@@ -122,10 +175,19 @@ type (
 	//     If you want to manually set HTTP service use UseProviderManual() method,
 	//     providing a fasthttp's Request initializer for your service.
 	//
+	//     Need authentication? Bearer token, basic auth, API key, HMAC signing
+	//     or OAuth2 client credentials are ready to use (see SetAuthenticator()).
+	//
+	//     Need custom CAs, client certificates (mTLS), HTTP proxy or HTTP/2?
+	//     Use configured fasthttp's client or net/http one (see SetTransport()).
+	//
 	// 10. Fast.
 	//     Uses fasthttp ( https://github.com/valyala/fasthttp ) under the hood,
 	//     as http client. Pools, reusing, caching, optimizations. All you need.
 	//
+	//     Pay less for the traffic. Entries packs may be compressed
+	//     using gzip, deflate or zstd (see SetCompression() method).
+	//
 	// --------
 	//
 	// WARNING!
@@ -145,6 +207,66 @@ type (
 
 		providerInitializer  func(req *fasthttp.Request)
 		providerBodyPreparer func(oldBody io.Reader) (newBody io.Reader)
+		providerPingBody     []byte
+
+		// An additional initializer of ping HTTP request (see Ping()),
+		// for the providers, that can't be pinged by their logs ingesting endpoint.
+		providerPingInitializer func(req *fasthttp.Request)
+
+		// Skip the implicit ping at the initialization, for the providers,
+		// that can be pinged only by ingesting a log entry.
+		providerPingSkip bool
+
+		// A classifier of HTTP response. Predefined providers, that may reject
+		// some of log entries w/o HTTP error, set their own.
+		// User's one (see SetResponseHandler()) is called after it.
+		providerResponseHandler CI_WriterHttp_ResponseHandler
+		responseHandler         CI_WriterHttp_ResponseHandler
+
+		// A handler of HTTP response of failed request, for the providers,
+		// that may fix the cause of failure (like creating missing resource).
+		// Returns true if the request may be retried.
+		providerFailureHandler func(status int, body []byte) (retry bool)
+
+		// A splitter of entries pack, for the providers, that can't accept
+		// some encoded log entries in the same request (like too distant in time).
+		// Returns the groups of indexes (each is sorted) of encoded log entries,
+		// that are sent by separate requests, or nil if there's no need to split.
+		providerPackSplitter func(pack *_CI_WriterHttpPack) (groups [][]int)
+
+		// Limits of the provider, that can't be overwritten by setters.
+		// User's ones (see SetPackMaxSize(), SetEntryMaxSize()) are lowered
+		// to them at the initialization.
+		// The overhead is the number of bytes, that provider counts
+		// for each encoded log entry in addition to its size (see SetPackMaxSize()).
+		providerMaxEntriesPerPack uint16
+		providerPackMaxSize       uint32
+		providerEntryMaxSize      uint32
+		packEntryOverhead         uint32
+
+		// The compression, the provider supports. Used if user hasn't set
+		// its own (see SetCompression()).
+		providerCompressionAlgo CI_WriterHttp_Compression
+
+		// An error occurred while predefined provider has been set up
+		// (like invalid DSN). Returned at the initialization.
+		providerErr *ekaerr.Error
+
+		// Grafana Loki specific options. See UseProviderLoki().
+		lokiTenantID string
+		lokiProtobuf bool
+
+		// The authenticator of the provider. Used if user hasn't set
+		// its own (see SetAuthenticator()).
+		providerAuthenticator CI_WriterHttp_Authenticator
+		authenticator         CI_WriterHttp_Authenticator
+
+		transport      CI_WriterHttp_Transport
+		requestTimeout time.Duration
+
+		// Nil if the provider's addr is used as is.
+		endpoints        *_CI_WriterHttpEndpoints
+		endpointCooldown time.Duration
 
 		entriesBufferLen         uint32
 		deferredEntriesBufferLen *uint32
@@ -152,13 +274,56 @@ type (
 		workerNum              uint16
 		workerEntriesBufferLen uint16
 		workerFlushDelay       time.Duration
+		workerBufferInitSize   uint32
+
+		packMaxSize         uint32
+		entryMaxSize        uint32
+		entryOversizePolicy CI_WriterHttp_OversizePolicy
+
+		overflowPolicy     CI_WriterHttp_OverflowPolicy
+		overflowTimeout    time.Duration
+		priorityClassifier func(encodedEntry []byte) bool
+
+		rateLimitRequests      float64
+		rateLimitBytes         float64
+		rateLimitIgnoreHeaders bool
+		throttlePolicy         CI_WriterHttp_ThrottlePolicy
+		throttleSampleRate     float64
 
 		dataBefore  []byte
 		dataAfter   []byte
 		dataBetween []byte
 
+		// Nil if 'dataBefore', 'dataAfter', 'dataBetween' are used.
+		// User's one (see SetPackBuilder()) takes precedence over provider's one.
+		// The active one is set at the initialization.
+		packBuilder         CI_WriterHttp_PackBuilder
+		providerPackBuilder CI_WriterHttp_PackBuilder
+		packBuilderActive   CI_WriterHttp_PackBuilder
+
 		workerFlushDeferredPerIter uint16
 
+		spoolDir        string
+		spoolMaxSize    uint64
+		spoolSyncPolicy CI_WriterHttp_SpoolSync
+
+		retryMaxAttempts      uint8
+		retryBaseDelay        time.Duration
+		retryMaxDelay         time.Duration
+		retryJitter           float64
+		retryStatusCodes      map[int]struct{}
+		acceptedStatusCodes   map[int]struct{}
+		retryIgnoreRetryAfter bool
+
+		breakerFailureRate  float64
+		breakerWindow       uint16
+		breakerMinRequests  uint16
+		breakerOpenDuration time.Duration
+
+		compressionAlgo  CI_WriterHttp_Compression
+		compressionLevel int
+		compressionSet   bool
+
 		// Internal parts
 
 		casInitStatus int32
@@ -174,20 +339,67 @@ type (
 
 		workerTickers []*time.Ticker
 
+		// Flush() requests, each worker has its own channel.
+		workerFlushRequests []chan *sync.WaitGroup
+
 		// This channel will never be closed.
-		entries             chan []byte
-		entriesPackDeferred chan *bytes.Buffer
+		entries         chan []byte
+		entriesPriority chan []byte // nil if overflow policy is not "priority"
+
+		// Only one goroutine evicts low priority encoded log entries
+		// at the same time (see evictLowPriority()). Drained ones are kept here.
+		overflowMu     sync.Mutex
+		overflowQueued [][]byte
+
+		// Encoded log entries, taken from the buffers but not sent yet.
+		// Flush() waits for them.
+		inFlight _CI_WriterHttpInFlight
+
+		entriesPackDeferred chan *_CI_WriterHttpPack
+
+		// Disk-backed alternative of 'entriesPackDeferred'. Nil if not enabled.
+		// 1 while any goroutine drains the spool (see flushDeferredSpool()).
+		spool         *_CI_WriterHttpSpool
+		spoolDraining int32
+
+		// Pools of compressors. Nil if compression is not enabled.
+		compression *_CI_WriterHttpCompression
+
+		stats _CI_WriterHttpStats
 
 		entriesCompletelyLostCounter uint64
 
-		c fasthttp.Client
+		// How much sequential sending attempts (with retries) have been failed.
+		// Used to calculate a delay of next recovery attempt.
+		failedInRowCounter uint32
+
+		// While it's not closed, CI_WriterHttp is temporary disabled.
+		// The master worker probes the provider each tick of 'probeTicker'.
+		breaker     *_CI_WriterHttpBreaker
+		probeTicker *time.Ticker
+
+		// Shared across workers. See SetRateLimit().
+		limiter *_CI_WriterHttpRateLimiter
 	}
+
+	// CI_WriterHttp_OversizePolicy is what CI_WriterHttp does with encoded
+	// log entry that is bigger than allowed. See SetEntryMaxSize() method
+	// and CI_WRITER_HTTP_OVERSIZE_<...> constants.
+	CI_WriterHttp_OversizePolicy uint8
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	CI_WRITER_HTTP_OVERSIZE_DROP CI_WriterHttp_OversizePolicy = iota
+	CI_WRITER_HTTP_OVERSIZE_TRUNCATE
 )
 
 var (
-	ErrWriterIsNil      = fmt.Errorf("CI_WriterHttp: writer is nil (not initialized)")
-	ErrWriterDisabled   = fmt.Errorf("CI_WriterHttp: writer is disabled (stopped)")
-	ErrWriterBufferFull = fmt.Errorf("CI_WriterHttp: writer's buffer is full")
+	ErrWriterIsNil         = fmt.Errorf("CI_WriterHttp: writer is nil (not initialized)")
+	ErrWriterDisabled      = fmt.Errorf("CI_WriterHttp: writer is disabled (stopped)")
+	ErrWriterBufferFull    = fmt.Errorf("CI_WriterHttp: writer's buffer is full")
+	ErrWriterFlushAbort    = fmt.Errorf("CI_WriterHttp: flush is aborted")
+	ErrWriterEntryTooLarge = fmt.Errorf("CI_WriterHttp: log entry is too large")
 )
 
 // UseProviderManual is a log service provider manual configurator.
@@ -200,6 +412,9 @@ var (
 // WARNING!
 // You MUST NOT save and reuse old body that you receiver in your 2nd callback!
 //
+// All that has been set by the previous UseProvider<...>() call
+// (response handler, pack builder, provider's limits, etc) is reset.
+//
 // Nil safe. There is no-op if CI_WriterHttp already initialized.
 func (dw *CI_WriterHttp) UseProviderManual(
 
@@ -208,11 +423,20 @@ func (dw *CI_WriterHttp) UseProviderManual(
 
 ) *CI_WriterHttp {
 
+	return dw.configure((*CI_WriterHttp).resetProvider).useProvider(cb, bodyPreparer...)
+}
+
+// SetPingBody sets a body of HTTP request, that is sent by Ping()
+// (or by the initialization if Ping() has not been called).
+// It must be a valid request's body for your provider.
+//
+// Predefined providers (UseProvider<...>() methods) set it if it's required.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+// Default: "[]".
+func (dw *CI_WriterHttp) SetPingBody(body []byte) *CI_WriterHttp {
 	return dw.configure(func(dw *CI_WriterHttp) {
-		dw.providerInitializer = cb
-		if len(bodyPreparer) > 0 && bodyPreparer[0] != nil {
-			dw.providerBodyPreparer = bodyPreparer[0]
-		}
+		dw.providerPingBody = body
 	})
 }
 
@@ -299,6 +523,235 @@ func (dw *CI_WriterHttp) SetWorkerBufferCap(cap uint16) *CI_WriterHttp {
 	})
 }
 
+// SetWorkerBufferInitSize sets an initial size (in bytes) of the each worker's
+// buffer, encoded log entries are accumulated to.
+// The buffer grows if it's needed, so it's just an optimization
+// to avoid reallocations.
+//
+// If SetPackMaxSize() is used and its value is less,
+// max pack size is used as initial size.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [1KB..64MB].
+// Default: 2MB.
+func (dw *CI_WriterHttp) SetWorkerBufferInitSize(size uint32) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if size >= 1<<10 && size <= 64<<20 {
+			dw.workerBufferInitSize = size
+		}
+	})
+}
+
+// SetPackMaxSize sets a maximum size (in bytes) of the entries pack
+// (HTTP request's body before it's prepared by the body preparer and compressed),
+// including the data of AddBefore(), AddAfter(), AddBetween().
+//
+// A worker sends accumulated entries before adding a new one
+// if the size would be exceeded by that.
+// An encoded entry, that can not fit even an empty pack, is dropped
+// (it's reported by Stats() as dropped and lost as too large entry).
+//
+// It works together with SetWorkerBufferCap(). The pack is sent
+// when any of limits is reached.
+//
+// Predefined providers (UseProvider<...>() methods) may have their own limit.
+// 'size' can't exceed it (it's lowered at the initialization),
+// no matter whether this method is called before or after them.
+//
+// Read p.3 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: 0 or [1KB..1GB]. 0 means no limit.
+// Default: 0.
+func (dw *CI_WriterHttp) SetPackMaxSize(size uint32) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if size == 0 || size >= 1<<10 && size <= 1<<30 {
+			dw.packMaxSize = size
+		}
+	})
+}
+
+// SetEntryMaxSize sets a maximum size (in bytes) of the one encoded log entry
+// and what to do with those entries that are bigger.
+//
+// CI_WRITER_HTTP_OVERSIZE_DROP drops such entries (Write() returns
+// ErrWriterEntryTooLarge and they're counted as lost),
+// CI_WRITER_HTTP_OVERSIZE_TRUNCATE truncates them to 'size' bytes.
+// Keep in mind, truncated entry is not a valid JSON (or any other structured
+// format) anymore, so use truncating only if your provider accepts it.
+// Both of them are reported by Stats().
+//
+// Predefined providers (UseProvider<...>() methods) may have their own limit.
+// 'size' can't exceed it (it's lowered at the initialization),
+// no matter whether this method is called before or after them.
+//
+// Read p.3 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: 0 or [64..1GB]. 0 means no limit.
+// Default: 0, CI_WRITER_HTTP_OVERSIZE_DROP.
+func (dw *CI_WriterHttp) SetEntryMaxSize(size uint32, policy CI_WriterHttp_OversizePolicy) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		switch {
+		case size != 0 && (size < 64 || size > 1<<30):
+		case policy != CI_WRITER_HTTP_OVERSIZE_DROP && policy != CI_WRITER_HTTP_OVERSIZE_TRUNCATE:
+		default:
+			dw.entryMaxSize = size
+			dw.entryOversizePolicy = policy
+		}
+	})
+}
+
+// SetOverflowPolicy sets what Write() does with encoded log entry
+// when the internal buffer of encoded log entries is full (see SetBufferCap()).
+//
+// CI_WRITER_HTTP_OVERFLOW_REJECT drops the new encoded log entry
+// and ErrWriterBufferFull is returned.
+//
+// CI_WRITER_HTTP_OVERFLOW_BLOCK blocks Write() until there is a free space,
+// but no longer than 'timeout' (0 means no timeout). Useful for audit logs.
+//
+// CI_WRITER_HTTP_OVERFLOW_DROP_OLDEST drops the oldest queued encoded log entry
+// in favour of the new one. Useful for debug logs.
+//
+// CI_WRITER_HTTP_OVERFLOW_PRIORITY drops the new encoded log entry
+// if it's not high priority (see SetPriorityClassifier()).
+// High priority ones are queued to the separate buffer with the same capacity.
+// If it's full too, the oldest queued low priority encoded log entry is dropped
+// in favour of the new one (the order of others is kept), and if there is
+// no such one, Write() is blocked until there is a free space, but no longer
+// than 'timeout'. So, encoded log entries of error level and above
+// are never dropped in favour of lower ones.
+//
+// How much encoded log entries are dropped by each policy is reported by Stats().
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range of 'timeout': [0..1h].
+// Default: CI_WRITER_HTTP_OVERFLOW_REJECT.
+func (dw *CI_WriterHttp) SetOverflowPolicy(
+
+	policy CI_WriterHttp_OverflowPolicy,
+	timeout time.Duration,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if policy <= CI_WRITER_HTTP_OVERFLOW_PRIORITY && timeout >= 0 && timeout <= time.Hour {
+			dw.overflowPolicy = policy
+			dw.overflowTimeout = timeout
+		}
+	})
+}
+
+// SetPriorityClassifier sets a function, that reports whether
+// encoded log entry is high priority (see CI_WRITER_HTTP_OVERFLOW_PRIORITY).
+// It's called only when the internal buffer of encoded log entries is full.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Default: the encoded log entry (JSON object) is high priority, if its
+// "level", "log.level", "severity", "severityText" or "status" field
+// is error, critical, alert, emergency, fatal or panic (or syslog's level <= 3),
+// or if its "severityNumber" field (OpenTelemetry) is >= 17.
+// If there are no such fields, they are looked for in Rollbar's "data",
+// Splunk HEC's "event", Loki's "stream" and lines of "values".
+// OTLP/protobuf's LogRecord is high priority, if its severity number is >= 17.
+// Set your own classifier for any other format.
+func (dw *CI_WriterHttp) SetPriorityClassifier(cb func(encodedEntry []byte) bool) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.priorityClassifier = cb
+	})
+}
+
+// SetRateLimit sets the maximum rate of HTTP requests to the log service provider
+// (including retries and sending deferred entries packs): 'requestsPerSecond'
+// and 'bytesPerSecond' of HTTP requests' bodies. 0 means unlimited.
+// Both of them are token buckets with 1 second capacity, shared across workers.
+//
+// Useful if your provider throttles per API key, so a log storm
+// from one instance of your app gets the whole account rate-limited.
+// What is done, when entries pack can't be sent right now,
+// is set by SetThrottlePolicy().
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [0..+Inf).
+// Default: 0, 0 (unlimited, but provider's rate limit is honored,
+// see SetRateLimitHeadersHonoring()).
+func (dw *CI_WriterHttp) SetRateLimit(requestsPerSecond, bytesPerSecond float64) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if requestsPerSecond >= 0 && bytesPerSecond >= 0 {
+			dw.rateLimitRequests = requestsPerSecond
+			dw.rateLimitBytes = bytesPerSecond
+		}
+	})
+}
+
+// SetThrottlePolicy sets what CI_WriterHttp does with entries pack,
+// when it can't be sent right now because of rate limit (see SetRateLimit()).
+//
+// CI_WRITER_HTTP_THROTTLE_WAIT makes the worker wait.
+//
+// CI_WRITER_HTTP_THROTTLE_SAMPLE sends only 'sampleRate' part of encoded
+// log entries (chosen randomly), except high priority ones
+// (see SetPriorityClassifier()), and then waits.
+// Dropped encoded log entries are reported by Stats() as lost.
+//
+// CI_WRITER_HTTP_THROTTLE_COALESCE keeps accumulating encoded log entries
+// to the worker's entries pack instead of sending it by the flush timer,
+// so they are sent by less requests.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range of 'sampleRate': [0..1]. It's ignored by other policies.
+// Default: CI_WRITER_HTTP_THROTTLE_WAIT.
+func (dw *CI_WriterHttp) SetThrottlePolicy(
+
+	policy CI_WriterHttp_ThrottlePolicy,
+	sampleRate float64,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if policy <= CI_WRITER_HTTP_THROTTLE_COALESCE && sampleRate >= 0 && sampleRate <= 1 {
+			dw.throttlePolicy = policy
+			dw.throttleSampleRate = sampleRate
+		}
+	})
+}
+
+// SetRateLimitHeadersHonoring sets whether the provider's rate limit,
+// reported by "X-RateLimit-Remaining", "X-RateLimit-Reset" HTTP response headers
+// (or the same w/o "X-" prefix), must lower the rate of HTTP requests
+// until the reset. If there's no remaining requests, or if provider answers
+// HTTP 429, 503 with "Retry-After" HTTP header (see SetRetryAfterHonoring()),
+// no requests are sent by all workers until then.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Default: true.
+func (dw *CI_WriterHttp) SetRateLimitHeadersHonoring(enable bool) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.rateLimitIgnoreHeaders = !enable
+	})
+}
+
 // SetDeferredBufferCap looks like SetBufferCap(),
 // but sets a capacity of those encoded []byte entries, that is tried to be sent,
 // while CI_WriterHttp is temporary disabled.
@@ -329,6 +782,55 @@ func (dw *CI_WriterHttp) SetDeferredBufferCap(cap uint32) *CI_WriterHttp {
 	})
 }
 
+// SetDeferredSpool enables a disk-backed spool that is used instead of
+// the RAM buffer of deferred entries packs (see SetDeferredBufferCap()).
+//
+// When CI_WriterHttp is temporary disabled, the entries packs that could not be sent
+// are written to the segment files inside 'dir' directory (it will be created
+// if it's not exist), and they are sent from there when connection is restored.
+// If your app is crashed or killed while your provider is not available,
+// the entries packs are kept on the disk and will be sent after next start.
+//
+// Each spool's record has a checksum. Corrupted records are dropped
+// and considered as lost.
+//
+// 'maxSize' is a limit of total size of spool in bytes.
+// If it's reached, the next deferred entries packs will be discarded.
+//
+// 'syncPolicy' tells when fsync() must be called.
+// See CI_WRITER_HTTP_SPOOL_SYNC_<...> constants.
+//
+// The delivery is "at least once": if your app is crashed while spool is draining,
+// some already sent entries packs may be sent again after restart.
+//
+// WARNING!
+// Do not share the same 'dir' between two or more CI_WriterHttp objects.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range of 'maxSize': [1 MB..1 TB], 0 means default: 256 MB.
+func (dw *CI_WriterHttp) SetDeferredSpool(
+
+	dir string,
+	maxSize uint64,
+	syncPolicy CI_WriterHttp_SpoolSync,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if dir != "" && (maxSize == 0 || maxSize >= 1<<20 && maxSize <= 1<<40) &&
+			syncPolicy <= CI_WRITER_HTTP_SPOOL_SYNC_ALWAYS {
+
+			dw.spoolDir = dir
+			dw.spoolMaxSize = maxSize
+			dw.spoolSyncPolicy = syncPolicy
+		}
+	})
+}
+
 // SetWorkersNum sets how much goroutines will be spawned
 // to handle all passed encoded []byte entries and send them to your provider.
 //
@@ -366,6 +868,324 @@ func (dw *CI_WriterHttp) SetWorkerAutoFlushDelay(delay time.Duration) *CI_Writer
 	})
 }
 
+// SetRetryPolicy sets how failed HTTP requests are retried in place,
+// before entries pack is deferred and CI_WriterHttp is temporary disabled.
+//
+// 'maxAttempts' is the maximum number of attempts for one entries pack,
+// including the first one. 1 means "no retries".
+//
+// The delay before N-th retry is 'baseDelay' * 2^(N-1), but not greater
+// than 'maxDelay'. The same rules are used for delays between attempts
+// of connection restoring while CI_WriterHttp is temporary disabled.
+//
+// 'jitter' is a part of delay [0..1] that is randomized
+// (delay is decreased by the random value up to 'jitter' * delay).
+// It prevents the situation when all your instances hammer your provider
+// at the same moment.
+//
+// Only network errors and retryable HTTP status codes are retried
+// (see SetRetryableStatusCodes()).
+// If your provider returns "Retry-After" HTTP header, its value is used as delay
+// (see SetRetryAfterHonoring()). If it's greater than 'maxDelay', there are
+// no more attempts: the entries pack is deferred (see SetDeferredBufferCap(),
+// SetDeferredSpool()) and the failure is reported to the circuit breaker
+// (see SetCircuitBreaker()). If it's open then, it doesn't allow
+// to send requests at least until that moment.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range:
+//  - 'maxAttempts': [1..16], default: 3,
+//  - 'baseDelay': [1ms..1m], default: 500ms,
+//  - 'maxDelay': ['baseDelay'..1h], default: 30s,
+//  - 'jitter': [0..1], default: 0.2.
+func (dw *CI_WriterHttp) SetRetryPolicy(
+
+	maxAttempts uint8,
+	baseDelay, maxDelay time.Duration,
+	jitter float64,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if maxAttempts >= 1 && maxAttempts <= 16 &&
+			baseDelay >= time.Millisecond && baseDelay <= time.Minute &&
+			maxDelay >= baseDelay && maxDelay <= time.Hour &&
+			jitter >= 0 && jitter <= 1 {
+
+			dw.retryMaxAttempts = maxAttempts
+			dw.retryBaseDelay = baseDelay
+			dw.retryMaxDelay = maxDelay
+			dw.retryJitter = jitter
+		}
+	})
+}
+
+// SetRetryableStatusCodes overwrites the set of HTTP status codes,
+// the HTTP requests that are finished with are retried
+// (the rest of them are considered as failed immediately).
+// Network errors are retried always.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [400..599].
+// Default: 408, 429, 500, 502, 503, 504.
+func (dw *CI_WriterHttp) SetRetryableStatusCodes(codes ...int) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		statusCodes := make(map[int]struct{}, len(codes))
+		for _, code := range codes {
+			if code >= 400 && code <= 599 {
+				statusCodes[code] = struct{}{}
+			}
+		}
+		dw.retryStatusCodes = statusCodes
+	})
+}
+
+// SetAcceptedStatusCodes overwrites the set of HTTP status codes,
+// the HTTP requests that are finished with are considered as succeeded
+// (the rest of them are considered as failed).
+// Add 207 (Multi-Status) if your provider reports partial success that way
+// and set a response handler to parse it (see SetResponseHandler()).
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [200..299].
+// Default: 200, 202, 204.
+func (dw *CI_WriterHttp) SetAcceptedStatusCodes(codes ...int) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		statusCodes := make(map[int]struct{}, len(codes))
+		for _, code := range codes {
+			if code >= 200 && code <= 299 {
+				statusCodes[code] = struct{}{}
+			}
+		}
+		if len(statusCodes) > 0 {
+			dw.acceptedStatusCodes = statusCodes
+		}
+	})
+}
+
+// SetResponseHandler sets a CI_WriterHttp_ResponseHandler, that classifies
+// HTTP responses of the log service provider: whether the request is accepted,
+// may be retried, and which encoded log entries of accepted one are rejected
+// (like per-item errors of Elasticsearch bulk API, OTLP partial success,
+// Splunk HEC error codes).
+//
+// Predefined providers (see UseProvider...() methods) set their own handler.
+// 'handler' is called after it, so it gets provider's classification in 'result'
+// and may overwrite it. UseProvider...() methods don't reset 'handler',
+// no matter whether they're called before or after this method.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+// Default: classification by HTTP status code only.
+func (dw *CI_WriterHttp) SetResponseHandler(handler CI_WriterHttp_ResponseHandler) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.responseHandler = handler
+	})
+}
+
+// SetRetryAfterHonoring sets whether "Retry-After" HTTP response header
+// must be used as a delay before the next attempt.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Default: true.
+func (dw *CI_WriterHttp) SetRetryAfterHonoring(enable bool) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.retryIgnoreRetryAfter = !enable
+	})
+}
+
+// SetCircuitBreaker sets the circuit breaker's parameters.
+//
+// Results of the last 'window' entries packs sending (with all retries)
+// are kept. When there are at least 'minRequests' of them and the part
+// of failed ones reaches 'failureRate', the circuit breaker is open.
+// Only network errors, HTTP 408, 429 and 5xx are failures
+// (other HTTP status codes mean the provider is available):
+// CI_WriterHttp is temporary disabled, entries packs are deferred
+// (see SetDeferredBufferCap(), SetDeferredSpool()) for 'openDuration'
+// (or longer, with exponential backoff, see SetRetryPolicy(),
+// or if provider asks to wait by "Retry-After" HTTP header).
+//
+// Then the circuit breaker is half-open: the master worker sends one request
+// (probe), that is the first deferred entries pack or the ping request
+// if there is no one. If it's succeeded, the circuit breaker is closed,
+// CI_WriterHttp is ready and the deferred entries packs are sent.
+// Otherwise the circuit breaker is open again.
+// Results of requests, started before the circuit breaker has been opened,
+// are ignored.
+//
+// The probes are performed regardless of incoming log entries,
+// so a quiet app still restores connection and sends its deferred log entries.
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range:
+//  - 'failureRate': (0..1], default: 0.5,
+//  - 'window': [1..1024], default: 20,
+//  - 'minRequests': [1..'window'], default: 5,
+//  - 'openDuration': [100ms..1h], default: 5s.
+func (dw *CI_WriterHttp) SetCircuitBreaker(
+
+	failureRate float64,
+	window, minRequests uint16,
+	openDuration time.Duration,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		switch {
+		case failureRate <= 0 || failureRate > 1:
+		case window < 1 || window > 1024:
+		case minRequests < 1 || minRequests > window:
+		case openDuration < 100*time.Millisecond || openDuration > time.Hour:
+		default:
+			dw.breakerFailureRate = failureRate
+			dw.breakerWindow = window
+			dw.breakerMinRequests = minRequests
+			dw.breakerOpenDuration = openDuration
+		}
+	})
+}
+
+// SetEndpoints sets the log service provider's endpoints, HTTP requests
+// are sent to, and the strategy of choosing one of them for each HTTP request.
+//
+// Provider's request initializer (see UseProviderManual() and UseProvider<...>()
+// methods) prepares HTTP request as usual, and then its URI's scheme and host
+// are replaced by the chosen endpoint's ones (the path and the query are kept).
+// So, all endpoints must serve the same API.
+//
+// The endpoint, that is failed because of network error, HTTP 429 or HTTP 5xx,
+// is unhealthy and is not used until its cooldown ends
+// (see SetEndpointCooldown()), if there is a healthy one.
+// The retries (see SetRetryPolicy()) go to another healthy endpoint then.
+//
+// CI_WRITER_HTTP_ENDPOINTS_FAILOVER uses endpoints in the order they are specified
+// (the 1st one is primary, the 2nd one is secondary, etc),
+// CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN uses healthy endpoints one by one,
+// CI_WRITER_HTTP_ENDPOINTS_WEIGHTED chooses healthy endpoints randomly,
+// proportionally to their weights.
+//
+// Which endpoint is healthy and how much entries packs each endpoint got
+// are reported by Stats().
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once), or if any endpoint's addr is invalid.
+//
+// Default: the provider's addr is used as is.
+func (dw *CI_WriterHttp) SetEndpoints(
+
+	strategy CI_WriterHttp_EndpointStrategy,
+	endpoints ...CI_WriterHttp_Endpoint,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if e := newEndpoints(strategy, endpoints); e != nil {
+			dw.endpoints = e
+		}
+	})
+}
+
+// SetEndpointCooldown sets how long the endpoint, that is failed,
+// is not used if there is a healthy one. See SetEndpoints().
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [1s..1h], default: 30s.
+func (dw *CI_WriterHttp) SetEndpointCooldown(cooldown time.Duration) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if cooldown >= time.Second && cooldown <= time.Hour {
+			dw.endpointCooldown = cooldown
+		}
+	})
+}
+
+// SetCompression sets an algorithm and its level, using which
+// the HTTP request's body (entries pack) will be compressed before it's sent.
+// The "Content-Encoding" HTTP header is set accordingly.
+//
+// The body is compressed after it's prepared by the body preparer
+// (see UseProviderManual()).
+//
+// Make sure your provider supports chosen algorithm.
+// Predefined providers (UseProvider<...>() methods) enable compression
+// if their services support it, unless this method is called
+// (no matter before or after them).
+//
+// 'level' == 0 means default level for the chosen algorithm.
+// CI_WRITER_HTTP_COMPRESSION_NONE disables compression.
+//
+// Read p.10 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range of 'level':
+//  - gzip, deflate: [-2..9],
+//  - zstd: [1..22].
+// Default: no compression.
+func (dw *CI_WriterHttp) SetCompression(algo CI_WriterHttp_Compression, level int) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		switch {
+		case algo == CI_WRITER_HTTP_COMPRESSION_NONE:
+		case algo == CI_WRITER_HTTP_COMPRESSION_GZIP && level >= -2 && level <= 9:
+		case algo == CI_WRITER_HTTP_COMPRESSION_DEFLATE && level >= -2 && level <= 9:
+		case algo == CI_WRITER_HTTP_COMPRESSION_ZSTD && level >= 0 && level <= 22:
+		default:
+			return
+		}
+		dw.compressionAlgo = algo
+		dw.compressionLevel = level
+		dw.compressionSet = true
+	})
+}
+
+// SetPackBuilder sets a CI_WriterHttp_PackBuilder, that builds HTTP request's body
+// from the encoded log entries of an entries pack, framing each of them.
+// Data, set by AddBefore(), AddAfter(), AddBetween() methods, is ignored then.
+//
+// The pack max size (see SetPackMaxSize()) accounts the framing.
+//
+// 'builder' takes precedence over the predefined provider's one
+// (see UseProvider...() methods), no matter whether they're called
+// before or after this method.
+//
+// Read p.4 of CI_WriterHttp doc for more info.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+// Default: AddBefore(), AddAfter(), AddBetween() data is used.
+func (dw *CI_WriterHttp) SetPackBuilder(builder CI_WriterHttp_PackBuilder) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.packBuilder = builder
+	})
+}
+
 // AddBefore sets the data that will be added to the encoded entries pack's buffer
 // before the first encoded entry is added.
 //
@@ -435,6 +1255,9 @@ func (dw *CI_WriterHttp) AddBetweenS(data string) *CI_WriterHttp {
 //
 // All other variants of arguments are ignored and does no-op.
 //
+// Predefined providers (UseProvider<...>() methods) frame encoded log entries
+// by their own pack builder, so the data is ignored then (see SetPackBuilder()).
+//
 // Nil safe. There is no-op if CI_WriterHttp already initialized.
 func (dw *CI_WriterHttp) AddBeforeAfterBetween(args ...[]byte) *CI_WriterHttp {
 	return dw.configure(func(dw *CI_WriterHttp) {
@@ -497,11 +1320,15 @@ func (dw *CI_WriterHttp) Ping(cb ...func(req *fasthttp.Request)) *ekaerr.Error {
 // the CI_WriterHttp can not be used anymore.
 //
 // Returned errors:
-// - nil: OK, 'p' has been queued.
+// - nil: OK, 'p' has been queued (maybe truncated because of SetEntryMaxSize()).
 // - ErrWriterIsNil: CI_WriterHttp receiver is nil.
 // - ErrWriterDisabled: CI_WriterHttp is stopped and will never start again.
 // - ErrWriterBufferFull: Internal CI_WriterHttp's buffer of processed entries
-//   is full. Next time set bigger buffer's length using SetBufferCap().
+//   is full and 'p' is dropped according with overflow policy
+//   (see SetOverflowPolicy()). Next time set bigger buffer's length
+//   using SetBufferCap().
+// - ErrWriterEntryTooLarge: 'p' is bigger than the max size of encoded log entry
+//   and it's dropped according with oversize policy (see SetEntryMaxSize()).
 func (dw *CI_WriterHttp) Write(p []byte) (n int, err error) {
 	switch {
 
@@ -513,15 +1340,174 @@ func (dw *CI_WriterHttp) Write(p []byte) (n int, err error) {
 
 	case !dw.canWrite():
 		return -1, ErrWriterDisabled
+
+	case dw.entryMaxSize != 0 && uint32(len(p)) > dw.entryMaxSize:
+		if dw.entryOversizePolicy == CI_WRITER_HTTP_OVERSIZE_DROP {
+			atomic.AddUint64(&dw.stats.entriesDropped, 1)
+			dw.loseEntries(_LOST_REASON_TOO_LARGE, 1)
+			return -1, ErrWriterEntryTooLarge
+		}
+		n = len(p)
+		p = p[:dw.entryMaxSize]
+		atomic.AddUint64(&dw.stats.entriesTruncated, 1)
+	}
+
+	if n == 0 {
+		n = len(p)
 	}
 
 	select {
 
 	case dw.entries <- p:
-		return len(p), nil
+		atomic.AddUint64(&dw.stats.entriesWritten, 1)
+		return n, nil
 
 	default:
-		atomic.AddUint64(&dw.entriesCompletelyLostCounter, 1)
-		return -1, ErrWriterBufferFull
+		if err = dw.writeOverflow(p); err != nil {
+			return -1, err
+		}
+		atomic.AddUint64(&dw.stats.entriesWritten, 1)
+		return n, nil
+	}
+}
+
+// Flush forces all workers to send all accumulated encoded entries
+// (and those that are queued at the moment of the call) and waits until it's done.
+// Entries packs, that are being sent at the moment of the call
+// (by any worker), are waited for also.
+//
+// Returns an error if the 'ctx' is done before flushing is complete
+// (some entries may be sent later anyway) or if CI_WriterHttp is disabled.
+// Does nothing if CI_WriterHttp is not initialized yet.
+//
+// Keep in mind, that entries pack is considered sent even if it's deferred
+// because your provider is not available at this moment.
+// Use Stats() to know what is happening.
+//
+// Read p.7 of CI_WriterHttp doc for more info.
+func (dw *CI_WriterHttp) Flush(ctx context.Context) *ekaerr.Error {
+	switch {
+
+	case dw == nil:
+		return ekaerr.IllegalState.
+			New("CI_WriterHttp: writer is nil (not initialized)").
+			Throw()
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	switch atomic.LoadInt32(&dw.casInitStatus) {
+
+	case _CAS_STATUS_READY, _CAS_STATUS_TEMPORARY_DISABLED:
+
+	case _CAS_STATUS_NOT_INITIALIZED, _CAS_STATUS_INITIALIZING:
+		return nil
+
+	default:
+		return ekaerr.RejectedOperation.
+			New("CI_WriterHttp: writer is disabled (stopped)").
+			Throw()
+	}
+
+	// Entries packs that are being sent right now
+	// (or entries that are being evicted) must be waited for also.
+	inFlightBefore := dw.inFlight.now()
+
+	wg := new(sync.WaitGroup)
+	wg.Add(int(dw.workerNum))
+
+	for i := range dw.workerFlushRequests {
+		select {
+		case dw.workerFlushRequests[i] <- wg:
+		case <-dw.ctx.Done():
+			return ekaerr.RejectedOperation.
+				New("CI_WriterHttp: writer is disabled while flushing").
+				Throw()
+		case <-ctx.Done():
+			return ekaerr.RejectedOperation.
+				Wrap(ctx.Err(), "CI_WriterHttp: Flush is aborted").
+				Throw()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		dw.inFlight.wait(inFlightBefore)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-dw.ctx.Done():
+		return ekaerr.RejectedOperation.
+			New("CI_WriterHttp: writer is disabled while flushing").
+			Throw()
+	case <-ctx.Done():
+		return ekaerr.RejectedOperation.
+			Wrap(ctx.Err(), "CI_WriterHttp: Flush is aborted").
+			Throw()
+	}
+}
+
+// Sync implements ekatyp.Syncer interface, calling Flush() with no deadline.
+// So, ekalog.CommonIntegrator's Sync() call leads to flushing CI_WriterHttp.
+//
+// Returns ErrWriterIsNil, ErrWriterDisabled or ErrWriterFlushAbort
+// if flushing is failed.
+func (dw *CI_WriterHttp) Sync() error {
+	switch {
+
+	case dw == nil:
+		return ErrWriterIsNil
+
+	case dw.Flush(context.Background()).IsNotNil():
+		if atomic.LoadInt32(&dw.casInitStatus) == _CAS_STATUS_FINALLY_DISABLED {
+			return ErrWriterDisabled
+		}
+		return ErrWriterFlushAbort
+	}
+
+	return nil
+}
+
+// Close flushes all accumulated encoded entries (like Flush() does)
+// and then finally disables CI_WriterHttp. It can not be used anymore.
+//
+// Returns an error if the 'ctx' is done before it's complete.
+// In that case CI_WriterHttp is disabled anyway, but the rest of entries
+// are still being sent in the background.
+//
+// It's safe to call Close() many times, and it's safe to call it
+// with the ekadeath's destructor (see p.7 of CI_WriterHttp doc).
+func (dw *CI_WriterHttp) Close(ctx context.Context) *ekaerr.Error {
+	switch {
+
+	case dw == nil:
+		return ekaerr.IllegalState.
+			New("CI_WriterHttp: writer is nil (not initialized)").
+			Throw()
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		dw.disable()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ekaerr.RejectedOperation.
+			Wrap(ctx.Err(), "CI_WriterHttp: Close is aborted").
+			Throw()
 	}
 }
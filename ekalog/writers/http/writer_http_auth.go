@@ -0,0 +1,381 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_Authenticator is an authentication strategy of HTTP requests
+	// to the log service provider. See SetAuthenticator() method
+	// and NewAuth<...>() functions.
+	CI_WriterHttp_Authenticator interface {
+
+		// Authenticate authenticates HTTP request 'req', that is completely
+		// prepared (including compressed body). If an error is returned,
+		// the request is not sent and considered failed (and may be retried).
+		Authenticate(req *fasthttp.Request) *ekaerr.Error
+
+		// Invalidate is called when provider answers HTTP 401 Unauthorized
+		// or 403 Forbidden.
+		// Returns true if credentials are refreshed (or will be refreshed
+		// at the next Authenticate() call) and the request may be retried.
+		Invalidate() bool
+	}
+
+	// authHeader is a CI_WriterHttp_Authenticator,
+	// that sets the one HTTP header with static value.
+	authHeader struct {
+		header, value string
+	}
+
+	// authQuery is a CI_WriterHttp_Authenticator,
+	// that adds the one URI's query argument with static value.
+	authQuery struct {
+		param, value string
+	}
+
+	// authHMAC is a CI_WriterHttp_Authenticator,
+	// that signs HTTP request's body using HMAC-SHA256.
+	authHMAC struct {
+		secret          []byte
+		signatureHeader string
+		timestampHeader string
+	}
+
+	// authOAuth2 is a CI_WriterHttp_Authenticator,
+	// that uses OAuth2 client credentials grant to get an access token.
+	authOAuth2 struct {
+		tokenURL     string
+		clientID     string
+		clientSecret string
+		scopes       string
+
+		mu          sync.Mutex
+		accessToken string
+		expiresAt   time.Time
+
+		transport CI_WriterHttp_Transport
+	}
+
+	// CI_WriterHttp_TokenSource is a source of bearer access tokens.
+	// It returns an access token and its lifetime (0 means it's valid until
+	// provider answers HTTP 401 Unauthorized). See NewAuthTokenSource().
+	CI_WriterHttp_TokenSource func() (token string, expiresIn time.Duration, err *ekaerr.Error)
+
+	// authTokenSource is a CI_WriterHttp_Authenticator,
+	// that caches access tokens of CI_WriterHttp_TokenSource.
+	authTokenSource struct {
+		source CI_WriterHttp_TokenSource
+
+		mu          sync.Mutex
+		accessToken string
+		expiresAt   time.Time
+	}
+
+	// authTransportUser is implemented by CI_WriterHttp_Authenticator,
+	// that performs its own HTTP requests (like getting an access token).
+	// It's given CI_WriterHttp's transport (see SetTransport())
+	// at the initialization, so they use the same proxy, TLS, etc.
+	authTransportUser interface {
+		useTransport(transport CI_WriterHttp_Transport)
+	}
+
+	// oauth2TokenResponse is a successful OAuth2 access token response.
+	oauth2TokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Credentials (access token, AWS credentials) are refreshed
+	// this time before they're expired.
+	_CREDENTIALS_EXPIRY_DELTA = 30 * time.Second
+
+	// If it's unknown when credentials are expired,
+	// they're considered valid until provider answers HTTP 401.
+	_CREDENTIALS_NO_EXPIRY = 100 * 365 * 24 * time.Hour
+
+	// The maximum time the request to OAuth2 server may take (including redirects).
+	// The request is performed under the lock, so each HTTP request
+	// to the log service provider waits for it.
+	_OAUTH2_REQUEST_TIMEOUT = 10 * time.Second
+)
+
+// NewAuthBearer returns a CI_WriterHttp_Authenticator,
+// that sets "Authorization: Bearer <token>" HTTP header.
+func NewAuthBearer(token string) CI_WriterHttp_Authenticator {
+	return &authHeader{fasthttp.HeaderAuthorization, "Bearer " + token}
+}
+
+// NewAuthBasic returns a CI_WriterHttp_Authenticator,
+// that uses HTTP basic authentication.
+func NewAuthBasic(username, password string) CI_WriterHttp_Authenticator {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return &authHeader{fasthttp.HeaderAuthorization, "Basic " + credentials}
+}
+
+// NewAuthAPIKeyHeader returns a CI_WriterHttp_Authenticator,
+// that sets HTTP header 'header' to 'key' (like "X-API-Key: <key>").
+func NewAuthAPIKeyHeader(header, key string) CI_WriterHttp_Authenticator {
+	return &authHeader{header, key}
+}
+
+// NewAuthAPIKeyQuery returns a CI_WriterHttp_Authenticator,
+// that adds URI's query argument 'param' with the value 'key' (like "?api_key=<key>").
+func NewAuthAPIKeyQuery(param, key string) CI_WriterHttp_Authenticator {
+	return &authQuery{param, key}
+}
+
+// NewAuthHMAC returns a CI_WriterHttp_Authenticator,
+// that signs HTTP request's body (as it's sent, compressed if it's so)
+// using HMAC-SHA256 with 'secret' and sets hex encoded signature
+// to the HTTP header 'signatureHeader'.
+//
+// If 'timestampHeader' is not empty, the current unix timestamp (in seconds)
+// is set to this HTTP header, and the signed message is "<timestamp>.<body>",
+// protecting provider from replay attacks.
+func NewAuthHMAC(secret []byte, signatureHeader, timestampHeader string) CI_WriterHttp_Authenticator {
+	return &authHMAC{secret, signatureHeader, timestampHeader}
+}
+
+// NewAuthOAuth2ClientCredentials returns a CI_WriterHttp_Authenticator,
+// that gets an access token from OAuth2 server's 'tokenURL' using
+// client credentials grant, and sets "Authorization: Bearer <token>" HTTP header.
+//
+// The access token is cached and refreshed when it's expired,
+// or when provider answers HTTP 401 Unauthorized.
+func NewAuthOAuth2ClientCredentials(
+
+	tokenURL, clientID, clientSecret string,
+	scopes ...string,
+
+) CI_WriterHttp_Authenticator {
+
+	return &authOAuth2{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       strings.Join(scopes, " "),
+		transport:    NewTransportFastHTTP(nil),
+	}
+}
+
+// NewAuthTokenSource returns a CI_WriterHttp_Authenticator,
+// that gets an access token from 'source' and sets
+// "Authorization: Bearer <token>" HTTP header.
+//
+// The access token is cached and requested again when it's expired,
+// or when provider answers HTTP 401 Unauthorized.
+func NewAuthTokenSource(source CI_WriterHttp_TokenSource) CI_WriterHttp_Authenticator {
+	return &authTokenSource{source: source}
+}
+
+// SetAuthenticator sets a CI_WriterHttp_Authenticator, that authenticates
+// each HTTP request to the log service provider (including ping)
+// after the provider's request initializer is applied and the body is compressed.
+//
+// If provider answers HTTP 401 Unauthorized and authenticator refreshes
+// its credentials, the request is retried according with retry policy
+// (see SetRetryPolicy()).
+//
+// It overrides the authenticator of predefined provider, if it has one
+// (like UseProviderGoogleCloudLogging()).
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) SetAuthenticator(authenticator CI_WriterHttp_Authenticator) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.authenticator = authenticator
+	})
+}
+
+func (a *authHeader) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+	req.Header.Set(a.header, a.value)
+	return nil
+}
+
+func (a *authHeader) Invalidate() bool {
+	return false
+}
+
+func (a *authQuery) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+	req.URI().QueryArgs().Set(a.param, a.value)
+	return nil
+}
+
+func (a *authQuery) Invalidate() bool {
+	return false
+}
+
+func (a *authHMAC) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+
+	mac := hmac.New(sha256.New, a.secret)
+
+	if a.timestampHeader != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(a.timestampHeader, timestamp)
+		_, _ = mac.Write([]byte(timestamp + "."))
+	}
+
+	// Body() reads the body stream (if it's so) and replaces it by the read bytes.
+	_, _ = mac.Write(req.Body())
+
+	req.Header.Set(a.signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func (a *authHMAC) Invalidate() bool {
+	return false
+}
+
+func (a *authOAuth2) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accessToken == "" || time.Now().After(a.expiresAt) {
+		if err := a.refresh(); err.IsNotNil() {
+			return err.
+				AddMessage("CI_WriterHttp: Failed to get OAuth2 access token.").
+				Throw()
+		}
+	}
+
+	req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+a.accessToken)
+	return nil
+}
+
+func (a *authOAuth2) Invalidate() bool {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.accessToken = ""
+	return true
+}
+
+// refresh gets a new access token from OAuth2 server.
+// a.mu must be locked.
+func (a *authOAuth2) refresh() *ekaerr.Error {
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(a.tokenURL)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	args.Set("grant_type", "client_credentials")
+	if a.scopes != "" {
+		args.Set("scope", a.scopes)
+	}
+	req.SetBody(args.QueryString())
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(a.clientID + ":" + a.clientSecret))
+	req.Header.Set(fasthttp.HeaderAuthorization, "Basic "+credentials)
+
+	if legacyErr := a.transport.Do(req, resp, _OAUTH2_REQUEST_TIMEOUT); legacyErr != nil {
+		return ekaerr.ExternalError.
+			Wrap(legacyErr, "Failed to perform HTTP request.").
+			WithString("ci_writer_http_oauth2_url", a.tokenURL).
+			Throw()
+	}
+
+	if status := resp.StatusCode(); status != fasthttp.StatusOK {
+		return ekaerr.ExternalError.
+			New("Unexpected HTTP status code.").
+			WithInt("ci_writer_http_oauth2_status_code", status).
+			WithString("ci_writer_http_oauth2_url", a.tokenURL).
+			Throw()
+	}
+
+	var tokenResponse oauth2TokenResponse
+	if legacyErr := json.Unmarshal(resp.Body(), &tokenResponse); legacyErr != nil {
+		return ekaerr.ExternalError.
+			Wrap(legacyErr, "Failed to decode access token response.").
+			WithString("ci_writer_http_oauth2_url", a.tokenURL).
+			Throw()
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return ekaerr.ExternalError.
+			New("Access token response has no access token.").
+			WithString("ci_writer_http_oauth2_url", a.tokenURL).
+			Throw()
+	}
+
+	a.accessToken = tokenResponse.AccessToken
+	a.expiresAt = credentialsExpiresAt(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+
+	return nil
+}
+
+func (a *authTokenSource) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accessToken == "" || time.Now().After(a.expiresAt) {
+		accessToken, expiresIn, err := a.source()
+		if err.IsNotNil() {
+			return err.
+				AddMessage("CI_WriterHttp: Failed to get access token.").
+				Throw()
+		}
+
+		a.accessToken = accessToken
+		a.expiresAt = credentialsExpiresAt(expiresIn)
+	}
+
+	req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+a.accessToken)
+	return nil
+}
+
+func (a *authTokenSource) Invalidate() bool {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.accessToken = ""
+	return true
+}
+
+// credentialsExpiresAt returns the time credentials with lifetime 'expiresIn'
+// must be refreshed at. A little bit earlier than they're expired,
+// so the request, that is authenticated right before, is not rejected.
+// If 'expiresIn' <= 0, it's unknown when credentials are expired.
+func credentialsExpiresAt(expiresIn time.Duration) time.Time {
+
+	if expiresIn <= 0 {
+		expiresIn = _CREDENTIALS_NO_EXPIRY
+	} else if expiresIn > 2*_CREDENTIALS_EXPIRY_DELTA {
+		expiresIn -= _CREDENTIALS_EXPIRY_DELTA
+	}
+
+	return time.Now().Add(expiresIn)
+}
@@ -0,0 +1,288 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekastr"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_AWSCredentials are AWS security credentials.
+	// SessionToken is required only for temporary credentials.
+	CI_WriterHttp_AWSCredentials struct {
+		AccessKeyID     string
+		SecretAccessKey string
+		SessionToken    string
+	}
+
+	// CI_WriterHttp_AWSCredentialsSource is a source of AWS credentials.
+	// It returns credentials and their lifetime (0 means they're valid until
+	// provider answers HTTP 401 Unauthorized or 403 Forbidden).
+	// See NewAuthAWSSigV4WithSource().
+	CI_WriterHttp_AWSCredentialsSource func() (credentials CI_WriterHttp_AWSCredentials, expiresIn time.Duration, err *ekaerr.Error)
+
+	// authAWSSigV4 is a CI_WriterHttp_Authenticator,
+	// that signs HTTP requests using AWS Signature Version 4.
+	authAWSSigV4 struct {
+		region  string
+		service string
+		source  CI_WriterHttp_AWSCredentialsSource
+
+		mu          sync.Mutex
+		credentials CI_WriterHttp_AWSCredentials
+		expiresAt   time.Time
+	}
+
+	// awsHeader is a canonical HTTP header of AWS SigV4's canonical request.
+	awsHeader struct {
+		name, value string
+	}
+)
+
+// NewAuthAWSSigV4 returns a CI_WriterHttp_Authenticator, that signs
+// HTTP requests using AWS Signature Version 4
+// ( https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html ).
+// The signature covers the final body (prepared and compressed if it's so).
+//
+// 'region' and 'service' are AWS region (like "eu-west-1")
+// and signing name of AWS service (like "logs").
+//
+// If 'accessKeyID' is empty, credentials are taken from the environment variables
+// (see AWSEnvCredentialsSource()) and are read again
+// when provider answers HTTP 401 Unauthorized or 403 Forbidden.
+// 'sessionToken' is required only for temporary credentials.
+func NewAuthAWSSigV4(
+
+	region, service string,
+	accessKeyID, secretAccessKey, sessionToken string,
+
+) CI_WriterHttp_Authenticator {
+
+	if accessKeyID == "" {
+		return NewAuthAWSSigV4WithSource(region, service, AWSEnvCredentialsSource())
+	}
+
+	credentials := CI_WriterHttp_AWSCredentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
+
+	return &authAWSSigV4{
+		region:  region,
+		service: service,
+		source: func() (CI_WriterHttp_AWSCredentials, time.Duration, *ekaerr.Error) {
+			return credentials, 0, nil
+		},
+	}
+}
+
+// NewAuthAWSSigV4WithSource is the same as NewAuthAWSSigV4(), but credentials
+// are got from 'source' and cached until they're expired or provider
+// answers HTTP 401 Unauthorized or 403 Forbidden.
+// Use it for temporary credentials (STS, ECS task role, EC2 instance profile).
+func NewAuthAWSSigV4WithSource(
+
+	region, service string,
+	source CI_WriterHttp_AWSCredentialsSource,
+
+) CI_WriterHttp_Authenticator {
+
+	return &authAWSSigV4{
+		region:  region,
+		service: service,
+		source:  source,
+	}
+}
+
+// AWSEnvCredentialsSource returns a CI_WriterHttp_AWSCredentialsSource,
+// that reads credentials from the environment variables
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN.
+func AWSEnvCredentialsSource() CI_WriterHttp_AWSCredentialsSource {
+	return func() (CI_WriterHttp_AWSCredentials, time.Duration, *ekaerr.Error) {
+		credentials := CI_WriterHttp_AWSCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		return credentials, 0, nil
+	}
+}
+
+func (a *authAWSSigV4) Authenticate(req *fasthttp.Request) *ekaerr.Error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.credentials.AccessKeyID == "" || time.Now().After(a.expiresAt) {
+		credentials, expiresIn, err := a.source()
+		if err.IsNotNil() {
+			return err.
+				AddMessage("CI_WriterHttp: Failed to get AWS credentials.").
+				Throw()
+		}
+
+		a.credentials = credentials
+		a.expiresAt = credentialsExpiresAt(expiresIn)
+	}
+
+	if a.credentials.AccessKeyID == "" || a.credentials.SecretAccessKey == "" {
+		return ekaerr.IllegalState.
+			New("CI_WriterHttp: AWS credentials are not provided.").
+			Throw()
+	}
+
+	a.sign(req, a.credentials, time.Now())
+	return nil
+}
+
+// Invalidate gets credentials from the source again. The request may be retried
+// only if they're changed (it's not so for the static credentials
+// or if the environment variables are not updated).
+func (a *authAWSSigV4) Invalidate() bool {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	credentials, expiresIn, err := a.source()
+	if err.IsNotNil() {
+		return false
+	}
+
+	changed := credentials.AccessKeyID != a.credentials.AccessKeyID ||
+		credentials.SessionToken != a.credentials.SessionToken
+
+	a.credentials = credentials
+	a.expiresAt = credentialsExpiresAt(expiresIn)
+
+	return changed
+}
+
+// sign signs 'req' using 'credentials' at the time 't', adding "X-Amz-Date",
+// "X-Amz-Security-Token" (if it's required) and "Authorization" HTTP headers.
+func (a *authAWSSigV4) sign(req *fasthttp.Request, credentials CI_WriterHttp_AWSCredentials, t time.Time) {
+
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	scope := t.Format("20060102") + "/" + a.region + "/" + a.service + "/aws4_request"
+
+	req.Header.SetHost(ekastr.B2S(req.URI().Host()))
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// Body() reads the body stream (if it's so) and replaces it by the read bytes.
+	bodyHash := sha256.Sum256(req.Body())
+
+	// Canonical headers: Host, Content-Type and all X-Amz-* headers.
+	var headers []awsHeader
+	req.Header.VisitAll(func(key, value []byte) {
+		name := strings.ToLower(string(key))
+		if name == "host" || name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers = append(headers, awsHeader{name, strings.TrimSpace(string(value))})
+		}
+	})
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].name < headers[j].name
+	})
+
+	var canonicalHeaders, signedHeaders strings.Builder
+	for i := range headers {
+		canonicalHeaders.WriteString(headers[i].name + ":" + headers[i].value + "\n")
+		if i > 0 {
+			signedHeaders.WriteByte(';')
+		}
+		signedHeaders.WriteString(headers[i].name)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		string(req.Header.Method()),
+		awsCanonicalURI(string(req.URI().Path())),
+		awsCanonicalQuery(req.URI().QueryArgs()),
+		canonicalHeaders.String(),
+		signedHeaders.String(),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(canonicalRequestHash[:])
+
+	key := awsHMAC([]byte("AWS4"+credentials.SecretAccessKey), t.Format("20060102"))
+	key = awsHMAC(key, a.region)
+	key = awsHMAC(key, a.service)
+	key = awsHMAC(key, "aws4_request")
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set(fasthttp.HeaderAuthorization, "AWS4-HMAC-SHA256 "+
+		"Credential="+credentials.AccessKeyID+"/"+scope+", "+
+		"SignedHeaders="+signedHeaders.String()+", "+
+		"Signature="+signature)
+}
+
+// awsHMAC returns HMAC-SHA256 of 'data' using 'key'.
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsCanonicalURI returns URI-encoded 'path' (except "/").
+func awsCanonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return awsURIEncode(path, false)
+}
+
+// awsCanonicalQuery returns URI-encoded query arguments sorted by name and value.
+func awsCanonicalQuery(args *fasthttp.Args) string {
+
+	var pairs []string
+	args.VisitAll(func(key, value []byte) {
+		pairs = append(pairs, awsURIEncode(string(key), true)+"="+awsURIEncode(string(value), true))
+	})
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode encodes 's' as AWS SigV4 requires: all bytes except
+// unreserved chars (and "/" if 'encodeSlash' is false) are percent encoded.
+func awsURIEncode(s string, encodeSlash bool) string {
+
+	const hexChars = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(hexChars[c>>4])
+			sb.WriteByte(hexChars[c&15])
+		}
+	}
+
+	return sb.String()
+}
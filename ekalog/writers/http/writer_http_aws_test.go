@@ -0,0 +1,173 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/valyala/fasthttp"
+)
+
+// AWS SigV4 test suite's credentials
+// ( https://docs.aws.amazon.com/general/latest/gr/signature-v4-test-suite.html ).
+var awsTestCredentials = CI_WriterHttp_AWSCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestAuthAWSSigV4_Sign(t *testing.T) {
+
+	// "get-vanilla" case of AWS SigV4 test suite.
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI("https://example.amazonaws.com/")
+	req.Header.SetMethod(fasthttp.MethodGet)
+
+	a := NewAuthAWSSigV4("us-east-1", "service",
+		awsTestCredentials.AccessKeyID, awsTestCredentials.SecretAccessKey, "").(*authAWSSigV4)
+	a.sign(req, awsTestCredentials, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	const expected = "AWS4-HMAC-SHA256 " +
+		"Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+	if got := string(req.Header.Peek(fasthttp.HeaderAuthorization)); got != expected {
+		t.Fatalf("unexpected Authorization header:\n got: %s\nwant: %s", got, expected)
+	}
+	if got := string(req.Header.Peek("X-Amz-Date")); got != "20150830T123600Z" {
+		t.Fatalf("unexpected X-Amz-Date header: %s", got)
+	}
+}
+
+func TestAuthAWSSigV4_Refresh(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		tokens   []string
+		sourceN  int
+		statuses = []int{http.StatusForbidden, http.StatusOK}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("request is not signed: %q", r.Header.Get("Authorization"))
+		}
+		tokens = append(tokens, r.Header.Get("X-Amz-Security-Token"))
+
+		status := http.StatusOK
+		if len(tokens) <= len(statuses) {
+			status = statuses[len(tokens)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	source := func() (CI_WriterHttp_AWSCredentials, time.Duration, *ekaerr.Error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sourceN++
+		credentials := awsTestCredentials
+		credentials.SessionToken = "token" + string(rune('0'+sourceN))
+		return credentials, time.Hour, nil
+	}
+
+	dw := new(CI_WriterHttp).
+		UseProviderManual(func(req *fasthttp.Request) {
+			req.SetRequestURI(srv.URL)
+		}, nil).
+		SetAuthenticator(NewAuthAWSSigV4WithSource("us-east-1", "logs", source)).
+		SetRetryPolicy(3, time.Millisecond, time.Millisecond, 0)
+
+	if _, err := dw.Write([]byte(`{"message":"test"}`)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := dw.Flush(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to flush")
+	}
+	defer dw.Close(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The ping is answered by 403, so credentials are refreshed.
+	if sourceN != 2 {
+		t.Fatalf("credentials are requested %d times, want 2", sourceN)
+	}
+	if len(tokens) < 2 || tokens[0] != "token1" || tokens[1] != "token2" {
+		t.Fatalf("unexpected session tokens: %v", tokens)
+	}
+}
+
+func TestAuthAWSSigV4_Invalidate(t *testing.T) {
+
+	rotated := awsTestCredentials
+	rotated.SessionToken = "rotated"
+
+	tests := []struct {
+		name        string
+		credentials []CI_WriterHttp_AWSCredentials // returned by the source one by one
+		failed      bool                           // the source fails after the first call
+		refreshed   bool
+	}{
+		{"unchanged", []CI_WriterHttp_AWSCredentials{awsTestCredentials, awsTestCredentials}, false, false},
+		{"session token changed", []CI_WriterHttp_AWSCredentials{awsTestCredentials, rotated}, false, true},
+		{"source failed", []CI_WriterHttp_AWSCredentials{awsTestCredentials}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 0
+			source := func() (CI_WriterHttp_AWSCredentials, time.Duration, *ekaerr.Error) {
+				n++
+				if tt.failed && n > 1 {
+					return CI_WriterHttp_AWSCredentials{}, 0, ekaerr.ExternalError.New("failed").Throw()
+				}
+				return tt.credentials[n-1], 0, nil
+			}
+
+			a := NewAuthAWSSigV4WithSource("us-east-1", "logs", source)
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.SetRequestURI("https://logs.us-east-1.amazonaws.com/")
+
+			if err := a.Authenticate(req); err.IsNotNil() {
+				t.Fatal("failed to authenticate")
+			}
+			if refreshed := a.Invalidate(); refreshed != tt.refreshed {
+				t.Fatalf("Invalidate() = %v, want %v", refreshed, tt.refreshed)
+			}
+		})
+	}
+
+	static := NewAuthAWSSigV4("us-east-1", "logs",
+		awsTestCredentials.AccessKeyID, awsTestCredentials.SecretAccessKey, "")
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("https://logs.us-east-1.amazonaws.com/")
+
+	if err := static.Authenticate(req); err.IsNotNil() {
+		t.Fatal("failed to authenticate")
+	}
+	if static.Invalidate() {
+		t.Fatal("static credentials are refreshed")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// _CI_WriterHttpBreaker is a circuit breaker of HTTP requests
+	// to the log service provider.
+	//
+	// Closed: requests are sent, their results are saved to the sliding window
+	// of the last N results. When there are enough results and the part
+	// of failed ones reaches the threshold, the breaker is open.
+	//
+	// Open: requests are not sent (entries packs are deferred)
+	// until open duration is over. Then the breaker is half-open.
+	//
+	// Half-open: only one request (probe) is allowed. If it's succeeded,
+	// the breaker is closed, if it's failed, the breaker is open again.
+	//
+	// Thread-safe.
+	_CI_WriterHttpBreaker struct {
+		mu sync.Mutex
+
+		state uint8
+
+		failureRate  float64
+		minRequests  int
+		openDuration time.Duration
+
+		// Ring buffer of the last results. True means failed.
+		window         []bool
+		windowPos      int
+		windowLen      int
+		windowFailures int
+
+		// openedAt is when the breaker has been opened last time.
+		// Results of requests, started before that, are ignored.
+		openedAt      time.Time
+		openUntil     time.Time
+		probeInFlight bool
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_BREAKER_CLOSED = uint8(iota)
+	_BREAKER_OPEN
+	_BREAKER_HALF_OPEN
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_DEFAULT_BREAKER_FAILURE_RATE  = 0.5
+	_DEFAULT_BREAKER_WINDOW        = 20
+	_DEFAULT_BREAKER_MIN_REQUESTS  = 5
+	_DEFAULT_BREAKER_OPEN_DURATION = 5 * time.Second
+
+	// Probe ticker's interval is open duration divided by this value,
+	// but no more than _BREAKER_PROBE_INTERVAL_MAX.
+	_BREAKER_PROBES_PER_OPEN_DURATION = 4
+	_BREAKER_PROBE_INTERVAL_MAX       = time.Second
+)
+
+// newBreaker creates a new closed circuit breaker.
+func newBreaker(
+
+	failureRate float64,
+	window, minRequests uint16,
+	openDuration time.Duration,
+
+) *_CI_WriterHttpBreaker {
+
+	return &_CI_WriterHttpBreaker{
+		failureRate:  failureRate,
+		minRequests:  int(minRequests),
+		openDuration: openDuration,
+		window:       make([]bool, window),
+	}
+}
+
+// allow reports whether the request may be sent right now.
+// If the breaker is open and open duration is over, it becomes half-open,
+// and the caller's request is the probe.
+func (b *_CI_WriterHttpBreaker) allow() bool {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+
+	case _BREAKER_OPEN:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = _BREAKER_HALF_OPEN
+		b.probeInFlight = true
+		return true
+
+	case _BREAKER_HALF_OPEN:
+		if b.probeInFlight {
+			return false
+		}
+		b.probeInFlight = true
+		return true
+
+	default:
+		return true
+	}
+}
+
+// record saves the result of the request, that has been started at 'startedAt'.
+// 'minOpenDuration' is the minimum time the breaker is open for,
+// if it's opened (like provider's "Retry-After").
+// Returns the state of the breaker before and after.
+//
+// The result of the request, started before the breaker has been opened
+// last time, is ignored. It's outdated and must not close the breaker.
+func (b *_CI_WriterHttpBreaker) record(
+
+	failed bool,
+	minOpenDuration time.Duration,
+	startedAt time.Time,
+
+) (oldState, newState uint8) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldState = b.state
+
+	switch {
+
+	case startedAt.Before(b.openedAt):
+		// Outdated result. Ignore it.
+
+	case !failed && b.state != _BREAKER_CLOSED:
+		// The provider is available again.
+		b.state = _BREAKER_CLOSED
+		b.probeInFlight = false
+		b.windowPos, b.windowLen, b.windowFailures = 0, 0, 0
+
+	case failed && b.state == _BREAKER_HALF_OPEN:
+		b.open(minOpenDuration)
+
+	case b.state == _BREAKER_CLOSED:
+		if b.windowLen == len(b.window) {
+			if b.window[b.windowPos] {
+				b.windowFailures--
+			}
+		} else {
+			b.windowLen++
+		}
+
+		b.window[b.windowPos] = failed
+		b.windowPos = (b.windowPos + 1) % len(b.window)
+
+		if failed {
+			b.windowFailures++
+		}
+
+		if b.windowLen >= b.minRequests &&
+			float64(b.windowFailures) >= b.failureRate*float64(b.windowLen) {
+			b.open(minOpenDuration)
+		}
+	}
+
+	return oldState, b.state
+}
+
+// open opens the breaker for open duration, but at least for 'minOpenDuration'.
+// b.mu must be locked.
+func (b *_CI_WriterHttpBreaker) open(minOpenDuration time.Duration) {
+
+	openDuration := b.openDuration
+	if minOpenDuration > openDuration {
+		openDuration = minOpenDuration
+	}
+
+	b.state = _BREAKER_OPEN
+	b.probeInFlight = false
+	b.openedAt = time.Now()
+	b.openUntil = b.openedAt.Add(openDuration)
+}
+
+// release releases the probe slot (if the breaker is half-open),
+// when the probe request has not been sent to the provider at all
+// (like its body can't be prepared or all its entries are sampled out).
+// So, the next request is the probe.
+func (b *_CI_WriterHttpBreaker) release() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == _BREAKER_HALF_OPEN {
+		b.probeInFlight = false
+	}
+}
+
+// getState returns the current state of the breaker.
+func (b *_CI_WriterHttpBreaker) getState() uint8 {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// probeInterval returns how often the master worker checks whether
+// it's time to probe the provider.
+func (b *_CI_WriterHttpBreaker) probeInterval() time.Duration {
+
+	interval := b.openDuration / _BREAKER_PROBES_PER_OPEN_DURATION
+	if interval > _BREAKER_PROBE_INTERVAL_MAX {
+		interval = _BREAKER_PROBE_INTERVAL_MAX
+	}
+
+	return interval
+}
+
+// breakerStateString returns a human-readable representation of breaker's state.
+func breakerStateString(state uint8) string {
+	switch state {
+	case _BREAKER_CLOSED:
+		return "closed"
+	case _BREAKER_OPEN:
+		return "open"
+	case _BREAKER_HALF_OPEN:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
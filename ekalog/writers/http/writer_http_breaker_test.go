@@ -0,0 +1,160 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker_Open(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		results []bool // true means failed
+		want    uint8
+	}{
+		{"too few requests", []bool{true, true, true, true}, _BREAKER_CLOSED},
+		{"threshold reached", []bool{false, true, false, true, true}, _BREAKER_OPEN},
+		{"below threshold", []bool{false, true, false, true, false, false}, _BREAKER_CLOSED},
+		{"failures out of window", []bool{true, true, false, false, false, false, false, false, true, true}, _BREAKER_CLOSED},
+		{"failures in window", []bool{false, false, false, false, false, false, true, true, true}, _BREAKER_OPEN},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(0.5, 6, 5, time.Hour)
+
+			for _, failed := range tt.results {
+				b.record(failed, 0, time.Now())
+			}
+
+			if state := b.getState(); state != tt.want {
+				t.Fatalf("breaker is %s, want %s",
+					breakerStateString(state), breakerStateString(tt.want))
+			}
+			if allowed := b.allow(); allowed != (tt.want == _BREAKER_CLOSED) {
+				t.Fatalf("allow() = %t for %s breaker", allowed, breakerStateString(tt.want))
+			}
+		})
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		probeFail bool
+		want      uint8
+	}{
+		{"probe succeeded", false, _BREAKER_CLOSED},
+		{"probe failed", true, _BREAKER_OPEN},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(0.5, 2, 1, time.Hour)
+
+			b.record(true, 0, time.Now())
+			if b.allow() {
+				t.Fatal("allow() = true before open duration is over")
+			}
+
+			// Open duration is over.
+			b.openUntil = time.Now()
+
+			probeStartedAt := time.Now()
+			switch {
+			case !b.allow():
+				t.Fatal("allow() = false after open duration is over")
+			case b.getState() != _BREAKER_HALF_OPEN:
+				t.Fatalf("breaker is %s, want half-open", breakerStateString(b.getState()))
+			case b.allow():
+				t.Fatal("allow() = true while the probe is in flight")
+			}
+
+			oldState, newState := b.record(tt.probeFail, 0, probeStartedAt)
+			switch {
+			case oldState != _BREAKER_HALF_OPEN:
+				t.Fatalf("record() old state is %s, want half-open", breakerStateString(oldState))
+			case newState != tt.want:
+				t.Fatalf("record() new state is %s, want %s",
+					breakerStateString(newState), breakerStateString(tt.want))
+			case b.allow() != (tt.want == _BREAKER_CLOSED):
+				t.Fatalf("allow() = %t for %s breaker", !(tt.want == _BREAKER_CLOSED), breakerStateString(tt.want))
+			}
+		})
+	}
+}
+
+func TestBreaker_MinOpenDuration(t *testing.T) {
+
+	b := newBreaker(0.5, 2, 1, time.Millisecond)
+	b.record(true, time.Hour, time.Now())
+
+	if d := time.Until(b.openUntil); d < 59*time.Minute {
+		t.Fatalf("breaker is open for %s, want 1h", d)
+	}
+}
+
+func TestBreaker_Release(t *testing.T) {
+
+	b := newBreaker(0.5, 2, 1, time.Hour)
+	b.record(true, 0, time.Now())
+	b.openUntil = time.Now()
+
+	if !b.allow() {
+		t.Fatal("allow() = false after open duration is over")
+	}
+
+	// The probe has not been sent. The next request is the probe.
+	b.release()
+
+	switch {
+	case b.getState() != _BREAKER_HALF_OPEN:
+		t.Fatalf("breaker is %s after release(), want half-open", breakerStateString(b.getState()))
+	case !b.allow():
+		t.Fatal("allow() = false after release()")
+	case b.allow():
+		t.Fatal("allow() = true while the next probe is in flight")
+	}
+
+	// release() does nothing if the breaker is closed.
+	b.record(false, 0, time.Now())
+	b.release()
+
+	if b.getState() != _BREAKER_CLOSED || !b.allow() {
+		t.Fatalf("breaker is %s after release(), want closed", breakerStateString(b.getState()))
+	}
+}
+
+func TestBreaker_IgnoresOutdated(t *testing.T) {
+
+	b := newBreaker(0.5, 2, 1, time.Hour)
+
+	startedAt := time.Now()
+	time.Sleep(time.Millisecond)
+	b.record(true, 0, time.Now())
+
+	// The request has been started before the breaker is opened.
+	if _, state := b.record(false, 0, startedAt); state != _BREAKER_OPEN {
+		t.Fatalf("breaker is %s after outdated success, want open", breakerStateString(state))
+	}
+
+	b.openUntil = time.Now()
+	if !b.allow() {
+		t.Fatal("allow() = false after open duration is over")
+	}
+
+	// The same for the half-open breaker: only the probe's result matters.
+	if _, state := b.record(true, 0, startedAt); state != _BREAKER_HALF_OPEN {
+		t.Fatalf("breaker is %s after outdated failure, want half-open", breakerStateString(state))
+	}
+
+	if _, state := b.record(false, 0, time.Now()); state != _BREAKER_CLOSED {
+		t.Fatalf("breaker is %s after the probe succeeded, want closed", breakerStateString(state))
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type (
+	// cloudWatchLogEvent is a CloudWatch Logs's InputLogEvent.
+	cloudWatchLogEvent struct {
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	}
+
+	// cloudWatchPutLogEventsRequest is a CloudWatch Logs's PutLogEvents request.
+	cloudWatchPutLogEventsRequest struct {
+		LogGroupName  string               `json:"logGroupName"`
+		LogStreamName string               `json:"logStreamName"`
+		LogEvents     []cloudWatchLogEvent `json:"logEvents"`
+	}
+
+	// cloudWatchPutLogEventsResponse is a CloudWatch Logs's PutLogEvents response.
+	cloudWatchPutLogEventsResponse struct {
+		RejectedLogEventsInfo *struct {
+			TooNewLogEventStartIndex *int `json:"tooNewLogEventStartIndex"`
+			TooOldLogEventEndIndex   *int `json:"tooOldLogEventEndIndex"`
+			ExpiredLogEventEndIndex  *int `json:"expiredLogEventEndIndex"`
+		} `json:"rejectedLogEventsInfo"`
+	}
+
+	// cloudWatchError is a CloudWatch Logs's error response.
+	cloudWatchError struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// CloudWatch Logs's PutLogEvents limits.
+	// Each log event's size is its message's size plus 26 bytes.
+	_CLOUDWATCH_MAX_EVENTS_PER_BATCH = 10000
+	_CLOUDWATCH_MAX_BATCH_SIZE       = 1048576
+	_CLOUDWATCH_MAX_EVENT_SIZE       = 262144
+	_CLOUDWATCH_EVENT_OVERHEAD       = 26
+	_CLOUDWATCH_MAX_BATCH_SPAN       = 24 * time.Hour
+
+	_CLOUDWATCH_TARGET_PREFIX = "Logs_20140328."
+)
+
+// CloudWatchLogsAddr returns an addr of AWS CloudWatch Logs
+// for AWS region 'region' (like "eu-west-1").
+func CloudWatchLogsAddr(region string) string {
+	return "https://logs." + region + ".amazonaws.com"
+}
+
+// UseProviderCloudWatch setups CI_WriterHttp for AWS CloudWatch Logs
+// log service provider ( https://aws.amazon.com/cloudwatch/ ),
+// using its PutLogEvents API.
+//
+// You MUST specify 'addr' as CloudWatch Logs addr (see CloudWatchLogsAddr()),
+// or your local stub's addr, and the log group and the log stream
+// log entries are sent to. If they do not exist, they're created
+// (the permissions logs:CreateLogGroup, logs:CreateLogStream are required).
+//
+// You MUST set AWS Signature V4 authenticator (with "logs" service), like:
+//
+//     SetAuthenticator(NewAuthAWSSigV4("eu-west-1", "logs", "", "", ""))
+//
+// Encoded log entries MUST be single-line (use any JSON encoder).
+// They are log events' messages, and the time is extracted from
+// "@timestamp", "timestamp" or "time" field of encoded log entry (RFC3339 or ISO8601).
+// If there is no such field, the current time is used.
+// Log events are sorted chronologically, as CloudWatch Logs requires.
+//
+// CloudWatch Logs's limits are enforced: no more than 10,000 log events
+// and 1 MB per request, and no more than 256 KB per log event
+// (too big encoded log entries are dropped). Setters can't increase them.
+// Log events, that span more than 24 hours, are sent by separate requests.
+// Log events, that are rejected by CloudWatch Logs
+// (too old, too new or expired) are counted as lost.
+//
+// The ping (see Ping()) requests DescribeLogStreams
+// (the permission logs:DescribeLogStreams is required).
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderCloudWatch(addr, logGroup, logStream string) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/") + "/"
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", _CLOUDWATCH_TARGET_PREFIX+"PutLogEvents")
+	}
+
+	cb2 := func(body io.Reader) io.Reader {
+		return prepareCloudWatchBody(body, logGroup, logStream)
+	}
+
+	pingBody, _ := json.Marshal(map[string]interface{}{
+		"logGroupName":        logGroup,
+		"logStreamNamePrefix": logStream,
+		"limit":               1,
+	})
+
+	pingCb := func(req *fasthttp.Request) {
+		req.Header.Set("X-Amz-Target", _CLOUDWATCH_TARGET_PREFIX+"DescribeLogStreams")
+		req.Header.Del(fasthttp.HeaderContentEncoding)
+		req.SetBody(pingBody)
+	}
+
+	failureCb := func(status int, body []byte) bool {
+		return dw.cloudWatchCreateIfNotFound(addr, logGroup, logStream, status, body)
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingCb
+			dw.providerResponseHandler = responseBodyHandler(handleCloudWatchResponse)
+			dw.providerFailureHandler = failureCb
+			dw.providerPackSplitter = splitCloudWatchPack
+			dw.providerPackBuilder = newStaticPackBuilder("", "", "\n")
+			dw.providerMaxEntriesPerPack = _CLOUDWATCH_MAX_EVENTS_PER_BATCH
+			// Line separators are counted too, so it's a little bit less than 1 MB.
+			dw.providerPackMaxSize = _CLOUDWATCH_MAX_BATCH_SIZE
+			dw.providerEntryMaxSize = _CLOUDWATCH_MAX_EVENT_SIZE - _CLOUDWATCH_EVENT_OVERHEAD
+			dw.packEntryOverhead = _CLOUDWATCH_EVENT_OVERHEAD
+		}).
+		useProvider(cb1, cb2)
+}
+
+// prepareCloudWatchBody converts each line of 'body' (an encoded log entry)
+// to the log event, sorts them chronologically and returns PutLogEvents request.
+// If 'body' can't be read, _CI_WriterHttpBodyError is returned.
+func prepareCloudWatchBody(body io.Reader, logGroup, logStream string) io.Reader {
+
+	var (
+		req = cloudWatchPutLogEventsRequest{
+			LogGroupName:  logGroup,
+			LogStreamName: logStream,
+			LogEvents:     make([]cloudWatchLogEvent, 0, 32),
+		}
+		scanner = bufio.NewScanner(body)
+	)
+
+	// Encoded log entry might be much bigger than bufio.MaxScanTokenSize.
+	scanner.Buffer(nil, _CLOUDWATCH_MAX_EVENT_SIZE)
+
+	for scanner.Scan() {
+		encodedEntry := bytes.TrimSpace(scanner.Bytes())
+		if len(encodedEntry) == 0 {
+			continue
+		}
+		req.LogEvents = append(req.LogEvents, cloudWatchLogEvent{
+			Timestamp: encodedEntryTimeOf(encodedEntry).UnixNano() / 1e6,
+			Message:   string(encodedEntry),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &_CI_WriterHttpBodyError{err}
+	}
+
+	sort.SliceStable(req.LogEvents, func(i, j int) bool {
+		return req.LogEvents[i].Timestamp < req.LogEvents[j].Timestamp
+	})
+
+	encoded, _ := json.Marshal(req)
+	return bytes.NewReader(encoded)
+}
+
+// splitCloudWatchPack splits 'pack' into the groups of encoded log entries,
+// each of them spans no more than 24 hours, as CloudWatch Logs requires.
+// Returns nil if 'pack' may be sent as is.
+func splitCloudWatchPack(pack *_CI_WriterHttpPack) [][]int {
+
+	var (
+		data    = pack.data.Bytes()
+		offset  = 0
+		times   = make([]int64, len(pack.entries))
+		indexes = make([]int, len(pack.entries))
+	)
+
+	for i, n := range pack.entries {
+		times[i] = encodedEntryTimeOf(bytes.TrimSpace(data[offset : offset+int(n)])).UnixNano()
+		indexes[i] = i
+		offset += int(n)
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return times[indexes[i]] < times[indexes[j]]
+	})
+
+	var groups [][]int
+	for i, start := 0, 0; i <= len(indexes); i++ {
+		if i == len(indexes) ||
+			times[indexes[i]]-times[indexes[start]] >= int64(_CLOUDWATCH_MAX_BATCH_SPAN) {
+			group := append([]int(nil), indexes[start:i]...)
+			sort.Ints(group)
+			groups = append(groups, group)
+			start = i
+		}
+	}
+
+	if len(groups) <= 1 {
+		return nil
+	}
+
+	return groups
+}
+
+// handleCloudWatchResponse parses PutLogEvents response 'body'
+// and counts rejected log events.
+// See responseBodyHandler() for more info.
+func handleCloudWatchResponse(body []byte, entriesNum int) (retry []int, lost int, reason string) {
+
+	var resp cloudWatchPutLogEventsResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.RejectedLogEventsInfo == nil {
+		return nil, 0, ""
+	}
+
+	// Rejected log events are [0..oldEnd] and [newStart..entriesNum).
+	oldEnd, newStart := -1, entriesNum
+	info := resp.RejectedLogEventsInfo
+
+	if info.TooOldLogEventEndIndex != nil && *info.TooOldLogEventEndIndex > oldEnd {
+		oldEnd = *info.TooOldLogEventEndIndex
+	}
+	if info.ExpiredLogEventEndIndex != nil && *info.ExpiredLogEventEndIndex > oldEnd {
+		oldEnd = *info.ExpiredLogEventEndIndex
+	}
+	if info.TooNewLogEventStartIndex != nil && *info.TooNewLogEventStartIndex < newStart {
+		newStart = *info.TooNewLogEventStartIndex
+	}
+
+	if lost = oldEnd + 1 + entriesNum - newStart; lost > entriesNum {
+		lost = entriesNum
+	}
+
+	if lost <= 0 {
+		return nil, 0, ""
+	}
+
+	return nil, lost, "CloudWatch Logs: Some log events are rejected (too old, too new or expired)"
+}
+
+// cloudWatchCreateIfNotFound creates the log group (if it's required)
+// and the log stream if the failed request's response (its 'status' and 'body')
+// says they are not found. Returns true if they have been created.
+func (dw *CI_WriterHttp) cloudWatchCreateIfNotFound(
+
+	addr, logGroup, logStream string,
+	status int,
+	body []byte,
+
+) bool {
+
+	if status != fasthttp.StatusBadRequest || !isCloudWatchError(body, "ResourceNotFoundException") {
+		return false
+	}
+
+	streamBody, _ := json.Marshal(map[string]string{
+		"logGroupName":  logGroup,
+		"logStreamName": logStream,
+	})
+
+	status, body = dw.cloudWatchCall(addr, "CreateLogStream", streamBody)
+	if status == fasthttp.StatusOK || isCloudWatchError(body, "ResourceAlreadyExistsException") {
+		return true
+	}
+
+	if !isCloudWatchError(body, "ResourceNotFoundException") {
+		return false
+	}
+
+	// There is no log group. Create it.
+	groupBody, _ := json.Marshal(map[string]string{
+		"logGroupName": logGroup,
+	})
+
+	status, body = dw.cloudWatchCall(addr, "CreateLogGroup", groupBody)
+	if status != fasthttp.StatusOK && !isCloudWatchError(body, "ResourceAlreadyExistsException") {
+		return false
+	}
+
+	status, body = dw.cloudWatchCall(addr, "CreateLogStream", streamBody)
+	return status == fasthttp.StatusOK || isCloudWatchError(body, "ResourceAlreadyExistsException")
+}
+
+// cloudWatchCall performs CloudWatch Logs's API call 'action' with 'body',
+// authenticating it (see SetAuthenticator()).
+// Returns HTTP status code (or 0 if request is failed) and the response's body.
+func (dw *CI_WriterHttp) cloudWatchCall(addr, action string, body []byte) (int, []byte) {
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(addr)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", _CLOUDWATCH_TARGET_PREFIX+action)
+	req.SetBody(body)
+
+	if dw.authenticator != nil {
+		if err := dw.authenticator.Authenticate(req); err.IsNotNil() {
+			return 0, nil
+		}
+	}
+
+	if legacyErr := dw.transport.Do(req, resp, dw.requestTimeout); legacyErr != nil {
+		return 0, nil
+	}
+
+	return resp.StatusCode(), append([]byte(nil), resp.Body()...)
+}
+
+// isCloudWatchError reports whether 'body' is CloudWatch Logs's error response
+// with error type 'errorType'.
+func isCloudWatchError(body []byte, errorType string) bool {
+
+	var cwErr cloudWatchError
+	if err := json.Unmarshal(body, &cwErr); err != nil {
+		return false
+	}
+
+	// Error type might be prefixed by the namespace, like "...#ResourceNotFoundException".
+	return strings.HasSuffix(cwErr.Type, errorType)
+}
@@ -0,0 +1,223 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// cloudWatchStub is a local stub of CloudWatch Logs API.
+// Log group and log stream exist, if they're marked so
+// or created by CreateLogGroup, CreateLogStream.
+type cloudWatchStub struct {
+	mu            sync.Mutex
+	groupExists   bool
+	streamExists  bool
+	actions       []string
+	putLogEvents  []cloudWatchPutLogEventsRequest
+	unsignedCalls int
+}
+
+func (s *cloudWatchStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		s.unsignedCalls++
+	}
+
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), _CLOUDWATCH_TARGET_PREFIX)
+	s.actions = append(s.actions, action)
+
+	notFound := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.logs#ResourceNotFoundException",` +
+			`"message":"The specified resource does not exist."}`))
+	}
+
+	switch action {
+
+	case "CreateLogGroup":
+		s.groupExists = true
+
+	case "CreateLogStream":
+		if !s.groupExists {
+			notFound()
+			return
+		}
+		s.streamExists = true
+
+	case "PutLogEvents":
+		if !s.streamExists {
+			notFound()
+			return
+		}
+		var req cloudWatchPutLogEventsRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.putLogEvents = append(s.putLogEvents, req)
+	}
+
+	_, _ = w.Write([]byte(`{}`))
+}
+
+func TestCloudWatch_CreateIfNotFound(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		groupExists bool
+		actions     []string
+	}{
+		{"no log stream", true, []string{
+			"DescribeLogStreams", "PutLogEvents",
+			"CreateLogStream",
+			"PutLogEvents",
+		}},
+		{"no log group", false, []string{
+			"DescribeLogStreams", "PutLogEvents",
+			"CreateLogStream", "CreateLogGroup", "CreateLogStream",
+			"PutLogEvents",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &cloudWatchStub{groupExists: tt.groupExists}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			dw := new(CI_WriterHttp).
+				UseProviderCloudWatch(srv.URL, "group", "stream").
+				SetAuthenticator(NewAuthAWSSigV4("us-east-1", "logs",
+					awsTestCredentials.AccessKeyID, awsTestCredentials.SecretAccessKey, "")).
+				SetRetryPolicy(3, time.Millisecond, time.Millisecond, 0)
+
+			if _, err := dw.Write([]byte(`{"message":"test"}`)); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			if err := dw.Flush(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to flush")
+			}
+			defer dw.Close(context.Background())
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+
+			switch {
+			case strings.Join(stub.actions, ",") != strings.Join(tt.actions, ","):
+				t.Fatalf("unexpected CloudWatch Logs actions:\n got: %v\nwant: %v",
+					stub.actions, tt.actions)
+			case stub.unsignedCalls != 0:
+				t.Fatalf("%d requests are not signed", stub.unsignedCalls)
+			case len(stub.putLogEvents) != 1 || len(stub.putLogEvents[0].LogEvents) != 1:
+				t.Fatalf("log event is not put: %+v", stub.putLogEvents)
+			}
+			if stats := dw.Stats(); stats.PacksSent != 1 || stats.EntriesLost != 0 {
+				t.Fatalf("unexpected stats: packs sent %d, entries lost %d",
+					stats.PacksSent, stats.EntriesLost)
+			}
+		})
+	}
+}
+
+func TestCloudWatch_PutLogEvents(t *testing.T) {
+
+	base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	entryAt := func(d time.Duration) string {
+		return `{"@timestamp":"` + base.Add(d).Format(time.RFC3339) + `","message":"` + d.String() + `"}`
+	}
+
+	tests := []struct {
+		name    string
+		entries []time.Duration
+		batches [][]time.Duration // chronologically sorted
+	}{
+		{
+			"sorted chronologically",
+			[]time.Duration{2 * time.Hour, 0, time.Hour, 30 * time.Minute},
+			[][]time.Duration{{0, 30 * time.Minute, time.Hour, 2 * time.Hour}},
+		},
+		{
+			"24 hours span",
+			[]time.Duration{25 * time.Hour, 0, 24*time.Hour - time.Second, 48 * time.Hour},
+			[][]time.Duration{{0, 24*time.Hour - time.Second}, {25 * time.Hour, 48 * time.Hour}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &cloudWatchStub{groupExists: true, streamExists: true}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			dw := new(CI_WriterHttp).
+				UseProviderCloudWatch(srv.URL, "group", "stream").
+				SetWorkersNum(1).
+				SetWorkerAutoFlushDelay(time.Hour)
+
+			for _, d := range tt.entries {
+				if _, err := dw.Write([]byte(entryAt(d))); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+			if err := dw.Close(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to close")
+			}
+
+			if len(stub.putLogEvents) != len(tt.batches) {
+				t.Fatalf("%d PutLogEvents requests, want %d", len(stub.putLogEvents), len(tt.batches))
+			}
+			for i, batch := range tt.batches {
+				req := stub.putLogEvents[i]
+				if req.LogGroupName != "group" || req.LogStreamName != "stream" {
+					t.Fatalf("unexpected log group, log stream: %q, %q", req.LogGroupName, req.LogStreamName)
+				}
+				if len(req.LogEvents) != len(batch) {
+					t.Fatalf("batch %d has %d log events, want %d", i, len(req.LogEvents), len(batch))
+				}
+				for j, d := range batch {
+					event := req.LogEvents[j]
+					if event.Timestamp != base.Add(d).UnixNano()/1e6 || event.Message != entryAt(d) {
+						t.Fatalf("batch %d, log event %d is %+v, want %s", i, j, event, entryAt(d))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareCloudWatchBody_ReadError(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body io.Reader
+	}{
+		{"too long line", strings.NewReader(strings.Repeat("x", _CLOUDWATCH_MAX_EVENT_SIZE+1))},
+		{"read error", iotest.TimeoutReader(strings.NewReader(`{"message":"test"}` + "\n"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := prepareCloudWatchBody(tt.body, "group", "stream").(*_CI_WriterHttpBodyError); !ok {
+				t.Fatal("body error is not returned")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_Compression is a type of algorithm HTTP request's body
+	// is compressed with. See SetCompression() method
+	// and CI_WRITER_HTTP_COMPRESSION_<...> constants.
+	CI_WriterHttp_Compression uint8
+
+	// _CI_WriterHttpCompressor is an interface all used compressors implement.
+	_CI_WriterHttpCompressor interface {
+		io.WriteCloser
+		Reset(w io.Writer)
+	}
+
+	// _CI_WriterHttpCompression is a set of pools of compressors
+	// and their output buffers, for the one compression algorithm.
+	_CI_WriterHttpCompression struct {
+		contentEncoding string
+		compressors     sync.Pool
+		buffers         sync.Pool
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	CI_WRITER_HTTP_COMPRESSION_NONE CI_WriterHttp_Compression = iota
+	CI_WRITER_HTTP_COMPRESSION_GZIP
+	CI_WRITER_HTTP_COMPRESSION_DEFLATE
+	CI_WRITER_HTTP_COMPRESSION_ZSTD
+)
+
+// newCompression creates a new _CI_WriterHttpCompression for the provided
+// compression algorithm and its level. 'level' == 0 means default level.
+// Returns nil if it's CI_WRITER_HTTP_COMPRESSION_NONE or unknown algorithm.
+func newCompression(algo CI_WriterHttp_Compression, level int) *_CI_WriterHttpCompression {
+
+	var (
+		contentEncoding string
+		newCompressor   func() _CI_WriterHttpCompressor
+	)
+
+	switch algo {
+
+	case CI_WRITER_HTTP_COMPRESSION_GZIP:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		contentEncoding = "gzip"
+		newCompressor = func() _CI_WriterHttpCompressor {
+			c, _ := gzip.NewWriterLevel(nil, level) // level is already checked
+			return c
+		}
+
+	case CI_WRITER_HTTP_COMPRESSION_DEFLATE:
+		// According with RFC 7230, "deflate" content coding
+		// is a "zlib" data format containing a "deflate" compressed data stream.
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		contentEncoding = "deflate"
+		newCompressor = func() _CI_WriterHttpCompressor {
+			c, _ := zlib.NewWriterLevel(nil, level) // level is already checked
+			return c
+		}
+
+	case CI_WRITER_HTTP_COMPRESSION_ZSTD:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		contentEncoding = "zstd"
+		newCompressor = func() _CI_WriterHttpCompressor {
+			c, _ := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+			return c
+		}
+
+	default:
+		return nil
+	}
+
+	c := &_CI_WriterHttpCompression{
+		contentEncoding: contentEncoding,
+	}
+
+	c.compressors.New = func() interface{} { return newCompressor() }
+	c.buffers.New = func() interface{} { return bytes.NewBuffer(make([]byte, 0, 64<<10)) }
+
+	return c
+}
+
+// Compress reads 'body' until EOF, compressing it to the buffer, that is returned.
+// The returned buffer MUST be returned back using Release() when it's not needed.
+func (c *_CI_WriterHttpCompression) Compress(body io.Reader) (*bytes.Buffer, *ekaerr.Error) {
+
+	buf := c.buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	compressor := c.compressors.Get().(_CI_WriterHttpCompressor)
+	compressor.Reset(buf)
+	defer c.compressors.Put(compressor)
+
+	_, legacyErr := io.Copy(compressor, body)
+	if legacyErr == nil {
+		legacyErr = compressor.Close()
+	}
+
+	if legacyErr != nil {
+		c.Release(buf)
+		return nil, ekaerr.IllegalState.
+			Wrap(legacyErr, "CI_WriterHttp: Failed to compress HTTP request's body.").
+			WithString("ci_writer_http_content_encoding", c.contentEncoding).
+			Throw()
+	}
+
+	return buf, nil
+}
+
+// Release returns the buffer that has been returned by Compress()
+// back to the pool.
+func (c *_CI_WriterHttpCompression) Release(buf *bytes.Buffer) {
+	c.buffers.Put(buf)
+}
@@ -15,6 +15,12 @@ const (
 	DATADOG_ADDR_EU = "https://http-intake.logs.datadoghq.eu/v1/input"
 )
 
+//noinspection GoSnakeCaseUsage
+const (
+	_DATADOG_MAX_PACK_SIZE  = 5 << 20
+	_DATADOG_MAX_ENTRY_SIZE = 1 << 20
+)
+
 // UseProviderDataDog setups CI_WriterHttp for DataDog log service provider
 // ( https://www.datadoghq.com/ ).
 //
@@ -22,6 +28,12 @@ const (
 // constants DATADOG_ADDR_US, DATADOG_ADDR_EU) or use your own and DataDog
 // service's token as 'token'.
 //
+// Enables gzip compression of entries packs (DataDog supports it),
+// unless you set your own by SetCompression().
+//
+// Also limits entries pack by 5MB and drops log entries bigger than 1MB,
+// because DataDog rejects them anyway. Setters can't increase these limits.
+//
 // Nil safe. There is no-op if CI_WriterHttp already initialized.
 func (dw *CI_WriterHttp) UseProviderDataDog(addr, token string) *CI_WriterHttp {
 
@@ -31,5 +43,13 @@ func (dw *CI_WriterHttp) UseProviderDataDog(addr, token string) *CI_WriterHttp {
 		req.Header.Set("DD-API-KEY", token)
 	}
 
-	return dw.AddBeforeAfterBetweenS("[],").UseProviderManual(cb1)
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPackBuilder = newStaticPackBuilder("[", "]", ",")
+			dw.providerCompressionAlgo = CI_WRITER_HTTP_COMPRESSION_GZIP
+			dw.providerPackMaxSize = _DATADOG_MAX_PACK_SIZE
+			dw.providerEntryMaxSize = _DATADOG_MAX_ENTRY_SIZE
+		}).
+		useProvider(cb1)
 }
@@ -0,0 +1,181 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type (
+	// elasticsearchIndexPattern is a parsed index pattern of UseProviderElasticsearch().
+	// Even parts are constant strings, odd parts are Go time layouts.
+	// It's a CI_WriterHttp_PackBuilder, that adds "create" action lines.
+	elasticsearchIndexPattern []string
+
+	// elasticsearchBulkResponse is a part of Elasticsearch's _bulk API response,
+	// that is required to find out which documents are failed.
+	elasticsearchBulkResponse struct {
+		Errors bool                                       `json:"errors"`
+		Items  []map[string]elasticsearchBulkResponseItem `json:"items"`
+	}
+
+	elasticsearchBulkResponseItem struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+)
+
+// UseProviderElasticsearch setups CI_WriterHttp for Elasticsearch
+// ( https://www.elastic.co/elasticsearch/ ) or OpenSearch
+// ( https://opensearch.org/ ) log service provider, using its _bulk API.
+//
+// You MUST specify 'addr' as your Elasticsearch's addr,
+// like "http://elasticsearch.example.com:9200". The "/_bulk" path is added.
+//
+// 'indexPattern' is the name of index documents are created in.
+// It may contain Go time layouts in curly braces, like "logs-{2006.01.02}",
+// that are formatted using the log entry's time (in UTC). The time is extracted
+// from "@timestamp", "timestamp" or "time" field of encoded log entry
+// (RFC3339 or ISO8601). If there is no such field, the current time is used.
+// Keep in mind, Elasticsearch's index names must be lowercase.
+//
+// Encoded log entries MUST be single-line JSON objects (use any JSON encoder).
+// A "create" action line is added before each of them (see SetPackBuilder())
+// (so it works with data streams too). Action lines are counted
+// by the pack max size (see SetPackMaxSize()) as well as documents.
+//
+// Elasticsearch answers HTTP 200 even if some documents are failed.
+// The response is parsed, and documents, that are failed because of
+// HTTP 429 or 5xx, are retried according with retry policy
+// (see SetRetryPolicy()) and deferred if they're still failed.
+// Other failed documents are counted as lost.
+//
+// The ping (see Ping()) requests Elasticsearch's root endpoint.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderElasticsearch(addr, indexPattern string) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/")
+	pattern := parseElasticsearchIndexPattern(indexPattern)
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr + "/_bulk")
+		req.Header.SetContentType("application/x-ndjson")
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingGetInitializer(addr + "/")
+			dw.providerResponseHandler = responseBodyHandler(handleElasticsearchResponse)
+			dw.providerPackBuilder = pattern
+		}).
+		useProvider(cb1)
+}
+
+// parseElasticsearchIndexPattern splits 'pattern' to the constant parts
+// and Go time layouts (in curly braces).
+func parseElasticsearchIndexPattern(pattern string) elasticsearchIndexPattern {
+
+	var parts elasticsearchIndexPattern
+
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(pattern[start:], '}')
+		if end == -1 {
+			break
+		}
+		parts = append(parts, pattern[:start], pattern[start+1:start+end])
+		pattern = pattern[start+end+1:]
+	}
+
+	return append(parts, pattern)
+}
+
+// Index returns an index name for the document 'doc'.
+func (p elasticsearchIndexPattern) Index(doc []byte) string {
+
+	if len(p) == 1 {
+		return p[0]
+	}
+
+	t := encodedEntryTimeOf(doc).UTC()
+
+	var sb strings.Builder
+	for i := range p {
+		if i%2 == 0 {
+			sb.WriteString(p[i])
+		} else {
+			sb.WriteString(t.Format(p[i]))
+		}
+	}
+
+	return sb.String()
+}
+
+// Begin implements CI_WriterHttp_PackBuilder. There's nothing before documents.
+func (p elasticsearchIndexPattern) Begin(_ *bytes.Buffer) {}
+
+// AppendEntry implements CI_WriterHttp_PackBuilder.
+// Adds a "create" action line before the document 'doc'.
+func (p elasticsearchIndexPattern) AppendEntry(body *bytes.Buffer, _ int, doc []byte) {
+
+	doc = bytes.TrimSpace(doc)
+	index, _ := json.Marshal(p.Index(doc))
+
+	_, _ = body.WriteString(`{"create":{"_index":`)
+	_, _ = body.Write(index)
+	_, _ = body.WriteString("}}\n")
+	_, _ = body.Write(doc)
+	_ = body.WriteByte('\n')
+}
+
+// End implements CI_WriterHttp_PackBuilder. There's nothing after documents.
+func (p elasticsearchIndexPattern) End(_ *bytes.Buffer, _ int) {}
+
+// handleElasticsearchResponse parses Elasticsearch's _bulk API response 'body'
+// and returns the indexes of documents, that may be retried
+// (failed because of HTTP 429 or 5xx), the number of documents,
+// that are failed and can't be retried, and the reason of the first failure.
+func handleElasticsearchResponse(body []byte, entriesNum int) (retry []int, lost int, reason string) {
+
+	var resp elasticsearchBulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil || !resp.Errors {
+		return nil, 0, ""
+	}
+
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+
+			if reason == "" {
+				reason = "Elasticsearch: " + result.Error.Type + ": " + result.Error.Reason
+			}
+
+			// Documents can be retried only if it's known which ones are they.
+			retryable := result.Status == fasthttp.StatusTooManyRequests || result.Status >= 500
+			if retryable && len(resp.Items) == entriesNum {
+				retry = append(retry, i)
+			} else {
+				lost++
+			}
+		}
+	}
+
+	return retry, lost, reason
+}
@@ -0,0 +1,102 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestElasticsearchIndexPattern(t *testing.T) {
+
+	tests := []struct {
+		name, pattern, doc, body string
+	}{
+		{
+			"constant",
+			"logs", `{"message":"test"}`,
+			`{"create":{"_index":"logs"}}` + "\n" + `{"message":"test"}` + "\n",
+		},
+		{
+			"time layout",
+			"logs-{2006.01.02}", ` {"@timestamp":"2021-03-04T23:30:00-02:00"}` + "\n",
+			`{"create":{"_index":"logs-2021.03.05"}}` + "\n" + `{"@timestamp":"2021-03-04T23:30:00-02:00"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := new(CI_WriterHttp).UseProviderElasticsearch("http://127.0.0.1:9200", tt.pattern)
+			dw.packBuilderActive = dw.providerPackBuilder
+
+			var scratch bytes.Buffer
+			if size := dw.packEntrySize(&scratch, 0, []byte(tt.doc)); size != len(tt.body) {
+				t.Fatalf("packEntrySize() = %d, want %d (action line is counted)", size, len(tt.body))
+			}
+
+			p := newPack(0)
+			p.Add([]byte(tt.doc))
+
+			body := dw.buildPackBody(p)
+			defer releasePackBody(body)
+
+			if got := body.String(); got != tt.body {
+				t.Fatalf("unexpected body:\n got: %q\nwant: %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestHandleElasticsearchResponse(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		body       string
+		entriesNum int
+		retry      []int
+		lost       int
+		reason     string
+	}{
+		{
+			"no errors",
+			`{"errors":false,"items":[{"create":{"status":201}},{"create":{"status":201}}]}`,
+			2, nil, 0, "",
+		},
+		{
+			"malformed",
+			`{"errors":`,
+			2, nil, 0, "",
+		},
+		{
+			"retryable and rejected",
+			`{"errors":true,"items":[` +
+				`{"create":{"status":201}},` +
+				`{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue is full"}}},` +
+				`{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}},` +
+				`{"create":{"status":503,"error":{"type":"unavailable_shards_exception","reason":"no shards"}}}]}`,
+			4, []int{1, 3}, 1,
+			"Elasticsearch: es_rejected_execution_exception: queue is full",
+		},
+		{
+			"items do not match documents",
+			`{"errors":true,"items":[` +
+				`{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue is full"}}}]}`,
+			2, nil, 1,
+			"Elasticsearch: es_rejected_execution_exception: queue is full",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry, lost, reason := handleElasticsearchResponse([]byte(tt.body), tt.entriesNum)
+			if !reflect.DeepEqual(retry, tt.retry) || lost != tt.lost || reason != tt.reason {
+				t.Fatalf("got (%v, %d, %q), want (%v, %d, %q)",
+					retry, lost, reason, tt.retry, tt.lost, tt.reason)
+			}
+		})
+	}
+}
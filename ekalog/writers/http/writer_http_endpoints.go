@@ -0,0 +1,273 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_EndpointStrategy is a strategy of choosing an endpoint
+	// each HTTP request is sent to. See SetEndpoints() method
+	// and CI_WRITER_HTTP_ENDPOINTS_<...> constants.
+	CI_WriterHttp_EndpointStrategy uint8
+
+	// CI_WriterHttp_Endpoint is an endpoint of the log service provider.
+	// See SetEndpoints() method.
+	CI_WriterHttp_Endpoint struct {
+
+		// Addr is a scheme and a host (with port if it's required)
+		// of the endpoint, like "https://http-intake.logs.datadoghq.eu".
+		// The path and the query are ignored.
+		Addr string
+
+		// Weight is used by CI_WRITER_HTTP_ENDPOINTS_WEIGHTED strategy only.
+		// 0 is the same as 1.
+		Weight uint32
+	}
+
+	// CI_WriterHttpEndpointStats is a snapshot of CI_WriterHttp's endpoint
+	// state and counters. See CI_WriterHttpStats.
+	CI_WriterHttpEndpointStats struct {
+
+		// Addr is the endpoint's scheme and host.
+		Addr string
+
+		// Healthy reports whether the endpoint is not in the cooldown.
+		// UnhealthyUntil is when the cooldown ends (zero if it's healthy).
+		Healthy        bool
+		UnhealthyUntil time.Time
+
+		// PacksSent is how much HTTP requests (including pings)
+		// have been sent to the endpoint successfully.
+		// RequestsFailed is how much HTTP requests to the endpoint are failed.
+		PacksSent      uint64
+		RequestsFailed uint64
+	}
+
+	// _CI_WriterHttpEndpoints is a set of the log service provider's endpoints
+	// and the strategy of choosing one of them.
+	//
+	// Thread-safe.
+	_CI_WriterHttpEndpoints struct {
+		strategy CI_WriterHttp_EndpointStrategy
+		cooldown time.Duration
+
+		list        []*_CI_WriterHttpEndpoint
+		totalWeight uint64
+
+		roundRobinCounter uint64
+
+		randMu sync.Mutex
+		rand   *rand.Rand
+
+		// now returns the current time. It's time.Now(), but tests may replace it.
+		now func() time.Time
+	}
+
+	// _CI_WriterHttpEndpoint is a _CI_WriterHttpEndpoints's endpoint.
+	_CI_WriterHttpEndpoint struct {
+		addr   string
+		scheme []byte
+		host   []byte
+		weight uint64
+
+		// UnixNano timestamp until which the endpoint is not used
+		// (if there is a healthy one).
+		unhealthyUntil int64
+
+		packsSent      uint64
+		requestsFailed uint64
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Endpoints are used in the order they are specified.
+	// The next one is used only if all previous ones are unhealthy.
+	CI_WRITER_HTTP_ENDPOINTS_FAILOVER CI_WriterHttp_EndpointStrategy = iota
+
+	// Healthy endpoints are used one by one.
+	CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN
+
+	// Healthy endpoints are chosen randomly, proportionally to their weights.
+	CI_WRITER_HTTP_ENDPOINTS_WEIGHTED
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_DEFAULT_ENDPOINT_COOLDOWN = 30 * time.Second
+)
+
+// newEndpoints parses 'endpoints' and returns a set of them.
+// Returns nil if 'endpoints' is empty, 'strategy' is unknown,
+// or any endpoint's addr is invalid.
+func newEndpoints(
+
+	strategy CI_WriterHttp_EndpointStrategy,
+	endpoints []CI_WriterHttp_Endpoint,
+
+) *_CI_WriterHttpEndpoints {
+
+	if len(endpoints) == 0 || strategy > CI_WRITER_HTTP_ENDPOINTS_WEIGHTED {
+		return nil
+	}
+
+	e := &_CI_WriterHttpEndpoints{
+		strategy: strategy,
+		list:     make([]*_CI_WriterHttpEndpoint, 0, len(endpoints)),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:      time.Now,
+	}
+
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+
+	for _, endpoint := range endpoints {
+		uri.Reset()
+		if err := uri.Parse(nil, []byte(endpoint.Addr)); err != nil || len(uri.Host()) == 0 {
+			return nil
+		}
+
+		weight := uint64(endpoint.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+
+		e.list = append(e.list, &_CI_WriterHttpEndpoint{
+			addr:   string(uri.Scheme()) + "://" + string(uri.Host()),
+			scheme: append([]byte(nil), uri.Scheme()...),
+			host:   append([]byte(nil), uri.Host()...),
+			weight: weight,
+		})
+		e.totalWeight += weight
+	}
+
+	return e
+}
+
+// pick returns an endpoint the next HTTP request must be sent to,
+// according with the strategy. If all endpoints are unhealthy,
+// the one, which cooldown ends first, is returned.
+func (e *_CI_WriterHttpEndpoints) pick() *_CI_WriterHttpEndpoint {
+
+	now := e.now().UnixNano()
+
+	switch e.strategy {
+
+	case CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN:
+		n := uint64(len(e.list))
+		start := atomic.AddUint64(&e.roundRobinCounter, 1) - 1
+		for i := uint64(0); i < n; i++ {
+			if endpoint := e.list[(start+i)%n]; endpoint.isHealthy(now) {
+				return endpoint
+			}
+		}
+
+	case CI_WRITER_HTTP_ENDPOINTS_WEIGHTED:
+		healthyWeight := uint64(0)
+		for _, endpoint := range e.list {
+			if endpoint.isHealthy(now) {
+				healthyWeight += endpoint.weight
+			}
+		}
+		if healthyWeight == 0 {
+			break
+		}
+		e.randMu.Lock()
+		r := uint64(e.rand.Int63n(int64(healthyWeight)))
+		e.randMu.Unlock()
+		for _, endpoint := range e.list {
+			if !endpoint.isHealthy(now) {
+				continue
+			}
+			if r < endpoint.weight {
+				return endpoint
+			}
+			r -= endpoint.weight
+		}
+
+	default:
+		for _, endpoint := range e.list {
+			if endpoint.isHealthy(now) {
+				return endpoint
+			}
+		}
+	}
+
+	// All endpoints are unhealthy.
+	chosen := e.list[0]
+	for _, endpoint := range e.list[1:] {
+		if atomic.LoadInt64(&endpoint.unhealthyUntil) < atomic.LoadInt64(&chosen.unhealthyUntil) {
+			chosen = endpoint
+		}
+	}
+
+	return chosen
+}
+
+// apply replaces the scheme and the host of HTTP request 'req' by endpoint's ones.
+func (ep *_CI_WriterHttpEndpoint) apply(req *fasthttp.Request) {
+	req.URI().SetSchemeBytes(ep.scheme)
+	req.URI().SetHostBytes(ep.host)
+	req.Header.SetHostBytes(ep.host)
+}
+
+// report saves the result of HTTP request to the endpoint 'ep'.
+// If it's failed because of the endpoint ('status' is 0 (no response),
+// HTTP 429 or HTTP 5xx), the endpoint becomes unhealthy for the cooldown.
+func (e *_CI_WriterHttpEndpoints) report(
+
+	ep *_CI_WriterHttpEndpoint,
+	succeeded bool,
+	status int,
+
+) {
+
+	if succeeded {
+		atomic.AddUint64(&ep.packsSent, 1)
+		atomic.StoreInt64(&ep.unhealthyUntil, 0)
+		return
+	}
+
+	atomic.AddUint64(&ep.requestsFailed, 1)
+
+	if status == 0 || status == fasthttp.StatusTooManyRequests || status >= 500 {
+		atomic.StoreInt64(&ep.unhealthyUntil, e.now().Add(e.cooldown).UnixNano())
+	}
+}
+
+// isHealthy reports whether the endpoint is not in the cooldown at 'now' (UnixNano).
+func (ep *_CI_WriterHttpEndpoint) isHealthy(now int64) bool {
+	return atomic.LoadInt64(&ep.unhealthyUntil) <= now
+}
+
+// stats returns a snapshot of endpoints' state and counters.
+func (e *_CI_WriterHttpEndpoints) stats() []CI_WriterHttpEndpointStats {
+
+	now := e.now().UnixNano()
+	s := make([]CI_WriterHttpEndpointStats, len(e.list))
+
+	for i, endpoint := range e.list {
+		s[i] = CI_WriterHttpEndpointStats{
+			Addr:           endpoint.addr,
+			Healthy:        endpoint.isHealthy(now),
+			PacksSent:      atomic.LoadUint64(&endpoint.packsSent),
+			RequestsFailed: atomic.LoadUint64(&endpoint.requestsFailed),
+		}
+		if !s[i].Healthy {
+			s[i].UnhealthyUntil = time.Unix(0, atomic.LoadInt64(&endpoint.unhealthyUntil))
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,258 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// newTestEndpoints returns endpoints with addrs "http://<i>.example"
+// and weights 'weights' (one per endpoint), that use a fake clock.
+// Pass the returned func to move the clock forward.
+func newTestEndpoints(
+
+	t *testing.T,
+	strategy CI_WriterHttp_EndpointStrategy,
+	weights ...uint32,
+
+) (*_CI_WriterHttpEndpoints, func(d time.Duration)) {
+
+	t.Helper()
+
+	endpoints := make([]CI_WriterHttp_Endpoint, len(weights))
+	for i, weight := range weights {
+		endpoints[i] = CI_WriterHttp_Endpoint{
+			Addr:   "http://" + string(rune('0'+i)) + ".example",
+			Weight: weight,
+		}
+	}
+
+	e := newEndpoints(strategy, endpoints)
+	if e == nil {
+		t.Fatal("failed to create endpoints")
+	}
+
+	now := time.Unix(1_600_000_000, 0)
+	e.now = func() time.Time { return now }
+	e.cooldown = time.Minute
+	e.rand = rand.New(rand.NewSource(1))
+
+	return e, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestEndpoints_Pick(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		strategy  CI_WriterHttp_EndpointStrategy
+		weights   []uint32
+		unhealthy []int // indexes of endpoints, reported as failed
+		want      []int // indexes of picked endpoints
+	}{
+		{"failover", CI_WRITER_HTTP_ENDPOINTS_FAILOVER,
+			[]uint32{0, 0, 0}, nil, []int{0, 0, 0}},
+		{"failover to secondary", CI_WRITER_HTTP_ENDPOINTS_FAILOVER,
+			[]uint32{0, 0, 0}, []int{0}, []int{1, 1, 1}},
+		{"failover to tertiary", CI_WRITER_HTTP_ENDPOINTS_FAILOVER,
+			[]uint32{0, 0, 0}, []int{0, 1}, []int{2, 2}},
+		{"round robin", CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN,
+			[]uint32{0, 0, 0}, nil, []int{0, 1, 2, 0, 1}},
+		{"round robin skips unhealthy", CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN,
+			[]uint32{0, 0, 0}, []int{1}, []int{0, 2, 2, 0}},
+		{"weighted skips unhealthy", CI_WRITER_HTTP_ENDPOINTS_WEIGHTED,
+			[]uint32{100, 1, 1}, []int{0, 2}, []int{1, 1, 1}},
+		{"all unhealthy", CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN,
+			[]uint32{0, 0, 0}, []int{1, 2, 0}, []int{1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, advance := newTestEndpoints(t, tt.strategy, tt.weights...)
+
+			// So, the 1st reported endpoint's cooldown ends first.
+			for _, i := range tt.unhealthy {
+				e.report(e.list[i], false, fasthttp.StatusServiceUnavailable)
+				advance(time.Second)
+			}
+
+			for i, want := range tt.want {
+				if got := e.pick(); got != e.list[want] {
+					t.Fatalf("pick() #%d = %s, want %s", i, got.addr, e.list[want].addr)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpoints_PickWeighted(t *testing.T) {
+
+	e, _ := newTestEndpoints(t, CI_WRITER_HTTP_ENDPOINTS_WEIGHTED, 1, 3, 0)
+
+	const picks = 10_000
+	picked := make(map[*_CI_WriterHttpEndpoint]int)
+	for i := 0; i < picks; i++ {
+		picked[e.pick()]++
+	}
+
+	// Weights are 1, 3 and 1 (0 is the same as 1).
+	for i, want := range []float64{0.2, 0.6, 0.2} {
+		if got := float64(picked[e.list[i]]) / picks; got < want-0.05 || got > want+0.05 {
+			t.Fatalf("%s is picked %.2f of times, want %.2f", e.list[i].addr, got, want)
+		}
+	}
+}
+
+func TestEndpoints_Report(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		succeeded bool
+		status    int
+		unhealthy bool
+	}{
+		{"succeeded", true, fasthttp.StatusOK, false},
+		{"network error", false, 0, true},
+		{"too many requests", false, fasthttp.StatusTooManyRequests, true},
+		{"server error", false, fasthttp.StatusBadGateway, true},
+		{"client error", false, fasthttp.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, advance := newTestEndpoints(t, CI_WRITER_HTTP_ENDPOINTS_FAILOVER, 0, 0)
+
+			e.report(e.list[0], tt.succeeded, tt.status)
+
+			wantSent, wantFailed := uint64(0), uint64(1)
+			if tt.succeeded {
+				wantSent, wantFailed = 1, 0
+			}
+
+			stats := e.stats()[0]
+			switch {
+			case stats.Healthy == tt.unhealthy:
+				t.Fatalf("endpoint is healthy: %t, want %t", stats.Healthy, !tt.unhealthy)
+			case stats.PacksSent != wantSent || stats.RequestsFailed != wantFailed:
+				t.Fatalf("endpoint's sent/failed counters are %d/%d, want %d/%d",
+					stats.PacksSent, stats.RequestsFailed, wantSent, wantFailed)
+			case tt.unhealthy && !stats.UnhealthyUntil.Equal(e.now().Add(e.cooldown)):
+				t.Fatalf("endpoint is unhealthy until %s, want %s",
+					stats.UnhealthyUntil, e.now().Add(e.cooldown))
+			}
+
+			// The cooldown is not over yet.
+			advance(e.cooldown - time.Second)
+			if healthy := e.stats()[0].Healthy; healthy == tt.unhealthy {
+				t.Fatalf("endpoint is healthy: %t before the cooldown ends", healthy)
+			}
+
+			advance(time.Second)
+			if !e.stats()[0].Healthy || e.pick() != e.list[0] {
+				t.Fatal("endpoint is unhealthy after the cooldown ends")
+			}
+
+			// Success makes the endpoint healthy immediately.
+			e.report(e.list[0], false, fasthttp.StatusServiceUnavailable)
+			e.report(e.list[0], true, fasthttp.StatusOK)
+			if !e.stats()[0].Healthy {
+				t.Fatal("endpoint is unhealthy after the request succeeded")
+			}
+		})
+	}
+}
+
+func TestEndpoints_Servers(t *testing.T) {
+
+	const packsNum = 6
+
+	tests := []struct {
+		name     string
+		strategy CI_WriterHttp_EndpointStrategy
+		statuses []int // HTTP status codes servers answer with
+		want     []int32
+	}{
+		{"failover", CI_WRITER_HTTP_ENDPOINTS_FAILOVER,
+			[]int{200, 200, 200}, []int32{packsNum, 0, 0}},
+		{"failover to secondary", CI_WRITER_HTTP_ENDPOINTS_FAILOVER,
+			[]int{503, 200, 200}, []int32{1, packsNum, 0}},
+		{"round robin", CI_WRITER_HTTP_ENDPOINTS_ROUND_ROBIN,
+			[]int{200, 200, 200}, []int32{2, 2, 2}},
+		{"weighted skips unhealthy", CI_WRITER_HTTP_ENDPOINTS_WEIGHTED,
+			[]int{503, 503, 200}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make([]int32, len(tt.statuses))
+			endpoints := make([]CI_WriterHttp_Endpoint, len(tt.statuses))
+
+			for i := range tt.statuses {
+				i := i
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if body, _ := ioutil.ReadAll(r.Body); string(body) != "ping" {
+						atomic.AddInt32(&received[i], 1)
+						w.WriteHeader(tt.statuses[i])
+					}
+				}))
+				defer srv.Close()
+				endpoints[i] = CI_WriterHttp_Endpoint{Addr: srv.URL}
+			}
+
+			dw := new(CI_WriterHttp).
+				UseProviderManual(func(req *fasthttp.Request) {
+					req.SetRequestURI("http://provider.example/logs")
+				}).
+				SetEndpoints(tt.strategy, endpoints...).
+				SetRetryPolicy(uint8(len(tt.statuses)), time.Millisecond, time.Millisecond, 0).
+				SetPingBody([]byte("ping")).
+				SetWorkersNum(1).
+				SetWorkerBufferCap(1).
+				SetWorkerAutoFlushDelay(time.Hour)
+
+			for i := 0; i < packsNum; i++ {
+				if _, legacyErr := dw.Write([]byte("entry")); legacyErr != nil {
+					t.Fatalf("Write() = %v", legacyErr)
+				}
+			}
+
+			// Failed requests are not retried while CI_WriterHttp is closing.
+			if err := dw.Flush(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to flush")
+			}
+			if err := dw.Close(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to close")
+			}
+
+			accepted := int32(0)
+			for i, endpointStats := range dw.Stats().Endpoints {
+				if tt.statuses[i] == 200 {
+					accepted += received[i]
+				}
+				if healthy := tt.statuses[i] == 200 || received[i] == 0; endpointStats.Healthy != healthy {
+					t.Fatalf("endpoint #%d is healthy: %t, want %t", i, endpointStats.Healthy, healthy)
+				}
+			}
+
+			if accepted != packsNum {
+				t.Fatalf("endpoints accepted %d entries packs, want %d", accepted, packsNum)
+			}
+			for i := range tt.want {
+				if received[i] != tt.want[i] {
+					t.Fatalf("endpoint #%d received %d entries packs, want %d", i, received[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
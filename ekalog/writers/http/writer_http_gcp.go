@@ -0,0 +1,324 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/valyala/fasthttp"
+)
+
+type (
+	// gcpMonitoredResource is a Google Cloud Logging's MonitoredResource.
+	gcpMonitoredResource struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels,omitempty"`
+	}
+
+	// gcpLogEntry is a Google Cloud Logging's LogEntry.
+	gcpLogEntry struct {
+		Severity       string                     `json:"severity,omitempty"`
+		Timestamp      json.RawMessage            `json:"timestamp,omitempty"`
+		Trace          json.RawMessage            `json:"trace,omitempty"`
+		SpanID         json.RawMessage            `json:"spanId,omitempty"`
+		Labels         json.RawMessage            `json:"labels,omitempty"`
+		SourceLocation json.RawMessage            `json:"sourceLocation,omitempty"`
+		HTTPRequest    json.RawMessage            `json:"httpRequest,omitempty"`
+		JSONPayload    map[string]json.RawMessage `json:"jsonPayload,omitempty"`
+		TextPayload    string                     `json:"textPayload,omitempty"`
+	}
+
+	// gcpWriteLogEntriesRequest is a Google Cloud Logging's entries:write request.
+	gcpWriteLogEntriesRequest struct {
+		LogName  string               `json:"logName"`
+		Resource gcpMonitoredResource `json:"resource"`
+		Entries  []gcpLogEntry        `json:"entries"`
+	}
+
+	// authGCPMetadata is a CI_WriterHttp_Authenticator, that gets
+	// an access token of the default service account from the metadata server
+	// using CI_WriterHttp's transport (see SetTransport()).
+	authGCPMetadata struct {
+		authTokenSource
+		transport CI_WriterHttp_Transport
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Google Cloud Logging's limits.
+	_GCP_MAX_ENTRY_SIZE  = 256 * 1024
+	_GCP_MAX_BATCH_SIZE  = 10 * 1024 * 1024
+	_GCP_DEFAULT_ADDR    = "https://logging.googleapis.com"
+	_GCP_METADATA_SERVER = "http://metadata.google.internal"
+
+	// The metadata server is local, it answers quickly or isn't available at all.
+	_GCP_METADATA_TIMEOUT = 3 * time.Second
+)
+
+// UseProviderGoogleCloudLogging setups CI_WriterHttp for Google Cloud Logging
+// log service provider ( https://cloud.google.com/logging ),
+// using its entries:write API.
+//
+// 'addr' is Google Cloud Logging's addr (https://logging.googleapis.com
+// if it's empty), or your local stub's addr. Log entries are written to
+// the log "projects/<projectID>/logs/<logID>" of the monitored resource,
+// which type and labels (as name, value pairs) are 'resource'
+// ("global" w/o labels if it's empty).
+//
+// The access token is got from 'tokenSource'. If it's nil, the access token
+// of the default service account is got from the metadata server, that is
+// available on GCE, GKE (with Workload Identity), Cloud Run, etc.
+// The metadata server is requested using the same transport (see SetTransport()).
+// Your own authenticator (see SetAuthenticator()) overrides both of them.
+//
+// Encoded log entries MUST be single-line. The ones, encoded by
+// ekalog_encoder_gcp.CI_GCPEncoder (or any another encoder, that follows
+// Google Cloud's structured logging) are converted to LogEntry: "severity",
+// "time" (or "timestamp"), "httpRequest", "logging.googleapis.com/trace",
+// "logging.googleapis.com/spanId", "logging.googleapis.com/labels",
+// "logging.googleapis.com/sourceLocation" fields are moved to the LogEntry's
+// corresponding fields, the rest ones are its JSON payload.
+// Not JSON encoded log entries are sent as text payload.
+//
+// Google Cloud Logging's limits are enforced: no more than 10 MB per request,
+// and no more than 256 KB per log entry (too big encoded log entries are dropped).
+// Setters can't increase them.
+//
+// The ping (see Ping()) writes no log entries.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderGoogleCloudLogging(
+
+	addr, projectID, logID string,
+	tokenSource CI_WriterHttp_TokenSource,
+	resource ...string,
+
+) *CI_WriterHttp {
+
+	if addr == "" {
+		addr = _GCP_DEFAULT_ADDR
+	}
+	addr = strings.TrimSuffix(addr, "/") + "/v2/entries:write"
+
+	logName := "projects/" + projectID + "/logs/" + url.PathEscape(logID)
+
+	monitoredResource := gcpMonitoredResource{Type: "global"}
+	if len(resource) > 0 && resource[0] != "" {
+		monitoredResource.Type = resource[0]
+		for i := 1; i+1 < len(resource); i += 2 {
+			if monitoredResource.Labels == nil {
+				monitoredResource.Labels = make(map[string]string)
+			}
+			monitoredResource.Labels[resource[i]] = resource[i+1]
+		}
+	}
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/json")
+	}
+
+	cb2 := func(body io.Reader) io.Reader {
+		return prepareGoogleCloudLoggingBody(body, logName, monitoredResource)
+	}
+
+	pingBody, _ := json.Marshal(gcpWriteLogEntriesRequest{
+		LogName:  logName,
+		Resource: monitoredResource,
+		Entries:  []gcpLogEntry{},
+	})
+
+	pingCb := func(req *fasthttp.Request) {
+		req.Header.Del(fasthttp.HeaderContentEncoding)
+		req.SetBody(pingBody)
+	}
+
+	var authenticator CI_WriterHttp_Authenticator = newAuthGCPMetadata()
+	if tokenSource != nil {
+		authenticator = NewAuthTokenSource(tokenSource)
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingCb
+			dw.providerAuthenticator = authenticator
+			dw.providerPackBuilder = newStaticPackBuilder("", "", "\n")
+			dw.providerPackMaxSize = _GCP_MAX_BATCH_SIZE
+			dw.providerEntryMaxSize = _GCP_MAX_ENTRY_SIZE
+		}).
+		useProvider(cb1, cb2)
+}
+
+// newAuthGCPMetadata returns an authGCPMetadata, that uses the default transport
+// until it's given CI_WriterHttp's one.
+func newAuthGCPMetadata() *authGCPMetadata {
+
+	a := &authGCPMetadata{
+		transport: NewTransportFastHTTP(nil),
+	}
+	a.source = a.token
+
+	return a
+}
+
+// useTransport implements authTransportUser.
+func (a *authGCPMetadata) useTransport(transport CI_WriterHttp_Transport) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.transport = transport
+}
+
+// token is a CI_WriterHttp_TokenSource, that requests the metadata server.
+// It's called by authTokenSource under the lock.
+func (a *authGCPMetadata) token() (string, time.Duration, *ekaerr.Error) {
+
+	const tokenURL = _GCP_METADATA_SERVER +
+		"/computeMetadata/v1/instance/service-accounts/default/token"
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(tokenURL)
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	if legacyErr := a.transport.Do(req, resp, _GCP_METADATA_TIMEOUT); legacyErr != nil {
+		return "", 0, ekaerr.ExternalError.
+			Wrap(legacyErr, "Failed to perform HTTP request.").
+			WithString("ci_writer_http_gcp_metadata_url", tokenURL).
+			Throw()
+	}
+
+	if status := resp.StatusCode(); status != fasthttp.StatusOK {
+		return "", 0, ekaerr.ExternalError.
+			New("Unexpected HTTP status code.").
+			WithInt("ci_writer_http_gcp_metadata_status_code", status).
+			WithString("ci_writer_http_gcp_metadata_url", tokenURL).
+			Throw()
+	}
+
+	var tokenResponse oauth2TokenResponse
+	if legacyErr := json.Unmarshal(resp.Body(), &tokenResponse); legacyErr != nil {
+		return "", 0, ekaerr.ExternalError.
+			Wrap(legacyErr, "Failed to decode access token response.").
+			WithString("ci_writer_http_gcp_metadata_url", tokenURL).
+			Throw()
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return "", 0, ekaerr.ExternalError.
+			New("Access token response has no access token.").
+			WithString("ci_writer_http_gcp_metadata_url", tokenURL).
+			Throw()
+	}
+
+	return tokenResponse.AccessToken,
+		time.Duration(tokenResponse.ExpiresIn) * time.Second, nil
+}
+
+// prepareGoogleCloudLoggingBody converts each line of 'body' (an encoded log entry)
+// to the LogEntry and returns entries:write request.
+// If 'body' can't be read, _CI_WriterHttpBodyError is returned.
+func prepareGoogleCloudLoggingBody(
+
+	body io.Reader,
+	logName string,
+	resource gcpMonitoredResource,
+
+) io.Reader {
+
+	var (
+		req = gcpWriteLogEntriesRequest{
+			LogName:  logName,
+			Resource: resource,
+			Entries:  make([]gcpLogEntry, 0, 32),
+		}
+		scanner = bufio.NewScanner(body)
+	)
+
+	// Encoded log entry might be much bigger than bufio.MaxScanTokenSize.
+	scanner.Buffer(nil, _GCP_MAX_ENTRY_SIZE+1)
+
+	for scanner.Scan() {
+		encodedEntry := bytes.TrimSpace(scanner.Bytes())
+		if len(encodedEntry) == 0 {
+			continue
+		}
+		req.Entries = append(req.Entries, gcpLogEntryOf(encodedEntry))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &_CI_WriterHttpBodyError{err}
+	}
+
+	encoded, _ := json.Marshal(req)
+	return bytes.NewReader(encoded)
+}
+
+// gcpLogEntryOf converts encoded log entry 'encodedEntry'
+// (Google Cloud's structured log entry) to the LogEntry.
+func gcpLogEntryOf(encodedEntry []byte) gcpLogEntry {
+
+	var (
+		entry   gcpLogEntry
+		payload map[string]json.RawMessage
+	)
+
+	if err := json.Unmarshal(encodedEntry, &payload); err != nil {
+		entry.TextPayload = string(encodedEntry)
+		return entry
+	}
+
+	if severity, ok := payload["severity"]; ok {
+		_ = json.Unmarshal(severity, &entry.Severity)
+		entry.Severity = strings.ToUpper(entry.Severity)
+		delete(payload, "severity")
+	}
+
+	for _, key := range []string{"time", "timestamp"} {
+		if v, ok := payload[key]; ok {
+			if entry.Timestamp == nil {
+				entry.Timestamp = v
+			}
+			delete(payload, key)
+		}
+	}
+
+	fields := []struct {
+		key string
+		dst *json.RawMessage
+	}{
+		{"httpRequest", &entry.HTTPRequest},
+		{"logging.googleapis.com/trace", &entry.Trace},
+		{"logging.googleapis.com/spanId", &entry.SpanID},
+		{"logging.googleapis.com/labels", &entry.Labels},
+		{"logging.googleapis.com/sourceLocation", &entry.SourceLocation},
+	}
+
+	for _, field := range fields {
+		if v, ok := payload[field.key]; ok {
+			*field.dst = v
+			delete(payload, field.key)
+		}
+	}
+
+	entry.JSONPayload = payload
+	return entry
+}
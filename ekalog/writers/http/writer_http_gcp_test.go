@@ -0,0 +1,189 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestGCPLogEntryOf(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		encodedEntry string
+		expected     string
+	}{
+		{
+			"text",
+			`not a JSON`,
+			`{"textPayload":"not a JSON"}`,
+		},
+		{
+			"payload only",
+			`{"message":"test","user":{"id":1}}`,
+			`{"jsonPayload":{"message":"test","user":{"id":1}}}`,
+		},
+		{
+			"severity is upper cased",
+			`{"severity":"warning","message":"test"}`,
+			`{"severity":"WARNING","jsonPayload":{"message":"test"}}`,
+		},
+		{
+			"time wins over timestamp",
+			`{"time":"2021-06-01T12:00:00Z","timestamp":"2020-01-01T00:00:00Z","message":"test"}`,
+			`{"timestamp":"2021-06-01T12:00:00Z","jsonPayload":{"message":"test"}}`,
+		},
+		{
+			"timestamp",
+			`{"timestamp":"2020-01-01T00:00:00Z","message":"test"}`,
+			`{"timestamp":"2020-01-01T00:00:00Z","jsonPayload":{"message":"test"}}`,
+		},
+		{
+			"special fields",
+			`{"severity":"ERROR","message":"test",` +
+				`"httpRequest":{"requestMethod":"GET","status":500},` +
+				`"logging.googleapis.com/trace":"projects/p/traces/t",` +
+				`"logging.googleapis.com/spanId":"000000000000004a",` +
+				`"logging.googleapis.com/labels":{"env":"prod"},` +
+				`"logging.googleapis.com/sourceLocation":{"file":"main.go","line":"42"}}`,
+			`{"severity":"ERROR",` +
+				`"trace":"projects/p/traces/t",` +
+				`"spanId":"000000000000004a",` +
+				`"labels":{"env":"prod"},` +
+				`"sourceLocation":{"file":"main.go","line":"42"},` +
+				`"httpRequest":{"requestMethod":"GET","status":500},` +
+				`"jsonPayload":{"message":"test"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(gcpLogEntryOf([]byte(tt.encodedEntry)))
+			if err != nil {
+				t.Fatalf("failed to encode LogEntry: %v", err)
+			}
+			if string(encoded) != tt.expected {
+				t.Fatalf("unexpected LogEntry:\n got: %s\nwant: %s", encoded, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrepareGoogleCloudLoggingBody(t *testing.T) {
+
+	resource := gcpMonitoredResource{
+		Type:   "k8s_container",
+		Labels: map[string]string{"cluster_name": "main"},
+	}
+
+	body := `{"severity":"INFO","message":"first"}` + "\n" +
+		"\n" +
+		` text ` + "\n" +
+		`{"message":"third"}`
+
+	encoded, err := ioutil.ReadAll(prepareGoogleCloudLoggingBody(
+		strings.NewReader(body), "projects/p/logs/l", resource))
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+
+	const expected = `{"logName":"projects/p/logs/l",` +
+		`"resource":{"type":"k8s_container","labels":{"cluster_name":"main"}},` +
+		`"entries":[` +
+		`{"severity":"INFO","jsonPayload":{"message":"first"}},` +
+		`{"textPayload":"text"},` +
+		`{"jsonPayload":{"message":"third"}}]}`
+
+	if string(encoded) != expected {
+		t.Fatalf("unexpected entries:write request:\n got: %s\nwant: %s", encoded, expected)
+	}
+
+	readErrBody := iotest.TimeoutReader(strings.NewReader(`{"message":"test"}` + "\n"))
+	if _, ok := prepareGoogleCloudLoggingBody(readErrBody, "", resource).(*_CI_WriterHttpBodyError); !ok {
+		t.Fatal("body error is not returned")
+	}
+}
+
+func TestUseProviderGoogleCloudLogging_MetadataServer(t *testing.T) {
+
+	var (
+		mu      sync.Mutex
+		dialed  = make(map[string]bool)
+		entries []gcpLogEntry
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+
+		case strings.HasPrefix(r.URL.Path, "/computeMetadata/"):
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600,"token_type":"Bearer"}`))
+
+		case r.URL.Path == "/v2/entries:write":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req gcpWriteLogEntriesRequest
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			entries = append(entries, req.Entries...)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	// Both the metadata server and Google Cloud Logging are the stub,
+	// if they're requested by the configured transport.
+	transport := NewTransportFastHTTP(&fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed[addr] = true
+			mu.Unlock()
+			return fasthttp.Dial(srv.Listener.Addr().String())
+		},
+	})
+
+	dw := new(CI_WriterHttp).
+		UseProviderGoogleCloudLogging("http://logging.example.com", "project", "log", nil).
+		SetTransport(transport)
+
+	if _, err := dw.Write([]byte(`{"severity":"INFO","message":"test"}`)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := dw.Close(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to close")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch {
+	case !dialed["metadata.google.internal:80"]:
+		t.Fatalf("metadata server is not requested by the configured transport: %v", dialed)
+	case len(entries) != 1 || entries[0].Severity != "INFO":
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	grayLogPingBody = []byte(`{"version":"1.1","host":"ekago_ext",` +
+		`"short_message":"CI_WriterHttp: ping","level":7}`)
+)
+
+// UseProviderGrayLog setups CI_WriterHttp for GrayLog log service provider
+// ( https://www.graylog.org/ ), using its GELF HTTP input.
+//
+// You MUST specify 'addr' as your GrayLog's GELF HTTP input's addr,
+// like "http://graylog.example.com:12201". The "/gelf" path is added
+// if it's not presented.
+//
+// GELF HTTP input accepts only one GELF message per request,
+// so each entries pack has only one encoded log entry, whatever the worker's
+// buffer capacity is (see SetWorkerBufferCap()),
+// and there's nothing before, after or between encoded log entries.
+// Use ekalog_encoder_gelf.CI_GELFEncoder to encode your log entries.
+//
+// GrayLog does not accept empty GELF messages, so the ping (see Ping())
+// sends a GELF message with debug level. GELF HTTP input has no endpoint
+// to be pinged w/o ingesting a message, so there's no implicit ping
+// at the initialization. Call Ping() explicitly if you need it.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderGrayLog(addr string) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/")
+	if !strings.HasSuffix(addr, "/gelf") {
+		addr += "/gelf"
+	}
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/json")
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		SetPingBody(grayLogPingBody).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingSkip = true
+			dw.providerMaxEntriesPerPack = 1
+		}).
+		useProvider(cb1)
+}
@@ -0,0 +1,96 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"sync"
+)
+
+type (
+	// _CI_WriterHttpInFlight tracks the work with encoded log entries,
+	// that are already taken from the buffers but are not sent (or deferred) yet:
+	// entries packs being sent by workers, entries being evicted, etc.
+	//
+	// Each such work gets a ticket by begin() and returns it by end().
+	// wait() waits for all the work, begun before the given ticket.
+	// It never blocks beginning a new work, so a work may wait for another one
+	// (e.g. for workers to take entries) while Flush() waits for them both.
+	//
+	// Thread-safe. Zero value is ready to use.
+	_CI_WriterHttpInFlight struct {
+		mu     sync.Mutex
+		cond   *sync.Cond
+		next   uint64
+		active map[uint64]struct{}
+	}
+)
+
+// begin registers a new work, returning its ticket that must be passed to end().
+func (f *_CI_WriterHttpInFlight) begin() uint64 {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active == nil {
+		f.active = make(map[uint64]struct{})
+	}
+
+	ticket := f.next
+	f.next++
+
+	f.active[ticket] = struct{}{}
+	return ticket
+}
+
+// end unregisters the work by its ticket, returned from begin().
+func (f *_CI_WriterHttpInFlight) end(ticket uint64) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.active, ticket)
+	if f.cond != nil {
+		f.cond.Broadcast()
+	}
+}
+
+// now returns the ticket the next work will get.
+// Pass it to wait() to wait for all the work, begun before.
+func (f *_CI_WriterHttpInFlight) now() uint64 {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.next
+}
+
+// wait blocks until all the work, begun before the 'ticket'
+// (see now()), is done. The work, begun after, is not waited for.
+func (f *_CI_WriterHttpInFlight) wait(ticket uint64) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cond == nil {
+		f.cond = sync.NewCond(&f.mu)
+	}
+
+	for f.hasActiveBefore(ticket) {
+		f.cond.Wait()
+	}
+}
+
+// hasActiveBefore reports whether there is a work, begun before the 'ticket'.
+// f.mu must be locked.
+func (f *_CI_WriterHttpInFlight) hasActiveBefore(ticket uint64) bool {
+
+	for activeTicket := range f.active {
+		if activeTicket < ticket {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+
+	var f _CI_WriterHttpInFlight
+
+	before := f.begin()
+	ticket := f.now()
+	after := f.begin()
+
+	done := make(chan struct{})
+	go func() {
+		f.wait(ticket)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("wait() returned while the work, begun before, is not done")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The work, begun after, is not waited for.
+	f.end(before)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait() didn't return when the work, begun before, is done")
+	}
+
+	f.end(after)
+	f.wait(f.now())
+}
@@ -0,0 +1,248 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type (
+	// lokiPushRequest is a Grafana Loki's push request's body in JSON format.
+	lokiPushRequest struct {
+		Streams []lokiStream `json:"streams"`
+	}
+
+	// lokiStream is a Grafana Loki's stream: labels and lines.
+	// Labels are kept as is, so streams with the same labels might be found
+	// w/o decoding them (the encoder writes them sorted).
+	lokiStream struct {
+		Stream json.RawMessage `json:"stream"`
+		Values [][2]string     `json:"values"`
+	}
+)
+
+var (
+	lokiPingBody = []byte(`{"streams":[]}`)
+
+	errLokiInvalidStream = fmt.Errorf("invalid labels or timestamp of Loki's stream")
+)
+
+// UseProviderLoki setups CI_WriterHttp for Grafana Loki log service provider
+// ( https://grafana.com/oss/loki/ ), using its push API.
+//
+// You MUST specify 'addr' as your Loki's addr, like "http://loki.example.com:3100".
+// The "/loki/api/v1/push" path is added if it's not presented.
+//
+// Use ekalog_encoder_loki.CI_LokiEncoder to encode your log entries.
+// Each encoded log entry is a stream with the only one line.
+// Before sending, the streams of the whole entries pack are grouped by their labels.
+//
+// If 'protobuf' is true, the request's body is converted to the Loki's
+// protobuf format and compressed using snappy, as Loki expects.
+// SetCompression() is ignored then.
+//
+// Use SetLokiTenantID() and SetLokiBasicAuth() if your Loki requires them.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderLoki(addr string, protobuf bool) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/")
+	if !strings.HasSuffix(addr, "/loki/api/v1/push") {
+		addr += "/loki/api/v1/push"
+	}
+
+	contentType := "application/json"
+	if protobuf {
+		contentType = "application/x-protobuf"
+	}
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType(contentType)
+		if dw.lokiTenantID != "" {
+			req.Header.Set("X-Scope-OrgID", dw.lokiTenantID)
+		}
+	}
+
+	cb2 := func(body io.Reader) io.Reader {
+		return prepareLokiBody(body, protobuf)
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.lokiProtobuf = protobuf
+			dw.providerPackBuilder = newStaticPackBuilder(`{"streams":[`, `]}`, `,`)
+		}).
+		SetPingBody(lokiPingBody).
+		useProvider(cb1, cb2)
+}
+
+// SetLokiTenantID sets a value of "X-Scope-OrgID" HTTP header,
+// that is required by Grafana Loki with multi-tenancy enabled.
+// Makes sense only with UseProviderLoki(). Call it after UseProviderLoki().
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) SetLokiTenantID(tenantID string) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.lokiTenantID = tenantID
+	})
+}
+
+// SetLokiBasicAuth sets credentials for HTTP basic authentication,
+// that Grafana Loki (or a proxy in front of it) requires.
+// It's the same as SetAuthenticator(NewAuthBasic(username, password)).
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) SetLokiBasicAuth(username, password string) *CI_WriterHttp {
+	return dw.SetAuthenticator(NewAuthBasic(username, password))
+}
+
+// prepareLokiBody groups the streams of Loki's push request in JSON format
+// by their labels, and returns a new body in JSON or (if 'protobuf' is true)
+// snappy compressed protobuf format.
+//
+// If 'body' can't be decoded, it's returned as is in JSON format.
+// In protobuf format it can't be sent then, so _CI_WriterHttpBodyError
+// is returned instead.
+func prepareLokiBody(body io.Reader, protobuf bool) io.Reader {
+
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return &_CI_WriterHttpBodyError{err}
+	}
+
+	var pushRequest lokiPushRequest
+	if err = json.Unmarshal(raw, &pushRequest); err != nil {
+		if protobuf {
+			return &_CI_WriterHttpBodyError{err}
+		}
+		return bytes.NewReader(raw)
+	}
+
+	var (
+		streams = make([]lokiStream, 0, len(pushRequest.Streams))
+		indexes = make(map[string]int, len(pushRequest.Streams))
+	)
+
+	for _, stream := range pushRequest.Streams {
+		if idx, found := indexes[string(stream.Stream)]; found {
+			streams[idx].Values = append(streams[idx].Values, stream.Values...)
+		} else {
+			indexes[string(stream.Stream)] = len(streams)
+			streams = append(streams, stream)
+		}
+	}
+
+	if !protobuf {
+		encoded, err := json.Marshal(lokiPushRequest{Streams: streams})
+		if err != nil {
+			return bytes.NewReader(raw)
+		}
+		return bytes.NewReader(encoded)
+	}
+
+	encoded, ok := encodeLokiProtobuf(streams)
+	if !ok {
+		return &_CI_WriterHttpBodyError{errLokiInvalidStream}
+	}
+
+	return bytes.NewReader(snappy.Encode(nil, encoded))
+}
+
+// encodeLokiProtobuf encodes 'streams' as Loki's logproto.PushRequest:
+//
+//     PushRequest  { repeated StreamAdapter streams = 1; }
+//     StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//     EntryAdapter  { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//
+// Returns false if some stream has invalid labels or timestamp.
+func encodeLokiProtobuf(streams []lokiStream) ([]byte, bool) {
+
+	var b []byte
+
+	for _, stream := range streams {
+
+		labels, ok := lokiLabelsString(stream.Stream)
+		if !ok {
+			return nil, false
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.BytesType)
+		sb = protowire.AppendString(sb, labels)
+
+		for _, value := range stream.Values {
+
+			ns, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				return nil, false
+			}
+
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(ns/1e9))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(ns%1e9))
+
+			var eb []byte
+			eb = protowire.AppendTag(eb, 1, protowire.BytesType)
+			eb = protowire.AppendBytes(eb, ts)
+			eb = protowire.AppendTag(eb, 2, protowire.BytesType)
+			eb = protowire.AppendString(eb, value[1])
+
+			sb = protowire.AppendTag(sb, 2, protowire.BytesType)
+			sb = protowire.AppendBytes(sb, eb)
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+
+	return b, true
+}
+
+// lokiLabelsString converts stream's labels from JSON object
+// to the Prometheus's labels format, like `{level="info", service="api"}`.
+func lokiLabelsString(raw json.RawMessage) (string, bool) {
+
+	var labels map[string]string
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return "", false
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+
+	sb.WriteByte('}')
+	return sb.String(), true
+}
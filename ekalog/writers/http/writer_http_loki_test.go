@@ -0,0 +1,231 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type (
+	// lokiTestStream is a decoded logproto.StreamAdapter.
+	lokiTestStream struct {
+		Labels  string
+		Entries []lokiTestEntry
+	}
+
+	// lokiTestEntry is a decoded logproto.EntryAdapter.
+	lokiTestEntry struct {
+		Seconds, Nanos uint64
+		Line           string
+	}
+)
+
+// lokiTestPushBody is a push request's body of 4 streams with 3 different labels,
+// as CI_WriterHttp builds it from the encoded log entries.
+const lokiTestPushBody = `{"streams":[` +
+	`{"stream":{"level":"info","service":"api"},"values":[["1622550645000000001","first"]]},` +
+	`{"stream":{"level":"error","service":"api"},"values":[["1622550646000000002","second"]]},` +
+	`{"stream":{"level":"info","service":"api"},"values":[["1622550647000000003","third"]]},` +
+	`{"stream":{"service":"web \"front\""},"values":[["1622550648000000004","fourth"]]}` +
+	`]}`
+
+// decodeLokiProtobuf decodes logproto.PushRequest, encoded by encodeLokiProtobuf().
+func decodeLokiProtobuf(t *testing.T, b []byte) []lokiTestStream {
+
+	var streams []lokiTestStream
+
+	forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		var stream lokiTestStream
+		forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				stream.Labels = string(v)
+			case 2:
+				var entry lokiTestEntry
+				forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					switch num {
+					case 1:
+						forEachField(t, v, func(num protowire.Number, _ []byte, varint uint64) {
+							if num == 1 {
+								entry.Seconds = varint
+							} else {
+								entry.Nanos = varint
+							}
+						})
+					case 2:
+						entry.Line = string(v)
+					}
+				})
+				stream.Entries = append(stream.Entries, entry)
+			}
+		})
+		streams = append(streams, stream)
+	})
+
+	return streams
+}
+
+// forEachField calls 'cb' for each field of protobuf message 'b',
+// passing its bytes (for bytes type) or value (for varint type).
+func forEachField(t *testing.T, b []byte, cb func(num protowire.Number, v []byte, varint uint64)) {
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("malformed tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("malformed field %d: %v", num, protowire.ParseError(n))
+			}
+			cb(num, v, 0)
+			b = b[n:]
+
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("malformed field %d: %v", num, protowire.ParseError(n))
+			}
+			cb(num, nil, v)
+			b = b[n:]
+
+		default:
+			t.Fatalf("unexpected type %d of field %d", typ, num)
+		}
+	}
+}
+
+func TestPrepareLokiBody_JSON(t *testing.T) {
+
+	body, err := ioutil.ReadAll(prepareLokiBody(strings.NewReader(lokiTestPushBody), false))
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+
+	var pushRequest lokiPushRequest
+	if err = json.Unmarshal(body, &pushRequest); err != nil {
+		t.Fatalf("failed to decode the body: %v", err)
+	}
+
+	expected := []struct {
+		labels string
+		values [][2]string
+	}{
+		{`{"level":"info","service":"api"}`, [][2]string{
+			{"1622550645000000001", "first"}, {"1622550647000000003", "third"},
+		}},
+		{`{"level":"error","service":"api"}`, [][2]string{
+			{"1622550646000000002", "second"},
+		}},
+		{`{"service":"web \"front\""}`, [][2]string{
+			{"1622550648000000004", "fourth"},
+		}},
+	}
+
+	if len(pushRequest.Streams) != len(expected) {
+		t.Fatalf("%d streams, want %d: %s", len(pushRequest.Streams), len(expected), body)
+	}
+	for i, stream := range pushRequest.Streams {
+		if string(stream.Stream) != expected[i].labels || !reflect.DeepEqual(stream.Values, expected[i].values) {
+			t.Fatalf("stream %d is %s %v, want %s %v",
+				i, stream.Stream, stream.Values, expected[i].labels, expected[i].values)
+		}
+	}
+}
+
+func TestPrepareLokiBody_Protobuf(t *testing.T) {
+
+	compressed, err := ioutil.ReadAll(prepareLokiBody(strings.NewReader(lokiTestPushBody), true))
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+
+	encoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatalf("failed to decompress the body: %v", err)
+	}
+
+	expected := []lokiTestStream{
+		{`{level="info", service="api"}`, []lokiTestEntry{
+			{1622550645, 1, "first"}, {1622550647, 3, "third"},
+		}},
+		{`{level="error", service="api"}`, []lokiTestEntry{
+			{1622550646, 2, "second"},
+		}},
+		{`{service="web \"front\""}`, []lokiTestEntry{
+			{1622550648, 4, "fourth"},
+		}},
+	}
+
+	if streams := decodeLokiProtobuf(t, encoded); !reflect.DeepEqual(streams, expected) {
+		t.Fatalf("unexpected streams:\n got: %+v\nwant: %+v", streams, expected)
+	}
+}
+
+func TestPrepareLokiBody_Malformed(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		body     string
+		protobuf bool
+		bodyErr  bool
+	}{
+		{"not JSON, JSON format", `{"streams":[`, false, false},
+		{"not JSON, protobuf format", `{"streams":[`, true, true},
+		{"invalid labels", `{"streams":[{"stream":["level"],"values":[["1","line"]]}]}`, true, true},
+		{"invalid timestamp", `{"streams":[{"stream":{},"values":[["now","line"]]}]}`, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := prepareLokiBody(strings.NewReader(tt.body), tt.protobuf)
+
+			if _, ok := body.(*_CI_WriterHttpBodyError); ok != tt.bodyErr {
+				t.Fatalf("body error is %t, want %t", ok, tt.bodyErr)
+			}
+			if !tt.bodyErr {
+				if b, _ := ioutil.ReadAll(body); string(b) != tt.body {
+					t.Fatalf("body is %q, want it as is", b)
+				}
+			}
+		})
+	}
+}
+
+func TestLokiLabelsString(t *testing.T) {
+
+	tests := []struct {
+		raw      string
+		expected string
+		ok       bool
+	}{
+		{`{}`, `{}`, true},
+		{`{"service":"api","level":"info","app":"x"}`, `{app="x", level="info", service="api"}`, true},
+		{`{"path":"C:\\logs\n\"a\""}`, `{path="C:\\logs\n\"a\""}`, true},
+		{`["level"]`, ``, false},
+		{`{"level":1}`, ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			labels, ok := lokiLabelsString(json.RawMessage(tt.raw))
+			if labels != tt.expected || ok != tt.ok {
+				t.Fatalf("got (%s, %t), want (%s, %t)", labels, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
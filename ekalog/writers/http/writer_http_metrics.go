@@ -0,0 +1,292 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttpHistogram is a snapshot of the histogram of durations.
+	// See CI_WriterHttpStats.
+	CI_WriterHttpHistogram struct {
+
+		// Bounds are the upper bounds of buckets (inclusive).
+		// Counts are how much observations are in each bucket (non-cumulative).
+		// The last bucket of Counts (len(Bounds)+1-th) has no upper bound.
+		Bounds []time.Duration
+		Counts []uint64
+
+		// Sum is the sum of all observations, Count is the number of them.
+		Sum   time.Duration
+		Count uint64
+	}
+
+	// _CI_WriterHttpHistogram is the lock-free histogram of durations
+	// with histogramBounds buckets.
+	_CI_WriterHttpHistogram struct {
+		counts [len(histogramBounds) + 1]uint64
+		sum    uint64 // nanoseconds
+		count  uint64
+	}
+
+	// metricsWriter writes metrics in Prometheus text exposition format.
+	metricsWriter struct {
+		w *bufio.Writer
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_METRICS_PREFIX = "ekalog_writer_http_"
+
+	_METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// histogramBounds are the upper bounds of buckets of _CI_WriterHttpHistogram.
+	// They're the same as Prometheus' default ones.
+	histogramBounds = [...]time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		25 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		250 * time.Millisecond,
+		500 * time.Millisecond,
+		1 * time.Second,
+		2500 * time.Millisecond,
+		5 * time.Second,
+		10 * time.Second,
+	}
+
+	// All statuses and circuit breaker's states, exposed by MetricsHandler().
+	metricsStatuses = [...]int32{
+		_CAS_STATUS_NOT_INITIALIZED,
+		_CAS_STATUS_INITIALIZING,
+		_CAS_STATUS_READY,
+		_CAS_STATUS_TEMPORARY_DISABLED,
+		_CAS_STATUS_FINALLY_DISABLED,
+	}
+	metricsBreakerStates = [...]uint8{
+		_BREAKER_CLOSED,
+		_BREAKER_OPEN,
+		_BREAKER_HALF_OPEN,
+	}
+
+	// metricsLabelValueEscaper escapes label values according with
+	// Prometheus text exposition format: only backslash, double quote
+	// and line feed are escaped.
+	metricsLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// MetricsHandler returns an http.Handler, that serves CI_WriterHttp's
+// state and counters (see Stats()) in Prometheus text exposition format.
+// Metrics are prefixed by "ekalog_writer_http_".
+//
+// Register it at the path your Prometheus scrapes (like "/metrics").
+// If you have many CI_WriterHttp, register each of them at its own path.
+//
+// Nil safe. Metrics of nil CI_WriterHttp are zero.
+func (dw *CI_WriterHttp) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", _METRICS_CONTENT_TYPE)
+		_ = dw.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes CI_WriterHttp's state and counters to 'w'
+// in Prometheus text exposition format. See MetricsHandler().
+//
+// Nil safe. Metrics of nil CI_WriterHttp are zero.
+func (dw *CI_WriterHttp) WriteMetrics(w io.Writer) error {
+
+	s := dw.Stats()
+	mw := metricsWriter{bufio.NewWriter(w)}
+
+	mw.header("state", "gauge", "Current state of the writer.")
+	for _, status := range metricsStatuses {
+		str := statusString(status)
+		mw.value("state", "state", strings.ReplaceAll(str, " ", "_"), boolToUint64(s.Status == str))
+	}
+
+	mw.header("circuit_breaker_state", "gauge", "Current state of the circuit breaker.")
+	for _, state := range metricsBreakerStates {
+		str := breakerStateString(state)
+		mw.value("circuit_breaker_state", "state", str, boolToUint64(s.CircuitBreaker == str))
+	}
+
+	mw.single("entries_written_total", "counter",
+		"Encoded log entries accepted by Write().", s.EntriesWritten)
+	mw.single("entries_queued", "gauge",
+		"Encoded log entries waiting for any worker.", uint64(s.EntriesQueued))
+	mw.single("entries_truncated_total", "counter",
+		"Encoded log entries truncated because they are too big.", s.EntriesTruncated)
+	mw.single("entries_dropped_total", "counter",
+		"Encoded log entries dropped because they are too big.", s.EntriesDropped)
+
+	reasons := make([]string, 0, len(s.EntriesLostByReason))
+	for reason := range s.EntriesLostByReason {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	mw.header("entries_lost_total", "counter",
+		"Encoded log entries (or entries packs) lost, by reason.")
+	for _, reason := range reasons {
+		mw.value("entries_lost_total", "reason", reason, s.EntriesLostByReason[reason])
+	}
+
+	mw.single("packs_sent_total", "counter",
+		"Entries packs sent successfully.", s.PacksSent)
+	mw.single("packs_failed_total", "counter",
+		"Entries packs sending failures (after all retries).", s.PacksFailed)
+	mw.single("packs_in_flight", "gauge",
+		"HTTP requests performing right now.", uint64(s.PacksInFlight))
+	mw.single("packs_deferred", "gauge",
+		"Entries packs waiting for connection restoring.", uint64(s.PacksDeferred))
+	mw.single("bytes_sent_total", "counter",
+		"Bytes of HTTP requests' bodies accepted by provider.", s.BytesSent)
+	mw.single("requests_throttled_total", "counter",
+		"HTTP requests delayed because of rate limit.", s.RequestsThrottled)
+
+	codes := make([]int, 0, len(s.Responses))
+	for code := range s.Responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	mw.header("responses_total", "counter",
+		"HTTP responses by status code (0 is no response).")
+	for _, code := range codes {
+		mw.value("responses_total", "code", strconv.Itoa(code), s.Responses[code])
+	}
+
+	mw.histogram("request_duration_seconds",
+		"Duration of HTTP requests to provider.", s.RequestDuration)
+
+	if len(s.Endpoints) > 0 {
+		mw.header("endpoint_healthy", "gauge", "Whether the endpoint is not in the cooldown.")
+		for _, ep := range s.Endpoints {
+			mw.value("endpoint_healthy", "endpoint", ep.Addr, boolToUint64(ep.Healthy))
+		}
+		mw.header("endpoint_packs_sent_total", "counter", "HTTP requests sent to the endpoint successfully.")
+		for _, ep := range s.Endpoints {
+			mw.value("endpoint_packs_sent_total", "endpoint", ep.Addr, ep.PacksSent)
+		}
+		mw.header("endpoint_requests_failed_total", "counter", "HTTP requests to the endpoint failed.")
+		for _, ep := range s.Endpoints {
+			mw.value("endpoint_requests_failed_total", "endpoint", ep.Addr, ep.RequestsFailed)
+		}
+	}
+
+	return mw.w.Flush()
+}
+
+// PublishExpvar publishes CI_WriterHttp's state and counters (see Stats())
+// as expvar variable 'name' (served at "/debug/vars" by expvar package).
+//
+// Does nothing if expvar variable 'name' already exists.
+// Nil safe. Stats of nil CI_WriterHttp are zero.
+func (dw *CI_WriterHttp) PublishExpvar(name string) *CI_WriterHttp {
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			return dw.Stats()
+		}))
+	}
+	return dw
+}
+
+// observe adds duration 'd' to the histogram.
+func (h *_CI_WriterHttpHistogram) observe(d time.Duration) {
+
+	i := sort.Search(len(histogramBounds), func(i int) bool {
+		return d <= histogramBounds[i]
+	})
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// snapshot returns the current state of the histogram.
+// The sum and the number of observations may be inconsistent
+// with buckets, if observations are added concurrently.
+func (h *_CI_WriterHttpHistogram) snapshot() CI_WriterHttpHistogram {
+
+	s := CI_WriterHttpHistogram{
+		Bounds: append([]time.Duration(nil), histogramBounds[:]...),
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sum)),
+		Count:  atomic.LoadUint64(&h.count),
+	}
+
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+
+	return s
+}
+
+// header writes HELP and TYPE lines of metric 'name'.
+func (mw metricsWriter) header(name, typ, help string) {
+	_, _ = mw.w.WriteString("# HELP " + _METRICS_PREFIX + name + " " + help + "\n")
+	_, _ = mw.w.WriteString("# TYPE " + _METRICS_PREFIX + name + " " + typ + "\n")
+}
+
+// single writes metric 'name' w/o labels, that has only one value.
+func (mw metricsWriter) single(name, typ, help string, value uint64) {
+	mw.header(name, typ, help)
+	mw.value(name, "", "", value)
+}
+
+// value writes the value of metric 'name' with label 'label'="labelValue"
+// (if 'label' is not empty).
+func (mw metricsWriter) value(name, label, labelValue string, value uint64) {
+
+	_, _ = mw.w.WriteString(_METRICS_PREFIX + name)
+	if label != "" {
+		_, _ = mw.w.WriteString("{" + label + "=\"" + metricsLabelValueEscaper.Replace(labelValue) + "\"}")
+	}
+	_, _ = mw.w.WriteString(" " + strconv.FormatUint(value, 10) + "\n")
+}
+
+// histogram writes histogram metric 'name', converting durations to seconds.
+func (mw metricsWriter) histogram(name, help string, h CI_WriterHttpHistogram) {
+
+	mw.header(name, "histogram", help)
+
+	var cumulative uint64
+	for i := range h.Counts {
+		cumulative += h.Counts[i]
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i].Seconds(), 'g', -1, 64)
+		}
+		mw.value(name+"_bucket", "le", le, cumulative)
+	}
+
+	_, _ = mw.w.WriteString(_METRICS_PREFIX + name + "_sum " +
+		strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64) + "\n")
+	mw.value(name+"_count", "", "", h.Count)
+}
+
+// boolToUint64 returns 1 if 'b' is true, or 0 otherwise.
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
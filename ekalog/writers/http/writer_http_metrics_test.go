@@ -0,0 +1,148 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestWriteMetrics(t *testing.T) {
+
+	dw := new(CI_WriterHttp)
+	for _, d := range []time.Duration{3 * time.Millisecond, 7 * time.Millisecond, 20 * time.Second} {
+		dw.stats.requestDuration.observe(d)
+	}
+	atomic.AddUint64(&dw.stats.entriesWritten, 42)
+
+	var buf bytes.Buffer
+	if legacyErr := dw.WriteMetrics(&buf); legacyErr != nil {
+		t.Fatalf("WriteMetrics() = %v", legacyErr)
+	}
+
+	want := []string{
+		"# HELP ekalog_writer_http_entries_written_total Encoded log entries accepted by Write().",
+		"# TYPE ekalog_writer_http_entries_written_total counter",
+		"ekalog_writer_http_entries_written_total 42",
+		"# TYPE ekalog_writer_http_state gauge",
+		`ekalog_writer_http_state{state="not_initialized"} 1`,
+		`ekalog_writer_http_state{state="ready"} 0`,
+		"# TYPE ekalog_writer_http_circuit_breaker_state gauge",
+		"# HELP ekalog_writer_http_request_duration_seconds Duration of HTTP requests to provider.",
+		"# TYPE ekalog_writer_http_request_duration_seconds histogram",
+		`ekalog_writer_http_request_duration_seconds_bucket{le="0.005"} 1`,
+		`ekalog_writer_http_request_duration_seconds_bucket{le="0.01"} 2`,
+		`ekalog_writer_http_request_duration_seconds_bucket{le="10"} 2`,
+		`ekalog_writer_http_request_duration_seconds_bucket{le="+Inf"} 3`,
+		"ekalog_writer_http_request_duration_seconds_sum 20.01",
+		"ekalog_writer_http_request_duration_seconds_count 3",
+	}
+
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		lines[line] = true
+	}
+
+	for _, line := range want {
+		if !lines[line] {
+			t.Fatalf("there is no line %q in metrics:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsWriter_Value(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		label      string
+		labelValue string
+		want       string
+	}{
+		{"no label", "", "", "ekalog_writer_http_m 7\n"},
+		{"plain", "l", "v", `ekalog_writer_http_m{l="v"} 7` + "\n"},
+		{"escaped", "l", "a\\b\"c\nd", `ekalog_writer_http_m{l="a\\b\"c\nd"} 7` + "\n"},
+		{"not escaped", "l", "ы\t☺", "ekalog_writer_http_m{l=\"ы\t☺\"} 7\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			mw := metricsWriter{bufio.NewWriter(&buf)}
+
+			mw.value("m", tt.label, tt.labelValue, 7)
+			_ = mw.w.Flush()
+
+			if buf.String() != tt.want {
+				t.Fatalf("value() wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestBytesSent(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		bodyPreparer func(body io.Reader) io.Reader
+		compression  CI_WriterHttp_Compression
+		pingGet      bool
+	}{
+		{"plain", nil, CI_WRITER_HTTP_COMPRESSION_NONE, false},
+		{"body preparer", func(body io.Reader) io.Reader {
+			return io.MultiReader(strings.NewReader("prepared:"), body)
+		}, CI_WRITER_HTTP_COMPRESSION_NONE, false},
+		{"compressed", nil, CI_WRITER_HTTP_COMPRESSION_GZIP, false},
+		{"ping get", nil, CI_WRITER_HTTP_COMPRESSION_NONE, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				atomic.AddInt64(&received, int64(len(body)))
+			}))
+			defer srv.Close()
+
+			dw := new(CI_WriterHttp).
+				UseProviderManual(func(req *fasthttp.Request) {
+					req.SetRequestURI(srv.URL)
+				}, tt.bodyPreparer).
+				SetPingBody([]byte("ping")).
+				SetCompression(tt.compression, 0).
+				SetWorkersNum(1).
+				SetWorkerAutoFlushDelay(time.Hour)
+
+			if tt.pingGet {
+				dw.configure(func(dw *CI_WriterHttp) {
+					dw.providerPingInitializer = pingGetInitializer(srv.URL)
+				})
+			}
+
+			if _, legacyErr := dw.Write([]byte(strings.Repeat("entry", 100))); legacyErr != nil {
+				t.Fatalf("Write() = %v", legacyErr)
+			}
+			if err := dw.Close(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to close")
+			}
+
+			if bytesSent := dw.Stats().BytesSent; bytesSent != uint64(atomic.LoadInt64(&received)) {
+				t.Fatalf("BytesSent = %d, but %d bytes are received", bytesSent, received)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type (
+	// otlpJSONResponse is an OTLP/JSON ExportLogsServiceResponse.
+	// Int64 might be encoded as JSON string or number.
+	otlpJSONResponse struct {
+		PartialSuccess *struct {
+			RejectedLogRecords json.RawMessage `json:"rejectedLogRecords"`
+			ErrorMessage       string          `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}
+)
+
+var (
+	otlpPingBody = []byte(`{"resourceLogs":[]}`)
+)
+
+// UseProviderOTLP setups CI_WriterHttp for OpenTelemetry collector
+// ( https://opentelemetry.io/docs/collector/ ) or any other service,
+// that supports OTLP/HTTP logs ingesting.
+//
+// You MUST specify 'addr' as your collector's OTLP/HTTP addr,
+// like "http://otel-collector.example.com:4318". The "/v1/logs" path is added
+// if it's not presented.
+//
+// Use ekalog_encoder_otlp.CI_OTLPEncoder to encode your log entries.
+// If 'protobuf' is true, OTLP/protobuf is used, and the encoder MUST use it too
+// (see its SetProtobuf()). Otherwise it's OTLP/JSON.
+//
+// An entries pack is wrapped to ExportLogsServiceRequest with one
+// ResourceLogs and one ScopeLogs (with "ekalog" scope's name).
+// 'resourceAttrs' is a sequence of resource's attribute's name and its value pairs
+// (like "service.name", "api", "deployment.environment", "prod"),
+// the last name w/o value is ignored.
+//
+// If collector answers that some log records are rejected (partial success),
+// they're counted as lost, because they can't be retried by OTLP spec.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderOTLP(addr string, protobuf bool, resourceAttrs ...string) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/")
+	if !strings.HasSuffix(addr, "/v1/logs") {
+		addr += "/v1/logs"
+	}
+
+	if len(resourceAttrs)%2 != 0 {
+		resourceAttrs = resourceAttrs[:len(resourceAttrs)-1]
+	}
+
+	dw = dw.configure((*CI_WriterHttp).resetProvider)
+
+	if protobuf {
+		return dw.useProviderOTLPProtobuf(addr, resourceAttrs)
+	}
+
+	var before strings.Builder
+	before.WriteString(`{"resourceLogs":[{"resource":{"attributes":[`)
+	for i := 0; i < len(resourceAttrs); i += 2 {
+		if i > 0 {
+			before.WriteByte(',')
+		}
+		key, _ := json.Marshal(resourceAttrs[i])
+		value, _ := json.Marshal(resourceAttrs[i+1])
+		before.WriteString(`{"key":` + string(key) + `,"value":{"stringValue":` + string(value) + `}}`)
+	}
+	before.WriteString(`]},"scopeLogs":[{"scope":{"name":"ekalog"},"logRecords":[`)
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/json")
+	}
+
+	return dw.
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerResponseHandler = responseBodyHandler(handleOTLPJSONResponse)
+			dw.providerPackBuilder = newStaticPackBuilder(before.String(), `]}]}]}`, `,`)
+		}).
+		SetPingBody(otlpPingBody).
+		useProvider(cb1)
+}
+
+// useProviderOTLPProtobuf is UseProviderOTLP() for OTLP/protobuf.
+// Encoded log entries are already ScopeLogs's log_records fields,
+// the body preparer wraps them to the ExportLogsServiceRequest.
+func (dw *CI_WriterHttp) useProviderOTLPProtobuf(addr string, resourceAttrs []string) *CI_WriterHttp {
+
+	// ResourceLogs.resource = Resource { repeated KeyValue attributes = 1; }
+	var resource []byte
+	for i := 0; i < len(resourceAttrs); i += 2 {
+		var value, kv []byte
+		value = protowire.AppendTag(value, 1, protowire.BytesType)
+		value = protowire.AppendString(value, resourceAttrs[i+1])
+		kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+		kv = protowire.AppendString(kv, resourceAttrs[i])
+		kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+		kv = protowire.AppendBytes(kv, value)
+		resource = protowire.AppendTag(resource, 1, protowire.BytesType)
+		resource = protowire.AppendBytes(resource, kv)
+	}
+
+	// ScopeLogs.scope = InstrumentationScope { string name = 1; }
+	scope := protowire.AppendTag(nil, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, "ekalog")
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/x-protobuf")
+	}
+
+	cb2 := func(body io.Reader) io.Reader {
+		logRecords, err := ioutil.ReadAll(body)
+		if err != nil || len(logRecords) == 0 {
+			// Empty ExportLogsServiceRequest.
+			return bytes.NewReader(nil)
+		}
+
+		var scopeLogs, resourceLogs, exportRequest []byte
+
+		scopeLogs = protowire.AppendTag(scopeLogs, 1, protowire.BytesType)
+		scopeLogs = protowire.AppendBytes(scopeLogs, scope)
+		scopeLogs = append(scopeLogs, logRecords...)
+
+		resourceLogs = protowire.AppendTag(resourceLogs, 1, protowire.BytesType)
+		resourceLogs = protowire.AppendBytes(resourceLogs, resource)
+		resourceLogs = protowire.AppendTag(resourceLogs, 2, protowire.BytesType)
+		resourceLogs = protowire.AppendBytes(resourceLogs, scopeLogs)
+
+		exportRequest = protowire.AppendTag(exportRequest, 1, protowire.BytesType)
+		exportRequest = protowire.AppendBytes(exportRequest, resourceLogs)
+
+		return bytes.NewReader(exportRequest)
+	}
+
+	// The ping sends an empty ExportLogsServiceRequest.
+	pingCb := func(req *fasthttp.Request) {
+		req.Header.Del(fasthttp.HeaderContentEncoding)
+		req.ResetBody()
+		req.Header.SetContentLength(0)
+	}
+
+	return dw.
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingCb
+			dw.providerResponseHandler = responseBodyHandler(handleOTLPProtobufResponse)
+		}).
+		useProvider(cb1, cb2)
+}
+
+// handleOTLPJSONResponse parses OTLP/JSON ExportLogsServiceResponse 'body'.
+// See responseBodyHandler() for more info.
+func handleOTLPJSONResponse(body []byte, _ int) (retry []int, lost int, reason string) {
+
+	var resp otlpJSONResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.PartialSuccess == nil {
+		return nil, 0, ""
+	}
+
+	rejected := strings.Trim(string(resp.PartialSuccess.RejectedLogRecords), `"`)
+	n, _ := strconv.Atoi(rejected)
+
+	return otlpPartialSuccess(n, resp.PartialSuccess.ErrorMessage)
+}
+
+// handleOTLPProtobufResponse parses OTLP/protobuf ExportLogsServiceResponse 'body':
+//
+//     ExportLogsServiceResponse { ExportLogsPartialSuccess partial_success = 1; }
+//     ExportLogsPartialSuccess { int64 rejected_log_records = 1; string error_message = 2; }
+//
+// See responseBodyHandler() for more info.
+func handleOTLPProtobufResponse(body []byte, _ int) (retry []int, lost int, reason string) {
+
+	var (
+		rejected     int
+		errorMessage string
+	)
+
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return nil, 0, ""
+		}
+		body = body[n:]
+
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, body); n < 0 {
+				return nil, 0, ""
+			}
+			body = body[n:]
+			continue
+		}
+
+		partialSuccess, n := protowire.ConsumeBytes(body)
+		if n < 0 {
+			return nil, 0, ""
+		}
+		body = body[n:]
+
+		for len(partialSuccess) > 0 {
+			num, typ, n := protowire.ConsumeTag(partialSuccess)
+			if n < 0 {
+				return nil, 0, ""
+			}
+			partialSuccess = partialSuccess[n:]
+
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				v, n := protowire.ConsumeVarint(partialSuccess)
+				if n < 0 {
+					return nil, 0, ""
+				}
+				rejected, partialSuccess = int(v), partialSuccess[n:]
+
+			case num == 2 && typ == protowire.BytesType:
+				v, n := protowire.ConsumeString(partialSuccess)
+				if n < 0 {
+					return nil, 0, ""
+				}
+				errorMessage, partialSuccess = v, partialSuccess[n:]
+
+			default:
+				if n = protowire.ConsumeFieldValue(num, typ, partialSuccess); n < 0 {
+					return nil, 0, ""
+				}
+				partialSuccess = partialSuccess[n:]
+			}
+		}
+	}
+
+	return otlpPartialSuccess(rejected, errorMessage)
+}
+
+// otlpPartialSuccess returns the result of responseBodyHandler()'s callback
+// for OTLP's partial success.
+func otlpPartialSuccess(rejected int, errorMessage string) (retry []int, lost int, reason string) {
+
+	if rejected <= 0 && errorMessage == "" {
+		return nil, 0, ""
+	}
+
+	reason = "OTLP: " + strconv.Itoa(rejected) + " log records are rejected"
+	if errorMessage != "" {
+		reason += ": " + errorMessage
+	}
+
+	return nil, rejected, reason
+}
@@ -0,0 +1,88 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpTestPartialSuccess returns OTLP/protobuf ExportLogsServiceResponse
+// with partial_success of 'rejected' and 'errorMessage'.
+func otlpTestPartialSuccess(rejected uint64, errorMessage string) []byte {
+
+	var partialSuccess []byte
+	partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+	partialSuccess = protowire.AppendVarint(partialSuccess, rejected)
+	if errorMessage != "" {
+		partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+		partialSuccess = protowire.AppendString(partialSuccess, errorMessage)
+	}
+	// Unknown fields must be skipped.
+	partialSuccess = protowire.AppendTag(partialSuccess, 15, protowire.VarintType)
+	partialSuccess = protowire.AppendVarint(partialSuccess, 1)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, partialSuccess)
+}
+
+func TestHandleOTLPResponse(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		json     string
+		protobuf []byte
+		lost     int
+		reason   string
+	}{
+		{"empty", `{}`, nil, 0, ""},
+		{"malformed", `{"partialSuccess":`, []byte{0x0A, 0x05, 0x08}, 0, ""},
+		{
+			"partial success w/o rejected records",
+			`{"partialSuccess":{}}`, otlpTestPartialSuccess(0, ""),
+			0, "",
+		},
+		{
+			"rejected records",
+			`{"partialSuccess":{"rejectedLogRecords":"3","errorMessage":"too old"}}`,
+			otlpTestPartialSuccess(3, "too old"),
+			3, "OTLP: 3 log records are rejected: too old",
+		},
+		{
+			"rejected records as JSON number",
+			`{"partialSuccess":{"rejectedLogRecords":2}}`, otlpTestPartialSuccess(2, ""),
+			2, "OTLP: 2 log records are rejected",
+		},
+		{
+			"warning only",
+			`{"partialSuccess":{"errorMessage":"deprecated field"}}`,
+			otlpTestPartialSuccess(0, "deprecated field"),
+			0, "OTLP: 0 log records are rejected: deprecated field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for format, handler := range map[string]func([]byte, int) ([]int, int, string){
+				"json":     handleOTLPJSONResponse,
+				"protobuf": handleOTLPProtobufResponse,
+			} {
+				body := []byte(tt.json)
+				if format == "protobuf" {
+					body = tt.protobuf
+				}
+
+				retry, lost, reason := handler(body, 5)
+				if retry != nil || lost != tt.lost || reason != tt.reason {
+					t.Fatalf("%s: got (%v, %d, %q), want (%v, %d, %q)",
+						format, retry, lost, reason, []int(nil), tt.lost, tt.reason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_OverflowPolicy is what CI_WriterHttp.Write() does
+	// with encoded log entry when the internal buffer of encoded log entries
+	// is full. See SetOverflowPolicy() method
+	// and CI_WRITER_HTTP_OVERFLOW_<...> constants.
+	CI_WriterHttp_OverflowPolicy uint8
+
+	// _CI_WriterHttpEntryLevel is the fields of encoded log entry (JSON object),
+	// the log entry's level is tried to be extracted from
+	// by the default priority classifier (see SetPriorityClassifier()).
+	_CI_WriterHttpEntryLevel struct {
+		Level          json.RawMessage `json:"level"`
+		LogLevel       json.RawMessage `json:"log.level"`
+		Severity       json.RawMessage `json:"severity"`
+		SeverityText   json.RawMessage `json:"severityText"`
+		SeverityNumber json.RawMessage `json:"severityNumber"`
+		Status         json.RawMessage `json:"status"`
+
+		// Nested objects, the level is in: Rollbar's item's "data",
+		// Splunk HEC's "event", Loki's "stream" (labels) and "values" (lines).
+		Data   json.RawMessage `json:"data"`
+		Event  json.RawMessage `json:"event"`
+		Stream json.RawMessage `json:"stream"`
+		Values json.RawMessage `json:"values"`
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// The new encoded log entry is dropped, ErrWriterBufferFull is returned.
+	CI_WRITER_HTTP_OVERFLOW_REJECT CI_WriterHttp_OverflowPolicy = iota
+
+	// Write() blocks until there is a free space (or until timeout).
+	CI_WRITER_HTTP_OVERFLOW_BLOCK
+
+	// The oldest queued encoded log entry is dropped in favour of the new one.
+	CI_WRITER_HTTP_OVERFLOW_DROP_OLDEST
+
+	// High priority (error and above by default) encoded log entries
+	// are queued to the separate buffer (evicting the oldest queued low priority one
+	// or blocking until timeout if it's full too), others are dropped.
+	CI_WRITER_HTTP_OVERFLOW_PRIORITY
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// OpenTelemetry's SeverityNumber of ERROR.
+	_OTLP_SEVERITY_NUMBER_ERROR = 17
+
+	// Syslog's (and GELF's) level of error.
+	_SYSLOG_LEVEL_ERROR = 3
+
+	// OTLP/protobuf's field numbers of ScopeLogs.log_records
+	// and LogRecord.severity_number.
+	_OTLP_PROTOBUF_LOG_RECORDS     = 2
+	_OTLP_PROTOBUF_SEVERITY_NUMBER = 2
+)
+
+var (
+	// highPriorityLevels are the (lower case) levels of encoded log entries,
+	// that are high priority for the default priority classifier.
+	highPriorityLevels = map[string]struct{}{
+		"error":     {},
+		"err":       {},
+		"critical":  {},
+		"crit":      {},
+		"alert":     {},
+		"emergency": {},
+		"emerg":     {},
+		"fatal":     {},
+		"panic":     {},
+	}
+)
+
+// writeOverflow is a part of Write(), that is called when the internal buffer
+// of encoded log entries is full. Queues 'p' or drops it (or another one)
+// according with overflow policy (see SetOverflowPolicy()).
+// Returns nil if 'p' has been queued.
+func (dw *CI_WriterHttp) writeOverflow(p []byte) error {
+
+	switch dw.overflowPolicy {
+
+	case CI_WRITER_HTTP_OVERFLOW_BLOCK:
+		if dw.writeBlocking(dw.entries, p) {
+			return nil
+		}
+		atomic.AddUint64(&dw.stats.entriesOverflowTimedOut, 1)
+
+	case CI_WRITER_HTTP_OVERFLOW_DROP_OLDEST:
+		// Other goroutines may fill the freed space before us. Try a few times.
+		for i := 0; i < 4; i++ {
+			select {
+			case <-dw.entries:
+				atomic.AddUint64(&dw.stats.entriesOverflowDroppedOldest, 1)
+				dw.loseEntries(_LOST_REASON_OVERFLOW, 1)
+			default:
+				// Workers took them.
+			}
+			select {
+			case dw.entries <- p:
+				return nil
+			default:
+			}
+		}
+		atomic.AddUint64(&dw.stats.entriesOverflowRejected, 1)
+
+	case CI_WRITER_HTTP_OVERFLOW_PRIORITY:
+		if !dw.priorityClassifier(p) {
+			atomic.AddUint64(&dw.stats.entriesOverflowDroppedLowPriority, 1)
+			break
+		}
+		if dw.writePriority(p) {
+			return nil
+		}
+		atomic.AddUint64(&dw.stats.entriesOverflowTimedOut, 1)
+
+	default:
+		atomic.AddUint64(&dw.stats.entriesOverflowRejected, 1)
+	}
+
+	dw.loseEntries(_LOST_REASON_OVERFLOW, 1)
+	return ErrWriterBufferFull
+}
+
+// writeBlocking pushes 'p' to the 'entries' channel, blocking until
+// there is a free space, overflow timeout is over (see SetOverflowPolicy())
+// or CI_WriterHttp is finally disabled. Returns true if 'p' has been pushed.
+func (dw *CI_WriterHttp) writeBlocking(entries chan<- []byte, p []byte) bool {
+
+	var timeoutChan <-chan time.Time
+	if dw.overflowTimeout > 0 {
+		t := time.NewTimer(dw.overflowTimeout)
+		defer t.Stop()
+		timeoutChan = t.C
+	}
+
+	select {
+	case entries <- p:
+		return true
+	case <-timeoutChan:
+		return false
+	case <-dw.ctx.Done():
+		return false
+	}
+}
+
+// writePriority is a part of writeOverflow(), that queues high priority 'p'.
+// If the separate buffer is full, the oldest queued low priority encoded log entry
+// is evicted (see evictLowPriority()) and 'p' takes its place.
+// If there is no such one, 'p' waits for a free space. High priority ones
+// are never evicted, and 'p' is never rejected while a low priority one is queued.
+// Blocks until overflow timeout is over (see SetOverflowPolicy())
+// or CI_WriterHttp is finally disabled. Returns true if 'p' has been queued.
+func (dw *CI_WriterHttp) writePriority(p []byte) bool {
+
+	select {
+	case dw.entriesPriority <- p:
+		return true
+	default:
+	}
+
+	var timeoutChan <-chan time.Time
+	if dw.overflowTimeout > 0 {
+		t := time.NewTimer(dw.overflowTimeout)
+		defer t.Stop()
+		timeoutChan = t.C
+	}
+
+	dw.evictLowPriority()
+
+	select {
+	case dw.entriesPriority <- p:
+		return true
+	case dw.entries <- p:
+		return true
+	case <-dw.ctx.Done():
+		return false
+	case <-timeoutChan:
+	}
+
+	// The last chance. Low priority ones might be queued while we're waiting.
+	if dw.evictLowPriority() {
+		select {
+		case dw.entries <- p:
+			return true
+		default:
+		}
+	}
+
+	return false
+}
+
+// evictLowPriority is a part of writePriority(), that drains the buffer
+// of encoded log entries, drops the oldest low priority one and queues the rest
+// back in their original order. Returns true if it's been dropped,
+// and so there is a free space for a new one.
+//
+// Only one goroutine evicts at the same time. But other goroutines
+// still may take the free space before all of them are queued back
+// (their encoded log entries are queued before ours then). Those that are
+// already drained are never dropped because of that: evictLowPriority() waits
+// until workers drain the buffer or CI_WriterHttp is finally disabled.
+func (dw *CI_WriterHttp) evictLowPriority() bool {
+
+	dw.overflowMu.Lock()
+	defer dw.overflowMu.Unlock()
+
+	// Drained entries are in none of buffers until they're queued back.
+	ticket := dw.inFlight.begin()
+	defer dw.inFlight.end(ticket)
+
+	queued := dw.overflowQueued[:0]
+	for drained := false; !drained; {
+		select {
+		case encodedEntry := <-dw.entries:
+			queued = append(queued, encodedEntry)
+		default:
+			// Workers may take some of them. It doesn't matter.
+			drained = true
+		}
+	}
+
+	evicted := false
+	for i, encodedEntry := range queued {
+		if !dw.priorityClassifier(encodedEntry) {
+			queued = append(queued[:i], queued[i+1:]...)
+			atomic.AddUint64(&dw.stats.entriesOverflowDroppedLowPriority, 1)
+			dw.loseEntries(_LOST_REASON_OVERFLOW, 1)
+			evicted = true
+			break
+		}
+	}
+
+	for i := 0; i < len(queued); i++ {
+		select {
+		case dw.entries <- queued[i]:
+			continue
+		case <-dw.ctx.Done():
+		}
+		// Nowhere to queue them back.
+		dw.loseEntries(_LOST_REASON_OVERFLOW, uint64(len(queued)-i))
+		break
+	}
+
+	// Let them be collected by GC.
+	for i := range queued {
+		queued[i] = nil
+	}
+
+	dw.overflowQueued = queued[:0]
+	return evicted
+}
+
+// isHighPriorityEntry is the default priority classifier
+// (see SetPriorityClassifier()). Reports whether encoded log entry
+// (JSON object or OTLP/protobuf's LogRecord) has a level of error or above.
+//
+// The level is taken from "level", "log.level", "severity", "severityText"
+// or "status" field (a name like "error", "CRITICAL", or a syslog's number),
+// or "severityNumber" field (OpenTelemetry's severity number).
+// If there are no such fields, they are looked for in Rollbar's "data",
+// Splunk HEC's "event", Loki's "stream" and lines of "values".
+func isHighPriorityEntry(encodedEntry []byte) bool {
+
+	encodedEntry = bytes.TrimSpace(encodedEntry)
+	if len(encodedEntry) == 0 {
+		return false
+	}
+
+	if encodedEntry[0] != '{' {
+		return isHighPriorityOTLPProtobufEntry(encodedEntry)
+	}
+
+	isHigh, _ := isHighPriorityJSONEntry(encodedEntry, true)
+	return isHigh
+}
+
+// isHighPriorityJSONEntry is isHighPriorityEntry() for JSON object.
+// Returns also whether the level has been found.
+// Nested objects are checked only if 'nested' is true.
+func isHighPriorityJSONEntry(encodedEntry []byte, nested bool) (isHigh, found bool) {
+
+	var entryLevel _CI_WriterHttpEntryLevel
+	if err := json.Unmarshal(encodedEntry, &entryLevel); err != nil {
+		return false, false
+	}
+
+	if isHigh, found = entryLevel.isHigh(); found || !nested {
+		return isHigh, found
+	}
+
+	for _, v := range []json.RawMessage{entryLevel.Data, entryLevel.Event, entryLevel.Stream} {
+		if v = bytes.TrimSpace(v); len(v) != 0 && v[0] == '{' {
+			if isHigh, found = isHighPriorityJSONEntry(v, false); found {
+				return isHigh, found
+			}
+		}
+	}
+
+	// Loki's "values" are [ [ "<ts>", "<line>" ], ... ].
+	var values [][]string
+	if err := json.Unmarshal(entryLevel.Values, &values); err != nil {
+		return false, false
+	}
+
+	for i := range values {
+		if len(values[i]) < 2 {
+			continue
+		}
+		line := bytes.TrimSpace([]byte(values[i][1]))
+		if len(line) != 0 && line[0] == '{' {
+			if isHigh, found = isHighPriorityJSONEntry(line, false); found {
+				return isHigh, found
+			}
+		}
+	}
+
+	return false, false
+}
+
+// isHighPriorityOTLPProtobufEntry is isHighPriorityEntry() for OTLP/protobuf's
+// encoded log entry: ScopeLogs.log_records field (with its tag and length),
+// see ekalog_encoder_otlp.CI_OTLPEncoder.
+func isHighPriorityOTLPProtobufEntry(encodedEntry []byte) bool {
+
+	num, typ, n := protowire.ConsumeTag(encodedEntry)
+	if n < 0 || num != _OTLP_PROTOBUF_LOG_RECORDS || typ != protowire.BytesType {
+		return false
+	}
+
+	record, n := protowire.ConsumeBytes(encodedEntry[n:])
+	if n < 0 {
+		return false
+	}
+
+	for len(record) > 0 {
+		num, typ, n = protowire.ConsumeTag(record)
+		if n < 0 {
+			return false
+		}
+		record = record[n:]
+
+		if num == _OTLP_PROTOBUF_SEVERITY_NUMBER && typ == protowire.VarintType {
+			severityNumber, n := protowire.ConsumeVarint(record)
+			return n >= 0 && severityNumber >= _OTLP_SEVERITY_NUMBER_ERROR
+		}
+
+		if n = protowire.ConsumeFieldValue(num, typ, record); n < 0 {
+			return false
+		}
+		record = record[n:]
+	}
+
+	return false
+}
+
+// isHigh reports whether the level of encoded log entry is error or above.
+// Returns also whether any of the level's fields is presented.
+func (entryLevel *_CI_WriterHttpEntryLevel) isHigh() (isHigh, found bool) {
+
+	if n, err := strconv.Atoi(string(entryLevel.SeverityNumber)); err == nil {
+		return n >= _OTLP_SEVERITY_NUMBER_ERROR, true
+	}
+
+	for _, v := range []json.RawMessage{
+		entryLevel.Level, entryLevel.LogLevel, entryLevel.Severity,
+		entryLevel.SeverityText, entryLevel.Status,
+	} {
+		v = bytes.TrimSpace(v)
+		switch {
+		case len(v) == 0:
+			continue
+
+		case v[0] == '"':
+			var level string
+			if err := json.Unmarshal(v, &level); err != nil {
+				continue
+			}
+			_, isHigh = highPriorityLevels[strings.ToLower(level)]
+			return isHigh, true
+
+		default:
+			if n, err := strconv.Atoi(string(v)); err == nil {
+				return n <= _SYSLOG_LEVEL_ERROR, true
+			}
+		}
+	}
+
+	return false, false
+}
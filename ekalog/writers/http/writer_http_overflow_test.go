@@ -0,0 +1,205 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// overflowTestDrain returns all encoded log entries, queued to 'ch'.
+func overflowTestDrain(ch chan []byte) (entries []string) {
+	for len(ch) > 0 {
+		entries = append(entries, string(<-ch))
+	}
+	return entries
+}
+
+func TestWriteOverflow(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		policy      CI_WriterHttp_OverflowPolicy
+		entry       string
+		consume     bool // a worker takes the oldest one while Write() is blocked
+		wantErr     bool
+		wantEntries []string
+		stat        func(s *_CI_WriterHttpStats) uint64
+		counted     uint64 // by 'stat'
+	}{
+		{
+			name:        "reject",
+			policy:      CI_WRITER_HTTP_OVERFLOW_REJECT,
+			entry:       "info new",
+			wantErr:     true,
+			wantEntries: []string{"info 1", "info 2"},
+			stat:        func(s *_CI_WriterHttpStats) uint64 { return s.entriesOverflowRejected },
+			counted:     1,
+		},
+		{
+			name:        "block, timed out",
+			policy:      CI_WRITER_HTTP_OVERFLOW_BLOCK,
+			entry:       "info new",
+			wantErr:     true,
+			wantEntries: []string{"info 1", "info 2"},
+			stat:        func(s *_CI_WriterHttpStats) uint64 { return s.entriesOverflowTimedOut },
+			counted:     1,
+		},
+		{
+			name:        "block, consumed",
+			policy:      CI_WRITER_HTTP_OVERFLOW_BLOCK,
+			entry:       "info new",
+			consume:     true,
+			wantEntries: []string{"info 2", "info new"},
+			stat:        func(s *_CI_WriterHttpStats) uint64 { return s.entriesOverflowTimedOut },
+			counted:     0,
+		},
+		{
+			name:        "drop oldest",
+			policy:      CI_WRITER_HTTP_OVERFLOW_DROP_OLDEST,
+			entry:       "info new",
+			wantEntries: []string{"info 2", "info new"},
+			stat:        func(s *_CI_WriterHttpStats) uint64 { return s.entriesOverflowDroppedOldest },
+			counted:     1,
+		},
+		{
+			name:        "priority, low priority one",
+			policy:      CI_WRITER_HTTP_OVERFLOW_PRIORITY,
+			entry:       "info new",
+			wantErr:     true,
+			wantEntries: []string{"info 1", "info 2"},
+			stat:        func(s *_CI_WriterHttpStats) uint64 { return s.entriesOverflowDroppedLowPriority },
+			counted:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := &CI_WriterHttp{
+				ctx:                context.Background(),
+				overflowPolicy:     tt.policy,
+				overflowTimeout:    10 * time.Millisecond,
+				entries:            make(chan []byte, 2),
+				entriesPriority:    make(chan []byte, 1),
+				priorityClassifier: func(p []byte) bool { return strings.HasPrefix(string(p), "error") },
+			}
+			dw.entries <- []byte("info 1")
+			dw.entries <- []byte("info 2")
+
+			if tt.consume {
+				// Write() must not time out, however late the worker is scheduled.
+				dw.overflowTimeout = time.Minute
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					<-dw.entries
+				}()
+			}
+
+			if err := dw.writeOverflow([]byte(tt.entry)); (err != nil) != tt.wantErr {
+				t.Fatalf("writeOverflow() = %v, want error: %v", err, tt.wantErr)
+			}
+			if got := overflowTestDrain(dw.entries); strings.Join(got, ",") != strings.Join(tt.wantEntries, ",") {
+				t.Fatalf("entries buffer: got %v, want %v", got, tt.wantEntries)
+			}
+			if n := tt.stat(&dw.stats); n != tt.counted {
+				t.Fatalf("overflow is counted %d times, want %d", n, tt.counted)
+			}
+			wantLost := uint64(0)
+			if tt.wantErr || tt.policy == CI_WRITER_HTTP_OVERFLOW_DROP_OLDEST {
+				wantLost = 1
+			}
+			if lost := dw.stats.entriesLost[_LOST_REASON_OVERFLOW]; lost != wantLost {
+				t.Fatalf("lost %d encoded log entries, want %d", lost, wantLost)
+			}
+		})
+	}
+}
+
+func TestWritePriority(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		entries      []string // queued to the buffer, that is full then
+		priorityFull bool     // the separate buffer is full too
+		queued       bool
+		wantEntries  []string
+		wantPriority []string
+		droppedLow   uint64
+	}{
+		{
+			name:         "separate buffer has space",
+			entries:      []string{"info 1", "info 2"},
+			queued:       true,
+			wantEntries:  []string{"info 1", "info 2"},
+			wantPriority: []string{"error new"},
+		},
+		{
+			name:         "oldest is low priority",
+			entries:      []string{"info 1", "error 2"},
+			priorityFull: true,
+			queued:       true,
+			wantEntries:  []string{"error 2", "error new"},
+			wantPriority: []string{"error full"},
+			droppedLow:   1,
+		},
+		{
+			name:         "oldest is high priority",
+			entries:      []string{"error 1", "info 2", "info 3"},
+			priorityFull: true,
+			queued:       true,
+			wantEntries:  []string{"error 1", "info 3", "error new"},
+			wantPriority: []string{"error full"},
+			droppedLow:   1,
+		},
+		{
+			name:         "all are high priority",
+			entries:      []string{"error 1", "error 2"},
+			priorityFull: true,
+			queued:       false,
+			wantEntries:  []string{"error 1", "error 2"},
+			wantPriority: []string{"error full"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := &CI_WriterHttp{
+				ctx:                context.Background(),
+				overflowTimeout:    10 * time.Millisecond,
+				entries:            make(chan []byte, len(tt.entries)),
+				entriesPriority:    make(chan []byte, 1),
+				priorityClassifier: func(p []byte) bool { return strings.HasPrefix(string(p), "error") },
+			}
+			for _, entry := range tt.entries {
+				dw.entries <- []byte(entry)
+			}
+			if tt.priorityFull {
+				dw.entriesPriority <- []byte("error full")
+			}
+
+			start := time.Now()
+			if queued := dw.writePriority([]byte("error new")); queued != tt.queued {
+				t.Fatalf("writePriority() = %v, want %v", queued, tt.queued)
+			}
+			if elapsed := time.Since(start); elapsed > 5*dw.overflowTimeout {
+				t.Fatalf("writePriority() is blocked for %v, longer than overflow timeout", elapsed)
+			}
+
+			if got := overflowTestDrain(dw.entries); strings.Join(got, ",") != strings.Join(tt.wantEntries, ",") {
+				t.Fatalf("entries buffer: got %v, want %v", got, tt.wantEntries)
+			}
+			if got := overflowTestDrain(dw.entriesPriority); strings.Join(got, ",") != strings.Join(tt.wantPriority, ",") {
+				t.Fatalf("separate buffer: got %v, want %v", got, tt.wantPriority)
+			}
+
+			if dropped := dw.stats.entriesOverflowDroppedLowPriority; dropped != tt.droppedLow {
+				t.Fatalf("dropped low priority %d, want %d", dropped, tt.droppedLow)
+			}
+		})
+	}
+}
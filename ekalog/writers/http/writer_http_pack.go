@@ -0,0 +1,273 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_PackBuilder builds HTTP request's body from the encoded
+	// log entries of an entries pack, framing each of them as your log service
+	// provider requires (length-prefixed frames, bulk action lines,
+	// syslog octet counting, envelope item headers, etc).
+	// See SetPackBuilder() method.
+	//
+	// The body is built each time the entries pack is sent,
+	// so the same entries pack (or its part, if it's split) may be built many times.
+	// Methods are called from many goroutines. They must be thread-safe.
+	CI_WriterHttp_PackBuilder interface {
+
+		// Begin writes the data, that is placed before the first encoded log entry.
+		Begin(body *bytes.Buffer)
+
+		// AppendEntry writes 'i'-th (starting from 0) encoded log entry
+		// with its framing. 'encodedEntry' must not be retained.
+		AppendEntry(body *bytes.Buffer, i int, encodedEntry []byte)
+
+		// End writes the data, that is placed after the last encoded log entry.
+		// 'n' is the number of encoded log entries.
+		End(body *bytes.Buffer, n int)
+	}
+
+	// _CI_WriterHttpStaticPackBuilder is the default CI_WriterHttp_PackBuilder,
+	// that uses constant data before, after and between encoded log entries.
+	// See AddBefore(), AddAfter(), AddBetween() methods.
+	_CI_WriterHttpStaticPackBuilder struct {
+		before, after, between []byte
+	}
+
+	// _CI_WriterHttpPack is an entries pack. Encoded log entries
+	// (as is, w/o any framing) and their lengths.
+	// HTTP request's body is built from them by CI_WriterHttp_PackBuilder.
+	//
+	// Lengths are required to split an entries pack if it's too large
+	// for the log service provider (HTTP 413).
+	_CI_WriterHttpPack struct {
+		data    *bytes.Buffer
+		entries []uint32
+	}
+)
+
+var (
+	// packBodyPool is a pool of HTTP request's bodies, built from entries packs.
+	packBodyPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+)
+
+// Begin implements CI_WriterHttp_PackBuilder. Writes the data before
+// the first encoded log entry (see AddBefore()).
+func (b *_CI_WriterHttpStaticPackBuilder) Begin(body *bytes.Buffer) {
+	_, _ = body.Write(b.before)
+}
+
+// AppendEntry implements CI_WriterHttp_PackBuilder. Writes the data between
+// encoded log entries (see AddBetween()), if it's not the first one,
+// and then 'encodedEntry' as is.
+func (b *_CI_WriterHttpStaticPackBuilder) AppendEntry(body *bytes.Buffer, i int, encodedEntry []byte) {
+	if i > 0 {
+		_, _ = body.Write(b.between)
+	}
+	_, _ = body.Write(encodedEntry)
+}
+
+// End implements CI_WriterHttp_PackBuilder. Writes the data after
+// the last encoded log entry (see AddAfter()).
+func (b *_CI_WriterHttpStaticPackBuilder) End(body *bytes.Buffer, _ int) {
+	_, _ = body.Write(b.after)
+}
+
+// newStaticPackBuilder returns a _CI_WriterHttpStaticPackBuilder
+// with the data 'before', 'after' and 'between' encoded log entries.
+// Predefined providers use it to frame encoded log entries.
+func newStaticPackBuilder(before, after, between string) *_CI_WriterHttpStaticPackBuilder {
+	return &_CI_WriterHttpStaticPackBuilder{[]byte(before), []byte(after), []byte(between)}
+}
+
+// newPack creates a new empty _CI_WriterHttpPack,
+// with the data of 'size' bytes capacity.
+func newPack(size int) *_CI_WriterHttpPack {
+	return &_CI_WriterHttpPack{
+		data: bytes.NewBuffer(make([]byte, 0, size)),
+	}
+}
+
+// Add adds encoded log entry to the current _CI_WriterHttpPack.
+func (p *_CI_WriterHttpPack) Add(encodedEntry []byte) {
+	_, _ = p.data.Write(encodedEntry)
+	p.entries = append(p.entries, uint32(len(encodedEntry)))
+}
+
+// Reset makes the current _CI_WriterHttpPack empty, keeping allocated memory.
+func (p *_CI_WriterHttpPack) Reset() {
+	p.data.Reset()
+	p.entries = p.entries[:0]
+}
+
+// Copy returns a deep copy of the current _CI_WriterHttpPack.
+func (p *_CI_WriterHttpPack) Copy() *_CI_WriterHttpPack {
+
+	pc := newPack(p.data.Len())
+	_, _ = pc.data.Write(p.data.Bytes())
+	pc.entries = append(make([]uint32, 0, len(p.entries)), p.entries...)
+
+	return pc
+}
+
+// Marshal returns a binary representation of the current _CI_WriterHttpPack,
+// that might be restored by unmarshalPack() then.
+//
+// Layout: [entries number BE4][entry length BE4]...[data].
+func (p *_CI_WriterHttpPack) Marshal() []byte {
+
+	b := make([]byte, 4+4*len(p.entries)+p.data.Len())
+	binary.BigEndian.PutUint32(b, uint32(len(p.entries)))
+
+	for i, n := range p.entries {
+		binary.BigEndian.PutUint32(b[4+4*i:], n)
+	}
+
+	copy(b[4+4*len(p.entries):], p.data.Bytes())
+	return b
+}
+
+// unmarshalPack restores _CI_WriterHttpPack from its binary representation,
+// generated by Marshal(). Returns nil if 'b' is malformed,
+// including the case when encoded log entries' lengths don't match
+// the data's length.
+func unmarshalPack(b []byte) *_CI_WriterHttpPack {
+
+	if len(b) < 4 {
+		return nil
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if n == 0 || uint64(len(b)) < 4+4*uint64(n) {
+		return nil
+	}
+
+	p := &_CI_WriterHttpPack{
+		entries: make([]uint32, n),
+	}
+
+	total := uint64(0)
+	for i := range p.entries {
+		p.entries[i] = binary.BigEndian.Uint32(b[4+4*i:])
+		total += uint64(p.entries[i])
+	}
+
+	data := b[4+4*n:]
+	if uint64(len(data)) != total {
+		return nil
+	}
+
+	p.data = bytes.NewBuffer(data)
+	return p
+}
+
+// buildPackBody builds HTTP request's body from 'p' using pack builder
+// (see SetPackBuilder()). The returned body must be released
+// by releasePackBody() when it's no longer needed.
+func (dw *CI_WriterHttp) buildPackBody(p *_CI_WriterHttpPack) *bytes.Buffer {
+
+	body := packBodyPool.Get().(*bytes.Buffer)
+	body.Reset()
+
+	data, offset := p.data.Bytes(), 0
+
+	dw.packBuilderActive.Begin(body)
+	for i, n := range p.entries {
+		dw.packBuilderActive.AppendEntry(body, i, data[offset:offset+int(n)])
+		offset += int(n)
+	}
+	dw.packBuilderActive.End(body, len(p.entries))
+
+	return body
+}
+
+// releasePackBody returns 'body', built by buildPackBody(), to the pool.
+func releasePackBody(body *bytes.Buffer) {
+	packBodyPool.Put(body)
+}
+
+// packEntrySize returns how much bytes 'i'-th encoded log entry takes
+// in HTTP request's body with its framing. 'scratch' is a reusable buffer.
+func (dw *CI_WriterHttp) packEntrySize(scratch *bytes.Buffer, i int, encodedEntry []byte) int {
+
+	if b, ok := dw.packBuilderActive.(*_CI_WriterHttpStaticPackBuilder); ok {
+		if i > 0 {
+			return len(b.between) + len(encodedEntry)
+		}
+		return len(encodedEntry)
+	}
+
+	scratch.Reset()
+	dw.packBuilderActive.AppendEntry(scratch, i, encodedEntry)
+	return scratch.Len()
+}
+
+// packEdgesSize returns how much bytes the data before the first
+// and after the last encoded log entry take in HTTP request's body,
+// if there are 'n' encoded log entries. 'scratch' is a reusable buffer.
+func (dw *CI_WriterHttp) packEdgesSize(scratch *bytes.Buffer, n int) int {
+
+	scratch.Reset()
+	dw.packBuilderActive.Begin(scratch)
+	dw.packBuilderActive.End(scratch, n)
+
+	return scratch.Len()
+}
+
+// splitPack splits 'p' into two entries packs, each of them contains
+// a half of 'p's encoded log entries. 'p' must contain at least 2 encoded log entries.
+func (dw *CI_WriterHttp) splitPack(p *_CI_WriterHttpPack) (left, right *_CI_WriterHttpPack) {
+
+	half := len(p.entries) / 2
+
+	indexes := make([]int, len(p.entries))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	return dw.pickPack(p, indexes[:half]), dw.pickPack(p, indexes[half:])
+}
+
+// pickPack returns a new entries pack, that contains only those
+// encoded log entries of 'p', which indexes are 'indexes' (must be sorted).
+//
+// The new entries pack's capacity is the size of picked encoded log entries,
+// so a deep split of a large entries pack doesn't allocate 'p's size on each level.
+func (dw *CI_WriterHttp) pickPack(p *_CI_WriterHttpPack, indexes []int) *_CI_WriterHttpPack {
+
+	size := 0
+	for i, next := 0, 0; i < len(p.entries) && next < len(indexes); i++ {
+		if indexes[next] == i {
+			size += int(p.entries[i])
+			next++
+		}
+	}
+
+	var (
+		data   = p.data.Bytes()
+		offset = 0
+		next   = 0
+		pp     = newPack(size)
+	)
+
+	for i, n := range p.entries {
+		if next < len(indexes) && indexes[next] == i {
+			pp.Add(data[offset : offset+int(n)])
+			next++
+		}
+		offset += int(n)
+	}
+
+	return pp
+}
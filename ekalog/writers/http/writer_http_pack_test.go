@@ -0,0 +1,251 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// packTestOf returns a new entries pack of 'entries'.
+func packTestOf(entries ...string) *_CI_WriterHttpPack {
+	p := newPack(0)
+	for _, entry := range entries {
+		p.Add([]byte(entry))
+	}
+	return p
+}
+
+// packTestEntries returns the encoded log entries of 'p'.
+func packTestEntries(p *_CI_WriterHttpPack) []string {
+
+	var (
+		entries []string
+		data    = p.data.Bytes()
+		offset  = 0
+	)
+
+	for _, n := range p.entries {
+		entries = append(entries, string(data[offset:offset+int(n)]))
+		offset += int(n)
+	}
+
+	return entries
+}
+
+func TestPack_MarshalUnmarshal(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		entries []string
+	}{
+		{"one entry", []string{`{"message":"first"}`}},
+		{"many entries", []string{`{"message":"first"}`, `{"message":"second"}`, `{}`}},
+		{"empty entry", []string{`{"message":"first"}`, ``, `{"message":"third"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := unmarshalPack(packTestOf(tt.entries...).Marshal())
+			if p == nil {
+				t.Fatal("marshalled pack is not unmarshalled")
+			}
+			if got := packTestEntries(p); strings.Join(got, "|") != strings.Join(tt.entries, "|") ||
+				len(got) != len(tt.entries) {
+				t.Fatalf("unmarshalled %q, want %q", got, tt.entries)
+			}
+		})
+	}
+}
+
+func TestUnmarshalPack_Malformed(t *testing.T) {
+
+	valid := packTestOf("first", "second").Marshal()
+
+	// withLength returns 'valid' with 'i'-th entry's length replaced by 'n'.
+	withLength := func(i int, n uint32) []byte {
+		b := append([]byte(nil), valid...)
+		binary.BigEndian.PutUint32(b[4+4*i:], n)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"truncated counter", valid[:3]},
+		{"no entries", packTestOf().Marshal()},
+		{"truncated lengths", valid[:4+4]},
+		{"truncated data", valid[:len(valid)-1]},
+		{"trailing data", append(append([]byte(nil), valid...), 'X')},
+		{"length is too big", withLength(1, 7)},
+		{"length is too small", withLength(0, 1)},
+		{"length overflows", withLength(0, 1<<32-1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p := unmarshalPack(tt.b); p != nil {
+				t.Fatalf("malformed pack is unmarshalled: %q", packTestEntries(p))
+			}
+		})
+	}
+}
+
+func TestSplitPack(t *testing.T) {
+
+	tests := []struct {
+		entries     []string
+		left, right []string
+	}{
+		{[]string{"1", "2"}, []string{"1"}, []string{"2"}},
+		{[]string{"1", "22", "333"}, []string{"1"}, []string{"22", "333"}},
+		{[]string{"1", "22", "333", "4444"}, []string{"1", "22"}, []string{"333", "4444"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.entries, ","), func(t *testing.T) {
+			left, right := new(CI_WriterHttp).splitPack(packTestOf(tt.entries...))
+
+			gotLeft, gotRight := packTestEntries(left), packTestEntries(right)
+			if strings.Join(gotLeft, ",") != strings.Join(tt.left, ",") ||
+				strings.Join(gotRight, ",") != strings.Join(tt.right, ",") {
+				t.Fatalf("split to %q, %q, want %q, %q", gotLeft, gotRight, tt.left, tt.right)
+			}
+
+			// Halves must not reserve the whole parent's size.
+			for _, half := range [...]*_CI_WriterHttpPack{left, right} {
+				if c, l := half.data.Cap(), half.data.Len(); c != l {
+					t.Fatalf("half %q has capacity %d, want %d", packTestEntries(half), c, l)
+				}
+			}
+		})
+	}
+}
+
+func TestSendPack_TooLarge(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		maxEntries int // provider accepts at most that many entries per request
+		entries    int
+		tooLarge   uint64 // entries, that are lost because they are too large alone
+	}{
+		{"accepted", 8, 8, 0},
+		{"split once", 4, 8, 0},
+		{"split many times", 1, 7, 0},
+		{"too large alone", 0, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received []string
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				var entries []string
+				if err := json.Unmarshal(body, &entries); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if len(entries) > tt.maxEntries && len(entries) > 0 {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				mu.Lock()
+				received = append(received, entries...)
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			dw := new(CI_WriterHttp).
+				UseProviderManual(func(req *fasthttp.Request) {
+					req.SetRequestURI(srv.URL)
+				}).
+				AddBeforeAfterBetweenS("[", "]", ",").
+				SetWorkersNum(1).
+				SetWorkerAutoFlushDelay(time.Hour)
+
+			var want []string
+			for i := 0; i < tt.entries; i++ {
+				entry := `"entry ` + string(rune('a'+i)) + `"`
+				if _, legacyErr := dw.Write([]byte(entry)); legacyErr != nil {
+					t.Fatalf("failed to write: %v", legacyErr)
+				}
+				want = append(want, entry[1:len(entry)-1])
+			}
+
+			if err := dw.Close(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to close")
+			}
+
+			stats := dw.Stats()
+			if lost := stats.EntriesLostByReason["too_large"]; lost != tt.tooLarge {
+				t.Fatalf("lost as too large %d entries, want %d", lost, tt.tooLarge)
+			}
+			if tt.tooLarge != 0 {
+				want = nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			sort.Strings(received)
+			if strings.Join(received, "|") != strings.Join(want, "|") {
+				t.Fatalf("provider received %q, want %q", received, want)
+			}
+		})
+	}
+}
+
+func TestPackBuilder_Precedence(t *testing.T) {
+
+	user := sentryEnvelopeBuilder{}
+	provider := func(dw *CI_WriterHttp) *CI_WriterHttp {
+		return dw.UseProviderElasticsearch("http://127.0.0.1:9200", "logs")
+	}
+
+	tests := []struct {
+		name  string
+		dw    *CI_WriterHttp
+		check func(b CI_WriterHttp_PackBuilder) bool
+	}{
+		{"static", new(CI_WriterHttp),
+			func(b CI_WriterHttp_PackBuilder) bool { _, ok := b.(*_CI_WriterHttpStaticPackBuilder); return ok }},
+		{"provider's", provider(new(CI_WriterHttp)),
+			func(b CI_WriterHttp_PackBuilder) bool { _, ok := b.(elasticsearchIndexPattern); return ok }},
+		{"user's before provider's", provider(new(CI_WriterHttp).SetPackBuilder(user)),
+			func(b CI_WriterHttp_PackBuilder) bool { return b == user }},
+		{"user's after provider's", provider(new(CI_WriterHttp)).SetPackBuilder(user),
+			func(b CI_WriterHttp_PackBuilder) bool { return b == user }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dw.initOverwriteZeroValues()
+			if !tt.check(tt.dw.packBuilderActive) {
+				t.Fatalf("wrong active pack builder: %T", tt.dw.packBuilderActive)
+			}
+		})
+	}
+}
@@ -8,6 +8,11 @@ package ekalog_writer_http
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -53,7 +58,8 @@ const (
 	// Two cases:
 	//
 	// 1. There was a some network problem and CI_WriterHttp "paused" at this moment.
-	//    (Only one worker is running at this moment and tries to restore connection).
+	//    (The circuit breaker is open or half-open. Entries packs are deferred,
+	//    the master worker probes the provider and restores connection).
 	//
 	// 2. Final dying is requested by destructor.
 	//    Will be changed to "finally disabled" soon (almost instantly).
@@ -77,8 +83,51 @@ const (
 	_DEFAULT_ENTRIES_DEFERRED_BUF_SIZE      = 16384
 	_DEFAULT_WORKER_NUM                     = 2
 	_DEFAULT_ENTRIES_PER_WORKER_BUF_SIZE    = 32
+	_DEFAULT_WORKER_BUF_INIT_SIZE           = 2 << 20
 	_DEFAULT_WORKER_FLUSH_DELAY             = 10 * time.Second
 	_DEFAULT_WORKER_FLUSH_DEFERRED_PER_ITER = 5
+	_DEFAULT_RETRY_MAX_ATTEMPTS             = 3
+	_DEFAULT_RETRY_BASE_DELAY               = 500 * time.Millisecond
+	_DEFAULT_RETRY_MAX_DELAY                = 30 * time.Second
+	_DEFAULT_RETRY_JITTER                   = 0.2
+)
+
+var (
+	// defaultRetryStatusCodes is used when SetRetryableStatusCodes() is not called.
+	defaultRetryStatusCodes = map[int]struct{}{
+		fasthttp.StatusRequestTimeout:      {},
+		fasthttp.StatusTooManyRequests:     {},
+		fasthttp.StatusInternalServerError: {},
+		fasthttp.StatusBadGateway:          {},
+		fasthttp.StatusServiceUnavailable:  {},
+		fasthttp.StatusGatewayTimeout:      {},
+	}
+
+	// encodedEntryTimeLayouts are the layouts encoded log entry's time
+	// is tried to be parsed with.
+	encodedEntryTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z0700",
+	}
+)
+
+type (
+	// _CI_WriterHttpEntryTime is the fields of encoded log entry (JSON object),
+	// the log entry's time is tried to be extracted from (in that order).
+	_CI_WriterHttpEntryTime struct {
+		AtTimestamp string `json:"@timestamp"`
+		Timestamp   string `json:"timestamp"`
+		Time        string `json:"time"`
+	}
+
+	// _CI_WriterHttpBodyError is returned by predefined providers' body preparers
+	// (see UseProviderManual()) instead of a new body, if it can't be prepared.
+	// The request is not sent then, and its encoded log entries are lost.
+	_CI_WriterHttpBodyError struct {
+		err error
+	}
 )
 
 // configure is a private part of public configuration methods.
@@ -101,6 +150,48 @@ func (dw *CI_WriterHttp) configure(cb func(dw *CI_WriterHttp)) *CI_WriterHttp {
 	return dw
 }
 
+// resetProvider resets all fields, that are set by UseProvider<...>() methods,
+// so the previously used provider's settings do not leak to the next one.
+// Predefined providers call it before they set their own values.
+// Must be called by configure().
+func (dw *CI_WriterHttp) resetProvider() {
+
+	dw.providerInitializer = nil
+	dw.providerBodyPreparer = nil
+	dw.providerPingInitializer = nil
+	dw.providerPingSkip = false
+	dw.providerResponseHandler = nil
+	dw.providerFailureHandler = nil
+	dw.providerPackSplitter = nil
+	dw.providerMaxEntriesPerPack = 0
+	dw.providerPackMaxSize = 0
+	dw.providerEntryMaxSize = 0
+	dw.packEntryOverhead = 0
+	dw.providerCompressionAlgo = CI_WRITER_HTTP_COMPRESSION_NONE
+	dw.providerAuthenticator = nil
+	dw.providerErr = nil
+	dw.lokiTenantID = ""
+	dw.lokiProtobuf = false
+	dw.providerPackBuilder = nil
+}
+
+// useProvider sets provider's request initializer and body preparer
+// (see UseProviderManual()) w/o resetting other provider's fields.
+func (dw *CI_WriterHttp) useProvider(
+
+	cb func(req *fasthttp.Request),
+	bodyPreparer ...func(reader io.Reader) io.Reader,
+
+) *CI_WriterHttp {
+
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.providerInitializer = cb
+		if len(bodyPreparer) > 0 && bodyPreparer[0] != nil {
+			dw.providerBodyPreparer = bodyPreparer[0]
+		}
+	})
+}
+
 // canWrite reports whether Write() method can add a new encoded log entry
 // as []byte to the 'entries' channel. If CI_WriterHttp is not initialized yet,
 // it does an initialization and starts workers.
@@ -166,12 +257,19 @@ func (dw *CI_WriterHttp) canWrite() bool {
 
 // performInitialization initializes a CI_WriterHttp.
 // Returns error if no provider is selected or ping was failed.
-// Tries to ping only if the user didn't do it himself.
+// Tries to ping only if the user didn't do it himself
+// and the provider can be pinged w/o ingesting a log entry.
 func (dw *CI_WriterHttp) performInitialization() *ekaerr.Error {
 
 	// At this code point, dw.slowInit mutex is acquired (locked).
 	// Overwrite default or incorrect values.
 
+	if dw.providerErr.IsNotNil() {
+		return dw.providerErr.
+			AddMessage("CI_WriterHttp: Failed to set up provider.").
+			Throw()
+	}
+
 	if dw.providerInitializer == nil {
 		return ekaerr.IllegalArgument.
 			New("CI_WriterHttp: Provider is not presented. " +
@@ -179,8 +277,13 @@ func (dw *CI_WriterHttp) performInitialization() *ekaerr.Error {
 			Throw()
 	}
 
+	// Compressors' pools are created once, setters can't change them later.
+	// Explicit Ping() before the initialization is sent uncompressed.
+	dw.initOverwriteZeroValues()
+	dw.compression = newCompression(dw.compressionAlgo, dw.compressionLevel)
+
 	// Try to ping.
-	if !dw.beenPinged {
+	if !dw.beenPinged && !dw.providerPingSkip {
 		if err := dw.ping(false, nil); err.IsNotNil() {
 			return err.
 				AddMessage("CI_WriterHttp: Ping failed. " +
@@ -189,11 +292,30 @@ func (dw *CI_WriterHttp) performInitialization() *ekaerr.Error {
 		}
 	}
 
+	dw.breaker = newBreaker(dw.breakerFailureRate, dw.breakerWindow,
+		dw.breakerMinRequests, dw.breakerOpenDuration)
+
+	dw.limiter = newRateLimiter(dw.rateLimitRequests, dw.rateLimitBytes)
+
+	if dw.spoolDir != "" {
+		spool, err := spoolOpen(dw.spoolDir, int64(dw.spoolMaxSize),
+			_DEFAULT_SPOOL_SEGMENT_MAX, dw.spoolSyncPolicy)
+		if err.IsNotNil() {
+			return err.
+				AddMessage("CI_WriterHttp: Failed to open deferred entries spool.").
+				Throw()
+		}
+		dw.spool = spool
+	}
+
 	// OK, run workers.
 
 	dw.workerTickers = make([]*time.Ticker, dw.workerNum)
 	dw.entries = make(chan []byte, dw.entriesBufferLen)
-	dw.entriesPackDeferred = make(chan *bytes.Buffer, *dw.deferredEntriesBufferLen)
+	if dw.overflowPolicy == CI_WRITER_HTTP_OVERFLOW_PRIORITY {
+		dw.entriesPriority = make(chan []byte, dw.entriesBufferLen)
+	}
+	dw.entriesPackDeferred = make(chan *_CI_WriterHttpPack, *dw.deferredEntriesBufferLen)
 
 	if dw.ctx == nil {
 		dw.ctx = context.Background()
@@ -205,22 +327,30 @@ func (dw *CI_WriterHttp) performInitialization() *ekaerr.Error {
 		dw.externalWg.Add(1)
 	}
 
+	dw.workerFlushRequests = make([]chan *sync.WaitGroup, dw.workerNum)
+	dw.probeTicker = time.NewTicker(dw.breaker.probeInterval())
+
 	for i := uint16(0); i < dw.workerNum; i++ {
 		dw.workerTickers[i] = time.NewTicker(dw.workerFlushDelay)
-		go dw.worker(i == 0, dw.entries, dw.workerTickers[i].C)
+		dw.workerFlushRequests[i] = make(chan *sync.WaitGroup, 1)
+		go dw.worker(i == 0, dw.entries, dw.entriesPriority,
+			dw.workerTickers[i].C, dw.workerFlushRequests[i])
 	}
 
 	// OK, workers ran, register destructor
 	// (we need to flush all changes before app will be closed).
 	ekadeath.Reg(func() {
 		lostEntries := atomic.LoadUint64(&dw.entriesCompletelyLostCounter)
+		if dw.spool != nil {
+			lostEntries += dw.spool.Corrupted()
+		}
 		if lostEntries > 0 {
 			err := ekaerr.RejectedOperation.
 				New("CI_WriterHttp: Some log entries are lost and will never be logged.").
 				WithUint64("ci_writer_http_min_lost_entries_num", lostEntries)
 			ekalog.Warne("", err)
 		}
-		dw.disable(false)
+		dw.disable()
 	})
 
 	return nil
@@ -242,6 +372,31 @@ func (dw *CI_WriterHttp) initOverwriteZeroValues() {
 		dw.workerEntriesBufferLen = _DEFAULT_ENTRIES_PER_WORKER_BUF_SIZE
 	}
 
+	if dw.workerBufferInitSize <= 0 {
+		dw.workerBufferInitSize = _DEFAULT_WORKER_BUF_INIT_SIZE
+	}
+
+	if dw.providerMaxEntriesPerPack != 0 && dw.workerEntriesBufferLen > dw.providerMaxEntriesPerPack {
+		dw.workerEntriesBufferLen = dw.providerMaxEntriesPerPack
+	}
+
+	if dw.providerPackMaxSize != 0 && (dw.packMaxSize == 0 || dw.packMaxSize > dw.providerPackMaxSize) {
+		dw.packMaxSize = dw.providerPackMaxSize
+	}
+
+	if dw.providerEntryMaxSize != 0 && (dw.entryMaxSize == 0 || dw.entryMaxSize > dw.providerEntryMaxSize) {
+		dw.entryMaxSize = dw.providerEntryMaxSize
+	}
+
+	if !dw.compressionSet {
+		dw.compressionAlgo = dw.providerCompressionAlgo
+		dw.compressionLevel = 0
+	}
+
+	if dw.packMaxSize != 0 && dw.workerBufferInitSize > dw.packMaxSize {
+		dw.workerBufferInitSize = dw.packMaxSize
+	}
+
 	if dw.workerFlushDelay <= 0 {
 		dw.workerFlushDelay = _DEFAULT_WORKER_FLUSH_DELAY
 	}
@@ -254,18 +409,84 @@ func (dw *CI_WriterHttp) initOverwriteZeroValues() {
 		var v uint32 = _DEFAULT_ENTRIES_DEFERRED_BUF_SIZE
 		dw.deferredEntriesBufferLen = &v
 	}
+
+	if dw.spoolMaxSize <= 0 {
+		dw.spoolMaxSize = _DEFAULT_SPOOL_MAX_SIZE
+	}
+
+	if dw.retryMaxAttempts <= 0 {
+		dw.retryMaxAttempts = _DEFAULT_RETRY_MAX_ATTEMPTS
+		dw.retryBaseDelay = _DEFAULT_RETRY_BASE_DELAY
+		dw.retryMaxDelay = _DEFAULT_RETRY_MAX_DELAY
+		dw.retryJitter = _DEFAULT_RETRY_JITTER
+	}
+
+	if dw.retryStatusCodes == nil {
+		dw.retryStatusCodes = defaultRetryStatusCodes
+	}
+
+	if dw.transport == nil {
+		dw.transport = NewTransportFastHTTP(nil)
+	}
+
+	if dw.authenticator == nil {
+		dw.authenticator = dw.providerAuthenticator
+	}
+
+	if a, ok := dw.authenticator.(authTransportUser); ok {
+		a.useTransport(dw.transport)
+	}
+
+	if dw.requestTimeout <= 0 {
+		dw.requestTimeout = _DEFAULT_REQUEST_TIMEOUT
+	}
+
+	if dw.acceptedStatusCodes == nil {
+		dw.acceptedStatusCodes = defaultAcceptedStatusCodes
+	}
+
+	if dw.priorityClassifier == nil {
+		dw.priorityClassifier = isHighPriorityEntry
+	}
+
+	dw.packBuilderActive = dw.packBuilder
+	if dw.packBuilderActive == nil {
+		dw.packBuilderActive = dw.providerPackBuilder
+	}
+	if dw.packBuilderActive == nil {
+		dw.packBuilderActive = &_CI_WriterHttpStaticPackBuilder{dw.dataBefore, dw.dataAfter, dw.dataBetween}
+	}
+
+	if dw.breakerWindow <= 0 {
+		dw.breakerFailureRate = _DEFAULT_BREAKER_FAILURE_RATE
+		dw.breakerWindow = _DEFAULT_BREAKER_WINDOW
+		dw.breakerMinRequests = _DEFAULT_BREAKER_MIN_REQUESTS
+		dw.breakerOpenDuration = _DEFAULT_BREAKER_OPEN_DURATION
+	}
+
+	if dw.endpoints != nil {
+		if dw.endpointCooldown <= 0 {
+			dw.endpointCooldown = _DEFAULT_ENDPOINT_COOLDOWN
+		}
+		dw.endpoints.cooldown = dw.endpointCooldown
+	}
+
+	// Loki's protobuf body is already compressed using snappy.
+	if dw.lokiProtobuf {
+		dw.compressionAlgo = CI_WRITER_HTTP_COMPRESSION_NONE
+	}
 }
 
-// disable disables temporary or finally the CI_WriterHttp object.
-// Temporary disabling performs only when some HTTP request has been failed,
-// but final disabling performs only at the stopping the whole app.
+// disable finally disables the CI_WriterHttp object.
+// It performs only at the stopping the whole app (or when Close() is called).
+// Temporary disabling is the circuit breaker's job (see recordResult()).
 //
-// If it's the final disabling, stops all internal timers, tickers, workers, etc.
+// Stops all internal timers, tickers, workers, etc.
 // Flushes all pending log entries, closes connections, prepares self to disable.
 //
 // It guarantees that disable() will be locked until performInitialization()
 // is processed. Thus, there won't be an "initialization <-> dying" data race.
-func (dw *CI_WriterHttp) disable(temporary bool) {
+func (dw *CI_WriterHttp) disable() {
 
 	// WARNING!
 	// Do not change the order of CAS and mutex acquiring.
@@ -276,8 +497,19 @@ func (dw *CI_WriterHttp) disable(temporary bool) {
 	atomic.CompareAndSwapInt32(&dw.casInitStatus,
 		_CAS_STATUS_READY, _CAS_STATUS_TEMPORARY_DISABLED)
 
-	if temporary {
+	switch atomic.LoadInt32(&dw.casInitStatus) {
+
+	case _CAS_STATUS_NOT_INITIALIZED:
+		// There's nothing to stop. Workers aren't spawned yet.
+		atomic.StoreInt32(&dw.casInitStatus, _CAS_STATUS_FINALLY_DISABLED)
+		dw.slowInit.Unlock()
+		return
+
+	case _CAS_STATUS_FINALLY_DISABLED:
+		// Already disabled (or being disabled right now) by someone else.
+		// Just wait until workers are stopped.
 		dw.slowInit.Unlock()
+		dw.workersWg.Wait()
 		return
 	}
 
@@ -294,6 +526,7 @@ func (dw *CI_WriterHttp) disable(temporary bool) {
 	for i := uint16(0); i < dw.workerNum; i++ {
 		dw.workerTickers[i].Stop()
 	}
+	dw.probeTicker.Stop()
 
 	// DO NOT CHANGE THE ORDER!
 	dw.slowInit.Unlock()
@@ -304,6 +537,10 @@ func (dw *CI_WriterHttp) disable(temporary bool) {
 	}
 
 	close(dw.entriesPackDeferred)
+
+	if dw.spool != nil {
+		dw.spool.Close()
+	}
 }
 
 // ping tries to perform a dummy HTTP request to the log service provider
@@ -323,10 +560,48 @@ func (dw *CI_WriterHttp) ping(
 	dw.beenPinged = true
 	dw.initOverwriteZeroValues()
 
-	buf := bytes.NewBuffer(nil)
-	_, _ = buf.WriteString("[]")
+	_, retryable, _, err := dw.doPingRequest(cbs)
+	if err.IsNotNil() && retryable {
+		// Maybe it's a temporary issue or the provider has fixed it
+		// (see 'providerFailureHandler'). One more time.
+		_, _, _, err = dw.doPingRequest(cbs)
+	}
+
+	return err
+}
+
+// doPingRequest performs a dummy HTTP request to the log service provider
+// using doRequest(), applying provider's ping initializer and then 'cbs'.
+// Returns HTTP status code (or 0 if there's no response).
+func (dw *CI_WriterHttp) doPingRequest(
+
+	cbs []func(req *fasthttp.Request), // additional ping HTTP request initializers
+
+) (status int, retryable bool, retryAfter time.Duration, err *ekaerr.Error) {
+
+	buf := bytes.NewBuffer(dw.providerPingBody)
+	if buf.Len() == 0 {
+		_, _ = buf.WriteString("[]")
+	}
+
+	if dw.providerPingInitializer != nil {
+		cbs = append([]func(req *fasthttp.Request){dw.providerPingInitializer}, cbs...)
+	}
+
+	status, result, retryAfter, err := dw.doRequest(buf, cbs, 0)
+	return status, result.Retryable, retryAfter, err
+}
 
-	return dw.sendRequest(buf, cbs)
+// pingGetInitializer returns a ping HTTP request initializer
+// (see 'providerPingInitializer'), that turns it to the HTTP GET request to 'uri'.
+func pingGetInitializer(uri string) func(req *fasthttp.Request) {
+	return func(req *fasthttp.Request) {
+		req.Header.SetMethod(fasthttp.MethodGet)
+		req.Header.Del(fasthttp.HeaderContentEncoding)
+		req.SetRequestURI(uri)
+		req.ResetBody()
+		req.Header.SetContentLength(0)
+	}
 }
 
 // worker is a CI_WriterHttp's worker that runs in the separate goroutine.
@@ -335,115 +610,241 @@ func (dw *CI_WriterHttp) worker(
 
 	masterWorker bool, // indicates whether this worker is master (not slave)
 	encodedEntries <-chan []byte, // channel, log entries being processed are coming from
+	priorityEntries <-chan []byte, // the same, but for high priority ones (may be nil)
 	ticker <-chan time.Time, // ticker "when accumulated log entries must be flushed"
+	flushRequests <-chan *sync.WaitGroup, // Flush() requests, Done() must be called
 ) {
 	defer dw.workersWg.Done()
 
-	// Internal entries buffer. Reusable.
-	// At this moment allocates 1 MB RAM. TODO: Probably add a setter?
-	buf := bytes.NewBuffer(make([]byte, 0, 2<<20))
-	_, _ = buf.Write(dw.dataBefore)
+	// Internal entries pack. Reusable.
+	// Its initial size might be set by SetWorkerBufferInitSize().
+	pack := newPack(int(dw.workerBufferInitSize))
+
+	// The size of HTTP request's body, built from 'pack' (w/o data before
+	// the first and after the last encoded log entries), and a reusable buffer
+	// to calculate it (see SetPackBuilder()).
+	// Used only if the pack max size is set.
+	packBodySize := 0
+	var scratch bytes.Buffer
+
+	// ProcessAndSendBuf is a helper function, that sends 'pack'
+	// to the log service and makes it empty.
+	ProcessAndSendBuf := func() {
+		ticket := dw.inFlight.begin()
+		dw.processEntriesBuffer(pack)
+		dw.inFlight.end(ticket)
+		pack.Reset()
+		packBodySize = 0
+	}
+
+	// i is workerBuffer's index.
+	i := uint16(0)
+
+	// AddEntry is a helper function, that adds received encoded entry
+	// to the internal worker pool. If the pool is full, flushes it
+	// using its HTTP/S API, reusing the pool after flushing by setting its index = 0.
+	//
+	// If the pack max size is set, flushes the pool before adding an entry,
+	// if after adding the pack would be too big.
+	AddEntry := func(encodedEntry []byte) {
+
+		if dw.packMaxSize != 0 {
+			// Provider may count some extra bytes for each encoded log entry.
+			entrySize := dw.packEntrySize(&scratch, int(i), encodedEntry)
+			packSize := packBodySize + entrySize + dw.packEdgesSize(&scratch, int(i)+1) +
+				int(dw.packEntryOverhead)*(int(i)+1)
+
+			if packSize > int(dw.packMaxSize) && i > 0 {
+				ProcessAndSendBuf()
+				i = 0
+				entrySize = dw.packEntrySize(&scratch, 0, encodedEntry)
+				packSize = entrySize + dw.packEdgesSize(&scratch, 1) +
+					int(dw.packEntryOverhead)
+			}
+
+			if packSize > int(dw.packMaxSize) {
+				// Even empty pack can't contain this entry.
+				atomic.AddUint64(&dw.stats.entriesDropped, 1)
+				dw.loseEntries(_LOST_REASON_TOO_LARGE, 1)
+				return
+			}
+
+			packBodySize += entrySize
+		}
+
+		pack.Add(encodedEntry)
+		i++
+
+		if i == dw.workerEntriesBufferLen {
+			ProcessAndSendBuf()
+			i = 0
+		}
+	}
+
+	// DrainEntries is a helper function, that adds all encoded entries
+	// that are already queued (but no more than that) to the internal worker pool
+	// and then flushes it if it contains something.
+	DrainEntries := func() {
+		for _, entries := range [...]<-chan []byte{priorityEntries, encodedEntries} {
+			for n := len(entries); n > 0; n-- {
+				select {
+				case encodedEntry := <-entries:
+					AddEntry(encodedEntry)
+				default:
+					// Another worker took them.
+					n = 1
+				}
+			}
+		}
+		if i > 0 {
+			ProcessAndSendBuf()
+			i = 0
+		}
+	}
 
-	// ProcessAndSendBuf is a helper function, that removes last 'dateBetween'
-	// from 'buf', writes 'dateAfter' instead ans send it to the log service.
-	ProcessAndSendBuf := func(masterWorker bool, dw *CI_WriterHttp, buf *bytes.Buffer) {
-		buf.Truncate(buf.Len() - len(dw.dataBetween))
-		_, _ = buf.Write(dw.dataAfter)
-		dw.processEntriesBuffer(masterWorker, buf)
-		buf.Reset()
-		_, _ = buf.Write(dw.dataBefore)
+	// Only master worker probes the provider (see probe()).
+	var probeTicker <-chan time.Time
+	if masterWorker {
+		probeTicker = dw.probeTicker.C
 	}
 
 	doneChan := dw.ctx.Done()
 
-	// i is workerBuffer's index.
-	for i := uint16(0); ; {
+	// Only master worker replays the spool, that might be left by the previous run,
+	// w/o waiting for the first successfully sent entries pack.
+	if masterWorker && dw.spool != nil {
+		dw.flushDeferredSpool()
+	}
+
+	for {
 		select {
 
 		case <-doneChan:
-			if i > 0 {
-				// There saved unprocessed entries. Send them.
-				ProcessAndSendBuf(masterWorker, dw, buf)
+			// There might be saved unprocessed entries. Send them.
+			// Flush() might be waiting for this worker. Release it.
+			DrainEntries()
+			for {
+				select {
+				case flushRequest := <-flushRequests:
+					flushRequest.Done()
+				default:
+					return
+				}
 			}
-			return
+
+		case flushRequest := <-flushRequests:
+			DrainEntries()
+			flushRequest.Done()
 
 		case encodedEntry := <-encodedEntries:
-			// Add received encoded entry to the internal worker pool.
-			_, _ = buf.Write(encodedEntry) // always returns nil as error
-			_, _ = buf.Write(dw.dataBetween)
-			i++
-
-			// If the pool is full, flush it using its HTTP/S API,
-			// reuse the pool after flushing by setting its index = 0.
-			if i == dw.workerEntriesBufferLen {
-				ProcessAndSendBuf(masterWorker, dw, buf)
-				i = 0
-			}
+			AddEntry(encodedEntry)
+
+		case encodedEntry := <-priorityEntries: // nil if it's not used
+			AddEntry(encodedEntry)
 
 		case <-ticker: // never been closed, even if Stop() is called
 			// Oops, it's time for scheduled flush. It doesn't matter whether
-			// pool is full or not yet. Flush anyway, if pool contains something.
-			if i > 0 {
-				ProcessAndSendBuf(masterWorker, dw, buf)
+			// pool is full or not yet. Flush anyway, if pool contains something,
+			// unless it's throttled and must be coalesced (see SetThrottlePolicy()).
+			if i > 0 && !dw.coalescing(pack.data.Len()) {
+				ProcessAndSendBuf()
 				i = 0
 			}
+
+		case <-probeTicker: // never been closed, even if Stop() is called
+			dw.probe()
 		}
 	}
 }
 
-// processEntriesBuffer tries to perform an HTTP request using 'buf' as HTTP POST
-// request's body, changing the CI_WriterHttp's status:
+// processEntriesBuffer tries to perform an HTTP request using 'pack' as HTTP POST
+// request's body, if the circuit breaker allows it (see SetCircuitBreaker()).
+// Otherwise (or if request has been failed), defers the request
+// to being processed later (when connection will be restored).
 //
-// -> Temporary disabled if request has been failed, deferring failed request
-//    to being processed later (when connection will be restored),
+// If request is succeeded, sends some of deferred entries packs also.
 //
-// -> Ready if CI_WriterHttp has been temporary disabled,
-//    but connection had been successfully restored.
-//
-// While CI_WriterHttp is temporary disabled, tries to send HTTP request
-// only if 'masterWorker' is true.
+// 'pack' may be reused after this method is returned.
 func (dw *CI_WriterHttp) processEntriesBuffer(
 
-	masterWorker bool, // indicates whether this worker is master (not slave)
-	buf *bytes.Buffer, // an HTTP POST request's body
+	pack *_CI_WriterHttpPack, // an HTTP POST request's body
 ) {
-	switch status := atomic.LoadInt32(&dw.casInitStatus); {
+	// The latest pushing attempt in the destructor ignores the circuit breaker.
+	if atomic.LoadInt32(&dw.casInitStatus) != _CAS_STATUS_FINALLY_DISABLED &&
+		!dw.breaker.allow() {
 
-	case status == _CAS_STATUS_TEMPORARY_DISABLED && !masterWorker:
+		// Try to send these entries later, when connection will be recovered.
+		// The circuit breaker is open, we don't want to hammer a provider.
+		dw.deferEntriesPack(pack, true)
+		return
+	}
 
-		// We won't send these entries at this moment, but after returning from
-		// this method, 'buf' will be reused. We have to copy that.
-		bufCopy := bytes.NewBuffer(make([]byte, 0, buf.Len()))
-		_, _ = bufCopy.Write(buf.Bytes())
+	// Throttled encoded log entries might be sampled (see SetThrottlePolicy()).
+	if pack = dw.throttlePack(pack); pack == nil {
+		// Nothing is sent. If it was the probe, the next request will be.
+		dw.breaker.release()
+		return
+	}
 
-		// Try to send made copy later, when connection will be recovered.
-		select {
-		case dw.entriesPackDeferred <- bufCopy:
-		default:
-			// The buffer of encoded deferred log entries is full.
-			// We can't do something with that.
-			atomic.AddUint64(&dw.entriesCompletelyLostCounter, 1)
+	if unsent, err := dw.sendPack(pack); err.IsNotNil() {
+		ekalog.Errore("CI_WriterHttp: Failed to send entries pack.", err)
+		for i := range unsent {
+			dw.deferEntriesPack(unsent[i], unsent[i] == pack)
 		}
+		return
+	}
+
+	// Request finished w/ no error.
+	// Maybe there are entries packs, that have been deferred before?
+	dw.flushDeferred()
+}
+
+// probe is called by the master worker periodically, regardless of incoming
+// encoded log entries. So, a quiet CI_WriterHttp still restores connection
+// and sends its deferred entries packs.
+//
+// If the circuit breaker is closed, sends some of deferred entries packs.
+// If it's open and it's time to probe the provider, the first deferred
+// entries pack is the probe (or the ping request if there are no ones).
+func (dw *CI_WriterHttp) probe() {
 
-		// This is not master worker.
-		// Only master worker can check whether connections is established again.
+	if dw.breaker.getState() == _BREAKER_CLOSED {
+		dw.flushDeferred()
 		return
 	}
 
-	if err := dw.sendRequest(buf, nil); err.IsNotNil() {
-		dw.disable(true)
-		ekalog.Errore("Failed to log to DataDog", err) // TODO
+	if !dw.breaker.allow() {
 		return
 	}
 
-	// Request finished w/ no error.
-	// Maybe CI_WriterHttp was temporary disabled and now it's time to recover?
-	atomic.CompareAndSwapInt32(&dw.casInitStatus,
-		_CAS_STATUS_TEMPORARY_DISABLED, _CAS_STATUS_READY)
+	if dw.flushDeferred() {
+		return
+	}
+
+	// There are no deferred entries packs. Ping the provider.
+	startedAt := time.Now()
+	status, retryable, retryAfter, err := dw.doPingRequest(nil)
+	if err.IsNotNil() && status == 0 && !retryable {
+		// The ping request has not been sent at all.
+		dw.breaker.release()
+	} else {
+		dw.recordResult(err.IsNotNil() && isProviderFailure(status, retryable),
+			retryAfter, startedAt)
+	}
+}
+
+// flushDeferred sends some (or all, if it's the call in the destructor)
+// of deferred entries packs until any of them is failed.
+// Returns true if there was at least one attempt.
+func (dw *CI_WriterHttp) flushDeferred() bool {
+
+	if dw.spool != nil {
+		return dw.flushDeferredSpool()
+	}
 
 	deferredEntriesPackNum := uint16(len(dw.entriesPackDeferred))
 	if deferredEntriesPackNum == 0 {
-		return
+		return false
 	} else if deferredEntriesPackNum > dw.workerFlushDeferredPerIter {
 		// We have to limit how much buffers will be processed again but only
 		// if it's not the call in the destructor (the latest pushing attempt).
@@ -452,48 +853,468 @@ func (dw *CI_WriterHttp) processEntriesBuffer(
 		}
 	}
 
+	attempted := false
+
 	for i := uint16(0); i < deferredEntriesPackNum; i++ {
 		select {
 		case deferredEntriesPack := <-dw.entriesPackDeferred:
-			deferredEntriesPackBak := bytes.NewBuffer(deferredEntriesPack.Bytes())
+			attempted = true
+			if unsent, err := dw.sendPack(deferredEntriesPack); err.IsNotNil() {
 
-			if err := dw.sendRequest(deferredEntriesPack, nil); err.IsNotNil() {
+				ekalog.Errore("CI_WriterHttp: Failed to send entries pack.", err)
 
-				// Oops, failed again.
-				dw.disable(true)
-				ekalog.Errore("Failed to log to DataDog", err) // TODO
+				if len(unsent) == 0 {
+					// Rejected by provider, encoded log entries are lost.
+					// Provider is available, so go on.
+					continue
+				}
 
+				// Oops, failed again.
 				// Try to defer entries pack again.
-				select {
-				case dw.entriesPackDeferred <- deferredEntriesPackBak:
-				default:
-					atomic.AddUint64(&dw.entriesCompletelyLostCounter, 1)
+				// They are not reused, so there's no need to copy them.
+				for i := range unsent {
+					dw.deferEntriesPack(unsent[i], false)
 				}
 
 				// Request failed. Next time will be better (hope).
-				return
+				return true
 			}
 
 		default:
 			// There is less data than we expecting.
 			// Guess another goroutine already did the job. There is nothing left to do.
-			return
+			return attempted
 		}
 	}
+
+	return attempted
 }
 
-// sendRequest sends an HTTP POST request to the remote log provider using fasthttp,
-// applying stored provider callback at the initialization to the fasthttp.Request
-// object and then applying each callback from 'cbs' one by one.
+// flushDeferredSpool is the same as flushDeferred(),
+// but sends deferred entries packs from the disk-backed spool.
+//
+// An entries pack is removed from the spool only if it has been sent successfully,
+// rejected by provider (see sendPackOnce()), or if it's been split
+// and its unsent parts are pushed back to the spool.
+//
+// Only one goroutine drains the spool at the same time, because the spool's head
+// is peeked and then committed after the request. Others return immediately.
+func (dw *CI_WriterHttp) flushDeferredSpool() bool {
+
+	if !atomic.CompareAndSwapInt32(&dw.spoolDraining, 0, 1) {
+		return false
+	}
+	defer atomic.StoreInt32(&dw.spoolDraining, 0)
+
+	// We have to limit how much buffers will be processed again but only
+	// if it's not the call in the destructor (the latest pushing attempt).
+	n := int(dw.workerFlushDeferredPerIter)
+	if atomic.LoadInt32(&dw.casInitStatus) == _CAS_STATUS_FINALLY_DISABLED {
+		n = dw.spool.Len()
+	}
+
+	attempted := false
+
+	for i := 0; i < n; i++ {
+
+		record, entries, ok := dw.spool.Peek()
+		if !ok {
+			return attempted
+		}
+
+		deferredEntriesPack := unmarshalPack(record)
+		if deferredEntriesPack == nil {
+			// Malformed record. Can't do something with that.
+			dw.loseEntries(_LOST_REASON_SPOOL_CORRUPTED, entries)
+			dw.spool.Commit()
+			continue
+		}
+
+		attempted = true
+
+		unsent, err := dw.sendPack(deferredEntriesPack)
+		if err.IsNil() {
+			dw.spool.Commit()
+			continue
+		}
+
+		ekalog.Errore("CI_WriterHttp: Failed to send entries pack.", err)
+
+		if len(unsent) == 0 {
+			// Rejected by provider, encoded log entries are lost.
+			// Provider is available, so go on.
+			dw.spool.Commit()
+			continue
+		}
+
+		// Oops, failed again.
+		// If the entries pack has been split, some parts of it are sent.
+		// Replace the entries pack by its unsent parts.
+		// Otherwise it's still in the spool. It's not committed.
+		if len(unsent) != 1 || unsent[0] != deferredEntriesPack {
+			dw.spool.Commit()
+			for i := range unsent {
+				dw.deferEntriesPack(unsent[i], false)
+			}
+		}
+
+		return true
+	}
+
+	return attempted
+}
+
+// deferEntriesPack saves 'pack' to be sent later, when connection will be restored.
+// Uses disk-backed spool if it's enabled, or the RAM buffer otherwise.
+//
+// If 'reused' is true, 'pack' may be reused after this method is returned,
+// and it will be copied if it's required.
+func (dw *CI_WriterHttp) deferEntriesPack(pack *_CI_WriterHttpPack, reused bool) {
+
+	if dw.spool != nil {
+		if err := dw.spool.Push(pack.Marshal(), len(pack.entries)); err != nil {
+			// The spool is full or there's an I/O error.
+			// We can't do something with that.
+			dw.loseEntries(_LOST_REASON_DEFERRED_OVERFLOW, uint64(len(pack.entries)))
+		}
+		return
+	}
+
+	// After returning from this method, 'pack' will be reused. We have to copy that.
+	if reused {
+		pack = pack.Copy()
+	}
+
+	select {
+	case dw.entriesPackDeferred <- pack:
+	default:
+		// The buffer of encoded deferred log entries is full.
+		// We can't do something with that.
+		dw.loseEntries(_LOST_REASON_DEFERRED_OVERFLOW, uint64(len(pack.entries)))
+	}
+}
+
+// sendPack sends 'pack' using sendPackOnce(). If the log service provider
+// rejects some of encoded log entries, that may be retried (see
+// SetResponseHandler()), they are sent again according with retry policy
+// (see SetRetryPolicy()). If they're still rejected, they're returned as unsent
+// along with an error.
+//
+// If provider can't accept some of encoded log entries in the same request
+// (see 'providerPackSplitter'), 'pack' is split and its parts are sent one by one.
 //
-// If HTTP request was failed (returned non 200, 202 HTTP codes),
-// an error object will be returned.
+// If an error is occurred, returns it and those entries packs,
+// that have not been sent ('pack' itself if it hasn't been split).
+func (dw *CI_WriterHttp) sendPack(
+
+	pack *_CI_WriterHttpPack,
+
+) (unsent []*_CI_WriterHttpPack, err *ekaerr.Error) {
+
+	if dw.providerPackSplitter != nil {
+		if groups := dw.providerPackSplitter(pack); len(groups) > 1 {
+			for i, group := range groups {
+				if unsent, err = dw.sendPack(dw.pickPack(pack, group)); err.IsNotNil() {
+					for _, restGroup := range groups[i+1:] {
+						unsent = append(unsent, dw.pickPack(pack, restGroup))
+					}
+					return unsent, err
+				}
+			}
+			return nil, nil
+		}
+	}
+
+	for attempt := uint8(1); ; attempt++ {
+
+		rejected, unsent, err := dw.sendPackOnce(pack)
+		if err.IsNotNil() || rejected == nil {
+			return unsent, err
+		}
+
+		if attempt >= dw.retryMaxAttempts || !dw.sleep(dw.retryDelay(uint32(attempt))) {
+			return []*_CI_WriterHttpPack{rejected}, ekaerr.ExternalError.
+				New("CI_WriterHttp: Provider rejects log entries.").
+				WithInt("ci_writer_http_entries_rejected", len(rejected.entries)).
+				WithUint8("ci_writer_http_attempts", attempt).
+				Throw()
+		}
+
+		pack = rejected
+	}
+}
+
+// sendPackOnce sends 'pack' using sendRequest(). If the log service provider
+// answers that the entries pack is too large (HTTP 413), splits it into halves
+// and sends them recursively, until they are accepted, or a single encoded
+// log entry is proven too large. Such entry is dropped and counted as lost.
+//
+// If request is succeeded, but provider rejects some of encoded log entries
+// (see SetResponseHandler()), those which may be retried are returned
+// as 'rejected' entries pack. Others are counted as lost.
+//
+// If provider rejects the entries pack, and the request can't be retried
+// (like HTTP 400, 403, 422), the entries pack will never be accepted.
+// Its encoded log entries are counted as lost, and only an error is returned.
+//
+// If an error is occurred, returns it and those entries packs,
+// that have not been sent ('pack' itself if it hasn't been split).
+func (dw *CI_WriterHttp) sendPackOnce(
+
+	pack *_CI_WriterHttpPack,
+
+) (rejected *_CI_WriterHttpPack, unsent []*_CI_WriterHttpPack, err *ekaerr.Error) {
+
+	body := dw.buildPackBody(pack)
+	status, result, err := dw.sendRequest(body, nil, len(pack.entries))
+	releasePackBody(body)
+
+	if err.IsNil() {
+		if result.Rejected > 0 {
+			dw.loseEntries(_LOST_REASON_REJECTED, uint64(result.Rejected))
+		}
+		if result.Reason != "" {
+			dw.stats.saveLastError(result.Reason)
+		}
+	}
+
+	switch {
+
+	case err.IsNil() && len(result.Retry) == 0:
+		return nil, nil, nil
+
+	case err.IsNil():
+		return dw.pickPack(pack, result.Retry), nil, nil
+
+	case status != fasthttp.StatusRequestEntityTooLarge && !result.Retryable:
+		dw.loseEntries(_LOST_REASON_REJECTED, uint64(len(pack.entries)))
+		return nil, nil, err
+
+	case status != fasthttp.StatusRequestEntityTooLarge:
+		return nil, []*_CI_WriterHttpPack{pack}, err
+
+	case len(pack.entries) <= 1:
+		// Well, this encoded log entry is too large. Nothing to do.
+		dw.loseEntries(_LOST_REASON_TOO_LARGE, uint64(len(pack.entries)))
+		ekalog.Warne("CI_WriterHttp: Encoded log entry is too large. Dropped.", err)
+		return nil, nil, nil
+	}
+
+	left, right := dw.splitPack(pack)
+
+	if unsent, err = dw.sendPack(left); err.IsNotNil() {
+		return nil, append(unsent, right), err
+	}
+
+	unsent, err = dw.sendPack(right)
+	return nil, unsent, err
+}
+
+// sendRequest calls doRequest() and retries it according with retry policy
+// (see SetRetryPolicy()) if it's failed, but only if it's allowed to be retried.
+//
+// If request is succeeded, the classification of its response is returned
+// (see SetResponseHandler()).
+//
+// The result is saved to the circuit breaker (see recordResult()).
+// If all attempts are failed, the minimum delay before next connection
+// restoring attempt is calculated, and the last error is returned
+// along with HTTP status code (or 0 if there's no response).
+// Only network errors, HTTP 408, 429 and 5xx are considered as connection
+// problem (see isProviderFailure()).
+//
+// 'buf' is not consumed. It contains the same data after this method is returned.
 func (dw *CI_WriterHttp) sendRequest(
 
 	buf *bytes.Buffer, // The bytes that will be attached to HTTP request as POST body
 	cbs []func(req *fasthttp.Request), // Callbacks will be called before sending request
+	entriesNum int, // The number of encoded log entries in 'buf', 0 for ping request
 
-) *ekaerr.Error {
+) (int, CI_WriterHttp_ResponseResult, *ekaerr.Error) {
+
+	var (
+		attempt    uint8
+		status     int
+		result     CI_WriterHttp_ResponseResult
+		retryAfter time.Duration
+		err        *ekaerr.Error
+	)
+
+	atomic.AddInt64(&dw.stats.packsInFlight, 1)
+	defer atomic.AddInt64(&dw.stats.packsInFlight, -1)
+
+	startedAt := time.Now()
+
+	for attempt = 1; ; attempt++ {
+
+		status, result, retryAfter, err = dw.doRequest(buf, cbs, entriesNum)
+		if err.IsNil() {
+			atomic.StoreUint32(&dw.failedInRowCounter, 0)
+			atomic.AddUint64(&dw.stats.packsSent, 1)
+			dw.recordResult(false, 0, startedAt)
+			return status, result, nil
+		}
+
+		if !result.Retryable || attempt >= dw.retryMaxAttempts {
+			break
+		}
+
+		delay := dw.retryDelay(uint32(attempt))
+		if retryAfter > 0 {
+			if retryAfter > dw.retryMaxDelay {
+				// Provider asks us to wait too long. Do not wait it in place.
+				break
+			}
+			delay = retryAfter
+		}
+
+		if !dw.sleep(delay) {
+			// CI_WriterHttp is finally disabling. No time to wait.
+			break
+		}
+	}
+
+	if status != fasthttp.StatusRequestEntityTooLarge {
+		// HTTP 413 is not a failure, the entries pack will be split.
+		atomic.AddUint64(&dw.stats.packsFailed, 1)
+	}
+
+	switch {
+
+	case status == 0 && !result.Retryable:
+		// The request has not been sent at all (like its body can't be prepared).
+		dw.breaker.release()
+
+	case !isProviderFailure(status, result.Retryable):
+		// Provider is available, just the entries pack is not accepted.
+		dw.recordResult(false, 0, startedAt)
+
+	default:
+		// All attempts are failed. Calculate when connection restoring may be tried,
+		// if the circuit breaker is open.
+		delay := dw.retryDelay(atomic.AddUint32(&dw.failedInRowCounter, 1))
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		dw.recordResult(true, delay, startedAt)
+	}
+
+	return status, result, err.
+		WithUint8("ci_writer_http_attempts", attempt).
+		Throw()
+}
+
+// recordResult saves the result of the request (including all its retries)
+// to the circuit breaker, changing the CI_WriterHttp's status:
+//
+// -> Temporary disabled if the circuit breaker is open,
+//
+// -> Ready if CI_WriterHttp has been temporary disabled,
+//    but connection had been successfully restored.
+//
+// 'minOpenDuration' is the minimum time the circuit breaker is open for,
+// if it's opened. 'startedAt' is when the request has been started.
+func (dw *CI_WriterHttp) recordResult(
+
+	failed bool,
+	minOpenDuration time.Duration,
+	startedAt time.Time,
+
+) {
+	oldState, newState := dw.breaker.record(failed, minOpenDuration, startedAt)
+
+	switch {
+	case oldState != _BREAKER_OPEN && newState == _BREAKER_OPEN:
+		atomic.CompareAndSwapInt32(&dw.casInitStatus,
+			_CAS_STATUS_READY, _CAS_STATUS_TEMPORARY_DISABLED)
+
+	case oldState != _BREAKER_CLOSED && newState == _BREAKER_CLOSED:
+		atomic.CompareAndSwapInt32(&dw.casInitStatus,
+			_CAS_STATUS_TEMPORARY_DISABLED, _CAS_STATUS_READY)
+	}
+}
+
+// isProviderFailure reports whether the failed request, that has been answered
+// by HTTP status code 'status' (or 0 if there's no response), says
+// the provider is not available: network errors (and failed authentication,
+// if 'retryable'), HTTP 408, 429 and 5xx. Only such failures are saved
+// to the circuit breaker as failed.
+func isProviderFailure(status int, retryable bool) bool {
+	switch {
+	case status == 0:
+		return retryable
+	case status == fasthttp.StatusRequestTimeout,
+		status == fasthttp.StatusTooManyRequests,
+		status >= fasthttp.StatusInternalServerError:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns a delay before 'n'-th retry (n >= 1), according with
+// exponential backoff with jitter, configured by SetRetryPolicy().
+func (dw *CI_WriterHttp) retryDelay(n uint32) time.Duration {
+
+	delay := dw.retryMaxDelay
+	if n < 32 {
+		if d := dw.retryBaseDelay << (n - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	if dw.retryJitter > 0 {
+		delay -= time.Duration(rand.Float64() * dw.retryJitter * float64(delay))
+	}
+
+	return delay
+}
+
+// sleep blocks the current goroutine for 'd' or until CI_WriterHttp
+// is finally disabled. Returns false in the 2nd case.
+func (dw *CI_WriterHttp) sleep(d time.Duration) bool {
+
+	var doneChan <-chan struct{}
+	if dw.ctx != nil {
+		doneChan = dw.ctx.Done()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-doneChan:
+		return false
+	}
+}
+
+// doRequest sends an HTTP POST request to the remote log provider
+// using CI_WriterHttp_Transport (see SetTransport()),
+// applying stored provider callback at the initialization to the fasthttp.Request
+// object, then applying each callback from 'cbs' one by one,
+// and then authenticating it (see SetAuthenticator()).
+// The response is classified by classifyResponse().
+//
+// Entries pack's request (not a ping one) waits for the rate limit
+// (see SetRateLimit()) right before sending, taking into account
+// the size of the body that is sent in fact (prepared, compressed).
+//
+// If HTTP request was failed (network error or the response isn't accepted),
+// an error object will be returned. In that case 'status' is HTTP status code
+// (or 0 if there's no response), 'result.Retryable' reports whether
+// the request may be retried and 'retryAfter' contains a delay
+// that provider asks to wait before next attempt (or 0 if it's not so).
+//
+// 'buf' is not consumed. It contains the same data after this method is returned.
+func (dw *CI_WriterHttp) doRequest(
+
+	buf *bytes.Buffer, // The bytes that will be attached to HTTP request as POST body
+	cbs []func(req *fasthttp.Request), // Callbacks will be called before sending request
+	entriesNum int, // The number of encoded log entries in 'buf', 0 for ping request
+
+) (status int, result CI_WriterHttp_ResponseResult, retryAfter time.Duration, err *ekaerr.Error) {
 
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
@@ -502,36 +1323,177 @@ func (dw *CI_WriterHttp) sendRequest(
 
 	req.Header.SetMethod(fasthttp.MethodPost)
 
+	body := io.Reader(bytes.NewReader(buf.Bytes()))
 	if dw.providerBodyPreparer != nil {
-		req.SetBodyStream(dw.providerBodyPreparer(buf), -1)
+		body = dw.providerBodyPreparer(body)
+		if bodyErr, ok := body.(*_CI_WriterHttpBodyError); ok {
+			dw.stats.saveLastError("Failed to prepare HTTP request's body: " + bodyErr.err.Error())
+			return 0, result, 0, ekaerr.IllegalFormat.
+				Wrap(bodyErr.err, "CI_WriterHttp: Failed to prepare HTTP request's body.").
+				Throw()
+		}
+	}
+
+	// The size of the body, that is sent in fact.
+	var bodySize int
+
+	if dw.compression != nil {
+		compressedBody, err := dw.compression.Compress(body)
+		if err.IsNotNil() {
+			return 0, result, 0, err
+		}
+		defer dw.compression.Release(compressedBody)
+
+		bodySize = compressedBody.Len()
+		req.SetBodyStream(compressedBody, bodySize)
+
+	} else if _, legacyErr := io.Copy(req.BodyWriter(), body); legacyErr != nil {
+		dw.stats.saveLastError("Failed to prepare HTTP request's body: " + legacyErr.Error())
+		return 0, result, 0, ekaerr.IllegalFormat.
+			Wrap(legacyErr, "CI_WriterHttp: Failed to prepare HTTP request's body.").
+			Throw()
+
 	} else {
-		req.SetBodyStream(buf, -1)
+		bodySize = len(req.Body())
 	}
 
 	dw.providerInitializer(req)
 
+	if dw.compression != nil {
+		req.Header.Set(fasthttp.HeaderContentEncoding, dw.compression.contentEncoding)
+	}
+
 	for i, n := 0, len(cbs); i < n; i++ {
 		if cbs[i] != nil {
 			cbs[i](req)
 		}
 	}
 
-	if legacyErr := dw.c.DoRedirects(req, resp, 5); legacyErr != nil {
-		return ekaerr.ExternalError.
+	if entriesNum > 0 {
+		// Wait before the endpoint is chosen and the request is authenticated,
+		// so neither the endpoint's health nor the signature is outdated.
+		dw.throttle(bodySize)
+	}
+
+	// The endpoint must be chosen before the request is authenticated,
+	// because some authenticators sign the host (like AWS SigV4).
+	var endpoint *_CI_WriterHttpEndpoint
+	if dw.endpoints != nil {
+		endpoint = dw.endpoints.pick()
+		endpoint.apply(req)
+	}
+
+	if dw.authenticator != nil {
+		if err := dw.authenticator.Authenticate(req); err.IsNotNil() {
+			dw.stats.saveLastError("Failed to authenticate HTTP request")
+			result.Retryable = true
+			return 0, result, 0, err.
+				WithString("ci_writer_http_url", string(req.URI().FullURI())).
+				Throw()
+		}
+	}
+
+	if !req.IsBodyStream() {
+		// The body has been read (like by authenticator) or reset
+		// (like for HTTP GET ping request).
+		bodySize = len(req.Body())
+	}
+
+	startedAt := time.Now()
+	legacyErr := dw.transport.Do(req, resp, dw.requestTimeout)
+	if legacyErr == nil {
+		status = resp.StatusCode()
+		result = dw.classifyResponse(resp, entriesNum)
+		dw.adaptRateLimit(resp)
+	}
+	dw.stats.saveResponse(status, time.Since(startedAt))
+
+	if endpoint != nil {
+		dw.endpoints.report(endpoint, result.Accepted, status)
+	}
+
+	if legacyErr != nil {
+		dw.stats.saveLastError("Failed to perform HTTP request: " + legacyErr.Error())
+		result.Retryable = true
+		return 0, result, 0, ekaerr.ExternalError.
 			Wrap(legacyErr, "CI_WriterHttp: Failed to perform HTTP request.").
-			WithString("ci_writer_http_url", ekastr.B2S(req.RequestURI())).
+			WithString("ci_writer_http_url", string(req.URI().FullURI())).
 			Throw()
 	}
 
-	switch status := resp.StatusCode(); status {
-	case fasthttp.StatusOK, fasthttp.StatusAccepted:
-	default:
-		return ekaerr.ExternalError.
-			New("CI_WriterHttp: Unexpected HTTP status code.").
+	if !result.Accepted {
+		if result.Reason != "" {
+			dw.stats.saveLastError(result.Reason)
+		} else {
+			dw.stats.saveLastError("Unexpected HTTP status code: " + strconv.Itoa(status))
+		}
+		if (status == fasthttp.StatusUnauthorized || status == fasthttp.StatusForbidden) &&
+			dw.authenticator != nil {
+			// Maybe credentials are expired (like OAuth2 access token
+			// or AWS temporary credentials).
+			result.Retryable = dw.authenticator.Invalidate()
+		}
+		if dw.providerFailureHandler != nil && dw.providerFailureHandler(status, resp.Body()) {
+			result.Retryable = true
+		}
+		if !dw.retryIgnoreRetryAfter {
+			retryAfter = parseRetryAfter(resp.Header.Peek(fasthttp.HeaderRetryAfter))
+		}
+		return status, result, retryAfter, ekaerr.ExternalError.
+			New("CI_WriterHttp: Unexpected HTTP response.").
 			WithInt("ci_writer_http_status_code", status).
-			WithString("ci_writer_http_url", ekastr.B2S(req.RequestURI())).
+			WithString("ci_writer_http_url", string(req.URI().FullURI())).
 			Throw()
 	}
 
-	return nil
+	atomic.AddUint64(&dw.stats.bytesSent, uint64(bodySize))
+	return status, result, 0, nil
+}
+
+// Read implements io.Reader, returning the error of body preparing.
+func (e *_CI_WriterHttpBodyError) Read(_ []byte) (int, error) {
+	return 0, e.err
+}
+
+// encodedEntryTimeOf returns the time of encoded log entry 'encodedEntry',
+// that is a JSON object, or the current time if it can't be extracted.
+func encodedEntryTimeOf(encodedEntry []byte) time.Time {
+
+	var entryTime _CI_WriterHttpEntryTime
+	if err := json.Unmarshal(encodedEntry, &entryTime); err == nil {
+		for _, v := range []string{entryTime.AtTimestamp, entryTime.Timestamp, entryTime.Time} {
+			if v == "" {
+				continue
+			}
+			for _, layout := range encodedEntryTimeLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t
+				}
+			}
+		}
+	}
+
+	return time.Now()
+}
+
+// parseRetryAfter parses a value of "Retry-After" HTTP header,
+// that might be either a number of seconds or an HTTP date.
+// Returns 0 if it's empty or has an invalid format.
+func parseRetryAfter(v []byte) time.Duration {
+
+	if len(v) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseUint(ekastr.B2S(v), 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := fasthttp.ParseHTTPDate(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
 }
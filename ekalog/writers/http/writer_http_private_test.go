@@ -0,0 +1,70 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRetryDelay(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		n        uint32
+		jitter   float64
+		min, max time.Duration
+	}{
+		{"first", 1, 0, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"second", 2, 0, 200 * time.Millisecond, 200 * time.Millisecond},
+		{"fourth", 4, 0, 800 * time.Millisecond, 800 * time.Millisecond},
+		{"capped", 5, 0, time.Second, time.Second},
+		{"overflowed shift", 40, 0, time.Second, time.Second},
+		{"jitter", 2, 0.5, 100 * time.Millisecond, 200 * time.Millisecond},
+		{"capped jitter", 10, 0.2, 800 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := new(CI_WriterHttp).
+				SetRetryPolicy(3, 100*time.Millisecond, time.Second, tt.jitter)
+
+			for i := 0; i < 100; i++ {
+				if d := dw.retryDelay(tt.n); d < tt.min || d > tt.max {
+					t.Fatalf("retryDelay(%d) = %s, want [%s..%s]", tt.n, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "120", 2 * time.Minute, 2 * time.Minute},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-5", 0, 0},
+		{"invalid", "soon", 0, 0},
+		{"http date", string(fasthttp.AppendHTTPDate(nil, time.Now().Add(time.Hour))),
+			59 * time.Minute, time.Hour},
+		{"past http date", string(fasthttp.AppendHTTPDate(nil, time.Now().Add(-time.Hour))), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := parseRetryAfter([]byte(tt.value)); d < tt.min || d > tt.max {
+				t.Fatalf("parseRetryAfter(%q) = %s, want [%s..%s]", tt.value, d, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekastr"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_ThrottlePolicy is what CI_WriterHttp does with entries pack,
+	// when it can't be sent right now because of rate limit.
+	// See SetThrottlePolicy() method and CI_WRITER_HTTP_THROTTLE_<...> constants.
+	CI_WriterHttp_ThrottlePolicy uint8
+
+	// _CI_WriterHttpRateLimiter is a pair of token buckets (requests and bytes
+	// per second), shared across workers. The rate of requests may be lowered
+	// temporary by provider (X-RateLimit-* HTTP headers) and requests
+	// may be paused (Retry-After HTTP header).
+	//
+	// Tokens are reserved in advance: the bucket may go into debt,
+	// and the caller waits until the debt is paid. So the entries pack,
+	// that is bigger than the bucket's capacity, is sent anyway.
+	//
+	// Thread-safe.
+	_CI_WriterHttpRateLimiter struct {
+		mu sync.Mutex
+
+		requests _CI_WriterHttpTokenBucket
+		bytes    _CI_WriterHttpTokenBucket
+
+		// Configured rates (see SetRateLimit()). 0 means unlimited.
+		requestsRate float64
+		bytesRate    float64
+
+		// The rate of requests, provider asks us to keep until 'adaptedUntil'.
+		adaptedRate  float64
+		adaptedUntil time.Time
+
+		// No requests may be sent before that.
+		pausedUntil time.Time
+	}
+
+	// _CI_WriterHttpTokenBucket is a token bucket, which capacity
+	// is 1 second of its rate (but at least 1 token).
+	_CI_WriterHttpTokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// The worker waits until entries pack may be sent.
+	// If it takes too long, the internal buffer of encoded log entries
+	// becomes full, and the overflow policy is applied (see SetOverflowPolicy()).
+	CI_WRITER_HTTP_THROTTLE_WAIT CI_WriterHttp_ThrottlePolicy = iota
+
+	// Encoded log entries of entries pack are sampled: only the part of them
+	// (see SetThrottlePolicy()) is sent, except high priority ones
+	// (see SetPriorityClassifier()), that are sent always.
+	// Then the worker waits like CI_WRITER_HTTP_THROTTLE_WAIT does.
+	CI_WRITER_HTTP_THROTTLE_SAMPLE
+
+	// Entries pack isn't sent by the flush timer (see SetWorkerAutoFlushDelay()),
+	// the worker keeps accumulating encoded log entries to it, until it's full.
+	// So, more encoded log entries are sent by less requests.
+	// Then the worker waits like CI_WRITER_HTTP_THROTTLE_WAIT does.
+	CI_WRITER_HTTP_THROTTLE_COALESCE
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Provider's X-RateLimit-Reset HTTP header greater than that
+	// is the Unix time (in seconds) rather than the number of seconds.
+	_RATE_LIMIT_RESET_UNIX_THRESHOLD = 1000000000
+)
+
+var (
+	// Prefixes of HTTP headers of provider's rate limit:
+	// <prefix>Remaining (requests), <prefix>Reset (seconds or Unix time).
+	rateLimitHeaderPrefixes = [...]string{"X-RateLimit-", "RateLimit-"}
+)
+
+// take reserves 'n' tokens at 'now', refilling the bucket with 'rate' per second,
+// and returns how long the caller must wait until they're available.
+// 'rate' must be > 0.
+func (b *_CI_WriterHttpTokenBucket) take(now time.Time, n, rate float64) time.Duration {
+
+	b.refill(now, rate)
+	b.tokens -= n
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// wait returns how long the caller must wait until 'n' tokens are available,
+// w/o reserving them. 'rate' must be > 0.
+//
+// If 'n' is greater than the bucket's capacity, the caller waits
+// until the bucket is full, because take() lets it go into debt then.
+func (b *_CI_WriterHttpTokenBucket) wait(now time.Time, n, rate float64) time.Duration {
+
+	b.refill(now, rate)
+
+	if capacity := tokenBucketCapacity(rate); n > capacity {
+		n = capacity
+	}
+
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+// refill adds tokens, that are accumulated since the last refill.
+// The bucket is full initially.
+func (b *_CI_WriterHttpTokenBucket) refill(now time.Time, rate float64) {
+
+	capacity := tokenBucketCapacity(rate)
+
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+	}
+
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// tokenBucketCapacity returns the capacity of the token bucket with 'rate'
+// per second: 1 second of its rate, but at least 1 token.
+func tokenBucketCapacity(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// newRateLimiter returns a new _CI_WriterHttpRateLimiter with the rates
+// of requests and bytes per second (0 means unlimited).
+func newRateLimiter(requestsRate, bytesRate float64) *_CI_WriterHttpRateLimiter {
+	return &_CI_WriterHttpRateLimiter{
+		requestsRate: requestsRate,
+		bytesRate:    bytesRate,
+	}
+}
+
+// reserve reserves the one request of 'size' bytes at 'now' and returns
+// how long the caller must wait before it may be sent.
+func (l *_CI_WriterHttpRateLimiter) reserve(now time.Time, size int) time.Duration {
+	return l.delay(now, size, true)
+}
+
+// throttled reports whether the one request of 'size' bytes
+// can't be sent at 'now' w/o waiting. Nothing is reserved.
+func (l *_CI_WriterHttpRateLimiter) throttled(now time.Time, size int) bool {
+	return l.delay(now, size, false) > 0
+}
+
+// delay is reserve() if 'take' is true, or throttled() otherwise.
+func (l *_CI_WriterHttpRateLimiter) delay(now time.Time, size int, take bool) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var d time.Duration
+
+	if requestsRate := l.effectiveRequestsRate(now); requestsRate > 0 {
+		if take {
+			d = l.requests.take(now, 1, requestsRate)
+		} else {
+			d = l.requests.wait(now, 1, requestsRate)
+		}
+	}
+
+	if l.bytesRate > 0 {
+		var bd time.Duration
+		if take {
+			bd = l.bytes.take(now, float64(size), l.bytesRate)
+		} else {
+			bd = l.bytes.wait(now, float64(size), l.bytesRate)
+		}
+		if bd > d {
+			d = bd
+		}
+	}
+
+	if pd := l.pausedUntil.Sub(now); pd > d {
+		d = pd
+	}
+
+	return d
+}
+
+// effectiveRequestsRate returns the rate of requests at 'now':
+// the configured one or the one provider asks us to keep, whichever is less.
+// 0 means unlimited.
+func (l *_CI_WriterHttpRateLimiter) effectiveRequestsRate(now time.Time) float64 {
+
+	if l.adaptedRate <= 0 || !now.Before(l.adaptedUntil) {
+		return l.requestsRate
+	}
+
+	if l.requestsRate > 0 && l.requestsRate < l.adaptedRate {
+		return l.requestsRate
+	}
+
+	return l.adaptedRate
+}
+
+// adapt saves the provider's rate limit: 'remaining' requests may be sent
+// until 'resetAt'. If there's no one, requests are paused until 'resetAt'.
+func (l *_CI_WriterHttpRateLimiter) adapt(now time.Time, remaining uint64, resetAt time.Time) {
+
+	if !resetAt.After(now) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if remaining == 0 {
+		if resetAt.After(l.pausedUntil) {
+			l.pausedUntil = resetAt
+		}
+		return
+	}
+
+	l.adaptedRate = float64(remaining) / resetAt.Sub(now).Seconds()
+	l.adaptedUntil = resetAt
+}
+
+// pause pauses requests until 'until'.
+func (l *_CI_WriterHttpRateLimiter) pause(until time.Time) {
+	l.mu.Lock()
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.mu.Unlock()
+}
+
+// throttle blocks the current goroutine until the request of 'size' bytes
+// may be sent according with the rate limit (see SetRateLimit()),
+// or until CI_WriterHttp is finally disabled (the request is sent then anyway).
+func (dw *CI_WriterHttp) throttle(size int) {
+	if d := dw.limiter.reserve(time.Now(), size); d > 0 {
+		atomic.AddUint64(&dw.stats.requestsThrottled, 1)
+		dw.sleep(d)
+	}
+}
+
+// throttlePack applies the throttle policy (see SetThrottlePolicy()) to 'pack',
+// that is about to be sent, and returns the entries pack, that must be sent
+// instead (it's 'pack' itself, if it's not throttled), or nil if there's nothing to send.
+func (dw *CI_WriterHttp) throttlePack(pack *_CI_WriterHttpPack) *_CI_WriterHttpPack {
+
+	if dw.throttlePolicy != CI_WRITER_HTTP_THROTTLE_SAMPLE ||
+		!dw.limiter.throttled(time.Now(), pack.data.Len()) {
+		return pack
+	}
+
+	var (
+		data    = pack.data.Bytes()
+		offset  = 0
+		indexes = make([]int, 0, len(pack.entries))
+	)
+
+	for i, n := range pack.entries {
+		encodedEntry := data[offset : offset+int(n)]
+		if rand.Float64() < dw.throttleSampleRate || dw.priorityClassifier(encodedEntry) {
+			indexes = append(indexes, i)
+		}
+		offset += int(n)
+	}
+
+	if dropped := len(pack.entries) - len(indexes); dropped > 0 {
+		dw.loseEntries(_LOST_REASON_THROTTLED, uint64(dropped))
+	}
+
+	switch {
+	case len(indexes) == 0:
+		return nil
+	case len(indexes) == len(pack.entries):
+		return pack
+	default:
+		return dw.pickPack(pack, indexes)
+	}
+}
+
+// coalescing reports whether the worker must not flush its entries pack
+// of 'size' bytes by the flush timer, but keep accumulating encoded log entries
+// to it (see CI_WRITER_HTTP_THROTTLE_COALESCE).
+func (dw *CI_WriterHttp) coalescing(size int) bool {
+	return dw.throttlePolicy == CI_WRITER_HTTP_THROTTLE_COALESCE &&
+		dw.limiter.throttled(time.Now(), size)
+}
+
+// adaptRateLimit adapts the rate limiter to the provider's rate limit,
+// that is reported by HTTP response 'resp' (X-RateLimit-* HTTP headers),
+// and pauses requests if provider asks to wait (Retry-After HTTP header).
+func (dw *CI_WriterHttp) adaptRateLimit(resp *fasthttp.Response) {
+
+	// Ping before initialization. There's no rate limiter yet.
+	if dw.rateLimitIgnoreHeaders || dw.limiter == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, prefix := range rateLimitHeaderPrefixes {
+		remaining, err := strconv.ParseUint(
+			ekastr.B2S(resp.Header.Peek(prefix+"Remaining")), 10, 64)
+		if err != nil {
+			continue
+		}
+		reset, err := strconv.ParseUint(
+			ekastr.B2S(resp.Header.Peek(prefix+"Reset")), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		resetAt := now.Add(time.Duration(reset) * time.Second)
+		if reset > _RATE_LIMIT_RESET_UNIX_THRESHOLD {
+			resetAt = time.Unix(int64(reset), 0)
+		}
+
+		dw.limiter.adapt(now, remaining, resetAt)
+		break
+	}
+
+	status := resp.StatusCode()
+	if (status == fasthttp.StatusTooManyRequests || status == fasthttp.StatusServiceUnavailable) &&
+		!dw.retryIgnoreRetryAfter {
+
+		if d := parseRetryAfter(resp.Header.Peek(fasthttp.HeaderRetryAfter)); d > 0 {
+			dw.limiter.pause(now.Add(d))
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRateLimiter_PackLargerThanByteRate(t *testing.T) {
+
+	const (
+		bytesRate = 100
+		packSize  = 1000
+	)
+
+	var (
+		l   = newRateLimiter(0, bytesRate)
+		now = time.Now()
+	)
+
+	// The bucket is full initially, the pack may be sent w/o waiting.
+	if l.throttled(now, packSize) {
+		t.Fatal("the first pack is throttled")
+	}
+
+	// The bucket goes into debt (packSize - bytesRate), that must be paid
+	// before the pack is sent. Then the bucket must be full again.
+	if d := l.reserve(now, packSize); d != 9*time.Second {
+		t.Fatalf("the first pack must wait for its debt, got delay %v, want 9s", d)
+	}
+
+	tests := []struct {
+		name      string
+		after     time.Duration
+		throttled bool
+	}{
+		{"right after", 0, true},
+		{"debt is paid", 9 * time.Second, true},
+		{"almost full", 9*time.Second + 900*time.Millisecond, true},
+		{"full", 10 * time.Second, false},
+		{"later", time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.throttled(now.Add(tt.after), packSize); got != tt.throttled {
+				t.Fatalf("throttled() = %v, want %v", got, tt.throttled)
+			}
+		})
+	}
+
+	if d := l.reserve(now.Add(10*time.Second), packSize); d != 9*time.Second {
+		t.Fatalf("the second pack must wait for its debt, got delay %v, want 9s", d)
+	}
+}
+
+func TestRateLimiter_Throttled(t *testing.T) {
+
+	tests := []struct {
+		name                    string
+		requestsRate, bytesRate float64
+		sizes                   []int // reserved one by one at the same moment
+		size                    int
+		throttled               bool
+	}{
+		{"unlimited", 0, 0, []int{1 << 20, 1 << 20}, 1 << 20, false},
+		{"requests left", 2, 0, []int{10}, 10, false},
+		{"no requests left", 2, 0, []int{10, 10}, 10, true},
+		{"bytes left", 0, 100, []int{40}, 60, false},
+		{"no bytes left", 0, 100, []int{40}, 61, true},
+		{"pack larger than rate, bucket is full", 0, 100, nil, 1000, false},
+		{"pack larger than rate, bucket isn't full", 0, 100, []int{1}, 1000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, now := newRateLimiter(tt.requestsRate, tt.bytesRate), time.Now()
+			for _, size := range tt.sizes {
+				l.reserve(now, size)
+			}
+			if got := l.throttled(now, tt.size); got != tt.throttled {
+				t.Fatalf("throttled() = %v, want %v", got, tt.throttled)
+			}
+		})
+	}
+}
+
+func TestAdaptRateLimit(t *testing.T) {
+
+	resetUnix := time.Now().Add(20 * time.Second).Unix()
+
+	tests := []struct {
+		name        string
+		status      int
+		headers     map[string]string
+		rate        float64       // adapted rate of requests, 0 if not adapted
+		adaptedFor  time.Duration // how long the adapted rate is kept
+		pausedFor   time.Duration // how long requests are paused, 0 if not paused
+		ignoreRetry bool
+	}{
+		{"no headers", 200, nil, 0, 0, 0, false},
+		{"x-ratelimit", 200, map[string]string{
+			"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "5"}, 2, 5 * time.Second, 0, false},
+		{"ratelimit", 200, map[string]string{
+			"RateLimit-Remaining": "30", "RateLimit-Reset": "10"}, 3, 10 * time.Second, 0, false},
+		{"invalid x-ratelimit, valid ratelimit", 200, map[string]string{
+			"X-RateLimit-Remaining": "many", "X-RateLimit-Reset": "5",
+			"RateLimit-Remaining": "30", "RateLimit-Reset": "10"}, 3, 10 * time.Second, 0, false},
+		{"no reset", 200, map[string]string{
+			"X-RateLimit-Remaining": "10"}, 0, 0, 0, false},
+		{"reset is unix time", 200, map[string]string{
+			"X-RateLimit-Remaining": "40", "X-RateLimit-Reset": strconv.FormatInt(resetUnix, 10)},
+			2, 20 * time.Second, 0, false},
+		{"reset is threshold", 200, map[string]string{
+			"X-RateLimit-Remaining": strconv.Itoa(_RATE_LIMIT_RESET_UNIX_THRESHOLD),
+			"X-RateLimit-Reset":     strconv.Itoa(_RATE_LIMIT_RESET_UNIX_THRESHOLD)},
+			1, _RATE_LIMIT_RESET_UNIX_THRESHOLD * time.Second, 0, false},
+		{"reset is past unix time", 200, map[string]string{
+			"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": strconv.Itoa(_RATE_LIMIT_RESET_UNIX_THRESHOLD + 1)},
+			0, 0, 0, false},
+		{"no requests remaining", 200, map[string]string{
+			"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "7"}, 0, 0, 7 * time.Second, false},
+		{"retry after, too many requests", 429, map[string]string{
+			"Retry-After": "3"}, 0, 0, 3 * time.Second, false},
+		{"retry after, unavailable", 503, map[string]string{
+			"Retry-After": "4"}, 0, 0, 4 * time.Second, false},
+		{"retry after, ok", 200, map[string]string{
+			"Retry-After": "3"}, 0, 0, 0, false},
+		{"retry after is ignored", 429, map[string]string{
+			"Retry-After": "3"}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := &CI_WriterHttp{
+				limiter:               newRateLimiter(0, 0),
+				retryIgnoreRetryAfter: tt.ignoreRetry,
+			}
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			resp.SetStatusCode(tt.status)
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+
+			now := time.Now()
+			dw.adaptRateLimit(resp)
+
+			const precision = time.Second
+			l := dw.limiter
+
+			switch {
+			case math.Abs(l.adaptedRate-tt.rate) > 0.2:
+				t.Fatalf("adapted rate is %.2f, want %.2f", l.adaptedRate, tt.rate)
+			case tt.rate > 0 && absDuration(l.adaptedUntil.Sub(now)-tt.adaptedFor) > precision:
+				t.Fatalf("adapted rate is kept for %s, want %s", l.adaptedUntil.Sub(now), tt.adaptedFor)
+			case tt.pausedFor == 0 && !l.pausedUntil.IsZero():
+				t.Fatalf("requests are paused for %s", l.pausedUntil.Sub(now))
+			case tt.pausedFor > 0 && absDuration(l.pausedUntil.Sub(now)-tt.pausedFor) > precision:
+				t.Fatalf("requests are paused for %s, want %s", l.pausedUntil.Sub(now), tt.pausedFor)
+			}
+		})
+	}
+}
+
+func TestThrottlePack(t *testing.T) {
+
+	encodedEntries := []string{
+		`{"level":"info","message":"a"}`,
+		`{"level":"error","message":"b"}`,
+		`{"level":"debug","message":"c"}`,
+		`{"level":"fatal","message":"d"}`,
+	}
+
+	tests := []struct {
+		name       string
+		policy     CI_WriterHttp_ThrottlePolicy
+		sampleRate float64
+		throttled  bool
+		entries    []int // indexes of encoded log entries
+		want       []int // indexes of encoded log entries that are sent, nil if nothing
+	}{
+		{"not throttled", CI_WRITER_HTTP_THROTTLE_SAMPLE, 0, false, []int{0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{"wait policy", CI_WRITER_HTTP_THROTTLE_WAIT, 0, true, []int{0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{"high priority are kept", CI_WRITER_HTTP_THROTTLE_SAMPLE, 0, true, []int{0, 1, 2, 3}, []int{1, 3}},
+		{"all are sampled", CI_WRITER_HTTP_THROTTLE_SAMPLE, 1, true, []int{0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{"all are sampled out", CI_WRITER_HTTP_THROTTLE_SAMPLE, 0, true, []int{0, 2}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := &CI_WriterHttp{
+				limiter:            newRateLimiter(1, 0),
+				throttlePolicy:     tt.policy,
+				throttleSampleRate: tt.sampleRate,
+				priorityClassifier: isHighPriorityEntry,
+			}
+			if tt.throttled {
+				dw.limiter.reserve(time.Now(), 0)
+			}
+
+			pack := newPack(0)
+			for _, i := range tt.entries {
+				pack.Add([]byte(encodedEntries[i]))
+			}
+
+			var got []string
+			if sent := dw.throttlePack(pack); sent != nil {
+				got = packTestEntries(sent)
+			}
+
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, encodedEntries[i])
+			}
+
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("sent encoded log entries %v, want %v", got, want)
+			}
+
+			throttled := uint64(len(tt.entries) - len(tt.want))
+			if stats := dw.Stats(); stats.EntriesLostByReason["throttled"] != throttled {
+				t.Fatalf("%d encoded log entries are lost as throttled, want %d",
+					stats.EntriesLostByReason["throttled"], throttled)
+			}
+		})
+	}
+}
+
+func TestThrottlePack_ReleasesProbe(t *testing.T) {
+
+	dw := &CI_WriterHttp{
+		limiter:            newRateLimiter(1, 0),
+		throttlePolicy:     CI_WRITER_HTTP_THROTTLE_SAMPLE,
+		priorityClassifier: isHighPriorityEntry,
+		breaker:            newBreaker(0.5, 2, 1, time.Hour),
+	}
+	dw.limiter.reserve(time.Now(), 0)
+
+	// The breaker is half-open, the next request is the probe.
+	dw.breaker.record(true, 0, time.Now())
+	dw.breaker.openUntil = time.Now()
+
+	pack := newPack(0)
+	pack.Add([]byte(`{"level":"info","message":"a"}`))
+
+	// All encoded log entries are sampled out, nothing is sent.
+	dw.processEntriesBuffer(pack)
+
+	if state := dw.breaker.getState(); state != _BREAKER_HALF_OPEN || !dw.breaker.allow() {
+		t.Fatalf("breaker is %s and its probe slot is not released", breakerStateString(state))
+	}
+}
+
+func TestThrottleCoalesce(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "ping" {
+			mu.Lock()
+			received = append(received, string(body))
+			mu.Unlock()
+		}
+	}))
+	defer srv.Close()
+
+	dw := new(CI_WriterHttp).
+		UseProviderManual(func(req *fasthttp.Request) {
+			req.SetRequestURI(srv.URL)
+		}).
+		AddBeforeAfterBetweenS("", "", ",").
+		SetPingBody([]byte("ping")).
+		SetRateLimit(1, 0).
+		SetThrottlePolicy(CI_WRITER_HTTP_THROTTLE_COALESCE, 0).
+		SetWorkersNum(1).
+		SetWorkerAutoFlushDelay(10 * time.Millisecond)
+
+	ReceivedNum := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	// The 1st request takes the only token (the bucket's capacity is 1),
+	// the next one may be sent in 1s.
+	_, _ = dw.Write([]byte("a"))
+	for start := time.Now(); ReceivedNum() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("the 1st entries pack is not sent")
+		}
+	}
+
+	// The flush timer ticks many times meanwhile, but the worker
+	// keeps accumulating encoded log entries.
+	_, _ = dw.Write([]byte("b"))
+	time.Sleep(50 * time.Millisecond)
+	_, _ = dw.Write([]byte("c"))
+
+	if err := dw.Close(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to close")
+	}
+
+	if got := strings.Join(received, " "); got != "a b,c" {
+		t.Fatalf("received entries packs %q, want %q", got, "a b,c")
+	}
+}
+
+func TestThrottle_ChargesPreparedBody(t *testing.T) {
+
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The ping's body is prepared also.
+		if body, _ := ioutil.ReadAll(r.Body); !strings.HasPrefix(string(body), "ping") {
+			atomic.AddInt32(&received, 1)
+		}
+	}))
+	defer srv.Close()
+
+	// The body is much larger than the encoded log entry.
+	dw := new(CI_WriterHttp).
+		UseProviderManual(func(req *fasthttp.Request) {
+			req.SetRequestURI(srv.URL)
+		}, func(body io.Reader) io.Reader {
+			return io.MultiReader(body, strings.NewReader(strings.Repeat(" ", 899)))
+		}).
+		SetPingBody([]byte("ping")).
+		SetRateLimit(0, 1000).
+		SetWorkersNum(1).
+		SetWorkerBufferCap(1).
+		SetWorkerAutoFlushDelay(time.Hour)
+
+	_, _ = dw.Write([]byte("a"))
+	for start := time.Now(); atomic.LoadInt32(&received) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("the entries pack is not sent")
+		}
+	}
+
+	// 900 bytes of 1000 are taken.
+	if !dw.limiter.throttled(time.Now(), 200) {
+		t.Fatal("the rate limit is charged less than the size of the prepared body")
+	}
+
+	if err := dw.Close(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to close")
+	}
+}
+
+// absDuration returns the absolute value of 'd'.
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
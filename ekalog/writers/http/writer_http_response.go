@@ -0,0 +1,154 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"sort"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_ResponseResult is the classification of HTTP response
+	// of the log service provider. See CI_WriterHttp_ResponseHandler.
+	CI_WriterHttp_ResponseResult struct {
+
+		// Accepted reports whether the request is succeeded.
+		// If it's not, the whole entries pack is considered as not sent
+		// and 'Retryable' reports whether the request may be retried.
+		Accepted  bool
+		Retryable bool
+
+		// Indexes (starting from 0) of encoded log entries of the accepted
+		// request, that are rejected by provider, but may be sent again,
+		// and the number of those, that are rejected and can't be.
+		// The last ones are counted as lost. Ignored if request isn't accepted.
+		//
+		// Indexes may be unordered and may repeat: 'Retry' is sorted
+		// and deduplicated after the response handler, and indexes out of
+		// [0, entriesNum) are discarded. 'Rejected' is clamped then,
+		// so 'Rejected' + len('Retry') never exceeds 'entriesNum'.
+		Retry    []int
+		Rejected int
+
+		// Reason is a human-readable reason of failure or rejection.
+		// It's saved as the last error (see Stats()), if it's not empty.
+		Reason string
+	}
+
+	// CI_WriterHttp_ResponseHandler classifies HTTP response 'resp'
+	// of the request, containing 'entriesNum' encoded log entries
+	// (0 for ping request), modifying 'result'.
+	// See SetResponseHandler() method.
+	//
+	// 'result' is already filled according with HTTP status code:
+	// it's accepted if status code is one of accepted ones
+	// (see SetAcceptedStatusCodes()) and retryable if it's one of retryable ones
+	// (see SetRetryableStatusCodes()). Handler may leave it as is,
+	// or parse the response (like per-item errors of bulk API) and overwrite it.
+	//
+	// 'resp' must not be retained. Handler is called from many goroutines.
+	// It must be thread-safe.
+	CI_WriterHttp_ResponseHandler func(
+		resp *fasthttp.Response, entriesNum int, result *CI_WriterHttp_ResponseResult)
+)
+
+var (
+	// defaultAcceptedStatusCodes is used when SetAcceptedStatusCodes() is not called.
+	defaultAcceptedStatusCodes = map[int]struct{}{
+		fasthttp.StatusOK:        {},
+		fasthttp.StatusAccepted:  {},
+		fasthttp.StatusNoContent: {},
+	}
+)
+
+// responseBodyHandler returns a CI_WriterHttp_ResponseHandler,
+// that parses the body of accepted response using 'cb',
+// for the log service providers, that may reject some of log entries
+// w/o HTTP error (like bulk APIs).
+//
+// 'cb' returns indexes of log entries that may be retried,
+// the number of lost log entries and the reason of rejection.
+func responseBodyHandler(
+
+	cb func(body []byte, entriesNum int) (retry []int, lost int, reason string),
+
+) CI_WriterHttp_ResponseHandler {
+
+	return func(resp *fasthttp.Response, entriesNum int, result *CI_WriterHttp_ResponseResult) {
+		if result.Accepted && entriesNum > 0 {
+			result.Retry, result.Rejected, result.Reason = cb(resp.Body(), entriesNum)
+		}
+	}
+}
+
+// classifyResponse returns the classification of HTTP response 'resp'
+// of the request, containing 'entriesNum' encoded log entries,
+// according with accepted and retryable HTTP status codes,
+// and then applying provider's and user's response handlers (see SetResponseHandler()).
+func (dw *CI_WriterHttp) classifyResponse(
+
+	resp *fasthttp.Response,
+	entriesNum int,
+
+) (result CI_WriterHttp_ResponseResult) {
+
+	status := resp.StatusCode()
+
+	_, result.Accepted = dw.acceptedStatusCodes[status]
+	if !result.Accepted {
+		_, result.Retryable = dw.retryStatusCodes[status]
+	}
+
+	if dw.providerResponseHandler != nil {
+		dw.providerResponseHandler(resp, entriesNum, &result)
+	}
+	if dw.responseHandler != nil {
+		dw.responseHandler(resp, entriesNum, &result)
+	}
+
+	if result.Accepted {
+		result.Retryable = false
+		result.Retry, result.Rejected = sanitizeRetry(result.Retry, result.Rejected, entriesNum)
+	} else {
+		result.Retry, result.Rejected = nil, 0
+	}
+
+	return result
+}
+
+// sanitizeRetry returns sorted and deduplicated 'retry' w/o indexes
+// out of [0, entriesNum), and 'rejected', clamped to the rest of encoded log entries.
+// 'retry' is modified in place.
+func sanitizeRetry(retry []int, rejected, entriesNum int) ([]int, int) {
+
+	sort.Ints(retry)
+
+	n := 0
+	for i, idx := range retry {
+		if idx < 0 || idx >= entriesNum || i > 0 && idx == retry[i-1] {
+			continue
+		}
+		retry[n] = idx
+		n++
+	}
+
+	if n == 0 {
+		retry = nil
+	} else {
+		retry = retry[:n]
+	}
+
+	switch {
+	case rejected < 0:
+		rejected = 0
+	case rejected > entriesNum-n:
+		rejected = entriesNum - n
+	}
+
+	return retry, rejected
+}
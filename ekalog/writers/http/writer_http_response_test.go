@@ -0,0 +1,92 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestClassifyResponse(t *testing.T) {
+
+	provider := responseBodyHandler(func(body []byte, entriesNum int) ([]int, int, string) {
+		return []int{1}, 1, "provider"
+	})
+	user := func(resp *fasthttp.Response, entriesNum int, result *CI_WriterHttp_ResponseResult) {
+		if result.Reason == "provider" {
+			result.Reason = "user"
+		}
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		provider CI_WriterHttp_ResponseHandler
+		user     CI_WriterHttp_ResponseHandler
+		want     CI_WriterHttp_ResponseResult
+	}{
+		{"accepted", fasthttp.StatusOK, nil, nil,
+			CI_WriterHttp_ResponseResult{Accepted: true}},
+		{"retryable", fasthttp.StatusServiceUnavailable, nil, nil,
+			CI_WriterHttp_ResponseResult{Retryable: true}},
+		{"rejected", fasthttp.StatusBadRequest, nil, nil,
+			CI_WriterHttp_ResponseResult{}},
+		{"provider's handler", fasthttp.StatusOK, provider, nil,
+			CI_WriterHttp_ResponseResult{Accepted: true, Retry: []int{1}, Rejected: 1, Reason: "provider"}},
+		{"provider's handler of failed request", fasthttp.StatusBadRequest, provider, nil,
+			CI_WriterHttp_ResponseResult{}},
+		{"user's handler after provider's one", fasthttp.StatusOK, provider, user,
+			CI_WriterHttp_ResponseResult{Accepted: true, Retry: []int{1}, Rejected: 1, Reason: "user"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := new(CI_WriterHttp).SetResponseHandler(tt.user)
+			dw.providerResponseHandler = tt.provider
+			dw.initOverwriteZeroValues()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			resp.SetStatusCode(tt.status)
+
+			if got := dw.classifyResponse(resp, 2); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRetry(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		retry        []int
+		rejected     int
+		entriesNum   int
+		wantRetry    []int
+		wantRejected int
+	}{
+		{"empty", nil, 0, 3, nil, 0},
+		{"sorted", []int{0, 2}, 1, 3, []int{0, 2}, 1},
+		{"unordered and duplicated", []int{2, 0, 2, 0}, 1, 3, []int{0, 2}, 1},
+		{"out of range", []int{-1, 1, 3, 10}, 0, 3, []int{1}, 0},
+		{"all out of range", []int{-1, 3}, 0, 3, nil, 0},
+		{"too many rejected", []int{0, 1}, 5, 3, []int{0, 1}, 1},
+		{"negative rejected", nil, -1, 3, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry, rejected := sanitizeRetry(tt.retry, tt.rejected, tt.entriesNum)
+			if !reflect.DeepEqual(retry, tt.wantRetry) || rejected != tt.wantRejected {
+				t.Fatalf("got (%v, %d), want (%v, %d)",
+					retry, rejected, tt.wantRetry, tt.wantRejected)
+			}
+		})
+	}
+}
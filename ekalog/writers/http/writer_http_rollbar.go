@@ -6,35 +6,46 @@
 package ekalog_writer_http
 
 import (
-	"bytes"
-	"io"
-
-	"github.com/qioalice/ekago/v3/ekastr"
-
 	"github.com/valyala/fasthttp"
 )
 
 //noinspection GoSnakeCaseUsage
 const (
-	ROLLBAR_ADDR = "https://api.rollbar.com/api/1/items/"
+	ROLLBAR_ADDR = "https://api.rollbar.com/api/1/item/"
+
+	// Rollbar's endpoint, that is used as ping (it doesn't ingest anything).
+	_ROLLBAR_PING_ADDR = "https://api.rollbar.com/api/1/status/ping"
 )
 
+// UseProviderRollbar setups CI_WriterHttp for Rollbar log service provider
+// ( https://rollbar.com/ ).
+//
+// You MUST specify 'token' as your Rollbar project's access token
+// with "post_server_item" scope. It's sent as "X-Rollbar-Access-Token" HTTP header.
+//
+// Rollbar accepts only one item per request,
+// so each entries pack has only one encoded log entry, whatever the worker's
+// buffer capacity is (see SetWorkerBufferCap()),
+// and there's nothing before, after or between encoded log entries.
+// Use ekalog_encoder_rollbar.CI_RollbarEncoder to encode your log entries.
+//
+// Rollbar does not accept empty items, so the ping (see Ping())
+// is HTTP GET request to Rollbar's status endpoint.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
 func (dw *CI_WriterHttp) UseProviderRollbar(token string) *CI_WriterHttp {
 
-	var (
-		JsonBodyStart = ekastr.S2B(`"access_token":"` + token + `",data:`)
-		JsonBodyEnd   = ekastr.S2B(`}`)
-	)
-
 	cb1 := func(req *fasthttp.Request) {
 		req.SetRequestURI(ROLLBAR_ADDR)
 		req.Header.SetContentType("application/json")
+		req.Header.Set("X-Rollbar-Access-Token", token)
 	}
 
-	cb2 := func(oldBody io.Reader) io.Reader {
-		r := bytes.NewReader
-		return io.MultiReader(r(JsonBodyStart), oldBody, r(JsonBodyEnd))
-	}
-
-	return dw.AddBeforeAfterBetweenS("[],").UseProviderManual(cb1, cb2)
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingGetInitializer(_ROLLBAR_PING_ADDR)
+			dw.providerMaxEntriesPerPack = 1
+		}).
+		useProvider(cb1)
 }
@@ -0,0 +1,120 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/valyala/fasthttp"
+)
+
+// UseProviderSentry setups CI_WriterHttp for Sentry log service provider
+// ( https://sentry.io/ ), using its envelope endpoint.
+//
+// You MUST specify 'dsn' as your Sentry project's DSN, like
+// "https://<public_key>@o0.ingest.sentry.io/<project_id>".
+// If it's invalid, the initialization will fail.
+//
+// An entries pack is sent as one envelope, the encoded log entry
+// is the envelope's event item (see SetPackBuilder()).
+// Sentry accepts only one event per envelope, so each entries pack
+// has only one encoded log entry, whatever the worker's buffer capacity is
+// (see SetWorkerBufferCap()).
+// Use ekalog_encoder_sentry.CI_SentryEncoder to encode your log entries.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderSentry(dsn string) *CI_WriterHttp {
+
+	addr, auth, err := parseSentryDSN(dsn)
+	if err.IsNotNil() {
+		return dw.configure(func(dw *CI_WriterHttp) {
+			dw.resetProvider()
+			dw.providerErr = err
+		})
+	}
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr)
+		req.Header.SetContentType("application/x-sentry-envelope")
+		req.Header.Set("X-Sentry-Auth", auth)
+	}
+
+	// The ping sends an envelope w/o items.
+	return dw.configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPackBuilder = sentryEnvelopeBuilder{}
+			dw.providerMaxEntriesPerPack = 1
+		}).
+		SetPingBody([]byte("{}\n")).
+		useProvider(cb1)
+}
+
+// sentryEnvelopeBuilder is a CI_WriterHttp_PackBuilder,
+// that builds Sentry's envelope from the encoded events.
+type sentryEnvelopeBuilder struct{}
+
+// Begin implements CI_WriterHttp_PackBuilder. Writes an empty envelope header.
+func (sentryEnvelopeBuilder) Begin(body *bytes.Buffer) {
+	_, _ = body.WriteString("{}\n")
+}
+
+// AppendEntry implements CI_WriterHttp_PackBuilder.
+// Writes an event item: its header with the length of 'event' and 'event' itself.
+func (sentryEnvelopeBuilder) AppendEntry(body *bytes.Buffer, _ int, event []byte) {
+
+	event = bytes.TrimSpace(event)
+
+	_, _ = body.WriteString(`{"type":"event","length":`)
+	_, _ = body.WriteString(strconv.Itoa(len(event)))
+	_, _ = body.WriteString("}\n")
+	_, _ = body.Write(event)
+	_ = body.WriteByte('\n')
+}
+
+// End implements CI_WriterHttp_PackBuilder. There's nothing after items.
+func (sentryEnvelopeBuilder) End(_ *bytes.Buffer, _ int) {}
+
+// parseSentryDSN parses Sentry's DSN
+// "<scheme>://<public_key>[:<secret_key>]@<host>[/<path>]/<project_id>",
+// returning an envelope endpoint's addr and "X-Sentry-Auth" HTTP header's value.
+func parseSentryDSN(dsn string) (addr, auth string, err *ekaerr.Error) {
+
+	u, legacyErr := url.Parse(dsn)
+	if legacyErr != nil {
+		return "", "", ekaerr.IllegalArgument.
+			Wrap(legacyErr, "CI_WriterHttp: Invalid Sentry DSN.").
+			Throw()
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndexByte(path, '/')
+
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https",
+		u.User == nil || u.User.Username() == "",
+		u.Host == "",
+		slash == -1 || slash == len(path)-1:
+
+		return "", "", ekaerr.IllegalArgument.
+			New("CI_WriterHttp: Invalid Sentry DSN. " +
+				"Expected: <scheme>://<public_key>@<host>/<project_id>.").
+			Throw()
+	}
+
+	addr = u.Scheme + "://" + u.Host + path[:slash] + "/api/" + path[slash+1:] + "/envelope/"
+	auth = "Sentry sentry_version=7, sentry_client=ekago_ext.sentry/3, sentry_key=" + u.User.Username()
+
+	if secret, ok := u.User.Password(); ok && secret != "" {
+		auth += ", sentry_secret=" + secret
+	}
+
+	return addr, auth, nil
+}
@@ -0,0 +1,128 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+type (
+	// splunkHECResponse is a Splunk HTTP Event Collector's response.
+	splunkHECResponse struct {
+		Text               string `json:"text"`
+		Code               *int   `json:"code"`
+		AckID              *int64 `json:"ackId"`
+		InvalidEventNumber *int   `json:"invalid-event-number"`
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Splunk HEC's "Server is busy" status code.
+	_SPLUNK_HEC_CODE_SERVER_BUSY = 9
+)
+
+// UseProviderSplunkHEC setups CI_WriterHttp for Splunk log service provider
+// ( https://www.splunk.com/ ), using its HTTP Event Collector (HEC).
+//
+// You MUST specify 'addr' as your HEC's addr, like "https://splunk.example.com:8088",
+// and 'token' as your HEC's token. The "/services/collector/event" path is added.
+//
+// Use ekalog_encoder_splunk.CI_SplunkHECEncoder to encode your log entries.
+// Encoded log entries are sent as is, separated by "\n".
+//
+// Each request has "X-Splunk-Request-Channel" HTTP header (a random channel ID,
+// generated once), so it works with HEC's tokens that have indexer
+// acknowledgement enabled. But indexer acknowledgements are NOT supported:
+// "/services/collector/ack" is never polled, and log entries are considered
+// sent when HEC accepts them, not when they're indexed.
+//
+// HEC's response is validated. If HEC answers with non zero code,
+// log entries are retried if HEC is busy, or counted as lost otherwise.
+// If HEC rejects the request because of invalid log entry
+// ("invalid-event-number"), the log entries before it are indexed,
+// the invalid one is lost, and the ones after it are retried.
+//
+// The ping (see Ping()) requests HEC's health endpoint.
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+func (dw *CI_WriterHttp) UseProviderSplunkHEC(addr, token string) *CI_WriterHttp {
+
+	addr = strings.TrimSuffix(addr, "/")
+	authorization := "Splunk " + token
+	channel := splunkChannelID()
+
+	cb1 := func(req *fasthttp.Request) {
+		req.SetRequestURI(addr + "/services/collector/event")
+		req.Header.SetContentType("application/json")
+		req.Header.Set(fasthttp.HeaderAuthorization, authorization)
+		req.Header.Set("X-Splunk-Request-Channel", channel)
+	}
+
+	return dw.
+		configure((*CI_WriterHttp).resetProvider).
+		configure(func(dw *CI_WriterHttp) {
+			dw.providerPingInitializer = pingGetInitializer(addr + "/services/collector/health")
+			dw.providerResponseHandler = handleSplunkHECResponse
+			dw.providerPackBuilder = newStaticPackBuilder("", "", "\n")
+		}).
+		useProvider(cb1)
+}
+
+// handleSplunkHECResponse is a CI_WriterHttp_ResponseHandler,
+// that validates Splunk HEC's response, whatever its HTTP status code is.
+func handleSplunkHECResponse(
+
+	resp *fasthttp.Response,
+	entriesNum int,
+	result *CI_WriterHttp_ResponseResult,
+
+) {
+
+	var hecResp splunkHECResponse
+	if entriesNum == 0 || json.Unmarshal(resp.Body(), &hecResp) != nil ||
+		hecResp.Code == nil || *hecResp.Code == 0 {
+		return
+	}
+
+	result.Reason = "Splunk HEC: " + hecResp.Text + " (code " + strconv.Itoa(*hecResp.Code) + ")"
+
+	switch invalidEventNumber := hecResp.InvalidEventNumber; {
+
+	case *hecResp.Code == _SPLUNK_HEC_CODE_SERVER_BUSY:
+		result.Accepted, result.Retryable = false, true
+
+	case invalidEventNumber != nil && *invalidEventNumber >= 0 && *invalidEventNumber < entriesNum:
+		// HEC stops at the invalid log entry, so the ones before it are indexed.
+		result.Accepted, result.Retryable = true, false
+		result.Retry, result.Rejected = nil, 1
+		for i := *invalidEventNumber + 1; i < entriesNum; i++ {
+			result.Retry = append(result.Retry, i)
+		}
+
+	case result.Accepted:
+		result.Rejected = entriesNum
+	}
+}
+
+// splunkChannelID generates a random Splunk HEC's channel ID (UUID4).
+func splunkChannelID() string {
+
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	b[6] = b[6]&0x0F | 0x40
+	b[8] = b[8]&0x3F | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
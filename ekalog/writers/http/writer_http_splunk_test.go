@@ -0,0 +1,95 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestHandleSplunkHECResponse(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   CI_WriterHttp_ResponseResult
+	}{
+		{"success", fasthttp.StatusOK, `{"text":"Success","code":0}`,
+			CI_WriterHttp_ResponseResult{Accepted: true}},
+		{"acknowledgement", fasthttp.StatusOK, `{"ackId":7}`,
+			CI_WriterHttp_ResponseResult{Accepted: true}},
+		{"malformed", fasthttp.StatusBadRequest, `{"code":`,
+			CI_WriterHttp_ResponseResult{}},
+		{
+			"invalid event",
+			fasthttp.StatusBadRequest,
+			`{"text":"Invalid data format","code":6,"invalid-event-number":1}`,
+			CI_WriterHttp_ResponseResult{
+				Accepted: true,
+				Retry:    []int{2, 3},
+				Rejected: 1,
+				Reason:   "Splunk HEC: Invalid data format (code 6)",
+			},
+		},
+		{
+			"invalid last event",
+			fasthttp.StatusBadRequest,
+			`{"text":"Event field cannot be blank","code":13,"invalid-event-number":3}`,
+			CI_WriterHttp_ResponseResult{
+				Accepted: true,
+				Rejected: 1,
+				Reason:   "Splunk HEC: Event field cannot be blank (code 13)",
+			},
+		},
+		{
+			"invalid event number out of pack",
+			fasthttp.StatusBadRequest,
+			`{"text":"Invalid data format","code":6,"invalid-event-number":4}`,
+			CI_WriterHttp_ResponseResult{Reason: "Splunk HEC: Invalid data format (code 6)"},
+		},
+		{
+			"no data",
+			fasthttp.StatusBadRequest,
+			`{"text":"No data","code":5}`,
+			CI_WriterHttp_ResponseResult{Reason: "Splunk HEC: No data (code 5)"},
+		},
+		{
+			"server is busy",
+			fasthttp.StatusServiceUnavailable,
+			`{"text":"Server is busy","code":9}`,
+			CI_WriterHttp_ResponseResult{Retryable: true, Reason: "Splunk HEC: Server is busy (code 9)"},
+		},
+		{
+			"error code of accepted request",
+			fasthttp.StatusOK,
+			`{"text":"Internal server error","code":8}`,
+			CI_WriterHttp_ResponseResult{
+				Accepted: true,
+				Rejected: 4,
+				Reason:   "Splunk HEC: Internal server error (code 8)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := new(CI_WriterHttp).UseProviderSplunkHEC("http://127.0.0.1:8088", "token")
+			dw.initOverwriteZeroValues()
+
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			resp.SetStatusCode(tt.status)
+			resp.SetBodyString(tt.body)
+
+			if got := dw.classifyResponse(resp, 4); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_SpoolSync is a type of fsync policy of CI_WriterHttp's
+	// disk-backed spool of deferred entries packs.
+	// See SetDeferredSpool() method and CI_WRITER_HTTP_SPOOL_SYNC_<...> constants.
+	CI_WriterHttp_SpoolSync uint8
+
+	// _CI_WriterHttpSpool is a disk-backed FIFO queue of deferred entries packs.
+	//
+	// The data is stored as a set of segment files inside some directory.
+	// Each segment is a sequence of records, each record is:
+	//
+	//     [4 bytes: payload's len, BE][4 bytes: CRC32-C, BE]
+	//     [4 bytes: number of encoded log entries, BE][payload]
+	//
+	// The checksum covers the number of encoded log entries and the payload.
+	// The number of encoded log entries is kept apart from the payload
+	// to count them as lost even if the payload is corrupted.
+	//
+	// Records are appended to the last segment. When it reaches its max size,
+	// a new segment is created. Records are read from the first segment,
+	// and when it's completely read, it's removed.
+	//
+	// Thread-safe.
+	_CI_WriterHttpSpool struct {
+		mu sync.Mutex
+
+		dir            string
+		maxSize        int64
+		segmentMaxSize int64
+		syncPolicy     CI_WriterHttp_SpoolSync
+
+		// segments are sorted by their IDs ASC.
+		// The last one is the segment records are written to (if w != nil).
+		segments []_CI_WriterHttpSpoolSegment
+
+		size    int64 // total size of records that are not committed yet in bytes
+		records int   // number of records that are not committed yet
+
+		w *os.File // file of the last segment, records are written to
+
+		r         *os.File // file of the first segment, records are read from
+		rID       uint64   // ID of the segment 'r' belongs to
+		rOffset   int64    // offset of next record in 'r'
+		rNext     int64    // offset of the record after peeked one (0 if not peeked)
+		rEntries  uint64   // number of encoded log entries of peeked record
+		rConsumed int      // number of committed records of the first segment
+
+		// Number of encoded log entries of committed records of the first segment.
+		rConsumedEntries uint64
+
+		// Encoded log entries of records that are lost due to checksum mismatch.
+		corruptedCounter uint64
+	}
+
+	// _CI_WriterHttpSpoolSegment is a _CI_WriterHttpSpool's segment descriptor.
+	_CI_WriterHttpSpoolSegment struct {
+		id      uint64
+		records int
+		entries uint64
+		size    int64
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Never call fsync(). Leave it to the OS.
+	// The fastest one, but a power loss may cause data loss.
+	CI_WRITER_HTTP_SPOOL_SYNC_NEVER CI_WriterHttp_SpoolSync = iota
+
+	// Call fsync() only when segment is full and a new one is created.
+	CI_WRITER_HTTP_SPOOL_SYNC_ON_ROTATE
+
+	// Call fsync() after each written record.
+	// The slowest one, but the safest.
+	CI_WRITER_HTTP_SPOOL_SYNC_ALWAYS
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_SPOOL_SEGMENT_FILE_EXT    = ".spool"
+	_SPOOL_RECORD_HEADER_SIZE  = 12
+	_SPOOL_RECORD_MAX_SIZE     = 1 << 30
+	_DEFAULT_SPOOL_MAX_SIZE    = 256 << 20
+	_DEFAULT_SPOOL_SEGMENT_MAX = 8 << 20
+)
+
+var (
+	spoolCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errSpoolFull = fmt.Errorf("CI_WriterHttp: spool is full")
+)
+
+// spoolOpen opens (creating, if necessary) a spool at the provided directory,
+// scanning all segments left by previous runs. Their records will be read first.
+func spoolOpen(
+
+	dir string,
+	maxSize, segmentMaxSize int64,
+	syncPolicy CI_WriterHttp_SpoolSync,
+
+) (*_CI_WriterHttpSpool, *ekaerr.Error) {
+
+	if legacyErr := os.MkdirAll(dir, 0755); legacyErr != nil {
+		return nil, ekaerr.ExternalError.
+			Wrap(legacyErr, "CI_WriterHttp: Failed to create spool directory.").
+			WithString("ci_writer_http_spool_dir", dir).
+			Throw()
+	}
+
+	files, legacyErr := ioutil.ReadDir(dir)
+	if legacyErr != nil {
+		return nil, ekaerr.ExternalError.
+			Wrap(legacyErr, "CI_WriterHttp: Failed to read spool directory.").
+			WithString("ci_writer_http_spool_dir", dir).
+			Throw()
+	}
+
+	s := &_CI_WriterHttpSpool{
+		dir:            dir,
+		maxSize:        maxSize,
+		segmentMaxSize: segmentMaxSize,
+		syncPolicy:     syncPolicy,
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, _SPOOL_SEGMENT_FILE_EXT) {
+			continue
+		}
+		id, legacyErr := strconv.ParseUint(strings.TrimSuffix(name, _SPOOL_SEGMENT_FILE_EXT), 10, 64)
+		if legacyErr != nil {
+			continue
+		}
+		records, entries, partialEntries, legacyErr := spoolCountRecords(s.segmentPath(id))
+		if legacyErr != nil {
+			return nil, ekaerr.ExternalError.
+				Wrap(legacyErr, "CI_WriterHttp: Failed to read spool segment.").
+				WithString("ci_writer_http_spool_segment", s.segmentPath(id)).
+				Throw()
+		}
+		s.segments = append(s.segments, _CI_WriterHttpSpoolSegment{
+			id:      id,
+			records: records,
+			entries: entries,
+			size:    file.Size(),
+		})
+		s.records += records
+		s.size += file.Size()
+		s.corruptedCounter += partialEntries
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+
+	return s, nil
+}
+
+// spoolCountRecords returns a number of records in the segment at 'path',
+// a total number of their encoded log entries and a number of encoded log entries
+// of the partially written record, the segment's scanning stops at.
+// Checksums are not validated here.
+func spoolCountRecords(path string) (int, uint64, uint64, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+
+	var (
+		header  [_SPOOL_RECORD_HEADER_SIZE]byte
+		n       int
+		entries uint64
+	)
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			return n, entries, 0, nil
+		}
+		l := int64(binary.BigEndian.Uint32(header[:4]))
+		recordEntries := uint64(binary.BigEndian.Uint32(header[8:]))
+		if l > _SPOOL_RECORD_MAX_SIZE {
+			return n, entries, recordEntries, nil
+		}
+		if _, err := io.CopyN(ioutil.Discard, f, l); err != nil {
+			return n, entries, recordEntries, nil
+		}
+		n++
+		entries += recordEntries
+	}
+}
+
+// segmentPath returns a path of segment file with the provided ID.
+func (s *_CI_WriterHttpSpool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, _SPOOL_SEGMENT_FILE_EXT))
+}
+
+// Len returns a number of records that are stored and not committed yet.
+func (s *_CI_WriterHttpSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// Corrupted returns a number of encoded log entries of records,
+// that has been dropped because they were corrupted
+// (checksum mismatch, partially written, etc).
+func (s *_CI_WriterHttpSpool) Corrupted() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.corruptedCounter
+}
+
+// Push writes 'payload' as a new record to the spool.
+// 'entries' is the number of encoded log entries the payload contains.
+// Returns errSpoolFull if there is no space left.
+//
+// Only records that are not committed yet count against the spool's max size.
+// So, the disk space might exceed it by the size of the first segment.
+func (s *_CI_WriterHttpSpool) Push(payload []byte, entries int) error {
+
+	recordSize := int64(_SPOOL_RECORD_HEADER_SIZE + len(payload))
+	if recordSize > _SPOOL_RECORD_MAX_SIZE {
+		return errSpoolFull
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+recordSize > s.maxSize {
+		return errSpoolFull
+	}
+
+	last := len(s.segments) - 1
+	if s.w == nil || s.segments[last].size > 0 && s.segments[last].size+recordSize > s.segmentMaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = len(s.segments) - 1
+	}
+
+	var header [_SPOOL_RECORD_HEADER_SIZE]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[8:], uint32(entries))
+	binary.BigEndian.PutUint32(header[4:8], spoolChecksum(header[8:], payload))
+
+	if _, err := s.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(payload); err != nil {
+		return err
+	}
+	if s.syncPolicy == CI_WRITER_HTTP_SPOOL_SYNC_ALWAYS {
+		if err := s.w.Sync(); err != nil {
+			return err
+		}
+	}
+
+	s.segments[last].records++
+	s.segments[last].entries += uint64(entries)
+	s.segments[last].size += recordSize
+	s.size += recordSize
+	s.records++
+
+	return nil
+}
+
+// rotate closes the current segment records are written to (if any)
+// and creates a new one. Mutex must be held.
+func (s *_CI_WriterHttpSpool) rotate() error {
+
+	if s.w != nil {
+		if s.syncPolicy != CI_WRITER_HTTP_SPOOL_SYNC_NEVER {
+			_ = s.w.Sync()
+		}
+		_ = s.w.Close()
+		s.w = nil
+	}
+
+	id := uint64(1)
+	if l := len(s.segments); l > 0 {
+		id = s.segments[l-1].id + 1
+	}
+
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, _CI_WriterHttpSpoolSegment{id: id})
+	s.w = f
+
+	return nil
+}
+
+// Peek returns the oldest record's payload that is not committed yet
+// and the number of its encoded log entries. Returns false if there are no records.
+//
+// The same record will be returned by the next Peek() calls until Commit() is called.
+// So, if you failed to handle returned payload, just do not call Commit().
+func (s *_CI_WriterHttpSpool) Peek() ([]byte, uint64, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 {
+		first := &s.segments[0]
+
+		if s.rConsumed == first.records {
+			if s.w != nil && len(s.segments) == 1 {
+				// It's the segment records are written to, and there's nothing more.
+				return nil, 0, false
+			}
+			s.removeFirstSegment()
+			continue
+		}
+
+		if s.r == nil || s.rID != first.id {
+			if s.r != nil {
+				_ = s.r.Close()
+			}
+			f, err := os.Open(s.segmentPath(first.id))
+			if err != nil {
+				s.dropFirstSegment()
+				continue
+			}
+			s.r, s.rID, s.rOffset, s.rNext, s.rConsumed, s.rConsumedEntries =
+				f, first.id, 0, 0, 0, 0
+		}
+
+		payload, entries, next, ok := s.readRecordAt(s.rOffset)
+		if ok {
+			s.rNext, s.rEntries = next, entries
+			return payload, entries, true
+		}
+
+		// The segment has a corrupted record. The rest of records can't be read.
+		s.dropFirstSegment()
+	}
+
+	return nil, 0, false
+}
+
+// readRecordAt reads a record from the first segment at the provided offset,
+// validating its checksum. Returns a payload, the number of its encoded log entries
+// and an offset of next record. Mutex must be held.
+func (s *_CI_WriterHttpSpool) readRecordAt(offset int64) ([]byte, uint64, int64, bool) {
+
+	var header [_SPOOL_RECORD_HEADER_SIZE]byte
+	if _, err := s.r.ReadAt(header[:], offset); err != nil {
+		return nil, 0, 0, false
+	}
+
+	l := int64(binary.BigEndian.Uint32(header[:4]))
+	if l > _SPOOL_RECORD_MAX_SIZE {
+		return nil, 0, 0, false
+	}
+
+	payload := make([]byte, l)
+	if _, err := s.r.ReadAt(payload, offset+_SPOOL_RECORD_HEADER_SIZE); err != nil {
+		return nil, 0, 0, false
+	}
+
+	if spoolChecksum(header[8:], payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, 0, false
+	}
+
+	entries := uint64(binary.BigEndian.Uint32(header[8:]))
+	return payload, entries, offset + _SPOOL_RECORD_HEADER_SIZE + l, true
+}
+
+// spoolChecksum returns CRC32-C of the record's number of encoded log entries
+// (as it's stored in the record's header) and the record's payload.
+func spoolChecksum(entries, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum(entries, spoolCrcTable), spoolCrcTable, payload)
+}
+
+// dropFirstSegment removes the first segment considering encoded log entries
+// of all its records that are not committed yet as corrupted. Mutex must be held.
+func (s *_CI_WriterHttpSpool) dropFirstSegment() {
+	if entries := s.segments[0].entries; entries > s.rConsumedEntries {
+		s.corruptedCounter += entries - s.rConsumedEntries
+	}
+	s.removeFirstSegment()
+}
+
+// removeFirstSegment closes and removes the first segment. Mutex must be held.
+func (s *_CI_WriterHttpSpool) removeFirstSegment() {
+
+	first := s.segments[0]
+
+	if s.r != nil && s.rID == first.id {
+		_ = s.r.Close()
+		s.r = nil
+	}
+	if s.w != nil && len(s.segments) == 1 {
+		_ = s.w.Close()
+		s.w = nil
+	}
+
+	_ = os.Remove(s.segmentPath(first.id))
+
+	s.records -= first.records - s.rConsumed // committed ones are subtracted already
+	s.size -= first.size - s.rOffset
+	s.segments = s.segments[1:]
+
+	s.rOffset, s.rNext, s.rConsumed, s.rConsumedEntries = 0, 0, 0, 0
+}
+
+// Commit marks the record returned by the last Peek() call as handled,
+// so it will never be returned again.
+// Removes the first segment if it's completely handled.
+func (s *_CI_WriterHttpSpool) Commit() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r == nil || s.rNext == 0 {
+		return
+	}
+
+	s.size -= s.rNext - s.rOffset
+	s.rOffset, s.rNext = s.rNext, 0
+	s.rConsumed++
+	s.rConsumedEntries += s.rEntries
+	s.records--
+
+	if s.rConsumed == s.segments[0].records {
+		// The first segment is handled completely.
+		// If it's the segment records are written to, it's removed too,
+		// and the next Push() call will create a new one.
+		s.removeFirstSegment()
+	}
+}
+
+// Close closes all opened segment files, applying the fsync policy.
+// All records that are not committed are kept on the disk
+// and will be read at the next spoolOpen() call.
+func (s *_CI_WriterHttpSpool) Close() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w != nil {
+		if s.syncPolicy != CI_WRITER_HTTP_SPOOL_SYNC_NEVER {
+			_ = s.w.Sync()
+		}
+		_ = s.w.Close()
+		s.w = nil
+	}
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r = nil
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type spoolTestRecord struct {
+	payload string
+	entries int
+}
+
+var spoolTestRecords = []spoolTestRecord{
+	{"first", 1},
+	{"second", 2},
+	{"third", 3},
+}
+
+// spoolTestOpen opens a spool in the new temporary directory.
+// The caller must remove the directory.
+func spoolTestOpen(t *testing.T, segmentMaxSize int64) (*_CI_WriterHttpSpool, string) {
+	t.Helper()
+
+	dir, legacyErr := ioutil.TempDir("", "ci_writer_http_spool")
+	if legacyErr != nil {
+		t.Fatalf("failed to create temp dir: %v", legacyErr)
+	}
+
+	return spoolTestReopen(t, dir, segmentMaxSize), dir
+}
+
+func spoolTestReopen(t *testing.T, dir string, segmentMaxSize int64) *_CI_WriterHttpSpool {
+	t.Helper()
+
+	s, err := spoolOpen(dir, _DEFAULT_SPOOL_MAX_SIZE, segmentMaxSize, CI_WRITER_HTTP_SPOOL_SYNC_NEVER)
+	if err.IsNotNil() {
+		t.Fatal("failed to open spool")
+	}
+	return s
+}
+
+// spoolTestReplay peeks and commits all records of the spool.
+func spoolTestReplay(s *_CI_WriterHttpSpool) []spoolTestRecord {
+	var records []spoolTestRecord
+	for {
+		payload, entries, ok := s.Peek()
+		if !ok {
+			return records
+		}
+		records = append(records, spoolTestRecord{string(payload), int(entries)})
+		s.Commit()
+	}
+}
+
+func TestSpool_WriteReplay(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		segmentMaxSize int64
+		reopen         bool
+	}{
+		{"one segment", _DEFAULT_SPOOL_SEGMENT_MAX, false},
+		{"one segment, reopened", _DEFAULT_SPOOL_SEGMENT_MAX, true},
+		{"segment per record", 1, false},
+		{"segment per record, reopened", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := spoolTestOpen(t, tt.segmentMaxSize)
+			defer os.RemoveAll(dir)
+
+			for _, r := range spoolTestRecords {
+				if legacyErr := s.Push([]byte(r.payload), r.entries); legacyErr != nil {
+					t.Fatalf("failed to push: %v", legacyErr)
+				}
+			}
+
+			if tt.reopen {
+				s.Close()
+				s = spoolTestReopen(t, dir, tt.segmentMaxSize)
+			}
+			defer s.Close()
+
+			if n := s.Len(); n != len(spoolTestRecords) {
+				t.Fatalf("Len() = %d, want %d", n, len(spoolTestRecords))
+			}
+
+			// A record is returned again until it's committed.
+			p1, _, _ := s.Peek()
+			p2, _, _ := s.Peek()
+			if string(p1) != string(p2) {
+				t.Fatalf("Peek() w/o Commit() returns another record: %q, %q", p1, p2)
+			}
+
+			records := spoolTestReplay(s)
+			if len(records) != len(spoolTestRecords) {
+				t.Fatalf("replayed %v, want %v", records, spoolTestRecords)
+			}
+			for i := range records {
+				if records[i] != spoolTestRecords[i] {
+					t.Fatalf("replayed %v, want %v", records, spoolTestRecords)
+				}
+			}
+
+			if n := s.Len(); n != 0 {
+				t.Fatalf("Len() = %d after replay, want 0", n)
+			}
+			if n := s.Corrupted(); n != 0 {
+				t.Fatalf("Corrupted() = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSpool_Corrupted(t *testing.T) {
+
+	// Offsets of the records' parts in the segment file.
+	var (
+		secondRecord   = int64(_SPOOL_RECORD_HEADER_SIZE + len(spoolTestRecords[0].payload))
+		thirdRecord    = secondRecord + int64(_SPOOL_RECORD_HEADER_SIZE+len(spoolTestRecords[1].payload))
+		secondPayload  = secondRecord + _SPOOL_RECORD_HEADER_SIZE
+		secondEntries  = secondRecord + 8
+		thirdTruncated = thirdRecord + _SPOOL_RECORD_HEADER_SIZE + 1
+	)
+
+	tests := []struct {
+		name      string
+		corrupt   func(f *os.File) error
+		replayed  int    // number of records that are read successfully
+		corrupted uint64 // number of encoded log entries that are lost
+	}{
+		{
+			name: "payload",
+			corrupt: func(f *os.File) error {
+				_, err := f.WriteAt([]byte{'X'}, secondPayload)
+				return err
+			},
+			replayed:  1,
+			corrupted: 2 + 3,
+		},
+		{
+			name: "entries counter",
+			corrupt: func(f *os.File) error {
+				_, err := f.WriteAt([]byte{0, 0, 0, 7}, secondEntries)
+				return err
+			},
+			replayed: 1,
+			// The counter of the corrupted record can't be trusted,
+			// but it's the only source of truth what's lost.
+			corrupted: 7 + 3,
+		},
+		{
+			name: "partially written record",
+			corrupt: func(f *os.File) error {
+				return f.Truncate(thirdTruncated)
+			},
+			replayed:  2,
+			corrupted: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := spoolTestOpen(t, _DEFAULT_SPOOL_SEGMENT_MAX)
+			defer os.RemoveAll(dir)
+
+			for _, r := range spoolTestRecords {
+				if legacyErr := s.Push([]byte(r.payload), r.entries); legacyErr != nil {
+					t.Fatalf("failed to push: %v", legacyErr)
+				}
+			}
+			s.Close()
+
+			paths, _ := filepath.Glob(filepath.Join(dir, "*"+_SPOOL_SEGMENT_FILE_EXT))
+			if len(paths) != 1 {
+				t.Fatalf("got %d segments, want 1", len(paths))
+			}
+			f, legacyErr := os.OpenFile(paths[0], os.O_RDWR, 0)
+			if legacyErr != nil {
+				t.Fatalf("failed to open segment: %v", legacyErr)
+			}
+			legacyErr = tt.corrupt(f)
+			_ = f.Close()
+			if legacyErr != nil {
+				t.Fatalf("failed to corrupt segment: %v", legacyErr)
+			}
+
+			s = spoolTestReopen(t, dir, _DEFAULT_SPOOL_SEGMENT_MAX)
+			defer s.Close()
+
+			records := spoolTestReplay(s)
+			if len(records) != tt.replayed {
+				t.Fatalf("replayed %v, want %d records", records, tt.replayed)
+			}
+			for i := range records {
+				if records[i] != spoolTestRecords[i] {
+					t.Fatalf("replayed %v, want %v", records, spoolTestRecords[:tt.replayed])
+				}
+			}
+			if n := s.Corrupted(); n != tt.corrupted {
+				t.Fatalf("Corrupted() = %d, want %d", n, tt.corrupted)
+			}
+			if n := s.Len(); n != 0 {
+				t.Fatalf("Len() = %d after replay, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSpool_MaxSize(t *testing.T) {
+
+	dir, legacyErr := ioutil.TempDir("", "ci_writer_http_spool")
+	if legacyErr != nil {
+		t.Fatalf("failed to create temp dir: %v", legacyErr)
+	}
+	defer os.RemoveAll(dir)
+
+	const recordsNum = 4
+	payload := []byte("payload")
+	recordSize := int64(_SPOOL_RECORD_HEADER_SIZE + len(payload))
+
+	// All records are in the same segment, that is never removed,
+	// because it's not handled completely.
+	s, err := spoolOpen(dir, recordsNum*recordSize, _DEFAULT_SPOOL_SEGMENT_MAX, CI_WRITER_HTTP_SPOOL_SYNC_NEVER)
+	if err.IsNotNil() {
+		t.Fatal("failed to open spool")
+	}
+	defer s.Close()
+
+	for i := 0; i < recordsNum; i++ {
+		if legacyErr := s.Push(payload, 1); legacyErr != nil {
+			t.Fatalf("failed to push: %v", legacyErr)
+		}
+	}
+	if legacyErr := s.Push(payload, 1); legacyErr != errSpoolFull {
+		t.Fatalf("Push() to the full spool = %v, want %v", legacyErr, errSpoolFull)
+	}
+
+	// Committed records free the space, even if their segment is still alive.
+	for i := 0; i < recordsNum-1; i++ {
+		if _, _, ok := s.Peek(); !ok {
+			t.Fatalf("Peek() = false, want record %d", i)
+		}
+		s.Commit()
+	}
+	for i := 0; i < recordsNum-1; i++ {
+		if legacyErr := s.Push(payload, 1); legacyErr != nil {
+			t.Fatalf("failed to push after commit: %v", legacyErr)
+		}
+	}
+	if legacyErr := s.Push(payload, 1); legacyErr != errSpoolFull {
+		t.Fatalf("Push() to the full spool = %v, want %v", legacyErr, errSpoolFull)
+	}
+
+	if records := spoolTestReplay(s); len(records) != recordsNum {
+		t.Fatalf("replayed %d records, want %d", len(records), recordsNum)
+	}
+	if legacyErr := s.Push(payload, 1); legacyErr != nil {
+		t.Fatalf("failed to push to the empty spool: %v", legacyErr)
+	}
+}
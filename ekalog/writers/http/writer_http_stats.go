@@ -0,0 +1,286 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttpStats is a snapshot of CI_WriterHttp's state and counters.
+	// Returned by CI_WriterHttp.Stats(). All counters are since initialization.
+	CI_WriterHttpStats struct {
+
+		// Status is one of: "not initialized", "initializing", "ready",
+		// "temporary disabled", "finally disabled".
+		Status string
+
+		// CircuitBreaker is one of: "closed", "open", "half-open".
+		// See SetCircuitBreaker().
+		CircuitBreaker string
+
+		// EntriesWritten is how much encoded log entries have been accepted by Write().
+		EntriesWritten uint64
+
+		// EntriesQueued is how much encoded log entries are waiting for any worker
+		// at this moment (does not include entries that are accumulated by workers).
+		EntriesQueued int
+
+		// PacksInFlight is how much HTTP requests are performing right now.
+		PacksInFlight int64
+
+		// PacksDeferred is how much entries packs are waiting for connection
+		// restoring at this moment (either in RAM or in the spool).
+		PacksDeferred int
+
+		// PacksSent is how much entries packs have been sent successfully.
+		PacksSent uint64
+
+		// PacksFailed is how much times sending an entries pack is failed
+		// (after all retries). The same entries pack may be counted many times.
+		PacksFailed uint64
+
+		// EntriesLost is how much encoded log entries
+		// have been lost because of buffer overflow, corrupted spool, etc.
+		// EntriesLostByReason is the same, but by the reason of loss:
+		//  - "overflow": entries buffer is full (see SetOverflowPolicy()),
+		//  - "deferred_overflow": deferred entries packs buffer or spool is full,
+		//  - "spool_corrupted": spool's record containing it is malformed,
+		//  - "rejected": provider rejected it and it can't be retried,
+		//  - "too_large": provider says it's too large even being alone,
+		//  - "throttled": it's not sampled because of rate limit.
+		EntriesLost         uint64
+		EntriesLostByReason map[string]uint64
+
+		// How much encoded log entries have been lost (they are counted
+		// in EntriesLost also) because of buffer overflow, by overflow policy
+		// (see SetOverflowPolicy()):
+		//  - EntriesOverflowRejected: the new ones are dropped,
+		//  - EntriesOverflowTimedOut: Write() has been blocked too long,
+		//  - EntriesOverflowDroppedOldest: the oldest ones are dropped,
+		//  - EntriesOverflowDroppedLowPriority: not high priority ones are dropped.
+		EntriesOverflowRejected           uint64
+		EntriesOverflowTimedOut           uint64
+		EntriesOverflowDroppedOldest      uint64
+		EntriesOverflowDroppedLowPriority uint64
+
+		// EntriesTruncated and EntriesDropped is how much encoded log entries
+		// have been truncated or dropped because they are too big.
+		// See SetEntryMaxSize(), SetPackMaxSize().
+		EntriesTruncated uint64
+		EntriesDropped   uint64
+
+		// BytesSent is how much bytes of HTTP requests' bodies (including pings)
+		// have been accepted by provider. It's the size of compressed body,
+		// if compression is enabled, or the size of body before it's changed
+		// by provider's body preparer otherwise.
+		BytesSent uint64
+
+		// RequestsThrottled is how much HTTP requests have been delayed
+		// because of rate limit (see SetRateLimit()).
+		RequestsThrottled uint64
+
+		// Responses is how much HTTP responses have been received
+		// by their HTTP status codes (0 is used for requests w/o response,
+		// like network errors or timeouts). Ping requests are included.
+		Responses map[int]uint64
+
+		// RequestDuration is the histogram of HTTP requests' durations
+		// (including pings and failed ones).
+		RequestDuration CI_WriterHttpHistogram
+
+		// Endpoints are the states and counters of each endpoint
+		// (nil if they're not set). See SetEndpoints().
+		Endpoints []CI_WriterHttpEndpointStats
+
+		// LastErrorMessage and LastErrorTime describe the last HTTP request's error.
+		// They are empty if there was no error.
+		LastErrorMessage string
+		LastErrorTime    time.Time
+	}
+
+	// _CI_WriterHttpStats is CI_WriterHttp's internal counters.
+	_CI_WriterHttpStats struct {
+		entriesWritten   uint64
+		entriesTruncated uint64
+		entriesDropped   uint64
+		packsInFlight    int64
+		packsSent        uint64
+		packsFailed      uint64
+
+		entriesOverflowRejected           uint64
+		entriesOverflowTimedOut           uint64
+		entriesOverflowDroppedOldest      uint64
+		entriesOverflowDroppedLowPriority uint64
+
+		bytesSent         uint64
+		requestsThrottled uint64
+
+		// Indexed by _LOST_REASON_<...> constants.
+		entriesLost [_LOST_REASONS_NUM]uint64
+
+		// Indexed by HTTP status code. Unexpected ones are counted as 0.
+		responses [_STATUS_CODES_NUM]uint64
+
+		requestDuration _CI_WriterHttpHistogram
+
+		lastErrorMu      sync.Mutex
+		lastErrorMessage string
+		lastErrorTime    time.Time
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	// Reasons of log entries loss. See loseEntries(), lostReasonString().
+	_LOST_REASON_OVERFLOW          = uint8(iota)
+	_LOST_REASON_DEFERRED_OVERFLOW = uint8(iota)
+	_LOST_REASON_SPOOL_CORRUPTED   = uint8(iota)
+	_LOST_REASON_REJECTED          = uint8(iota)
+	_LOST_REASON_TOO_LARGE         = uint8(iota)
+	_LOST_REASON_THROTTLED         = uint8(iota)
+	_LOST_REASONS_NUM              = iota
+
+	_STATUS_CODES_NUM = 600
+)
+
+// Stats returns a snapshot of CI_WriterHttp's state and counters.
+// It's safe to call it at any time, even if CI_WriterHttp is nil
+// (a zero snapshot with "not initialized" status is returned).
+func (dw *CI_WriterHttp) Stats() CI_WriterHttpStats {
+
+	if dw == nil {
+		return CI_WriterHttpStats{Status: statusString(_CAS_STATUS_NOT_INITIALIZED)}
+	}
+
+	status := atomic.LoadInt32(&dw.casInitStatus)
+
+	s := CI_WriterHttpStats{
+		Status:         statusString(status),
+		EntriesWritten: atomic.LoadUint64(&dw.stats.entriesWritten),
+		PacksInFlight:  atomic.LoadInt64(&dw.stats.packsInFlight),
+		PacksSent:      atomic.LoadUint64(&dw.stats.packsSent),
+		PacksFailed:    atomic.LoadUint64(&dw.stats.packsFailed),
+		EntriesLost:    atomic.LoadUint64(&dw.entriesCompletelyLostCounter),
+
+		EntriesTruncated: atomic.LoadUint64(&dw.stats.entriesTruncated),
+		EntriesDropped:   atomic.LoadUint64(&dw.stats.entriesDropped),
+
+		EntriesOverflowRejected:           atomic.LoadUint64(&dw.stats.entriesOverflowRejected),
+		EntriesOverflowTimedOut:           atomic.LoadUint64(&dw.stats.entriesOverflowTimedOut),
+		EntriesOverflowDroppedOldest:      atomic.LoadUint64(&dw.stats.entriesOverflowDroppedOldest),
+		EntriesOverflowDroppedLowPriority: atomic.LoadUint64(&dw.stats.entriesOverflowDroppedLowPriority),
+
+		EntriesLostByReason: make(map[string]uint64, _LOST_REASONS_NUM),
+		BytesSent:           atomic.LoadUint64(&dw.stats.bytesSent),
+		RequestsThrottled:   atomic.LoadUint64(&dw.stats.requestsThrottled),
+		Responses:           make(map[int]uint64),
+		RequestDuration:     dw.stats.requestDuration.snapshot(),
+	}
+
+	for i := 0; i < _LOST_REASONS_NUM; i++ {
+		s.EntriesLostByReason[lostReasonString(uint8(i))] = atomic.LoadUint64(&dw.stats.entriesLost[i])
+	}
+
+	for i := 0; i < _STATUS_CODES_NUM; i++ {
+		if n := atomic.LoadUint64(&dw.stats.responses[i]); n > 0 {
+			s.Responses[i] = n
+		}
+	}
+
+	if status != _CAS_STATUS_NOT_INITIALIZED && status != _CAS_STATUS_INITIALIZING {
+		s.EntriesQueued = len(dw.entries) + len(dw.entriesPriority)
+		if dw.breaker != nil {
+			s.CircuitBreaker = breakerStateString(dw.breaker.getState())
+		}
+		if dw.spool != nil {
+			s.PacksDeferred = dw.spool.Len()
+			s.EntriesLost += dw.spool.Corrupted()
+			s.EntriesLostByReason[lostReasonString(_LOST_REASON_SPOOL_CORRUPTED)] += dw.spool.Corrupted()
+		} else {
+			s.PacksDeferred = len(dw.entriesPackDeferred)
+		}
+	}
+
+	if dw.endpoints != nil {
+		s.Endpoints = dw.endpoints.stats()
+	}
+
+	dw.stats.lastErrorMu.Lock()
+	s.LastErrorMessage = dw.stats.lastErrorMessage
+	s.LastErrorTime = dw.stats.lastErrorTime
+	dw.stats.lastErrorMu.Unlock()
+
+	return s
+}
+
+// saveLastError saves the message of the last HTTP request's error
+// and the time it's occurred at.
+func (s *_CI_WriterHttpStats) saveLastError(message string) {
+	s.lastErrorMu.Lock()
+	s.lastErrorMessage = message
+	s.lastErrorTime = time.Now()
+	s.lastErrorMu.Unlock()
+}
+
+// saveResponse saves HTTP status code 'status' of the received response
+// (0 if there's no one) and the duration 'd' of HTTP request.
+func (s *_CI_WriterHttpStats) saveResponse(status int, d time.Duration) {
+	if status < 0 || status >= _STATUS_CODES_NUM {
+		status = 0
+	}
+	atomic.AddUint64(&s.responses[status], 1)
+	s.requestDuration.observe(d)
+}
+
+// loseEntries counts 'n' encoded log entries as lost
+// because of 'reason' (one of _LOST_REASON_<...> constants).
+func (dw *CI_WriterHttp) loseEntries(reason uint8, n uint64) {
+	atomic.AddUint64(&dw.entriesCompletelyLostCounter, n)
+	atomic.AddUint64(&dw.stats.entriesLost[reason], n)
+}
+
+// lostReasonString returns a string representation of the reason
+// of log entries loss. Used as a map key and a metric's label.
+func lostReasonString(reason uint8) string {
+	switch reason {
+	case _LOST_REASON_OVERFLOW:
+		return "overflow"
+	case _LOST_REASON_DEFERRED_OVERFLOW:
+		return "deferred_overflow"
+	case _LOST_REASON_SPOOL_CORRUPTED:
+		return "spool_corrupted"
+	case _LOST_REASON_REJECTED:
+		return "rejected"
+	case _LOST_REASON_TOO_LARGE:
+		return "too_large"
+	case _LOST_REASON_THROTTLED:
+		return "throttled"
+	default:
+		return "unknown"
+	}
+}
+
+// statusString returns a human-readable representation of CI_WriterHttp's status.
+func statusString(status int32) string {
+	switch status {
+	case _CAS_STATUS_NOT_INITIALIZED:
+		return "not initialized"
+	case _CAS_STATUS_INITIALIZING:
+		return "initializing"
+	case _CAS_STATUS_READY:
+		return "ready"
+	case _CAS_STATUS_TEMPORARY_DISABLED:
+		return "temporary disabled"
+	case _CAS_STATUS_FINALLY_DISABLED:
+		return "finally disabled"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestUseProvider_ResetsPreviousProvider(t *testing.T) {
+
+	manual := func(dw *CI_WriterHttp) *CI_WriterHttp {
+		return dw.UseProviderManual(func(req *fasthttp.Request) {})
+	}
+
+	tests := []struct {
+		name     string
+		previous func(dw *CI_WriterHttp) *CI_WriterHttp
+	}{
+		{"CloudWatch", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderCloudWatch("http://127.0.0.1", "group", "stream")
+		}},
+		{"DataDog", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderDataDog(DATADOG_ADDR_EU, "token")
+		}},
+		{"Elasticsearch", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderElasticsearch("http://127.0.0.1", "logs")
+		}},
+		{"Google Cloud Logging", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderGoogleCloudLogging("http://127.0.0.1", "project", "log", nil)
+		}},
+		{"GrayLog", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderGrayLog("http://127.0.0.1")
+		}},
+		{"Loki", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderLoki("http://127.0.0.1", true).SetLokiTenantID("tenant")
+		}},
+		{"OTLP", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderOTLP("http://127.0.0.1", true)
+		}},
+		{"Sentry, invalid DSN", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderSentry("invalid")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := manual(tt.previous(new(CI_WriterHttp)))
+
+			switch {
+			case dw.providerBodyPreparer != nil:
+				t.Fatal("body preparer is not reset")
+			case dw.providerPingInitializer != nil:
+				t.Fatal("ping initializer is not reset")
+			case dw.providerPingSkip:
+				t.Fatal("ping skipping is not reset")
+			case dw.providerResponseHandler != nil:
+				t.Fatal("response handler is not reset")
+			case dw.providerFailureHandler != nil:
+				t.Fatal("failure handler is not reset")
+			case dw.providerMaxEntriesPerPack != 0 || dw.packEntryOverhead != 0:
+				t.Fatal("provider's limits are not reset")
+			case dw.providerErr.IsNotNil():
+				t.Fatal("provider's error is not reset")
+			case dw.lokiProtobuf || dw.lokiTenantID != "":
+				t.Fatal("Loki options are not reset")
+			case dw.providerPackBuilder != nil:
+				t.Fatal("pack builder is not reset")
+			case dw.providerPackMaxSize != 0 || dw.providerEntryMaxSize != 0:
+				t.Fatal("provider's pack or entry limits are not reset")
+			case dw.providerCompressionAlgo != CI_WRITER_HTTP_COMPRESSION_NONE:
+				t.Fatal("provider's compression is not reset")
+			case dw.providerAuthenticator != nil || dw.authenticator != nil:
+				t.Fatal("provider's authenticator is not reset")
+			case dw.providerPackSplitter != nil:
+				t.Fatal("pack splitter is not reset")
+			}
+		})
+	}
+}
+
+func TestUseProvider_NothingLeaks(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		previous func(dw *CI_WriterHttp) *CI_WriterHttp
+	}{
+		{"CloudWatch", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderCloudWatch(CloudWatchLogsAddr("eu-west-1"), "group", "stream")
+		}},
+		{"DataDog", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderDataDog(DATADOG_ADDR_EU, "token")
+		}},
+		{"Elasticsearch", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderElasticsearch("http://127.0.0.1", "logs")
+		}},
+		{"Google Cloud Logging", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderGoogleCloudLogging("http://127.0.0.1", "project", "log",
+				func() (string, time.Duration, *ekaerr.Error) { return "token", 0, nil })
+		}},
+		{"GrayLog", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderGrayLog("http://127.0.0.1")
+		}},
+		{"Loki", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderLoki("http://127.0.0.1", false)
+		}},
+		{"OTLP", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderOTLP("http://127.0.0.1", false, "service.name", "api")
+		}},
+		{"Rollbar", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderRollbar("token")
+		}},
+		{"Sentry", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderSentry("https://key@o0.ingest.sentry.io/1")
+		}},
+		{"Splunk HEC", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderSplunkHEC("http://127.0.0.1:8088", "token")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := tt.previous(new(CI_WriterHttp)).
+				UseProviderManual(func(req *fasthttp.Request) {})
+
+			// Provider's settings are applied at the initialization.
+			dw.initOverwriteZeroValues()
+
+			switch {
+			case dw.authenticator != nil:
+				t.Fatal("authenticator leaks")
+			case dw.compressionAlgo != CI_WRITER_HTTP_COMPRESSION_NONE:
+				t.Fatal("compression leaks")
+			case dw.packMaxSize != 0 || dw.entryMaxSize != 0:
+				t.Fatal("pack or entry limits leak")
+			case dw.workerEntriesBufferLen != _DEFAULT_ENTRIES_PER_WORKER_BUF_SIZE:
+				t.Fatal("worker buffer cap leaks")
+			case len(dw.dataBefore) != 0 || len(dw.dataAfter) != 0 || len(dw.dataBetween) != 0:
+				t.Fatal("data separators leak")
+			case dw.packBuilderActive.(*_CI_WriterHttpStaticPackBuilder).between != nil:
+				t.Fatal("pack builder leaks")
+			case dw.providerPackSplitter != nil:
+				t.Fatal("pack splitter leaks")
+			}
+		})
+	}
+}
+
+func TestUseProvider_OneEntryPerPack(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		setup func(dw *CI_WriterHttp) *CI_WriterHttp
+	}{
+		{"GrayLog", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderGrayLog("http://127.0.0.1")
+		}},
+		{"Rollbar", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderRollbar("token")
+		}},
+		{"Sentry", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return dw.UseProviderSentry("https://key@o0.ingest.sentry.io/1")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, dw := range []*CI_WriterHttp{
+				tt.setup(new(CI_WriterHttp)).SetWorkerBufferCap(100),
+				tt.setup(new(CI_WriterHttp).SetWorkerBufferCap(100)),
+			} {
+				dw.initOverwriteZeroValues()
+				if dw.workerEntriesBufferLen != 1 {
+					t.Fatalf("worker's buffer capacity is %d, want 1", dw.workerEntriesBufferLen)
+				}
+			}
+		})
+	}
+}
+
+func TestUseProvider_Defaults(t *testing.T) {
+
+	datadog := func(dw *CI_WriterHttp) *CI_WriterHttp {
+		return dw.UseProviderDataDog(DATADOG_ADDR_EU, "token")
+	}
+
+	tests := []struct {
+		name         string
+		setup        func(dw *CI_WriterHttp) *CI_WriterHttp
+		compression  CI_WriterHttp_Compression
+		packMaxSize  uint32
+		entryMaxSize uint32
+	}{
+		{"provider's", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return datadog(dw)
+		}, CI_WRITER_HTTP_COMPRESSION_GZIP, _DATADOG_MAX_PACK_SIZE, _DATADOG_MAX_ENTRY_SIZE},
+		{"user's are lower", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return datadog(dw).SetPackMaxSize(1<<20).SetEntryMaxSize(1<<10, CI_WRITER_HTTP_OVERSIZE_DROP)
+		}, CI_WRITER_HTTP_COMPRESSION_GZIP, 1 << 20, 1 << 10},
+		{"user's are greater", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return datadog(dw).SetPackMaxSize(1<<30).SetEntryMaxSize(1<<30, CI_WRITER_HTTP_OVERSIZE_DROP)
+		}, CI_WRITER_HTTP_COMPRESSION_GZIP, _DATADOG_MAX_PACK_SIZE, _DATADOG_MAX_ENTRY_SIZE},
+		{"user's compression after", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return datadog(dw).SetCompression(CI_WRITER_HTTP_COMPRESSION_NONE, 0)
+		}, CI_WRITER_HTTP_COMPRESSION_NONE, _DATADOG_MAX_PACK_SIZE, _DATADOG_MAX_ENTRY_SIZE},
+		{"user's compression before", func(dw *CI_WriterHttp) *CI_WriterHttp {
+			return datadog(dw.SetCompression(CI_WRITER_HTTP_COMPRESSION_ZSTD, 0))
+		}, CI_WRITER_HTTP_COMPRESSION_ZSTD, _DATADOG_MAX_PACK_SIZE, _DATADOG_MAX_ENTRY_SIZE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := tt.setup(new(CI_WriterHttp))
+			dw.initOverwriteZeroValues()
+
+			switch {
+			case dw.compressionAlgo != tt.compression:
+				t.Fatalf("compression is %d, want %d", dw.compressionAlgo, tt.compression)
+			case dw.packMaxSize != tt.packMaxSize:
+				t.Fatalf("pack max size is %d, want %d", dw.packMaxSize, tt.packMaxSize)
+			case dw.entryMaxSize != tt.entryMaxSize:
+				t.Fatalf("entry max size is %d, want %d", dw.entryMaxSize, tt.entryMaxSize)
+			}
+		})
+	}
+}
+
+func TestUseProvider_KeepsUserHandlers(t *testing.T) {
+
+	var providerRetry []int
+	handler := func(resp *fasthttp.Response, entriesNum int, result *CI_WriterHttp_ResponseResult) {
+		providerRetry = result.Retry
+		result.Reason = "user"
+	}
+	builder := sentryEnvelopeBuilder{}
+
+	dw := new(CI_WriterHttp).
+		SetResponseHandler(handler).
+		SetPackBuilder(builder).
+		UseProviderElasticsearch("http://127.0.0.1:9200", "logs")
+
+	if dw.responseHandler == nil {
+		t.Fatal("response handler is reset")
+	}
+	if dw.packBuilder != builder {
+		t.Fatal("pack builder is reset")
+	}
+
+	dw.acceptedStatusCodes = defaultAcceptedStatusCodes
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	resp.SetStatusCode(fasthttp.StatusOK)
+	resp.SetBodyString(`{"errors":true,"items":[` +
+		`{"create":{"status":201}},` +
+		`{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue is full"}}}]}`)
+
+	result := dw.classifyResponse(resp, 2)
+	switch {
+	case len(providerRetry) != 1 || providerRetry[0] != 1:
+		t.Fatalf("provider's classification is not passed to user's handler: %v", providerRetry)
+	case result.Reason != "user":
+		t.Fatalf("user's handler is not applied: %q", result.Reason)
+	}
+}
+
+func TestWrite_EntryMaxSize(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		entrySize    int
+		packMaxSize  uint32
+		entryMaxSize uint32
+		policy       CI_WriterHttp_OversizePolicy
+		wantErr      error
+		wantSent     int // the size of sent encoded log entry, 0 if it's lost
+		truncated    uint64
+	}{
+		{"fits", 100, 0, 128, CI_WRITER_HTTP_OVERSIZE_DROP, nil, 100, 0},
+		{"truncated", 200, 0, 128, CI_WRITER_HTTP_OVERSIZE_TRUNCATE, nil, 128, 1},
+		{"dropped", 200, 0, 128, CI_WRITER_HTTP_OVERSIZE_DROP, ErrWriterEntryTooLarge, 0, 0},
+		{"larger than a pack", 2000, 1 << 10, 0, CI_WRITER_HTTP_OVERSIZE_DROP, nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received []string
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != "ping" {
+					mu.Lock()
+					received = append(received, strings.Split(string(body), "\n")...)
+					mu.Unlock()
+				}
+			}))
+			defer srv.Close()
+
+			dw := new(CI_WriterHttp).
+				UseProviderManual(func(req *fasthttp.Request) {
+					req.SetRequestURI(srv.URL)
+				}).
+				AddBeforeAfterBetweenS("", "", "\n").
+				SetPingBody([]byte("ping")).
+				SetPackMaxSize(tt.packMaxSize).
+				SetEntryMaxSize(tt.entryMaxSize, tt.policy).
+				SetWorkersNum(1).
+				SetWorkerAutoFlushDelay(time.Hour)
+
+			entry := strings.Repeat("a", tt.entrySize)
+			n, legacyErr := dw.Write([]byte(entry))
+			switch {
+			case legacyErr != tt.wantErr:
+				t.Fatalf("Write() = %v, want %v", legacyErr, tt.wantErr)
+			case legacyErr == nil && n != tt.entrySize:
+				t.Fatalf("Write() = %d, want %d", n, tt.entrySize)
+			}
+
+			if err := dw.Close(context.Background()); err.IsNotNil() {
+				t.Fatal("failed to close")
+			}
+
+			var want []string
+			if tt.wantSent != 0 {
+				want = []string{entry[:tt.wantSent]}
+			}
+			if strings.Join(received, ",") != strings.Join(want, ",") {
+				t.Fatalf("received %d encoded log entries, want %d", len(received), len(want))
+			}
+
+			stats := dw.Stats()
+			wantLost := uint64(0)
+			if tt.wantSent == 0 {
+				wantLost = 1
+			}
+			switch {
+			case stats.EntriesTruncated != tt.truncated:
+				t.Fatalf("truncated %d encoded log entries, want %d", stats.EntriesTruncated, tt.truncated)
+			case stats.EntriesDropped != wantLost:
+				t.Fatalf("dropped %d encoded log entries, want %d", stats.EntriesDropped, wantLost)
+			case stats.EntriesLostByReason["too_large"] != wantLost || stats.EntriesLost != wantLost:
+				t.Fatalf("lost %d encoded log entries as too large, want %d",
+					stats.EntriesLostByReason["too_large"], wantLost)
+			}
+		})
+	}
+}
+
+func TestFlush_WaitsInFlight(t *testing.T) {
+
+	const entriesNum = 8
+
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "ping" {
+			// Some packs are being sent while Flush() is called.
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&received, int32(len(strings.Split(string(body), "\n"))))
+		}
+	}))
+	defer srv.Close()
+
+	dw := new(CI_WriterHttp).
+		UseProviderManual(func(req *fasthttp.Request) {
+			req.SetRequestURI(srv.URL)
+		}).
+		AddBeforeAfterBetweenS("", "", "\n").
+		SetPingBody([]byte("ping")).
+		SetBufferCap(entriesNum).
+		SetWorkersNum(4).
+		SetWorkerBufferCap(1).
+		SetWorkerAutoFlushDelay(time.Hour)
+
+	for i := 0; i < entriesNum; i++ {
+		if _, legacyErr := dw.Write([]byte("entry")); legacyErr != nil {
+			t.Fatalf("Write() = %v", legacyErr)
+		}
+	}
+
+	if err := dw.Flush(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to flush")
+	}
+
+	if n := atomic.LoadInt32(&received); n != entriesNum {
+		t.Fatalf("Flush() returned when %d encoded log entries are sent, want %d", n, entriesNum)
+	}
+
+	if err := dw.Close(context.Background()); err.IsNotNil() {
+		t.Fatal("failed to close")
+	}
+}
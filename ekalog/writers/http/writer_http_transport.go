@@ -0,0 +1,287 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+//noinspection GoSnakeCaseUsage
+type (
+	// CI_WriterHttp_Transport performs HTTP requests to the log service provider.
+	// See SetTransport() method and NewTransport<...>() functions.
+	CI_WriterHttp_Transport interface {
+
+		// Do performs HTTP request 'req' and fills HTTP response 'resp',
+		// following redirects. If the response isn't received within 'timeout'
+		// (if it's > 0), an error must be returned.
+		//
+		// A redirect to another host or a redirect that changes the request's method
+		// (301, 302, 303 for the requests except GET and HEAD) must not be followed,
+		// because the request contains credentials and the encoded log entries.
+		// The redirect response is returned then.
+		//
+		// 'req' and 'resp' must not be retained after Do() is returned.
+		// Do() is called from many goroutines. It must be thread-safe.
+		Do(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+	}
+
+	// transportFastHTTP is a CI_WriterHttp_Transport, that uses fasthttp.Client.
+	transportFastHTTP struct {
+		c *fasthttp.Client
+	}
+
+	// transportNetHTTP is a CI_WriterHttp_Transport, that uses http.Client
+	// of standard library (HTTP/2, proxies, etc).
+	transportNetHTTP struct {
+		c *http.Client
+	}
+)
+
+//noinspection GoSnakeCaseUsage
+const (
+	_DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+
+	// How much redirects are followed by fasthttp transport.
+	_TRANSPORT_MAX_REDIRECTS = 5
+)
+
+// NewTransportFastHTTP returns a CI_WriterHttp_Transport, that uses
+// fasthttp.Client 'c'. Configure its TLS (TLSConfig field), proxy (Dial field,
+// see fasthttpproxy package), connection pool, read/write timeouts as you need.
+// If 'c' is nil, a zero value fasthttp.Client is used.
+//
+// It's the default transport.
+func NewTransportFastHTTP(c *fasthttp.Client) CI_WriterHttp_Transport {
+	if c == nil {
+		c = new(fasthttp.Client)
+	}
+	return &transportFastHTTP{c}
+}
+
+// NewTransportNetHTTP returns a CI_WriterHttp_Transport, that uses
+// http.Client 'c' of standard library. Use it, if your log service provider
+// requires HTTP/2 or you need all features of net/http.
+// If 'c' is nil, a client with the copy of http.DefaultTransport is used
+// (HTTP/2 is enabled, proxy is taken from the environment).
+//
+// Redirects are followed as 'c' is configured to (its CheckRedirect field).
+// The client, created if 'c' is nil, follows them as the fasthttp transport does.
+func NewTransportNetHTTP(c *http.Client) CI_WriterHttp_Transport {
+	if c == nil {
+		c = &http.Client{
+			Transport:     http.DefaultTransport.(*http.Transport).Clone(),
+			CheckRedirect: checkRedirectNetHTTP,
+		}
+	}
+	return &transportNetHTTP{c}
+}
+
+// NewTransportNetHTTPWithTLS is the same as NewTransportNetHTTP(nil),
+// but uses 'tlsConfig' for TLS connections (like custom CAs
+// or client certificates for mTLS). HTTP/2 is still enabled.
+func NewTransportNetHTTPWithTLS(tlsConfig *tls.Config) CI_WriterHttp_Transport {
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	t.ForceAttemptHTTP2 = true
+
+	return &transportNetHTTP{&http.Client{Transport: t, CheckRedirect: checkRedirectNetHTTP}}
+}
+
+// SetTransport sets a CI_WriterHttp_Transport, that performs each HTTP request
+// to the log service provider (including ping).
+//
+// Nil safe. There is no-op if CI_WriterHttp already initialized.
+// Default: NewTransportFastHTTP(nil).
+func (dw *CI_WriterHttp) SetTransport(transport CI_WriterHttp_Transport) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		dw.transport = transport
+	})
+}
+
+// SetRequestTimeout sets the maximum time the one HTTP request
+// to the log service provider may take (including redirects).
+// If it's exceeded, the request is failed (and may be retried).
+//
+// Read p.6 of CI_WriterHttp doc for more info.
+//
+// Does nothing, if CI_WriterHttp already running, stopped or disabled
+// (Write() has been called at least once).
+//
+// Allowed range: [100ms..10m].
+// Default: 30s.
+func (dw *CI_WriterHttp) SetRequestTimeout(timeout time.Duration) *CI_WriterHttp {
+	return dw.configure(func(dw *CI_WriterHttp) {
+		if timeout >= 100*time.Millisecond && timeout <= 10*time.Minute {
+			dw.requestTimeout = timeout
+		}
+	})
+}
+
+func (t *transportFastHTTP) Do(
+
+	req *fasthttp.Request,
+	resp *fasthttp.Response,
+	timeout time.Duration,
+
+) error {
+
+	// On timeout fasthttp continues the request in the background,
+	// so the body must not refer to the caller's buffers.
+	// Reading body stream makes it owned by 'req'.
+	// It also allows to send the same body after redirect.
+	if req.IsBodyStream() {
+		_ = req.Body()
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for redirects := 0; ; redirects++ {
+
+		var err error
+		if timeout > 0 {
+			err = t.c.DoDeadline(req, resp, deadline)
+		} else {
+			err = t.c.Do(req, resp)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fasthttp.StatusCodeIsRedirect(resp.StatusCode()) {
+			return nil
+		}
+
+		if redirects >= _TRANSPORT_MAX_REDIRECTS {
+			return fasthttp.ErrTooManyRedirects
+		}
+
+		location := resp.Header.Peek(fasthttp.HeaderLocation)
+		if len(location) == 0 {
+			return fasthttp.ErrMissingLocation
+		}
+
+		if !redirectAllowed(req, resp.StatusCode(), location) {
+			return nil
+		}
+
+		req.URI().UpdateBytes(location)
+	}
+}
+
+// redirectAllowed reports whether the redirect of 'req' with 'status'
+// to 'location' may be followed (see CI_WriterHttp_Transport's Do()).
+func redirectAllowed(req *fasthttp.Request, status int, location []byte) bool {
+
+	switch status {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther:
+		// Clients change the method to GET (and drop the body) for these redirects,
+		// so the log entries would never be delivered.
+		if !req.Header.IsGet() && !req.Header.IsHead() {
+			return false
+		}
+	}
+
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+
+	req.URI().CopyTo(uri)
+	uri.UpdateBytes(location)
+
+	// Credentials must not be sent to another host or w/o TLS.
+	return bytes.Equal(uri.Scheme(), req.URI().Scheme()) &&
+		bytes.Equal(uri.Host(), req.URI().Host())
+}
+
+// checkRedirectNetHTTP is http.Client's CheckRedirect, that follows redirects
+// as transportFastHTTP does (see CI_WriterHttp_Transport's Do()).
+func checkRedirectNetHTTP(req *http.Request, via []*http.Request) error {
+
+	if len(via) > _TRANSPORT_MAX_REDIRECTS {
+		return fasthttp.ErrTooManyRedirects
+	}
+
+	// http.Client has already changed the method of 301, 302, 303 redirects.
+	first := via[0]
+	if req.Method != first.Method ||
+		req.URL.Scheme != first.URL.Scheme || req.URL.Host != first.URL.Host {
+		return http.ErrUseLastResponse
+	}
+
+	return nil
+}
+
+func (t *transportNetHTTP) Do(
+
+	req *fasthttp.Request,
+	resp *fasthttp.Response,
+	timeout time.Duration,
+
+) error {
+
+	// net/http may read the body even after the request is finished,
+	// so the body is copied.
+	body := append([]byte(nil), req.Body()...)
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, timeout)
+		defer cancelFunc()
+	}
+
+	httpReq, legacyErr := http.NewRequest(
+		string(req.Header.Method()), req.URI().String(), bytes.NewReader(body))
+	if legacyErr != nil {
+		return legacyErr
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	req.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case fasthttp.HeaderHost:
+			httpReq.Host = string(value)
+		case fasthttp.HeaderContentLength, fasthttp.HeaderTransferEncoding,
+			fasthttp.HeaderConnection:
+		default:
+			httpReq.Header.Add(string(key), string(value))
+		}
+	})
+
+	httpResp, legacyErr := t.c.Do(httpReq)
+	if legacyErr != nil {
+		return legacyErr
+	}
+	defer httpResp.Body.Close()
+
+	resp.Reset()
+	resp.SetStatusCode(httpResp.StatusCode)
+
+	for key, values := range httpResp.Header {
+		switch key {
+		case fasthttp.HeaderContentLength, fasthttp.HeaderTransferEncoding,
+			fasthttp.HeaderConnection:
+		default:
+			for _, value := range values {
+				resp.Header.Add(key, value)
+			}
+		}
+	}
+
+	_, legacyErr = io.Copy(resp.BodyWriter(), httpResp.Body)
+	return legacyErr
+}
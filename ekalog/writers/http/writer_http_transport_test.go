@@ -0,0 +1,95 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekalog_writer_http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestTransport_Redirects(t *testing.T) {
+
+	var otherHostHits int32
+	otherHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&otherHostHits, 1)
+	}))
+	defer otherHost.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/307":
+			http.Redirect(w, r, "/target", http.StatusTemporaryRedirect)
+		case "/308":
+			http.Redirect(w, r, "/target", http.StatusPermanentRedirect)
+		case "/302":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/303":
+			http.Redirect(w, r, "/target", http.StatusSeeOther)
+		case "/other-host":
+			http.Redirect(w, r, otherHost.URL+"/target", http.StatusTemporaryRedirect)
+		case "/target":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != http.MethodPost || string(body) != "entries" ||
+				r.Header.Get("X-Api-Key") != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	transports := []struct {
+		name      string
+		transport CI_WriterHttp_Transport
+	}{
+		{"fasthttp", NewTransportFastHTTP(nil)},
+		{"net/http", NewTransportNetHTTP(nil)},
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/307", http.StatusOK},
+		{"/308", http.StatusOK},
+		{"/302", http.StatusFound},
+		{"/303", http.StatusSeeOther},
+		{"/other-host", http.StatusTemporaryRedirect},
+	}
+
+	for _, tr := range transports {
+		for _, tt := range tests {
+			for _, timeout := range []time.Duration{0, time.Second} {
+				t.Run(tr.name+tt.path+"/timeout="+timeout.String(), func(t *testing.T) {
+					req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+					defer fasthttp.ReleaseRequest(req)
+					defer fasthttp.ReleaseResponse(resp)
+
+					req.SetRequestURI(srv.URL + tt.path)
+					req.Header.SetMethod(fasthttp.MethodPost)
+					req.Header.Set("X-Api-Key", "secret")
+					req.SetBodyString("entries")
+
+					if err := tr.transport.Do(req, resp, timeout); err != nil {
+						t.Fatalf("failed to do request: %v", err)
+					}
+					if resp.StatusCode() != tt.status {
+						t.Fatalf("status = %d, want %d", resp.StatusCode(), tt.status)
+					}
+				})
+			}
+		}
+	}
+
+	if hits := atomic.LoadInt32(&otherHostHits); hits != 0 {
+		t.Fatalf("redirect to another host is followed %d times", hits)
+	}
+}
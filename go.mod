@@ -6,6 +6,9 @@ require (
 	github.com/go-pg/pg/v10 v10.0.3
 	github.com/jackc/pgio v1.0.0
 	github.com/jackc/pgtype v1.8.1
+	github.com/json-iterator/go v1.1.9
+	github.com/klauspost/compress v1.10.7
 	github.com/qioalice/ekago/v3 v3.2.6
 	github.com/valyala/fasthttp v1.16.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,72 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+// Package ekaenctest provides utilities for testing encoders
+// of ekalog_encoder_<...> packages with real ekalog.Entry.
+package ekaenctest
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+)
+
+type (
+	// fixedTimeEncoder is an ekalog.CI_Encoder, that replaces log entry's time
+	// by Time before it's encoded, so the encoded log entry is reproducible.
+	fixedTimeEncoder struct {
+		ekalog.CI_Encoder
+	}
+)
+
+var (
+	// Time is the time of each log entry, that is encoded by Encode().
+	Time = time.Date(2021, 6, 1, 12, 30, 45, 123456789, time.UTC)
+
+	// encodeMu serializes Encode() calls, because ekalog's integrator is global.
+	encodeMu sync.Mutex
+
+	// fileLineRegexp matches the line of Go file in the stack frame's format.
+	fileLineRegexp = regexp.MustCompile(`\.go:\d+`)
+)
+
+// Encode replaces ekalog's integrator by the one, that encodes log entries
+// by 'enc', calls 'log', that must log entries by ekalog's package level
+// functions (like ekalog.Info()), and returns encoded log entries.
+//
+// Log entries have time Time. Their own stacktraces are not generated,
+// but attached ekaerr.Error's ones are.
+func Encode(enc ekalog.CI_Encoder, log func()) string {
+
+	encodeMu.Lock()
+	defer encodeMu.Unlock()
+
+	var b bytes.Buffer
+
+	ekalog.ReplaceIntegrator(new(ekalog.CommonIntegrator).
+		WithEncoder(fixedTimeEncoder{enc}).
+		WithMinLevel(ekalog.LEVEL_DEBUG).
+		WithMinLevelForStackTrace(ekalog.LEVEL_EMERGENCY).
+		WriteTo(&b))
+
+	log()
+	return b.String()
+}
+
+// HideLines returns 's' with the lines of Go files in stack frames
+// (like "encoder_test.go:42") replaced by "<line>",
+// so the encoded log entry doesn't depend on where the test's code is.
+func HideLines(s string) string {
+	return fileLineRegexp.ReplaceAllString(s, ".go:<line>")
+}
+
+// EncodeEntry implements ekalog.CI_Encoder.
+func (e fixedTimeEncoder) EncodeEntry(entry *ekalog.Entry) []byte {
+	entry.Time = Time
+	return e.CI_Encoder.EncodeEntry(entry)
+}
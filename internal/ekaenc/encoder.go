@@ -0,0 +1,85 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekaenc
+
+import (
+	"crypto/rand"
+
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/json-iterator/go"
+)
+
+type (
+	// NopEncoder must be embedded into each encoder of ekalog_encoder_<...>
+	// packages. Never built, used only as no-op PreEncodeField() provider,
+	// because ekaletter package is internal and its types can't be named.
+	// Thus these encoders ignore the fields, pre-encoded using PreEncodeField().
+	NopEncoder struct {
+		ekalog.CI_Encoder
+	}
+)
+
+var (
+	// JsonApi is used by encoders to encode log entries
+	// and to encode those Field's values, that are not simple.
+	JsonApi = jsoniter.Config{
+		MarshalFloatWith6Digits:       true,
+		ObjectFieldMustBeSimpleString: true,
+	}.Froze()
+)
+
+// NewNopEncoder returns a NopEncoder, that is ready to be embedded.
+func NewNopEncoder() NopEncoder {
+	return NopEncoder{new(ekalog.CI_JSONEncoder)}
+}
+
+// CopyBuffer returns a copy of the encoded data of 's',
+// that may be used after 's' is returned to JsonApi.
+func CopyBuffer(s *jsoniter.Stream) []byte {
+
+	b := s.Buffer()
+	copied := make([]byte, len(b))
+	copy(copied, b)
+
+	return copied
+}
+
+// WriteStringIfNotEmpty writes JSON comma, 'key' and 'value' to 's'
+// if 'value' is not empty.
+func WriteStringIfNotEmpty(s *jsoniter.Stream, key, value string) {
+	if value != "" {
+		s.WriteMore()
+		s.WriteObjectField(key)
+		s.WriteString(value)
+	}
+}
+
+// UUID4 generates a new random UUID (version 4, RFC 4122 variant).
+func UUID4() (uuid [16]byte) {
+	_, _ = rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0F | 0x40
+	uuid[8] = uuid[8]&0x3F | 0x80
+	return uuid
+}
+
+// LevelOf5 returns a name of ekalog.Level for those log services,
+// that have only 5 levels. 'highest' is used for ekalog.LEVEL_EMERGENCY,
+// ekalog.LEVEL_ALERT and ekalog.LEVEL_CRITICAL, ekalog.LEVEL_NOTICE is "info".
+func LevelOf5(l ekalog.Level, highest string) string {
+	switch l {
+	case ekalog.LEVEL_EMERGENCY, ekalog.LEVEL_ALERT, ekalog.LEVEL_CRITICAL:
+		return highest
+	case ekalog.LEVEL_ERROR:
+		return "error"
+	case ekalog.LEVEL_WARNING:
+		return "warning"
+	case ekalog.LEVEL_NOTICE, ekalog.LEVEL_INFO:
+		return "info"
+	default:
+		return "debug"
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekaenc
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/json-iterator/go"
+)
+
+type (
+	// Field is a mirror of ekago's ekaletter.LetterField.
+	// Memory layout MUST BE THE SAME.
+	Field struct {
+		Key           string
+		Kind          uint8
+		IValue        int64
+		SValue        string
+		Value         interface{}
+		StackFrameIdx int16
+	}
+)
+
+// Mirrors of ekago's ekaletter.LetterFieldKind constants.
+//noinspection GoSnakeCaseUsage
+const (
+	KIND_MASK_BASE_TYPE = 0b_0001_1111
+	KIND_MASK_FLAGS     = 0b_1110_0000
+
+	KIND_FLAG_USER_DEFINED = 0b_0010_0000
+	KIND_FLAG_NULL         = 0b_0100_0000
+	KIND_FLAG_SYSTEM       = 0b_1000_0000
+
+	KIND_TYPE_INVALID = ^uint8(0)
+
+	KIND_SYS_TYPE_EKAERR_UUID       = 1
+	KIND_SYS_TYPE_EKAERR_CLASS_ID   = 2
+	KIND_SYS_TYPE_EKAERR_CLASS_NAME = 3
+
+	KIND_TYPE_BOOL        = 3
+	KIND_TYPE_INT         = 4
+	KIND_TYPE_INT_8       = 5
+	KIND_TYPE_INT_16      = 6
+	KIND_TYPE_INT_32      = 7
+	KIND_TYPE_INT_64      = 8
+	KIND_TYPE_UINT        = 9
+	KIND_TYPE_UINT_8      = 10
+	KIND_TYPE_UINT_16     = 11
+	KIND_TYPE_UINT_32     = 12
+	KIND_TYPE_UINT_64     = 13
+	KIND_TYPE_UINTPTR     = 14
+	KIND_TYPE_FLOAT_32    = 15
+	KIND_TYPE_FLOAT_64    = 16
+	KIND_TYPE_COMPLEX_64  = 17
+	KIND_TYPE_COMPLEX_128 = 18
+	KIND_TYPE_STRING      = 19
+	KIND_TYPE_ADDR        = 21
+	KIND_TYPE_UNIX        = 23
+	KIND_TYPE_UNIX_NANO   = 24
+	KIND_TYPE_DURATION    = 25
+	KIND_TYPE_ARRAY       = 27
+	KIND_TYPE_MAP         = 28
+	KIND_TYPE_EXTMAP      = 29
+	KIND_TYPE_STRUCT      = 30
+)
+
+func (f Field) BaseType() uint8 { return f.Kind & KIND_MASK_BASE_TYPE }
+func (f Field) IsSystem() bool  { return f.Kind&KIND_FLAG_SYSTEM != 0 }
+func (f Field) IsNil() bool     { return f.Kind&KIND_FLAG_NULL != 0 }
+func (f Field) IsInvalid() bool { return f.Kind == KIND_TYPE_INVALID }
+
+// IsNumber reports whether Field's value is a number
+// (and is encoded as JSON number by WriteJSON()).
+func (f Field) IsNumber() bool {
+	switch bt := f.BaseType(); {
+	case f.IsSystem() || f.IsNil() || f.IsInvalid():
+		return false
+	default:
+		return bt >= KIND_TYPE_INT && bt <= KIND_TYPE_UINT_64 ||
+			bt == KIND_TYPE_FLOAT_32 || bt == KIND_TYPE_FLOAT_64
+	}
+}
+
+// IsFloat reports whether Field's value is a float number.
+func (f Field) IsFloat() bool {
+	bt := f.BaseType()
+	return f.IsNumber() && (bt == KIND_TYPE_FLOAT_32 || bt == KIND_TYPE_FLOAT_64)
+}
+
+// Float64 returns Field's float number value. Field MUST BE a float number.
+func (f Field) Float64() float64 {
+	if f.BaseType() == KIND_TYPE_FLOAT_32 {
+		return float64(math.Float32frombits(uint32(f.IValue)))
+	}
+	return math.Float64frombits(uint64(f.IValue))
+}
+
+// IsHidden reports whether Field must not be written by encoders:
+// it's a system field or its key has "sys." prefix.
+func (f Field) IsHidden() bool {
+	return f.IsSystem() || strings.HasPrefix(f.Key, "sys.")
+}
+
+// KeyOrUnnamed returns Field's key if it's not empty or "unnamed_<idx>"
+// (like ekago does), incrementing 'unnamedIdx' in that case.
+func (f Field) KeyOrUnnamed(unnamedIdx *int16) string {
+
+	if f.Key != "" {
+		return f.Key
+	}
+
+	*unnamedIdx++
+
+	if *unnamedIdx < 10 {
+		return "unnamed_0" + strconv.Itoa(int(*unnamedIdx))
+	}
+
+	return "unnamed_" + strconv.Itoa(int(*unnamedIdx))
+}
+
+// WriteJSON writes Field's value to 's' as JSON value.
+// Numbers and bools are written as is, nil as null, arrays, maps and structs
+// are marshalled, all the rest are written as JSON strings.
+func (f Field) WriteJSON(s *jsoniter.Stream) {
+
+	switch bt := f.BaseType(); {
+
+	case f.IsSystem() && bt == KIND_SYS_TYPE_EKAERR_CLASS_ID:
+		s.WriteInt64(f.IValue)
+
+	case f.IsNil():
+		s.WriteNil()
+
+	case f.IsInvalid():
+		s.WriteString("<invalid_field>")
+
+	case bt == KIND_TYPE_BOOL:
+		s.WriteBool(f.IValue != 0)
+
+	case f.IsNumber():
+		s.SetBuffer(f.appendNumber(s.Buffer()))
+
+	case bt == KIND_TYPE_MAP, bt == KIND_TYPE_EXTMAP,
+		bt == KIND_TYPE_STRUCT, bt == KIND_TYPE_ARRAY:
+		s.WriteVal(f.Value)
+
+	default:
+		s.WriteString(f.String())
+	}
+}
+
+// WriteObjectField writes Field as JSON key-value pair to 's' with JSON comma
+// before. The key is KeyOrUnnamed(). Hidden fields are not written.
+func (f Field) WriteObjectField(s *jsoniter.Stream, unnamedIdx *int16) {
+	if !f.IsHidden() {
+		s.WriteMore()
+		s.WriteObjectField(f.KeyOrUnnamed(unnamedIdx))
+		f.WriteJSON(s)
+	}
+}
+
+// String returns a string representation of Field's value.
+// Arrays, maps and structs are represented as JSON.
+func (f Field) String() string {
+
+	switch bt := f.BaseType(); {
+
+	case f.IsSystem():
+		switch bt {
+		case KIND_SYS_TYPE_EKAERR_UUID, KIND_SYS_TYPE_EKAERR_CLASS_NAME:
+			return f.SValue
+		case KIND_SYS_TYPE_EKAERR_CLASS_ID:
+			return strconv.FormatInt(f.IValue, 10)
+		default:
+			return "<unsupported_system_field>"
+		}
+
+	case f.IsNil():
+		return "null"
+
+	case f.IsInvalid():
+		return "<invalid_field>"
+
+	case bt == KIND_TYPE_BOOL:
+		return strconv.FormatBool(f.IValue != 0)
+
+	case f.IsNumber():
+		return string(f.appendNumber(nil))
+
+	case bt == KIND_TYPE_UINTPTR, bt == KIND_TYPE_ADDR:
+		return "0x" + strconv.FormatInt(f.IValue, 16)
+
+	case bt == KIND_TYPE_STRING:
+		return f.SValue
+
+	case bt == KIND_TYPE_COMPLEX_64:
+		r := math.Float32frombits(uint32(f.IValue >> 32))
+		i := math.Float32frombits(uint32(f.IValue))
+		return strconv.FormatComplex(complex128(complex(r, i)), 'f', -1, 64)
+
+	case bt == KIND_TYPE_COMPLEX_128:
+		return strconv.FormatComplex(f.Value.(complex128), 'f', -1, 128)
+
+	case bt == KIND_TYPE_UNIX:
+		return time.Unix(f.IValue, 0).UTC().Format(time.RFC3339)
+
+	case bt == KIND_TYPE_UNIX_NANO:
+		return time.Unix(0, f.IValue).UTC().Format(time.RFC3339Nano)
+
+	case bt == KIND_TYPE_DURATION:
+		return time.Duration(f.IValue).String()
+
+	case bt == KIND_TYPE_MAP, bt == KIND_TYPE_EXTMAP,
+		bt == KIND_TYPE_STRUCT, bt == KIND_TYPE_ARRAY:
+		b, err := JsonApi.Marshal(f.Value)
+		if err != nil {
+			return "<unsupported_field>"
+		}
+		return string(b)
+
+	default:
+		return "<unsupported_field>"
+	}
+}
+
+// appendNumber appends Field's number value to 'b'. Field MUST BE a number.
+func (f Field) appendNumber(b []byte) []byte {
+
+	switch bt := f.BaseType(); {
+
+	case bt >= KIND_TYPE_INT && bt <= KIND_TYPE_INT_64:
+		return strconv.AppendInt(b, f.IValue, 10)
+
+	case bt >= KIND_TYPE_UINT && bt <= KIND_TYPE_UINT_64:
+		return strconv.AppendUint(b, uint64(f.IValue), 10)
+
+	case bt == KIND_TYPE_FLOAT_32:
+		return strconv.AppendFloat(b, float64(math.Float32frombits(uint32(f.IValue))), 'f', -1, 32)
+
+	default:
+		return strconv.AppendFloat(b, math.Float64frombits(uint64(f.IValue)), 'f', -1, 64)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekaenc
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekasys"
+)
+
+type (
+	// Letter is a mirror of ekago's ekaletter.Letter.
+	//
+	// ekaletter is an internal ekago's package, so its types can not be named
+	// outside of ekago, but custom ekalog's encoders need them.
+	// Memory layout MUST BE THE SAME.
+	Letter struct {
+		StackTrace   ekasys.StackTrace
+		Messages     []Message
+		Fields       []Field
+		SystemFields []Field
+
+		_ int16
+	}
+
+	// Message is a mirror of ekago's ekaletter.LetterMessage.
+	// Memory layout MUST BE THE SAME.
+	Message struct {
+		Body          string
+		StackFrameIdx int16
+	}
+)
+
+// LetterOf returns a Letter, 'p' points to. 'p' MUST BE a pointer
+// to ekago's ekaletter.Letter (like ekalog.Entry's LogLetter or ErrLetter)
+// or nil. In the last case nil is returned.
+func LetterOf(p unsafe.Pointer) *Letter {
+	return (*Letter)(p)
+}
+
+// Message returns a message of log entry, that has 'logLetter' and 'errLetter'
+// (the last one might be nil). If log entry's message is empty,
+// the last ekaerr.Error's message is used (like ekalog.CI_JSONEncoder does).
+func EntryMessage(logLetter, errLetter *Letter) string {
+
+	if len(logLetter.Messages) > 0 && logLetter.Messages[0].Body != "" {
+		return logLetter.Messages[0].Body
+	}
+
+	if errLetter != nil {
+		if l := len(errLetter.Messages); l > 0 {
+			return errLetter.Messages[l-1].Body
+		}
+	}
+
+	return ""
+}
+
+// EntryStackTrace returns a stacktrace of log entry, that has 'logLetter'
+// and 'errLetter' (the last one might be nil). It's an error's one,
+// if the log entry has no its own.
+func EntryStackTrace(logLetter, errLetter *Letter) ekasys.StackTrace {
+
+	if len(logLetter.StackTrace) == 0 && errLetter != nil {
+		return errLetter.StackTrace
+	}
+
+	return logLetter.StackTrace
+}
+
+// ErrorMessage returns all messages of ekaerr.Error, 'errLetter' belongs to,
+// from the last to the first, separated by ": " (repeated ones only once).
+// The last error's message might be moved to the log entry's one
+// and it's empty in 'errLetter' then, so EntryMessage() is returned
+// if there is no error's message.
+func ErrorMessage(logLetter, errLetter *Letter) string {
+
+	var (
+		sb   strings.Builder
+		last string
+	)
+
+	for i := len(errLetter.Messages) - 1; i >= 0; i-- {
+		if body := errLetter.Messages[i].Body; body != "" && body != last {
+			if sb.Len() > 0 {
+				sb.WriteString(": ")
+			}
+			sb.WriteString(body)
+			last = body
+		}
+	}
+
+	if sb.Len() == 0 {
+		return EntryMessage(logLetter, errLetter)
+	}
+
+	return sb.String()
+}
+
+// ErrorID returns ekaerr.Error's ID, if the current Letter belongs to it.
+// Returns an empty string otherwise.
+func (l *Letter) ErrorID() string {
+	return l.systemField(KIND_SYS_TYPE_EKAERR_UUID).SValue
+}
+
+// ErrorClassName returns ekaerr.Error's class name,
+// if the current Letter belongs to it. Returns an empty string otherwise.
+func (l *Letter) ErrorClassName() string {
+	return l.systemField(KIND_SYS_TYPE_EKAERR_CLASS_NAME).SValue
+}
+
+// FieldsOfFrame returns those fields of the current Letter,
+// that belong to stack frame with 'idx' index.
+func (l *Letter) FieldsOfFrame(idx int16) []Field {
+
+	from := 0
+	for from < len(l.Fields) && l.Fields[from].StackFrameIdx < idx {
+		from++
+	}
+
+	to := from
+	for to < len(l.Fields) && l.Fields[to].StackFrameIdx == idx {
+		to++
+	}
+
+	return l.Fields[from:to]
+}
+
+// MessageOfFrame returns a message of the current Letter,
+// that belongs to stack frame with 'idx' index or an empty string.
+func (l *Letter) MessageOfFrame(idx int16) string {
+
+	for i := range l.Messages {
+		if l.Messages[i].StackFrameIdx == idx {
+			return l.Messages[i].Body
+		}
+	}
+
+	return ""
+}
+
+// systemField returns a system field with provided base type 'kind'
+// or an empty Field if there is no such field.
+func (l *Letter) systemField(kind uint8) Field {
+
+	for i := range l.SystemFields {
+		if l.SystemFields[i].BaseType() == kind {
+			return l.SystemFields[i]
+		}
+	}
+
+	return Field{}
+}
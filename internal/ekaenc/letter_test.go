@@ -0,0 +1,104 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekaenc
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/qioalice/ekago/v3/ekaerr"
+	"github.com/qioalice/ekago/v3/ekalog"
+
+	"github.com/qioalice/ekago_ext/v3/internal/ekaenc/ekaenctest"
+)
+
+type (
+	// letterEncoder is an ekalog.CI_Encoder, that keeps the Letters
+	// of the last encoded ekalog.Entry, read by LetterOf().
+	letterEncoder struct {
+		NopEncoder
+		logLetter, errLetter *Letter
+	}
+)
+
+func (le *letterEncoder) EncodeEntry(e *ekalog.Entry) []byte {
+	// ekalog.Entry is returned to the pool after it's encoded.
+	le.logLetter = copyLetter(LetterOf(unsafe.Pointer(e.LogLetter)))
+	le.errLetter = copyLetter(LetterOf(unsafe.Pointer(e.ErrLetter)))
+	return nil
+}
+
+func copyLetter(l *Letter) *Letter {
+	if l == nil {
+		return nil
+	}
+	return &Letter{
+		StackTrace:   append(l.StackTrace[:0:0], l.StackTrace...),
+		Messages:     append(l.Messages[:0:0], l.Messages...),
+		Fields:       append(l.Fields[:0:0], l.Fields...),
+		SystemFields: append(l.SystemFields[:0:0], l.SystemFields...),
+	}
+}
+
+// TestLetterOf guards Letter's and Field's memory layout,
+// that must be the same as ekago's ekaletter ones.
+// If ekago is upgraded and the layout is changed, this test fails.
+func TestLetterOf(t *testing.T) {
+
+	enc := &letterEncoder{NopEncoder: NewNopEncoder()}
+
+	err := ekaerr.NotFound.New("the user is not found").
+		WithString("path", "/users/42").
+		Throw()
+
+	ekaenctest.Encode(enc, func() {
+		ekalog.Errore("request failed", err, "user_id", 42, "name", "alice")
+	})
+
+	logLetter, errLetter := enc.logLetter, enc.errLetter
+
+	switch {
+	case logLetter == nil || errLetter == nil:
+		t.Fatal("letters are not read")
+
+	case len(logLetter.Messages) != 1 || logLetter.Messages[0].Body != "request failed":
+		t.Fatalf("unexpected log's messages: %+v", logLetter.Messages)
+
+	case len(logLetter.Fields) != 2:
+		t.Fatalf("unexpected log's fields: %+v", logLetter.Fields)
+
+	case logLetter.Fields[0].Key != "user_id" ||
+		logLetter.Fields[0].BaseType() != KIND_TYPE_INT || logLetter.Fields[0].IValue != 42:
+		t.Fatalf("unexpected log's int field: %+v", logLetter.Fields[0])
+
+	case logLetter.Fields[1].Key != "name" ||
+		logLetter.Fields[1].BaseType() != KIND_TYPE_STRING || logLetter.Fields[1].SValue != "alice":
+		t.Fatalf("unexpected log's string field: %+v", logLetter.Fields[1])
+
+	case len(logLetter.StackTrace) != 0:
+		t.Fatalf("log has unexpected stacktrace of %d frames", len(logLetter.StackTrace))
+
+	case errLetter.ErrorID() == "" || errLetter.ErrorID() != err.ID():
+		t.Fatalf("error's ID is %q, want %q", errLetter.ErrorID(), err.ID())
+
+	case errLetter.ErrorClassName() != ekaerr.NotFound.Name():
+		t.Fatalf("error's class is %q, want %q", errLetter.ErrorClassName(), ekaerr.NotFound.Name())
+
+	case len(errLetter.Fields) != 1 || errLetter.Fields[0].Key != "path" ||
+		errLetter.Fields[0].BaseType() != KIND_TYPE_STRING || errLetter.Fields[0].SValue != "/users/42":
+		t.Fatalf("unexpected error's fields: %+v", errLetter.Fields)
+
+	case len(errLetter.StackTrace) == 0:
+		t.Fatal("error has no stacktrace")
+
+	case !strings.Contains(errLetter.StackTrace[0].Function, "TestLetterOf"):
+		t.Fatalf("unexpected error's stack frame: %+v", errLetter.StackTrace[0])
+
+	case len(errLetter.Messages) != 1 || errLetter.MessageOfFrame(0) != "the user is not found":
+		t.Fatalf("unexpected error's messages: %+v", errLetter.Messages)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © 2021. All rights reserved.
+// Author: Ilya Stroy.
+// Contacts: iyuryevich@pm.me, https://github.com/qioalice
+// License: https://opensource.org/licenses/MIT
+
+package ekaenc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/qioalice/ekago/v3/ekasys"
+)
+
+// SplitFunction splits a full function name (runtime.Frame's Function),
+// like "github.com/user/repo/pkg.(*T).Method" to its package path
+// ("github.com/user/repo/pkg") and the function itself ("(*T).Method").
+func SplitFunction(function string) (pkg, fn string) {
+
+	slash := strings.LastIndexByte(function, '/')
+	if slash == -1 {
+		slash = 0
+	}
+
+	dot := strings.IndexByte(function[slash:], '.')
+	if dot == -1 {
+		return "", function
+	}
+
+	dot += slash
+	return function[:dot], function[dot+1:]
+}
+
+// ShortFile returns a file name without its directory.
+func ShortFile(file string) string {
+	return file[strings.LastIndexByte(file, '/')+1:]
+}
+
+// FormatGoStack formats 'stackTrace' like Go's debug.Stack() does,
+// so the log services, that parse Go's stacks, are able to parse it.
+func FormatGoStack(stackTrace ekasys.StackTrace) string {
+
+	var sb strings.Builder
+	sb.WriteString("goroutine 1 [running]:")
+
+	for i := range stackTrace {
+		sb.WriteByte('\n')
+		sb.WriteString(stackTrace[i].Function)
+		sb.WriteString("(...)\n\t")
+		sb.WriteString(stackTrace[i].File)
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(stackTrace[i].Line))
+	}
+
+	return sb.String()
+}